@@ -30,6 +30,7 @@ import (
 	"time"
 
 	"github.com/pmylund/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/skydive-project/skydive/analyzer"
 	"github.com/skydive-project/skydive/api"
@@ -47,6 +48,7 @@ import (
 	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/topology/graph"
 	"github.com/skydive-project/skydive/topology/graph/traversal"
+	tprobes "github.com/skydive-project/skydive/topology/probes"
 )
 
 type Agent struct {
@@ -59,6 +61,7 @@ type Agent struct {
 	TopologyProbeBundle *probe.ProbeBundle
 	FlowProbeBundle     *fprobes.FlowProbeBundle
 	FlowTableAllocator  *flow.TableAllocator
+	FlowPipeline        *flow.FlowEnhancerPipeline
 	FlowClientPool      *analyzer.FlowClientPool
 	OnDemandProbeServer *ondemand.OnDemandProbeServer
 	HTTPServer          *shttp.Server
@@ -146,7 +149,19 @@ func (a *Agent) Start() {
 			pipeline.AddEnhancer(enhancers.NewNeutronFlowEnhancer(a.Graph, cache))
 		}
 
-		a.FlowTableAllocator = flow.NewTableAllocator(updateTime, expireTime, pipeline)
+		if config.GetConfig().GetBool("agent.flow.process_attribution") {
+			pipeline.AddEnhancer(enhancers.NewProcessFlowEnhancer(a.Graph, cache))
+		}
+
+		// correlate the flows with their conntrack entry if the probe is loaded
+		if probe := a.TopologyProbeBundle.GetProbe("conntrack"); probe != nil {
+			pipeline.AddEnhancer(enhancers.NewConntrackFlowEnhancer(a.Graph, cache, probe.(*tprobes.ConntrackProbe)))
+		}
+
+		a.FlowPipeline = pipeline
+
+		maxFlows := config.GetConfig().GetInt64("agent.flow.max_flows")
+		a.FlowTableAllocator = flow.NewTableAllocator(updateTime, expireTime, maxFlows, pipeline)
 
 		// expose a flow server through the client connections
 		flow.NewServer(a.FlowTableAllocator, a.WSAsyncClientPool)
@@ -184,6 +199,9 @@ func (a *Agent) Stop() {
 	if a.OnDemandProbeServer != nil {
 		a.OnDemandProbeServer.Stop()
 	}
+	if a.FlowPipeline != nil {
+		a.FlowPipeline.Stop()
+	}
 	if a.EtcdClient != nil {
 		a.EtcdClient.Stop()
 	}
@@ -222,6 +240,11 @@ func NewAgent() *Agent {
 
 	root := CreateRootNode(g)
 	api.RegisterTopologyAPI(hserver, tr)
+	api.RegisterRecordingAPI(hserver)
+
+	if err := prometheus.Register(graph.NewPrometheusCollector(g, common.AgentService)); err != nil {
+		logging.GetLogger().Errorf("Unable to register the graph metrics: %s", err.Error())
+	}
 
 	gserver := graph.NewServer(g, wsServer)
 
@@ -69,6 +69,16 @@ func NewTopologyProbeBundleFromConfig(g *graph.Graph, n *graph.Node, wspool *sht
 			probes["neutron"] = neutron
 		case "opencontrail":
 			probes[t] = tprobes.NewOpenContrailMapper(g, n)
+		case "netfilter":
+			probes[t] = tprobes.NewNetfilterProbeFromConfig(g, n)
+		case "conntrack":
+			probes[t] = tprobes.NewConntrackProbe(g, n)
+		case "lldp":
+			probes[t] = tprobes.NewLLDPProbeFromConfig(g, n)
+		case "tc":
+			probes[t] = tprobes.NewTrafficControlProbeFromConfig(g, n)
+		case "openflow":
+			probes[t] = tprobes.NewOpenFlowProbeFromConfig(g, n)
 		default:
 			logging.GetLogger().Errorf("unknown probe type %s", t)
 		}
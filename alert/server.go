@@ -33,6 +33,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/robertkrimen/otto"
 	"github.com/skydive-project/skydive/api"
 	"github.com/skydive-project/skydive/common"
@@ -52,6 +53,16 @@ const (
 	SCRIPT
 )
 
+var evaluationDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "skydive",
+		Subsystem: "alert",
+		Name:      "evaluation_duration_seconds",
+		Help:      "Duration of the alert evaluations",
+	},
+	[]string{"uuid"},
+)
+
 type GremlinAlert struct {
 	*api.Alert
 	triggered         bool
@@ -275,7 +286,9 @@ func (a *AlertServer) evaluateAlert(al *GremlinAlert) error {
 		return nil
 	}
 
+	start := time.Now()
 	data, err := al.Evaluate()
+	evaluationDuration.WithLabelValues(al.UUID).Observe(time.Since(start).Seconds())
 	if err != nil {
 		return err
 	}
@@ -388,6 +401,8 @@ func (a *AlertServer) UnregisterAlert(id string) {
 	a.Lock()
 	defer a.Unlock()
 
+	evaluationDuration.DeleteLabelValues(id)
+
 	if ch, found := a.alertTimers[id]; found {
 		close(ch)
 		delete(a.alertTimers, id)
@@ -434,3 +449,7 @@ func NewAlertServer(ah api.APIHandler, wsServer *shttp.WSServer, parser *travers
 
 	return as
 }
+
+func init() {
+	prometheus.MustRegister(evaluationDuration)
+}
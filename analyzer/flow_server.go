@@ -35,6 +35,7 @@ import (
 	"time"
 
 	"github.com/pmylund/go-cache"
+	"github.com/skydive-project/skydive/anomaly"
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
@@ -42,6 +43,7 @@ import (
 	"github.com/skydive-project/skydive/flow/storage"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/sink"
 	"github.com/skydive-project/skydive/topology/graph"
 )
 
@@ -65,6 +67,8 @@ type FlowServer struct {
 	Addr                 string
 	Port                 int
 	Storage              storage.Storage
+	EventSink            *sink.EventSink
+	AnomalyDetector      *anomaly.Detector
 	FlowEnhancerPipeline *flow.FlowEnhancerPipeline
 	conn                 *FlowServerConn
 	state                int64
@@ -287,12 +291,25 @@ func NewFlowClientConn(addr *net.UDPAddr) (a *FlowClientConn, err error) {
 }
 
 func (s *FlowServer) storeFlows(flows []*flow.Flow) {
-	if s.Storage != nil && len(flows) > 0 {
-		s.FlowEnhancerPipeline.Enhance(flows)
+	if len(flows) == 0 || (s.Storage == nil && s.EventSink == nil && s.AnomalyDetector == nil) {
+		return
+	}
+
+	s.FlowEnhancerPipeline.Enhance(flows)
+
+	if s.AnomalyDetector != nil {
+		s.AnomalyDetector.ProcessFlows(flows)
+	}
+
+	if s.Storage != nil {
 		s.Storage.StoreFlows(flows)
 
 		logging.GetLogger().Debugf("%d flows stored", len(flows))
 	}
+
+	if s.EventSink != nil {
+		s.EventSink.PublishFlows(flows)
+	}
 }
 
 // handleFlowPacket can handle connection based on TCP or UDP
@@ -373,6 +390,7 @@ func (s *FlowServer) Stop() {
 	if atomic.CompareAndSwapInt64(&s.state, common.RunningState, common.StoppingState) {
 		s.conn.Cleanup()
 		s.wgServer.Wait()
+		s.FlowEnhancerPipeline.Stop()
 	}
 }
 
@@ -389,6 +407,22 @@ func NewFlowServer(addr string, port int, g *graph.Graph, store storage.Storage,
 		pipeline.AddEnhancer(enhancers.NewNeutronFlowEnhancer(g, cache))
 	}
 
+	cfg := config.GetConfig()
+	if cfg.GetBool("analyzer.flow.reverse_dns.enabled") {
+		timeout := time.Duration(cfg.GetInt("analyzer.flow.reverse_dns.timeout")) * time.Second
+		expire := time.Duration(cfg.GetInt("analyzer.flow.reverse_dns.expire")) * time.Second
+		pipeline.AddEnhancer(enhancers.NewReverseDNSFlowEnhancer(timeout, expire, cfg.GetInt("analyzer.flow.reverse_dns.workers")))
+	}
+
+	countryDB, asnDB := cfg.GetString("analyzer.flow.geoip.country_database"), cfg.GetString("analyzer.flow.geoip.asn_database")
+	if countryDB != "" || asnDB != "" {
+		geoip, err := enhancers.NewGeoIPFlowEnhancer(countryDB, asnDB, cache)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the GeoIP databases: %s", err.Error())
+		}
+		pipeline.AddEnhancer(geoip)
+	}
+
 	return &FlowServer{
 		Addr:                 addr,
 		Port:                 port,
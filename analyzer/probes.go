@@ -23,6 +23,8 @@
 package analyzer
 
 import (
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/probe"
 	"github.com/skydive-project/skydive/topology/graph"
 	tprobes "github.com/skydive-project/skydive/topology/probes"
@@ -32,5 +34,36 @@ func NewTopologyProbeBundleFromConfig(g *graph.Graph) (*probe.ProbeBundle, error
 	probes := make(map[string]probe.Probe)
 	probes["fabric"] = tprobes.NewFabricProbe(g)
 	probes["peering"] = tprobes.NewPeeringProbe(g)
+
+	list := config.GetConfig().GetStringSlice("analyzer.topology.probes")
+	logging.GetLogger().Infof("Topology probes: %v", list)
+
+	for _, t := range list {
+		if _, ok := probes[t]; ok {
+			continue
+		}
+
+		switch t {
+		case "k8s":
+			k8s, err := tprobes.NewK8sProbeFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize Kubernetes probe: %s", err.Error())
+				return nil, err
+			}
+			probes[t] = k8s
+		case "neighbor":
+			probes[t] = tprobes.NewNeighborProbe(g)
+		case "ovn":
+			ovn, err := tprobes.NewOvnMapperFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize OVN probe: %s", err.Error())
+				return nil, err
+			}
+			probes[t] = ovn
+		default:
+			logging.GetLogger().Errorf("unknown probe type %s", t)
+		}
+	}
+
 	return probe.NewProbeBundle(probes), nil
 }
@@ -27,7 +27,10 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/skydive-project/skydive/alert"
+	"github.com/skydive-project/skydive/anomaly"
 	"github.com/skydive-project/skydive/api"
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
@@ -37,9 +40,12 @@ import (
 	"github.com/skydive-project/skydive/flow/storage"
 	ftraversal "github.com/skydive-project/skydive/flow/traversal"
 	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/packet_injector"
 	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/sink"
 	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
 	"github.com/skydive-project/skydive/topology/graph/traversal"
 )
 
@@ -54,6 +60,8 @@ type Server struct {
 	FlowServer        *FlowServer
 	ProbeBundle       *probe.ProbeBundle
 	Storage           storage.Storage
+	EventSink         *sink.EventSink
+	AnomalyDetector   *anomaly.Detector
 	EmbeddedEtcd      *etcd.EmbeddedEtcd
 	EtcdClient        *etcd.EtcdClient
 	running           atomic.Value
@@ -68,6 +76,14 @@ func (s *Server) Start() {
 		s.Storage.Start()
 	}
 
+	if s.EventSink != nil {
+		s.EventSink.Start()
+	}
+
+	if s.AnomalyDetector != nil {
+		s.AnomalyDetector.Start()
+	}
+
 	s.TopologyForwarder.ConnectAll()
 
 	s.ProbeBundle.Start()
@@ -98,6 +114,13 @@ func (s *Server) Stop() {
 	if s.Storage != nil {
 		s.Storage.Stop()
 	}
+	if s.EventSink != nil {
+		s.TopologyServer.Graph.RemoveEventListener(s.EventSink)
+		s.EventSink.Stop()
+	}
+	if s.AnomalyDetector != nil {
+		s.AnomalyDetector.Stop()
+	}
 	s.ProbeBundle.Stop()
 	s.OnDemandClient.Stop()
 	s.AlertServer.Stop()
@@ -182,10 +205,29 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
+	eventSink, err := sink.NewEventSinkFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if eventSink != nil {
+		fserver.EventSink = eventSink
+		tserver.Graph.AddEventListener(eventSink)
+	}
+
+	if anomalyDetector := anomaly.NewDetectorFromConfig(); anomalyDetector != nil {
+		anomalyDetector.AddListener(anomaly.NewGraphListener(tserver.Graph))
+		fserver.AnomalyDetector = anomalyDetector
+	}
+
 	tr := traversal.NewGremlinTraversalParser(tserver.Graph)
 	tr.AddTraversalExtension(topology.NewTopologyTraversalExtension())
 	tr.AddTraversalExtension(ftraversal.NewFlowTraversalExtension(tableClient, store))
 
+	if err := prometheus.Register(graph.NewPrometheusCollector(tserver.Graph, common.AnalyzerService)); err != nil {
+		logging.GetLogger().Errorf("Unable to register the graph metrics: %s", err.Error())
+	}
+
 	aserver := alert.NewAlertServer(alertAPIHandler, wsServer, tr, etcdClient)
 
 	piClient := packet_injector.NewPacketInjectorClient(wsServer)
@@ -204,12 +246,16 @@ func NewServerFromConfig() (*Server, error) {
 		FlowServer:        fserver,
 		ProbeBundle:       probeBundle,
 		Storage:           store,
+		EventSink:         eventSink,
+		AnomalyDetector:   fserver.AnomalyDetector,
 	}
 
 	wsServer.AddEventHandler(server)
 
 	api.RegisterTopologyAPI(httpServer, tr)
 
+	api.RegisterFlowAPI(httpServer, tr, tableClient)
+
 	api.RegisterPacketInjectorAPI(piClient, tserver.Graph, httpServer)
 
 	api.RegisterPcapAPI(httpServer, store)
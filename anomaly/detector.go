@@ -0,0 +1,439 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package anomaly
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+)
+
+const (
+	PortScanAnomaly = "PortScan"
+	SpikeAnomaly    = "Spike"
+	NewPeerAnomaly  = "NewPeer"
+)
+
+var anomaliesDetected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "skydive",
+		Subsystem: "anomaly",
+		Name:      "detected_total",
+		Help:      "Number of anomalies detected in the flows",
+	},
+	[]string{"type"},
+)
+
+// Anomaly describes an unusual behaviour of the flows of a capture node.
+// Spikes report the Metric whose Value exceeds the Baseline, either for the
+// whole node or for a flow Tuple. Port scans and new peers report the Peer.
+type Anomaly struct {
+	Type      string
+	NodeTID   string
+	Tuple     string `json:",omitempty"`
+	Peer      string `json:",omitempty"`
+	Metric    string `json:",omitempty"`
+	Value     float64
+	Baseline  float64
+	Timestamp int64
+}
+
+// Listener is notified of the detected anomalies
+type Listener interface {
+	OnAnomaly(a *Anomaly)
+}
+
+// DetectorOpts defines the parameters of the detection, TTLs are expressed
+// in number of intervals.
+type DetectorOpts struct {
+	Interval          time.Duration
+	Alpha             float64
+	Threshold         float64
+	Warmup            int64
+	PortScanThreshold int
+	PeerTTL           int64
+	IdleTTL           int64
+}
+
+type counters struct {
+	bytes   float64
+	packets float64
+}
+
+type flowState struct {
+	bytes    int64
+	packets  int64
+	lastSeen int64
+}
+
+type tupleState struct {
+	bytes    EWMA
+	packets  EWMA
+	current  counters
+	lastSeen int64
+}
+
+type nodeState struct {
+	bytes       EWMA
+	packets     EWMA
+	connections EWMA
+	peers       EWMA
+	current     counters
+	newFlows    float64
+	activePeers map[string]bool
+	scans       map[string]map[string]bool
+	knownPeers  map[string]int64
+	tuples      map[string]*tupleState
+	flows       map[string]*flowState
+}
+
+// Detector keeps, per capture node and per flow tuple, EWMA baselines of the
+// bytes and packets, of the new connection rate and of the number of
+// distinct peers computed on intervals of flow updates. It reports traffic
+// spikes, port scans and connections between peers never seen before.
+type Detector struct {
+	sync.RWMutex
+	opts      DetectorOpts
+	nodes     map[string]*nodeState
+	listeners []Listener
+	interval  int64
+	quit      chan bool
+	state     int64
+	wg        sync.WaitGroup
+}
+
+func newNodeState() *nodeState {
+	return &nodeState{
+		activePeers: make(map[string]bool),
+		scans:       make(map[string]map[string]bool),
+		knownPeers:  make(map[string]int64),
+		tuples:      make(map[string]*tupleState),
+		flows:       make(map[string]*flowState),
+	}
+}
+
+// peerKey returns the same key for both directions of the traffic
+func peerKey(f *flow.Flow) string {
+	peers := []string{f.Network.A, f.Network.B}
+	sort.Strings(peers)
+	return strings.Join(peers, "-")
+}
+
+func tupleKey(f *flow.Flow) string {
+	key := f.Network.A + "-" + f.Network.B
+	if f.Transport != nil {
+		key += "/" + f.Transport.Protocol.String() + "/" + f.Transport.B
+	}
+	return key
+}
+
+func isPortScanCandidate(f *flow.Flow) bool {
+	if f.Transport == nil {
+		return false
+	}
+	return f.Transport.Protocol == flow.FlowProtocol_TCPPORT || f.Transport.Protocol == flow.FlowProtocol_UDPPORT
+}
+
+func (d *Detector) AddListener(l Listener) {
+	d.Lock()
+	d.listeners = append(d.listeners, l)
+	d.Unlock()
+}
+
+func (d *Detector) processFlow(f *flow.Flow) (anomalies []*Anomaly) {
+	if f.NodeTID == "" || f.Metric == nil {
+		return
+	}
+
+	ns, ok := d.nodes[f.NodeTID]
+	if !ok {
+		ns = newNodeState()
+		d.nodes[f.NodeTID] = ns
+	}
+
+	// flow updates report the total metric of the flows, the baselines use
+	// the increase since the previous update
+	bytes := f.Metric.ABBytes + f.Metric.BABytes
+	packets := f.Metric.ABPackets + f.Metric.BAPackets
+
+	fs, seen := ns.flows[f.UUID]
+	if !seen {
+		fs = &flowState{}
+		ns.flows[f.UUID] = fs
+		ns.newFlows++
+	}
+
+	deltaBytes, deltaPackets := bytes-fs.bytes, packets-fs.packets
+	if deltaBytes < 0 || deltaPackets < 0 {
+		deltaBytes, deltaPackets = bytes, packets
+	}
+	fs.bytes, fs.packets, fs.lastSeen = bytes, packets, d.interval
+
+	if f.Expired {
+		delete(ns.flows, f.UUID)
+	}
+
+	ns.current.bytes += float64(deltaBytes)
+	ns.current.packets += float64(deltaPackets)
+
+	if f.Network == nil {
+		return
+	}
+
+	peer := peerKey(f)
+	ns.activePeers[peer] = true
+
+	if _, known := ns.knownPeers[peer]; !known && !seen && ns.bytes.Samples >= d.opts.Warmup {
+		anomalies = append(anomalies, &Anomaly{
+			Type:      NewPeerAnomaly,
+			NodeTID:   f.NodeTID,
+			Peer:      peer,
+			Timestamp: f.Last,
+		})
+	}
+	ns.knownPeers[peer] = d.interval
+
+	if !seen && isPortScanCandidate(f) {
+		scan := f.Network.A + "->" + f.Network.B
+		ports, ok := ns.scans[scan]
+		if !ok {
+			ports = make(map[string]bool)
+			ns.scans[scan] = ports
+		}
+		ports[f.Transport.B] = true
+
+		// reported once per interval
+		if len(ports) == d.opts.PortScanThreshold {
+			anomalies = append(anomalies, &Anomaly{
+				Type:      PortScanAnomaly,
+				NodeTID:   f.NodeTID,
+				Peer:      scan,
+				Value:     float64(len(ports)),
+				Timestamp: f.Last,
+			})
+		}
+	}
+
+	key := tupleKey(f)
+	ts, ok := ns.tuples[key]
+	if !ok {
+		ts = &tupleState{}
+		ns.tuples[key] = ts
+	}
+	ts.current.bytes += float64(deltaBytes)
+	ts.current.packets += float64(deltaPackets)
+	ts.lastSeen = d.interval
+
+	return
+}
+
+// ProcessFlows updates the current interval with flow updates
+func (d *Detector) ProcessFlows(flows []*flow.Flow) {
+	var anomalies []*Anomaly
+
+	d.Lock()
+	for _, f := range flows {
+		anomalies = append(anomalies, d.processFlow(f)...)
+	}
+	d.Unlock()
+
+	d.notify(anomalies)
+}
+
+func (d *Detector) checkSpike(e *EWMA, value float64, anomaly *Anomaly) *Anomaly {
+	var spike *Anomaly
+	if e.IsSpike(value, d.opts.Threshold, d.opts.Warmup) {
+		anomaly.Type = SpikeAnomaly
+		anomaly.Value = value
+		anomaly.Baseline = e.Mean
+		spike = anomaly
+	}
+	e.Update(value, d.opts.Alpha)
+	return spike
+}
+
+func (d *Detector) closeNodeInterval(tid string, ns *nodeState, now int64) (anomalies []*Anomaly) {
+	values := []struct {
+		metric string
+		ewma   *EWMA
+		value  float64
+	}{
+		{"Bytes", &ns.bytes, ns.current.bytes},
+		{"Packets", &ns.packets, ns.current.packets},
+		{"Connections", &ns.connections, ns.newFlows},
+		{"Peers", &ns.peers, float64(len(ns.activePeers))},
+	}
+
+	for _, v := range values {
+		if a := d.checkSpike(v.ewma, v.value, &Anomaly{NodeTID: tid, Metric: v.metric, Timestamp: now}); a != nil {
+			anomalies = append(anomalies, a)
+		}
+	}
+
+	for key, ts := range ns.tuples {
+		if d.interval-ts.lastSeen > d.opts.IdleTTL {
+			delete(ns.tuples, key)
+			continue
+		}
+
+		if a := d.checkSpike(&ts.bytes, ts.current.bytes, &Anomaly{NodeTID: tid, Tuple: key, Metric: "Bytes", Timestamp: now}); a != nil {
+			anomalies = append(anomalies, a)
+		}
+		if a := d.checkSpike(&ts.packets, ts.current.packets, &Anomaly{NodeTID: tid, Tuple: key, Metric: "Packets", Timestamp: now}); a != nil {
+			anomalies = append(anomalies, a)
+		}
+		ts.current = counters{}
+	}
+
+	for uuid, fs := range ns.flows {
+		if d.interval-fs.lastSeen > d.opts.IdleTTL {
+			delete(ns.flows, uuid)
+		}
+	}
+
+	for peer, lastSeen := range ns.knownPeers {
+		if d.interval-lastSeen > d.opts.PeerTTL {
+			delete(ns.knownPeers, peer)
+		}
+	}
+
+	ns.current = counters{}
+	ns.newFlows = 0
+	ns.activePeers = make(map[string]bool)
+	ns.scans = make(map[string]map[string]bool)
+
+	return
+}
+
+// closeInterval compares the values of the current interval to the
+// baselines, updates them and starts a new interval
+func (d *Detector) closeInterval(now int64) {
+	var anomalies []*Anomaly
+
+	d.Lock()
+	for tid, ns := range d.nodes {
+		anomalies = append(anomalies, d.closeNodeInterval(tid, ns, now)...)
+
+		if len(ns.flows) == 0 && len(ns.tuples) == 0 {
+			delete(d.nodes, tid)
+		}
+	}
+	d.interval++
+	d.Unlock()
+
+	d.notify(anomalies)
+}
+
+func (d *Detector) notify(anomalies []*Anomaly) {
+	if len(anomalies) == 0 {
+		return
+	}
+
+	d.RLock()
+	defer d.RUnlock()
+
+	for _, a := range anomalies {
+		logging.GetLogger().Infof("%s anomaly detected on %s: %+v", a.Type, a.NodeTID, a)
+		anomaliesDetected.WithLabelValues(a.Type).Inc()
+
+		for _, l := range d.listeners {
+			l.OnAnomaly(a)
+		}
+	}
+}
+
+func (d *Detector) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			d.closeInterval(common.UnixMillis(now))
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+func (d *Detector) Start() {
+	if atomic.CompareAndSwapInt64(&d.state, common.StoppedState, common.RunningState) {
+		d.wg.Add(1)
+		go d.run()
+	}
+}
+
+func (d *Detector) Stop() {
+	if atomic.CompareAndSwapInt64(&d.state, common.RunningState, common.StoppedState) {
+		d.quit <- true
+		d.wg.Wait()
+	}
+}
+
+func NewDetector(opts DetectorOpts) *Detector {
+	return &Detector{
+		opts:  opts,
+		nodes: make(map[string]*nodeState),
+		quit:  make(chan bool),
+		state: common.StoppedState,
+	}
+}
+
+// NewDetectorFromConfig returns the anomaly detector defined in the
+// configuration, nil is returned if the detection is disabled.
+func NewDetectorFromConfig() *Detector {
+	cfg := config.GetConfig()
+	if !cfg.GetBool("analyzer.anomaly.enabled") {
+		return nil
+	}
+
+	interval := cfg.GetInt("analyzer.anomaly.interval")
+	if interval <= 0 {
+		interval = 60
+	}
+
+	return NewDetector(DetectorOpts{
+		Interval:          time.Duration(interval) * time.Second,
+		Alpha:             cfg.GetFloat64("analyzer.anomaly.alpha"),
+		Threshold:         cfg.GetFloat64("analyzer.anomaly.threshold"),
+		Warmup:            int64(cfg.GetInt("analyzer.anomaly.warmup")),
+		PortScanThreshold: cfg.GetInt("analyzer.anomaly.port_scan_threshold"),
+		PeerTTL:           int64(cfg.GetInt("analyzer.anomaly.peer_ttl") / interval),
+		IdleTTL:           int64(cfg.GetInt("analyzer.anomaly.idle_ttl") / interval),
+	})
+}
+
+func init() {
+	prometheus.MustRegister(anomaliesDetected)
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package anomaly
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+)
+
+type anomalyCollector struct {
+	anomalies []*Anomaly
+}
+
+func (c *anomalyCollector) OnAnomaly(a *Anomaly) {
+	c.anomalies = append(c.anomalies, a)
+}
+
+func (c *anomalyCollector) byType(kind string) (anomalies []*Anomaly) {
+	for _, a := range c.anomalies {
+		if a.Type == kind {
+			anomalies = append(anomalies, a)
+		}
+	}
+	return
+}
+
+func newTestDetector(warmup int64) (*Detector, *anomalyCollector) {
+	d := NewDetector(DetectorOpts{
+		Interval:          time.Minute,
+		Alpha:             0.3,
+		Threshold:         3,
+		Warmup:            warmup,
+		PortScanThreshold: 20,
+		PeerTTL:           100,
+		IdleTTL:           100,
+	})
+
+	collector := &anomalyCollector{}
+	d.AddListener(collector)
+
+	return d, collector
+}
+
+func scanFlows(from, to int) (flows []*flow.Flow) {
+	for port := from; port < to; port++ {
+		flows = append(flows, &flow.Flow{
+			UUID:      fmt.Sprintf("scan-%d", port),
+			NodeTID:   "probe-tid",
+			Network:   &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "10.0.0.1", B: "10.0.0.2"},
+			Transport: &flow.FlowLayer{Protocol: flow.FlowProtocol_TCPPORT, A: "54321", B: strconv.Itoa(port)},
+			Metric:    &flow.FlowMetric{ABPackets: 1, ABBytes: 66},
+		})
+	}
+	return
+}
+
+func TestEWMA(t *testing.T) {
+	var e EWMA
+	for i := 0; i < 10; i++ {
+		e.Update(100, 0.3)
+	}
+
+	if e.Mean != 100 || e.Variance != 0 {
+		t.Errorf("Wrong EWMA of a constant value: %+v", e)
+	}
+
+	if e.IsSpike(110, 3, 5) {
+		t.Error("A small change should not be a spike")
+	}
+
+	if !e.IsSpike(200, 3, 5) {
+		t.Error("A doubled value should be a spike")
+	}
+
+	if e.IsSpike(200, 3, 20) {
+		t.Error("No spike should be reported during the warmup")
+	}
+}
+
+func TestPortScan(t *testing.T) {
+	d, collector := newTestDetector(3)
+
+	d.ProcessFlows(scanFlows(1, 51))
+
+	scans := collector.byType(PortScanAnomaly)
+	if len(scans) != 1 {
+		t.Fatalf("Expected one port scan, got: %+v", scans)
+	}
+
+	if scans[0].Peer != "10.0.0.1->10.0.0.2" || scans[0].NodeTID != "probe-tid" {
+		t.Errorf("Wrong port scan reported: %+v", scans[0])
+	}
+
+	d.closeInterval(common.UnixMillis(time.Now()))
+
+	d.ProcessFlows(scanFlows(51, 70))
+	if scans := collector.byType(PortScanAnomaly); len(scans) != 1 {
+		t.Errorf("Ports below the threshold should not be reported as a scan, got: %+v", scans)
+	}
+}
+
+func TestConnectionSpike(t *testing.T) {
+	d, collector := newTestDetector(5)
+
+	now := time.Now()
+	ft := flow.NewTable(nil, nil, flow.NewFlowEnhancerPipeline())
+
+	var seed int64
+	for i := 0; i < 10; i++ {
+		d.ProcessFlows(flow.GenerateTestFlows(t, ft, seed, "probe-tid", now))
+		d.closeInterval(common.UnixMillis(now))
+		seed++
+	}
+
+	for _, a := range collector.byType(SpikeAnomaly) {
+		if a.Metric == "Connections" {
+			t.Fatalf("Steady connection rate reported as a spike: %+v", a)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		d.ProcessFlows(flow.GenerateTestFlows(t, ft, seed, "probe-tid", now))
+		seed++
+	}
+	d.closeInterval(common.UnixMillis(now))
+
+	var spike *Anomaly
+	for _, a := range collector.byType(SpikeAnomaly) {
+		if a.Metric == "Connections" && a.Tuple == "" {
+			spike = a
+		}
+	}
+
+	if spike == nil {
+		t.Fatalf("Connection spike not detected, got: %+v", collector.anomalies)
+	}
+
+	if spike.Value != 50 || spike.Baseline != 10 {
+		t.Errorf("Wrong connection spike: %+v", spike)
+	}
+}
+
+func TestNewPeerFromPCAP(t *testing.T) {
+	d, collector := newTestDetector(2)
+
+	warmupFlows := flow.FlowsFromPCAPFile(t, "../flow/pcaptraces/ping-with-without-ethernet.pcap", "probe-tid")
+	for i := 0; i < 3; i++ {
+		d.ProcessFlows(warmupFlows)
+		d.closeInterval(common.UnixMillis(time.Now()))
+	}
+
+	if peers := collector.byType(NewPeerAnomaly); len(peers) != 0 {
+		t.Fatalf("No new peer expected during the warmup, got: %+v", peers)
+	}
+
+	known := make(map[string]bool)
+	for _, f := range warmupFlows {
+		if f.Network != nil {
+			known[peerKey(f)] = true
+		}
+	}
+
+	flows := flow.FlowsFromPCAPFile(t, "../flow/pcaptraces/eth-ip4-arp-dns-req-http-google.pcap", "probe-tid")
+
+	expected := make(map[string]bool)
+	for _, f := range flows {
+		if f.Network != nil && !known[peerKey(f)] {
+			expected[peerKey(f)] = true
+		}
+	}
+
+	if len(expected) == 0 {
+		t.Fatal("The PCAP file should contain new peers")
+	}
+
+	d.ProcessFlows(flows)
+
+	reported := make(map[string]bool)
+	for _, a := range collector.byType(NewPeerAnomaly) {
+		if reported[a.Peer] {
+			t.Errorf("Peer reported twice: %s", a.Peer)
+		}
+		reported[a.Peer] = true
+	}
+
+	if len(reported) != len(expected) {
+		t.Errorf("Expected new peers %v, got %v", expected, reported)
+	}
+	for peer := range expected {
+		if !reported[peer] {
+			t.Errorf("New peer %s not reported", peer)
+		}
+	}
+
+	count := len(collector.anomalies)
+	d.ProcessFlows(flows)
+	if len(collector.anomalies) != count {
+		t.Errorf("Known peers should not be reported again: %+v", collector.anomalies[count:])
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package anomaly
+
+import "math"
+
+// EWMA is an exponentially weighted moving average of a value and of its
+// variance, used as the baseline of the value.
+type EWMA struct {
+	Mean     float64
+	Variance float64
+	Samples  int64
+}
+
+// Update adds a sample, alpha being the weight of the new sample
+func (e *EWMA) Update(value float64, alpha float64) {
+	if e.Samples == 0 {
+		e.Mean = value
+	} else {
+		diff := value - e.Mean
+		incr := alpha * diff
+		e.Mean += incr
+		e.Variance = (1 - alpha) * (e.Variance + diff*incr)
+	}
+	e.Samples++
+}
+
+// Deviation returns the standard deviation of the value
+func (e *EWMA) Deviation() float64 {
+	return math.Sqrt(e.Variance)
+}
+
+// IsSpike returns whether the value is more than threshold deviations above
+// the mean once warmup samples have been seen. The deviation is at least 10%
+// of the mean so that small changes of steady values are not reported.
+func (e *EWMA) IsSpike(value float64, threshold float64, warmup int64) bool {
+	if e.Samples < warmup {
+		return false
+	}
+
+	deviation := math.Max(e.Deviation(), 0.1*e.Mean)
+	return value > e.Mean+threshold*deviation+1
+}
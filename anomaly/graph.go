@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package anomaly
+
+import (
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// GraphListener reports the last anomaly detected on a capture node as
+// metadata of the node so that it can be used by alerts, for instance
+// G.V().Has('Anomaly/Type', 'PortScan')
+type GraphListener struct {
+	Graph *graph.Graph
+}
+
+func (l *GraphListener) OnAnomaly(a *Anomaly) {
+	l.Graph.Lock()
+	defer l.Graph.Unlock()
+
+	node := l.Graph.LookupFirstNode(graph.Metadata{"TID": a.NodeTID})
+	if node == nil {
+		return
+	}
+
+	tr := l.Graph.StartMetadataTransaction(node)
+	tr.AddMetadata("Anomaly/Type", a.Type)
+	tr.AddMetadata("Anomaly/Tuple", a.Tuple)
+	tr.AddMetadata("Anomaly/Peer", a.Peer)
+	tr.AddMetadata("Anomaly/Metric", a.Metric)
+	tr.AddMetadata("Anomaly/Value", a.Value)
+	tr.AddMetadata("Anomaly/Baseline", a.Baseline)
+	tr.AddMetadata("Anomaly/Timestamp", a.Timestamp)
+	tr.Commit()
+}
+
+func NewGraphListener(g *graph.Graph) *GraphListener {
+	return &GraphListener{Graph: g}
+}
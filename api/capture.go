@@ -34,14 +34,22 @@ import (
 )
 
 type Capture struct {
-	UUID         string
-	GremlinQuery string `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
-	BPFFilter    string `json:"BPFFilter,omitempty"`
-	Name         string `json:"Name,omitempty"`
-	Description  string `json:"Description,omitempty"`
-	Type         string `json:"Type,omitempty"`
-	Count        int    `json:"Count,omitempty"`
-	PCAPSocket   string `json:"PCAPSocket,omitempty"`
+	UUID             string
+	GremlinQuery     string `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
+	BPFFilter        string `json:"BPFFilter,omitempty"`
+	Name             string `json:"Name,omitempty"`
+	Description      string `json:"Description,omitempty"`
+	Type             string `json:"Type,omitempty"`
+	Count            int    `json:"Count,omitempty"`
+	PCAPSocket       string `json:"PCAPSocket,omitempty"`
+	AppDissection    bool   `json:"AppDissection,omitempty"`
+	SamplingRate     int    `json:"SamplingRate,omitempty" valid:"min=0"`
+	FlowSamplingRate int    `json:"FlowSamplingRate,omitempty" valid:"min=0"`
+	MaxPPS           int    `json:"MaxPPS,omitempty" valid:"min=0"`
+	RingPackets      int    `json:"RingPackets,omitempty" valid:"min=0"`
+	RingBytes        int    `json:"RingBytes,omitempty" valid:"min=0"`
+	Recording        bool   `json:"Recording,omitempty"`
+	RecordingSnapLen int    `json:"RecordingSnapLen,omitempty" valid:"min=0"`
 }
 
 type CaptureResourceHandler struct {
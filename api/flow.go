@@ -0,0 +1,153 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+var trackingIDRegexp = regexp.MustCompile("^[a-zA-Z0-9-]+$")
+
+type FlowAPI struct {
+	gremlinParser *traversal.GremlinTraversalParser
+	tableClient   *flow.TableClient
+}
+
+func (f *FlowAPI) conversation(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	trackingID := mux.Vars(&r.Request)["trackingid"]
+	if !trackingIDRegexp.MatchString(trackingID) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid TrackingID: %s", trackingID))
+		return
+	}
+
+	query := fmt.Sprintf("G.Flows().Has('TrackingID', '%s').Conversation()", trackingID)
+	ts, err := f.gremlinParser.Parse(strings.NewReader(query), true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	res, err := ts.Exec()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	values := res.Values()
+	if len(values) == 0 || values[0] == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No flow found for TrackingID: %s", trackingID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(values[0]); err != nil {
+		panic(err)
+	}
+}
+
+// flowPCAP returns the last packets of a flow kept by the agent capturing it
+func (f *FlowAPI) flowPCAP(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	uuid := mux.Vars(&r.Request)["uuid"]
+
+	flowset, err := f.tableClient.LookupFlows(filters.SearchQuery{Filter: filters.NewTermStringFilter("UUID", uuid)})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(flowset.Flows) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Flow not found: %s", uuid))
+		return
+	}
+
+	g := f.gremlinParser.Graph
+	g.RLock()
+	node := g.LookupFirstNode(graph.Metadata{"TID": flowset.Flows[0].NodeTID})
+	var host string
+	if node != nil {
+		host = node.Host()
+	}
+	g.RUnlock()
+
+	if host == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Capture node of flow %s not found", uuid))
+		return
+	}
+
+	pcap, err := f.tableClient.LookupFlowPCAP(host, uuid)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == flow.ErrNoPackets {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.pcap", uuid))
+	w.WriteHeader(http.StatusOK)
+	w.Write(pcap)
+}
+
+func (f *FlowAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "FlowConversation",
+			Method:      "GET",
+			Path:        "/api/flow/conversation/{trackingid}",
+			HandlerFunc: f.conversation,
+		},
+		{
+			Name:        "FlowPCAP",
+			Method:      "GET",
+			Path:        "/api/flow/{uuid}/pcap",
+			HandlerFunc: f.flowPCAP,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+func RegisterFlowAPI(r *shttp.Server, parser *traversal.GremlinTraversalParser, tableClient *flow.TableClient) {
+	f := &FlowAPI{
+		gremlinParser: parser,
+		tableClient:   tableClient,
+	}
+
+	f.registerEndpoints(r)
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	shttp "github.com/skydive-project/skydive/http"
+)
+
+// RecordingAPI exposes the recording files of the captures running on an
+// agent
+type RecordingAPI struct {
+	path string
+}
+
+func validRecordingName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name && !strings.ContainsAny(name, `/\`)
+}
+
+func (ra *RecordingAPI) recordingList(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	capture := mux.Vars(&r.Request)["capture"]
+	if !validRecordingName(capture) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid capture: %s", capture))
+		return
+	}
+
+	files, err := flow.ListRecordingFiles(ra.path, capture)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("No recording for capture: %s", capture))
+		} else {
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(files); err != nil {
+		panic(err)
+	}
+}
+
+func (ra *RecordingAPI) recordingGet(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	vars := mux.Vars(&r.Request)
+	capture, file := vars["capture"], vars["file"]
+	if !validRecordingName(capture) || !validRecordingName(file) || !strings.HasSuffix(file, flow.RecordingExt) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid recording: %s/%s", capture, file))
+		return
+	}
+
+	path := filepath.Join(flow.RecordingDir(ra.path, capture), file)
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Recording not found: %s/%s", capture, file))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file))
+	http.ServeFile(w, &r.Request, path)
+}
+
+func (ra *RecordingAPI) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			Name:        "RecordingList",
+			Method:      "GET",
+			Path:        "/api/recording/{capture}",
+			HandlerFunc: ra.recordingList,
+		},
+		{
+			Name:        "RecordingGet",
+			Method:      "GET",
+			Path:        "/api/recording/{capture}/{file}",
+			HandlerFunc: ra.recordingGet,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+func RegisterRecordingAPI(r *shttp.Server) {
+	ra := &RecordingAPI{
+		path: config.GetConfig().GetString("agent.flow.recording.path"),
+	}
+
+	ra.registerEndpoints(r)
+}
@@ -39,6 +39,14 @@ var (
 	captureName        string
 	captureDescription string
 	captureType        string
+	appDissection      bool
+	samplingRate       int
+	flowSamplingRate   int
+	maxPPS             int
+	ringPackets        int
+	ringBytes          int
+	recording          bool
+	recordingSnapLen   int
 	nodeTID            string
 )
 
@@ -71,6 +79,14 @@ var CaptureCreate = &cobra.Command{
 		capture.Name = captureName
 		capture.Description = captureDescription
 		capture.Type = captureType
+		capture.AppDissection = appDissection
+		capture.SamplingRate = samplingRate
+		capture.FlowSamplingRate = flowSamplingRate
+		capture.MaxPPS = maxPPS
+		capture.RingPackets = ringPackets
+		capture.RingBytes = ringBytes
+		capture.Recording = recording
+		capture.RecordingSnapLen = recordingSnapLen
 		if err := validator.Validate(capture); err != nil {
 			logging.GetLogger().Fatalf(err.Error())
 		}
@@ -163,6 +179,14 @@ func addCaptureFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVarP(&captureName, "name", "", "", "capture name")
 	cmd.Flags().StringVarP(&captureDescription, "description", "", "", "capture description")
 	cmd.Flags().StringVarP(&captureType, "type", "", "", helpText)
+	cmd.Flags().BoolVarP(&appDissection, "app-dissection", "", false, "extract HTTP/TLS information from the first packets of flows")
+	cmd.Flags().IntVarP(&samplingRate, "sampling-rate", "", 0, "process 1 packet out of N, metrics are scaled accordingly")
+	cmd.Flags().IntVarP(&flowSamplingRate, "flow-sampling-rate", "", 0, "track 1 new flow out of N")
+	cmd.Flags().IntVarP(&maxPPS, "max-pps", "", 0, "maximum number of packets processed per second")
+	cmd.Flags().IntVarP(&ringPackets, "ring-packets", "", 0, "number of last packets kept per flow for pcap download")
+	cmd.Flags().IntVarP(&ringBytes, "ring-bytes", "", 0, "number of last bytes kept per flow for pcap download")
+	cmd.Flags().BoolVarP(&recording, "recording", "", false, "record the captured packets to rotating pcapng files on the agent")
+	cmd.Flags().IntVarP(&recordingSnapLen, "recording-snaplen", "", 0, "number of bytes recorded per packet, 65536 by default")
 }
 
 func init() {
@@ -60,12 +60,47 @@ func init() {
 	cfg.SetDefault("analyzer.listen", "127.0.0.1:8082")
 	cfg.SetDefault("analyzer.flowtable_expire", 600)
 	cfg.SetDefault("analyzer.flowtable_update", 60)
+	cfg.SetDefault("analyzer.anomaly.enabled", false)
+	cfg.SetDefault("analyzer.anomaly.interval", 60)
+	cfg.SetDefault("analyzer.anomaly.alpha", 0.3)
+	cfg.SetDefault("analyzer.anomaly.threshold", 3)
+	cfg.SetDefault("analyzer.anomaly.warmup", 10)
+	cfg.SetDefault("analyzer.anomaly.port_scan_threshold", 100)
+	cfg.SetDefault("analyzer.anomaly.peer_ttl", 86400)
+	cfg.SetDefault("analyzer.anomaly.idle_ttl", 600)
+	cfg.SetDefault("analyzer.flow.reverse_dns.enabled", false)
+	cfg.SetDefault("analyzer.flow.reverse_dns.timeout", 2)
+	cfg.SetDefault("analyzer.flow.reverse_dns.expire", 3600)
+	cfg.SetDefault("analyzer.flow.reverse_dns.workers", 4)
+	cfg.SetDefault("analyzer.flow.geoip.country_database", "")
+	cfg.SetDefault("analyzer.flow.geoip.asn_database", "")
+	cfg.SetDefault("analyzer.sink.type", "")
+	cfg.SetDefault("analyzer.sink.encoding", "json")
+	cfg.SetDefault("analyzer.sink.topology_topic", "skydive.topology")
+	cfg.SetDefault("analyzer.sink.flow_topic", "skydive.flows")
+	cfg.SetDefault("analyzer.sink.queue_size", 10000)
+	cfg.SetDefault("analyzer.sink.kafka.rest_proxy", "http://localhost:8084")
+	cfg.SetDefault("analyzer.sink.kafka.timeout", 5)
+	cfg.SetDefault("analyzer.sink.nats.addr", "localhost:4222")
+	cfg.SetDefault("analyzer.sink.nats.timeout", 5)
 	cfg.SetDefault("storage.elasticsearch.host", "127.0.0.1:9200")
 	cfg.SetDefault("storage.elasticsearch.maxconns", 10)
 	cfg.SetDefault("storage.elasticsearch.retry", 60)
 	cfg.SetDefault("storage.elasticsearch.bulk_maxdocs", 0)
+	cfg.SetDefault("storage.elasticsearch.retention.flow", 0)
+	cfg.SetDefault("storage.elasticsearch.retention.metric", 0)
+	cfg.SetDefault("storage.elasticsearch.retention.metric_1m", 0)
+	cfg.SetDefault("storage.elasticsearch.retention.metric_1h", 0)
+	cfg.SetDefault("storage.elasticsearch.rollup.minute_after", 0)
+	cfg.SetDefault("storage.elasticsearch.rollup.hour_after", 0)
+	cfg.SetDefault("storage.elasticsearch.rollup.interval", 300)
 	cfg.SetDefault("ws_pong_timeout", 5)
 	cfg.SetDefault("docker.url", "unix:///var/run/docker.sock")
+	cfg.SetDefault("k8s.url", "http://localhost:8080")
+	cfg.SetDefault("k8s.token_file", "")
+	cfg.SetDefault("k8s.ca_file", "")
+	cfg.SetDefault("ovn.northbound", "tcp://127.0.0.1:6641")
+	cfg.SetDefault("ovn.southbound", "tcp://127.0.0.1:6642")
 	cfg.SetDefault("netns.run_path", "/var/run/netns")
 	cfg.SetDefault("etcd.data_dir", "/var/lib/skydive/etcd")
 	cfg.SetDefault("etcd.embedded", true)
@@ -76,15 +111,35 @@ func init() {
 	cfg.SetDefault("storage.orientdb.database", "Skydive")
 	cfg.SetDefault("storage.orientdb.username", "root")
 	cfg.SetDefault("storage.orientdb.password", "root")
+	cfg.SetDefault("storage.influxdb.addr", "http://localhost:8086")
+	cfg.SetDefault("storage.influxdb.database", "skydive")
+	cfg.SetDefault("storage.influxdb.username", "")
+	cfg.SetDefault("storage.influxdb.password", "")
 	cfg.SetDefault("openstack.endpoint_type", "public")
 	cfg.SetDefault("agent.topology.probes", []string{"netlink", "netns"})
 	cfg.SetDefault("agent.topology.netlink.metrics_update", 30)
+	cfg.SetDefault("agent.topology.netlink.routing_tables", []string{})
+	cfg.SetDefault("agent.topology.netfilter.update", 30)
+	cfg.SetDefault("agent.topology.lldp.interfaces", []string{})
+	cfg.SetDefault("agent.topology.tc.update", 30)
+	cfg.SetDefault("agent.topology.openflow.update", 30)
 	cfg.SetDefault("agent.flow.pcapsocket.bind_address", "127.0.0.1")
 	cfg.SetDefault("agent.flow.pcapsocket.min_port", 8100)
 	cfg.SetDefault("agent.flow.pcapsocket.max_port", 8132)
 	cfg.SetDefault("analyzer.topology.probes", []string{})
 	cfg.SetDefault("opencontrail.mpls_udp_port", 51234)
 	cfg.SetDefault("agent.flow.stats_update", 1)
+	cfg.SetDefault("agent.flow.app_dissection_packets", 10)
+	cfg.SetDefault("agent.flow.process_attribution", false)
+	cfg.SetDefault("agent.flow.max_flows", 0)
+	cfg.SetDefault("agent.flow.table_max_flows", 0)
+	cfg.SetDefault("agent.flow.eviction_policy", "lru")
+	cfg.SetDefault("agent.flow.table_shards", 1)
+	cfg.SetDefault("agent.flow.recording.path", "/var/lib/skydive/recordings")
+	cfg.SetDefault("agent.flow.recording.max_size", 100)
+	cfg.SetDefault("agent.flow.recording.max_age", 3600)
+	cfg.SetDefault("agent.flow.recording.max_files", 10)
+	cfg.SetDefault("agent.flow.recording.retention", 0)
 	cfg.SetDefault("cache.expire", 300)
 	cfg.SetDefault("cache.cleanup", 30)
 
@@ -125,6 +180,27 @@ func checkConfig() error {
 		return err
 	}
 
+	// InfluxDB only keeps the flow metrics, flows can't be searched from it
+	if cfg.GetString("analyzer.storage") == "influxdb" {
+		return errors.New("influxdb can only be used as analyzer.metrics_storage")
+	}
+
+	switch backend := cfg.GetString("analyzer.metrics_storage"); backend {
+	case "":
+	case "influxdb":
+		if cfg.GetString("analyzer.storage") == "" {
+			return errors.New("analyzer.metrics_storage requires a flow storage, analyzer.storage")
+		}
+	default:
+		return fmt.Errorf("invalid value for analyzer.metrics_storage (%s)", backend)
+	}
+
+	switch policy := cfg.GetString("agent.flow.eviction_policy"); policy {
+	case "lru", "oldest":
+	default:
+		return fmt.Errorf("invalid value for agent.flow.eviction_policy (%s)", policy)
+	}
+
 	return nil
 }
 
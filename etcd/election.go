@@ -28,6 +28,7 @@ import (
 	"time"
 
 	etcd "github.com/coreos/etcd/client"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
 
 	"github.com/skydive-project/skydive/common"
@@ -39,6 +40,16 @@ const (
 	timeout = time.Second * 30
 )
 
+var electionState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "skydive",
+		Subsystem: "etcd",
+		Name:      "election_master",
+		Help:      "Whether this host is the master of the election, 1 for master, 0 for follower",
+	},
+	[]string{"election"},
+)
+
 type EtcdMasterElectionListener interface {
 	OnMaster()
 	OnSlave()
@@ -112,11 +123,13 @@ func (le *EtcdMasterElector) start(first chan struct{}) {
 
 		go le.holdLock(quit)
 
+		electionState.WithLabelValues(le.path).Set(1)
 		for _, listener := range le.listeners {
 			listener.OnMaster()
 		}
 	} else {
 		logging.GetLogger().Infof("starting as a follower: %s", le.Host)
+		electionState.WithLabelValues(le.path).Set(0)
 		for _, listener := range le.listeners {
 			listener.OnSlave()
 		}
@@ -156,6 +169,7 @@ func (le *EtcdMasterElector) start(first chan struct{}) {
 				go le.holdLock(quit)
 
 				logging.GetLogger().Infof("I'm now the master: %s", le.Host)
+				electionState.WithLabelValues(le.path).Set(1)
 				for _, listener := range le.listeners {
 					listener.OnMaster()
 				}
@@ -167,6 +181,7 @@ func (le *EtcdMasterElector) start(first chan struct{}) {
 
 			if !master {
 				logging.GetLogger().Infof("The master is now: %s", resp.Node.Value)
+				electionState.WithLabelValues(le.path).Set(0)
 				for _, listener := range le.listeners {
 					listener.OnSlave()
 				}
@@ -196,6 +211,8 @@ func (le *EtcdMasterElector) Stop() {
 	if atomic.CompareAndSwapInt64(&le.state, common.RunningState, common.StoppingState) {
 		le.cancel()
 		le.wg.Wait()
+
+		electionState.DeleteLabelValues(le.path)
 	}
 }
 
@@ -216,3 +233,7 @@ func NewEtcdMasterElectorFromConfig(serviceType common.ServiceType, key string,
 	host := config.GetConfig().GetString("host_id")
 	return NewEtcdMasterElector(host, serviceType, key, etcdClient)
 }
+
+func init() {
+	prometheus.MustRegister(electionState)
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+)
+
+type AggregationType int
+
+const (
+	SumAggregation AggregationType = 1 + iota
+	CountAggregation
+	TopKAggregation
+	HistogramAggregation
+)
+
+var ErrInvalidAggregation = errors.New("Invalid flow aggregation")
+
+// Aggregation describes an aggregation of the values of a field of flows,
+// optionally grouped by the value of another field. K is the number of
+// groups kept by a TopK, Interval the width of the Histogram buckets.
+type Aggregation struct {
+	GroupBy  string
+	Type     AggregationType
+	Field    string
+	K        int64
+	Interval int64
+}
+
+// AggregationBucket is an entry of a TopK or of an Histogram
+type AggregationBucket struct {
+	Key   interface{}
+	Value float64
+}
+
+type bucketsByValue []*AggregationBucket
+
+func (b bucketsByValue) Len() int {
+	return len(b)
+}
+
+func (b bucketsByValue) Less(i, j int) bool {
+	return b[i].Value > b[j].Value
+}
+
+func (b bucketsByValue) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+type bucketsByKey []*AggregationBucket
+
+func (b bucketsByKey) Len() int {
+	return len(b)
+}
+
+func (b bucketsByKey) Less(i, j int) bool {
+	return b[i].Key.(int64) < b[j].Key.(int64)
+}
+
+func (b bucketsByKey) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+// TopKBuckets returns the k buckets with the highest values
+func TopKBuckets(values map[string]float64, k int64) []*AggregationBucket {
+	var buckets []*AggregationBucket
+	for key, value := range values {
+		buckets = append(buckets, &AggregationBucket{Key: key, Value: value})
+	}
+	sort.Stable(bucketsByValue(buckets))
+
+	if k >= 0 && int64(len(buckets)) > k {
+		buckets = buckets[:k]
+	}
+	return buckets
+}
+
+// HistogramBuckets returns the buckets of an histogram sorted by key
+func HistogramBuckets(counts map[int64]float64) []*AggregationBucket {
+	buckets := make([]*AggregationBucket, 0, len(counts))
+	for key, count := range counts {
+		buckets = append(buckets, &AggregationBucket{Key: key, Value: count})
+	}
+	sort.Sort(bucketsByKey(buckets))
+	return buckets
+}
+
+func (f *Flow) groupKey(field string) (string, bool) {
+	if s, err := f.GetFieldString(field); err == nil {
+		return s, true
+	}
+	if i, err := f.GetFieldInt64(field); err == nil {
+		return strconv.FormatInt(i, 10), true
+	}
+	return "", false
+}
+
+// GroupBy splits the flows according to the value of the given field, flows
+// without this field are ignored
+func (fs *FlowSet) GroupBy(field string) map[string]*FlowSet {
+	groups := make(map[string]*FlowSet)
+	for _, f := range fs.Flows {
+		key, ok := f.groupKey(field)
+		if !ok {
+			continue
+		}
+
+		group, ok := groups[key]
+		if !ok {
+			group = NewFlowSet()
+			groups[key] = group
+		}
+		group.Flows = append(group.Flows, f)
+	}
+	return groups
+}
+
+func (fs *FlowSet) sum(field string) (float64, error) {
+	var s float64
+	for _, f := range fs.Flows {
+		v, err := f.GetFieldInt64(field)
+		if err != nil {
+			return s, err
+		}
+		s += float64(v)
+	}
+	return s, nil
+}
+
+func (fs *FlowSet) histogram(field string, interval int64) map[int64]float64 {
+	counts := make(map[int64]float64)
+	for _, f := range fs.Flows {
+		// ignore flows not having the field, like the Sort
+		if v, err := f.GetFieldInt64(field); err == nil {
+			key := v - v%interval
+			if v < 0 && v%interval != 0 {
+				key -= interval
+			}
+			counts[key]++
+		}
+	}
+	return counts
+}
+
+func (fs *FlowSet) aggregate(a *Aggregation) (interface{}, error) {
+	switch a.Type {
+	case SumAggregation:
+		return fs.sum(a.Field)
+	case CountAggregation:
+		return float64(len(fs.Flows)), nil
+	case HistogramAggregation:
+		if a.Interval <= 0 {
+			return nil, errors.New("Histogram interval has to be strictly positive")
+		}
+		return HistogramBuckets(fs.histogram(a.Field, a.Interval)), nil
+	}
+	return nil, ErrInvalidAggregation
+}
+
+// Aggregate computes the aggregation on the flows of the set. Without
+// GroupBy a Sum returns a float64 and an Histogram a list of buckets. Grouped
+// aggregations return a map of the group values to the aggregated value, a
+// TopK returns the K groups with the highest sums of the field.
+func (fs *FlowSet) Aggregate(a *Aggregation) (interface{}, error) {
+	if a.GroupBy == "" {
+		if a.Type == TopKAggregation || a.Type == CountAggregation {
+			return nil, ErrInvalidAggregation
+		}
+		return fs.aggregate(a)
+	}
+
+	groups := fs.GroupBy(a.GroupBy)
+
+	if a.Type == TopKAggregation {
+		sums := make(map[string]float64, len(groups))
+		for key, group := range groups {
+			s, err := group.sum(a.Field)
+			if err != nil {
+				return nil, err
+			}
+			sums[key] = s
+		}
+		return TopKBuckets(sums, a.K), nil
+	}
+
+	values := make(map[string]interface{}, len(groups))
+	for key, group := range groups {
+		v, err := group.aggregate(a)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newAggregationFlowSet() *FlowSet {
+	return &FlowSet{
+		Flows: []*Flow{
+			{Network: &FlowLayer{A: "10.0.0.1", B: "10.0.0.2"}, Metric: &FlowMetric{ABBytes: 100}},
+			{Network: &FlowLayer{A: "10.0.0.1", B: "10.0.0.3"}, Metric: &FlowMetric{ABBytes: 1500}},
+			{Network: &FlowLayer{A: "10.0.0.2", B: "10.0.0.3"}, Metric: &FlowMetric{ABBytes: 1000}},
+			{Network: &FlowLayer{A: "10.0.0.3", B: "10.0.0.1"}, Metric: &FlowMetric{ABBytes: 50}},
+		},
+	}
+}
+
+func TestAggregateSum(t *testing.T) {
+	fs := newAggregationFlowSet()
+
+	value, err := fs.Aggregate(&Aggregation{Type: SumAggregation, Field: "Metric.ABBytes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.(float64) != 2650 {
+		t.Errorf("Wrong sum, expected 2650, got %v", value)
+	}
+
+	value, err = fs.Aggregate(&Aggregation{GroupBy: "Network.A", Type: SumAggregation, Field: "Metric.ABBytes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"10.0.0.1": float64(1600),
+		"10.0.0.2": float64(1000),
+		"10.0.0.3": float64(50),
+	}
+	if !reflect.DeepEqual(expected, value) {
+		t.Errorf("Wrong grouped sum, expected %v, got %v", expected, value)
+	}
+}
+
+func TestAggregateCount(t *testing.T) {
+	fs := newAggregationFlowSet()
+
+	if _, err := fs.Aggregate(&Aggregation{Type: CountAggregation}); err != ErrInvalidAggregation {
+		t.Errorf("Count without GroupBy should be invalid, got %v", err)
+	}
+
+	value, err := fs.Aggregate(&Aggregation{GroupBy: "Network.B", Type: CountAggregation})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"10.0.0.1": float64(1),
+		"10.0.0.2": float64(1),
+		"10.0.0.3": float64(2),
+	}
+	if !reflect.DeepEqual(expected, value) {
+		t.Errorf("Wrong grouped count, expected %v, got %v", expected, value)
+	}
+}
+
+func TestAggregateTopK(t *testing.T) {
+	fs := newAggregationFlowSet()
+
+	value, err := fs.Aggregate(&Aggregation{GroupBy: "Network.A", Type: TopKAggregation, Field: "Metric.ABBytes", K: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*AggregationBucket{
+		{Key: "10.0.0.1", Value: 1600},
+		{Key: "10.0.0.2", Value: 1000},
+	}
+	if !reflect.DeepEqual(expected, value) {
+		t.Errorf("Wrong top talkers, expected %v, got %v", expected, value)
+	}
+}
+
+func TestAggregateHistogram(t *testing.T) {
+	fs := newAggregationFlowSet()
+
+	value, err := fs.Aggregate(&Aggregation{Type: HistogramAggregation, Field: "Metric.ABBytes", Interval: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*AggregationBucket{
+		{Key: int64(0), Value: 2},
+		{Key: int64(1000), Value: 2},
+	}
+	if !reflect.DeepEqual(expected, value) {
+		t.Errorf("Wrong histogram, expected %v, got %v", expected, value)
+	}
+
+	if _, err := fs.Aggregate(&Aggregation{Type: HistogramAggregation, Field: "Metric.ABBytes"}); err == nil {
+		t.Error("Histogram without interval should fail")
+	}
+}
@@ -32,11 +32,13 @@ type TableAllocator struct {
 	sync.RWMutex
 	update   time.Duration
 	expire   time.Duration
+	maxFlows int64
+	flows    int64
 	tables   map[*Table]bool
 	pipeline *FlowEnhancerPipeline
 }
 
-func (a *TableAllocator) aggregateReplies(query *TableQuery, replies []*TableReply) *TableReply {
+func aggregateReplies(replies []*TableReply) *TableReply {
 	reply := &TableReply{
 		status: http.StatusOK,
 		Obj:    make([][]byte, 0),
@@ -66,16 +68,17 @@ func (a *TableAllocator) QueryTable(query *TableQuery) *TableReply {
 		}
 	}
 
-	return a.aggregateReplies(query, replies)
+	return aggregateReplies(replies)
 }
 
-func (a *TableAllocator) Alloc(flowCallBack ExpireUpdateFunc) *Table {
+func (a *TableAllocator) Alloc(flowCallBack ExpireUpdateFunc, opts TableOpts) *Table {
 	a.Lock()
 	defer a.Unlock()
 
 	updateHandler := NewFlowHandler(flowCallBack, a.update)
 	expireHandler := NewFlowHandler(flowCallBack, a.expire)
-	t := NewTable(updateHandler, expireHandler, a.pipeline)
+	t := NewTable(updateHandler, expireHandler, a.pipeline, opts)
+	t.setAgentLimit(&a.flows, a.maxFlows)
 	a.tables[t] = true
 
 	return t
@@ -87,10 +90,13 @@ func (a *TableAllocator) Release(t *Table) {
 	a.Unlock()
 }
 
-func NewTableAllocator(update, expire time.Duration, pipeline *FlowEnhancerPipeline) *TableAllocator {
+// NewTableAllocator creates a new table allocator, maxFlows is the maximum
+// number of flows for all the allocated tables, 0 means no limit
+func NewTableAllocator(update, expire time.Duration, maxFlows int64, pipeline *FlowEnhancerPipeline) *TableAllocator {
 	return &TableAllocator{
 		update:   update,
 		expire:   expire,
+		maxFlows: maxFlows,
 		tables:   make(map[*Table]bool),
 		pipeline: pipeline,
 	}
@@ -0,0 +1,275 @@
+/*
+ * Copyright (C) 2017 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+const (
+	tlsRecordHandshake      = 0x16
+	tlsHandshakeClientHello = 0x01
+	tlsExtServerName        = 0x0000
+	tlsExtSupportedVersion  = 0x002b
+)
+
+var httpMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"PATCH":   true,
+	"OPTIONS": true,
+	"CONNECT": true,
+	"TRACE":   true,
+}
+
+var tlsVersions = map[uint16]string{
+	0x0300: "SSL3.0",
+	0x0301: "TLS1.0",
+	0x0302: "TLS1.1",
+	0x0303: "TLS1.2",
+	0x0304: "TLS1.3",
+}
+
+func (h *HTTPLayer) GetField(field string) (string, error) {
+	if h == nil {
+		return "", common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "Method":
+		return h.Method, nil
+	case "Host":
+		return h.Host, nil
+	case "Path":
+		return h.Path, nil
+	}
+	return "", common.ErrFieldNotFound
+}
+
+func (h *HTTPLayer) GetFieldInt64(field string) (int64, error) {
+	if h == nil {
+		return 0, common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "StatusCode":
+		return h.StatusCode, nil
+	}
+	return 0, common.ErrFieldNotFound
+}
+
+func (t *TLSLayer) GetField(field string) (string, error) {
+	if t == nil {
+		return "", common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "ServerName":
+		return t.ServerName, nil
+	case "Version":
+		return t.Version, nil
+	}
+	return "", common.ErrFieldNotFound
+}
+
+// applicationDissected returns whether all the application information that
+// can be extracted has been found for this flow
+func (f *Flow) applicationDissected() bool {
+	return f.TLS != nil || (f.HTTP != nil && f.HTTP.Method != "" && f.HTTP.StatusCode != 0)
+}
+
+// dissectApplication extracts HTTP or TLS information from the payload of a
+// TCP packet. Only the bytes captured are used, so information located after
+// the snaplen of the capture won't be found.
+func (f *Flow) dissectApplication(packet *gopacket.Packet) {
+	if (*packet).Layer(layers.LayerTypeTCP) == nil {
+		return
+	}
+
+	app := (*packet).ApplicationLayer()
+	if app == nil {
+		return
+	}
+
+	payload := app.Payload()
+	if len(payload) == 0 {
+		return
+	}
+
+	if f.HTTP == nil {
+		if tls := tlsLayerFromClientHello(payload); tls != nil {
+			f.TLS = tls
+			return
+		}
+	}
+
+	f.dissectHTTP(payload)
+}
+
+func (f *Flow) dissectHTTP(payload []byte) {
+	lines := bytes.Split(payload, []byte("\r\n"))
+
+	fields := strings.SplitN(string(lines[0]), " ", 3)
+	if len(fields) < 2 {
+		return
+	}
+
+	// response status line, ex: HTTP/1.1 200 OK
+	if strings.HasPrefix(fields[0], "HTTP/1.") {
+		code, err := strconv.Atoi(fields[1])
+		if err != nil || code < 100 || code > 999 {
+			return
+		}
+
+		if f.HTTP == nil {
+			f.HTTP = &HTTPLayer{}
+		}
+		if f.HTTP.StatusCode == 0 {
+			f.HTTP.StatusCode = int64(code)
+		}
+		return
+	}
+
+	// request line, ex: GET /index.html HTTP/1.1
+	if len(fields) != 3 || !httpMethods[fields[0]] || !strings.HasPrefix(fields[2], "HTTP/1.") {
+		return
+	}
+
+	if f.HTTP == nil {
+		f.HTTP = &HTTPLayer{}
+	}
+	if f.HTTP.Method != "" {
+		// only keep the first request of the flow
+		return
+	}
+
+	path := fields[1]
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		path = path[:i]
+	}
+
+	f.HTTP.Method = fields[0]
+	f.HTTP.Path = path
+
+	for _, line := range lines[1:] {
+		if len(line) == 0 {
+			break
+		}
+
+		header := strings.SplitN(string(line), ":", 2)
+		if len(header) == 2 && strings.EqualFold(header[0], "Host") {
+			f.HTTP.Host = strings.TrimSpace(header[1])
+			break
+		}
+	}
+}
+
+func tlsVersion(v uint16) string {
+	if version, ok := tlsVersions[v]; ok {
+		return version
+	}
+	return strconv.FormatUint(uint64(v), 16)
+}
+
+// tlsLayerFromClientHello returns the TLS information of a ClientHello
+// handshake message, nil is returned if the payload is not a ClientHello.
+func tlsLayerFromClientHello(payload []byte) *TLSLayer {
+	// record header: type(1), version(2), length(2)
+	// handshake header: type(1), length(3)
+	if len(payload) < 11 || payload[0] != tlsRecordHandshake || payload[1] != 0x03 || payload[5] != tlsHandshakeClientHello {
+		return nil
+	}
+
+	hello := payload[9:]
+	version := binary.BigEndian.Uint16(hello)
+	tls := &TLSLayer{Version: tlsVersion(version)}
+
+	// client version(2), random(32)
+	offset := 34
+
+	// session id
+	if len(hello) < offset+1 {
+		return tls
+	}
+	offset += 1 + int(hello[offset])
+
+	// cipher suites
+	if len(hello) < offset+2 {
+		return tls
+	}
+	offset += 2 + int(binary.BigEndian.Uint16(hello[offset:]))
+
+	// compression methods
+	if len(hello) < offset+1 {
+		return tls
+	}
+	offset += 1 + int(hello[offset])
+
+	// extensions length
+	offset += 2
+
+	for len(hello) >= offset+4 {
+		extType := binary.BigEndian.Uint16(hello[offset:])
+		extLen := int(binary.BigEndian.Uint16(hello[offset+2:]))
+		offset += 4
+
+		if len(hello) < offset+extLen {
+			break
+		}
+		ext := hello[offset : offset+extLen]
+
+		switch extType {
+		case tlsExtServerName:
+			// list length(2), name type(1), name length(2), name
+			if len(ext) >= 5 && ext[2] == 0 {
+				if l := int(binary.BigEndian.Uint16(ext[3:])); len(ext) >= 5+l {
+					tls.ServerName = string(ext[5 : 5+l])
+				}
+			}
+		case tlsExtSupportedVersion:
+			// list length(1), versions(2 each), keep the highest known version
+			for i := 1; i+1 < len(ext) && i+1 <= int(ext[0]); i += 2 {
+				if v := binary.BigEndian.Uint16(ext[i:]); v > version {
+					if _, ok := tlsVersions[v]; ok {
+						version = v
+						tls.Version = tlsVersion(v)
+					}
+				}
+			}
+		}
+		offset += extLen
+	}
+
+	return tls
+}
@@ -24,6 +24,8 @@ package flow
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
@@ -37,6 +39,9 @@ import (
 	"github.com/skydive-project/skydive/topology"
 )
 
+// ErrNoPackets is returned when no packet has been kept for a flow
+var ErrNoPackets = errors.New("No packet kept for this flow")
+
 type TableClient struct {
 	shttp.DefaultWSServerEventHandler
 	WSServer       *shttp.WSServer
@@ -179,6 +184,48 @@ func (f *TableClient) LookupFlowsByNodes(hnmap topology.HostNodeTIDMap, flowSear
 	return flowset, nil
 }
 
+// LookupFlowPCAP returns the last packets of a flow in the pcap format. The
+// flow ring buffer is only available on the agent where the flow is captured.
+func (f *TableClient) LookupFlowPCAP(host string, uuid string) ([]byte, error) {
+	tq := TableQuery{
+		Type: "PCAPQuery",
+		Obj:  []byte(uuid),
+	}
+	msg := shttp.NewWSMessage(Namespace, "TableQuery", tq)
+
+	ch := make(chan *json.RawMessage)
+	defer close(ch)
+
+	f.replyChanMutex.Lock()
+	f.replyChan[msg.UUID] = ch
+	f.replyChanMutex.Unlock()
+
+	defer func() {
+		f.replyChanMutex.Lock()
+		delete(f.replyChan, msg.UUID)
+		f.replyChanMutex.Unlock()
+	}()
+
+	if !f.WSServer.SendWSMessageTo(msg, host) {
+		return nil, fmt.Errorf("Unable to send message to agent: %s", host)
+	}
+
+	select {
+	case raw := <-ch:
+		var reply TableReply
+		if raw == nil || json.Unmarshal([]byte(*raw), &reply) != nil {
+			return nil, fmt.Errorf("Error returned while reading TableReply from: %s", host)
+		}
+
+		if len(reply.Obj) == 0 {
+			return nil, ErrNoPackets
+		}
+		return reply.Obj[0], nil
+	case <-time.After(time.Second * 10):
+		return nil, fmt.Errorf("Timeout while reading TableReply from: %s", host)
+	}
+}
+
 func NewTableClient(w *shttp.WSServer) *TableClient {
 	tc := &TableClient{
 		WSServer:  w,
@@ -0,0 +1,181 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"sort"
+)
+
+// ConversationHop describes a flow of a conversation as seen by one capture
+// point. Deltas are computed against the previous hop, a negative packets
+// delta means that packets have been dropped between the two hops.
+type ConversationHop struct {
+	NodeTID        string
+	FlowUUID       string
+	Distance       int
+	Start          int64
+	Last           int64
+	Metric         *FlowMetric
+	ABPacketsDelta int64
+	BAPacketsDelta int64
+	ABBytesDelta   int64
+	BABytesDelta   int64
+	LatencyDelta   int64
+}
+
+// Conversation lists the capture points where a flow, identified by its
+// TrackingID, has been seen
+type Conversation struct {
+	TrackingID string
+	Hops       []*ConversationHop
+}
+
+type conversationSort struct {
+	flows     []*Flow
+	distances map[string]int
+}
+
+func (c *conversationSort) distance(f *Flow) int {
+	if d, ok := c.distances[f.NodeTID]; ok {
+		return d
+	}
+	return -1
+}
+
+func (c *conversationSort) Len() int {
+	return len(c.flows)
+}
+
+func (c *conversationSort) Swap(i, j int) {
+	c.flows[i], c.flows[j] = c.flows[j], c.flows[i]
+}
+
+func (c *conversationSort) Less(i, j int) bool {
+	di, dj := c.distance(c.flows[i]), c.distance(c.flows[j])
+	if di != dj {
+		if di == -1 || dj == -1 {
+			return dj == -1
+		}
+		return di < dj
+	}
+	return c.flows[i].Start < c.flows[j].Start
+}
+
+// hopMetric returns the metric of the flow using the same A/B orientation as
+// the reference flow
+func hopMetric(ref, f *Flow) *FlowMetric {
+	m := f.Metric
+	if m == nil {
+		m = &FlowMetric{}
+	}
+
+	if ref != nil && ref.Network != nil && f.Network != nil && ref.Network.A != f.Network.A {
+		return &FlowMetric{
+			ABPackets: m.BAPackets,
+			ABBytes:   m.BABytes,
+			BAPackets: m.ABPackets,
+			BABytes:   m.ABBytes,
+		}
+	}
+	return m
+}
+
+// NewConversation returns the conversation of the given flows. distances
+// gives the topology distance between the capture node of a flow and the
+// capture node of the first seen flow. Hops are ordered by distance then by
+// first seen time, hops with an unknown distance are put at the end.
+func NewConversation(trackingID string, flows []*Flow, distances map[string]int) *Conversation {
+	sorted := &conversationSort{
+		flows:     make([]*Flow, len(flows)),
+		distances: distances,
+	}
+	copy(sorted.flows, flows)
+	sort.Stable(sorted)
+
+	conversation := &Conversation{TrackingID: trackingID}
+
+	var first, prev *Flow
+	var prevMetric *FlowMetric
+	for _, f := range sorted.flows {
+		if first == nil {
+			first = f
+		}
+		metric := hopMetric(first, f)
+
+		hop := &ConversationHop{
+			NodeTID:  f.NodeTID,
+			FlowUUID: f.UUID,
+			Distance: sorted.distance(f),
+			Start:    f.Start,
+			Last:     f.Last,
+			Metric:   metric,
+		}
+
+		if prev != nil {
+			hop.ABPacketsDelta = metric.ABPackets - prevMetric.ABPackets
+			hop.BAPacketsDelta = metric.BAPackets - prevMetric.BAPackets
+			hop.ABBytesDelta = metric.ABBytes - prevMetric.ABBytes
+			hop.BABytesDelta = metric.BABytes - prevMetric.BABytes
+			hop.LatencyDelta = f.Start - prev.Start
+		}
+
+		conversation.Hops = append(conversation.Hops, hop)
+		prev, prevMetric = f, metric
+	}
+
+	return conversation
+}
+
+// Conversations groups the flows by TrackingID and returns the conversation
+// of each of them. distances is called with the first seen flow of each
+// conversation.
+func Conversations(flows []*Flow, distances func(first *Flow, flows []*Flow) map[string]int) []*Conversation {
+	var order []string
+	groups := make(map[string][]*Flow)
+	for _, f := range flows {
+		if _, ok := groups[f.TrackingID]; !ok {
+			order = append(order, f.TrackingID)
+		}
+		groups[f.TrackingID] = append(groups[f.TrackingID], f)
+	}
+
+	var conversations []*Conversation
+	for _, trackingID := range order {
+		group := groups[trackingID]
+
+		first := group[0]
+		for _, f := range group[1:] {
+			if f.Start < first.Start {
+				first = f
+			}
+		}
+
+		var d map[string]int
+		if distances != nil {
+			d = distances(first, group)
+		}
+		conversations = append(conversations, NewConversation(trackingID, group, d))
+	}
+
+	return conversations
+}
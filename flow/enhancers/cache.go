@@ -38,10 +38,18 @@ type tidCacheEntry struct {
 	try int
 }
 
+// tidCache is shared by the enhancers resolving the TID of the interfaces
+// of a flow, the TID found by one of them being used by the others
 type tidCache struct {
 	*cache.Cache
 }
 
+// tidCacheKey returns the key of the TID of the interface with the given MAC
+// seen from a capture node, a MAC being possibly used in several places
+func tidCacheKey(nodeTID, mac string) string {
+	return nodeTID + "/" + mac
+}
+
 func (c *tidCache) get(key string) (*tidCacheEntry, bool) {
 	if entry, f := c.Get(key); f {
 		ce := entry.(*tidCacheEntry)
@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package enhancers
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pmylund/go-cache"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/probes"
+)
+
+// ConntrackFlowEnhancer adds the conntrack state and the address translation
+// of the connection to the flows. As the state changes during the life of
+// the connection, the flows are refreshed at each update.
+type ConntrackFlowEnhancer struct {
+	Graph *graph.Graph
+	cache *cache.Cache
+	probe *probes.ConntrackProbe
+}
+
+func flowProtocol(f *flow.Flow) string {
+	if f.Transport != nil {
+		switch f.Transport.Protocol {
+		case flow.FlowProtocol_TCPPORT:
+			return "tcp"
+		case flow.FlowProtocol_UDPPORT:
+			return "udp"
+		case flow.FlowProtocol_SCTPPORT:
+			return "sctp"
+		}
+	}
+
+	if strings.Contains(f.LayersPath, "ICMPv4") {
+		return "icmp"
+	}
+	if strings.Contains(f.LayersPath, "ICMPv6") {
+		return "ipv6-icmp"
+	}
+	return ""
+}
+
+func conntrackEndpoint(ip net.IP, port int64) string {
+	if port == 0 {
+		return ip.String()
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatInt(port, 10))
+}
+
+// getNamespace returns the ID of the namespace node of the capture node
+func (cfe *ConntrackFlowEnhancer) getNamespace(tid string) (graph.Identifier, bool) {
+	key := "conntrack/" + tid
+	if cfe.cache != nil {
+		if ns, f := cfe.cache.Get(key); f {
+			return ns.(graph.Identifier), true
+		}
+	}
+
+	cfe.Graph.RLock()
+	var ns graph.Identifier
+	var ok bool
+	if node := cfe.Graph.LookupFirstNode(graph.Metadata{"TID": tid}); node != nil {
+		ns, ok = cfe.probe.Namespace(node)
+	}
+	cfe.Graph.RUnlock()
+
+	if ok && cfe.cache != nil {
+		cfe.cache.Set(key, ns, cache.DefaultExpiration)
+	}
+
+	return ns, ok
+}
+
+func (cfe *ConntrackFlowEnhancer) Enhance(f *flow.Flow) {
+	if f.Network == nil {
+		return
+	}
+
+	protocol := flowProtocol(f)
+	if protocol == "" {
+		return
+	}
+
+	a, b := net.ParseIP(f.Network.A), net.ParseIP(f.Network.B)
+	if a == nil || b == nil {
+		return
+	}
+
+	var aPort, bPort int64
+	if f.Transport != nil {
+		aPort, _ = strconv.ParseInt(f.Transport.A, 10, 64)
+		bPort, _ = strconv.ParseInt(f.Transport.B, 10, 64)
+	}
+
+	ns, ok := cfe.getNamespace(f.NodeTID)
+	if !ok {
+		return
+	}
+
+	entry := cfe.probe.LookupNamespace(ns, protocol, a, b, aPort, bPort)
+	if entry == nil {
+		return
+	}
+
+	var nat []string
+	if entry.SNAT() {
+		nat = append(nat, "SNAT")
+	}
+	if entry.DNAT() {
+		nat = append(nat, "DNAT")
+	}
+
+	f.Conntrack = &flow.ConntrackInfo{
+		State:               entry.State,
+		Status:              entry.Status,
+		Mark:                entry.Mark,
+		Zone:                entry.Zone,
+		NAT:                 strings.Join(nat, ","),
+		OriginalSource:      conntrackEndpoint(entry.Original.Source, entry.Original.SourcePort),
+		OriginalDestination: conntrackEndpoint(entry.Original.Destination, entry.Original.DestinationPort),
+		ReplySource:         conntrackEndpoint(entry.Reply.Source, entry.Reply.SourcePort),
+		ReplyDestination:    conntrackEndpoint(entry.Reply.Destination, entry.Reply.DestinationPort),
+		TrackingID:          entry.TrackingID(),
+	}
+}
+
+func (cfe *ConntrackFlowEnhancer) EnhanceUpdate(f *flow.Flow) {
+	cfe.Enhance(f)
+}
+
+func NewConntrackFlowEnhancer(g *graph.Graph, cache *cache.Cache, probe *probes.ConntrackProbe) *ConntrackFlowEnhancer {
+	return &ConntrackFlowEnhancer{
+		Graph: g,
+		cache: cache,
+		probe: probe,
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package enhancers
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmylund/go-cache"
+	"github.com/skydive-project/skydive/flow"
+)
+
+// ReverseDNSFlowEnhancer fills the hostname of the network endpoints of the
+// flows. Addresses are resolved asynchronously by workers, flows being
+// enhanced with the names already resolved so that the flow processing is
+// never blocked by the lookups. Names, and failures, are cached.
+type ReverseDNSFlowEnhancer struct {
+	sync.Mutex
+	cache      *cache.Cache
+	timeout    time.Duration
+	queue      chan string
+	pending    map[string]bool
+	stopped    bool
+	wg         sync.WaitGroup
+	lookupAddr func(addr string) ([]string, error)
+}
+
+func (rfe *ReverseDNSFlowEnhancer) resolve(address string) string {
+	result := make(chan string, 1)
+	go func() {
+		names, err := rfe.lookupAddr(address)
+		if err != nil || len(names) == 0 {
+			result <- ""
+			return
+		}
+		result <- strings.TrimSuffix(names[0], ".")
+	}()
+
+	select {
+	case name := <-result:
+		return name
+	case <-time.After(rfe.timeout):
+		return ""
+	}
+}
+
+func (rfe *ReverseDNSFlowEnhancer) run() {
+	defer rfe.wg.Done()
+
+	for address := range rfe.queue {
+		rfe.Lock()
+		stopped := rfe.stopped
+		rfe.Unlock()
+
+		// drop the queued lookups once stopped
+		if stopped {
+			continue
+		}
+
+		rfe.cache.Set(address, rfe.resolve(address), cache.DefaultExpiration)
+
+		rfe.Lock()
+		delete(rfe.pending, address)
+		rfe.Unlock()
+	}
+}
+
+func (rfe *ReverseDNSFlowEnhancer) hostname(address string) string {
+	if name, f := rfe.cache.Get(address); f {
+		return name.(string)
+	}
+
+	rfe.Lock()
+	defer rfe.Unlock()
+
+	if rfe.stopped || rfe.pending[address] || net.ParseIP(address) == nil {
+		return ""
+	}
+
+	select {
+	case rfe.queue <- address:
+		rfe.pending[address] = true
+	default:
+		// too many lookups in progress, will be retried on the next update
+	}
+
+	return ""
+}
+
+func (rfe *ReverseDNSFlowEnhancer) Enhance(f *flow.Flow) {
+	if f.Network == nil {
+		return
+	}
+
+	if name := rfe.hostname(f.Network.A); name != "" {
+		endpointInfo(&f.AEndpoint).Hostname = name
+	}
+
+	if name := rfe.hostname(f.Network.B); name != "" {
+		endpointInfo(&f.BEndpoint).Hostname = name
+	}
+}
+
+// Stop stops the workers, the queued lookups are dropped
+func (rfe *ReverseDNSFlowEnhancer) Stop() {
+	rfe.Lock()
+	if rfe.stopped {
+		rfe.Unlock()
+		return
+	}
+	rfe.stopped = true
+	close(rfe.queue)
+	rfe.Unlock()
+
+	rfe.wg.Wait()
+}
+
+// NewReverseDNSFlowEnhancer returns a reverse DNS enhancer, lookups taking
+// longer than timeout are considered as failed, names are kept for expire.
+func NewReverseDNSFlowEnhancer(timeout time.Duration, expire time.Duration, workers int) *ReverseDNSFlowEnhancer {
+	rfe := &ReverseDNSFlowEnhancer{
+		cache:      cache.New(expire, expire),
+		timeout:    timeout,
+		queue:      make(chan string, 1000),
+		pending:    make(map[string]bool),
+		lookupAddr: net.LookupAddr,
+	}
+
+	rfe.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go rfe.run()
+	}
+
+	return rfe
+}
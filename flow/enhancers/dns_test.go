@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package enhancers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+func enhanceUntil(rfe *ReverseDNSFlowEnhancer, f *flow.Flow, done func() bool) bool {
+	for i := 0; i < 100; i++ {
+		rfe.Enhance(f)
+		if done() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestReverseDNSFlowEnhancer(t *testing.T) {
+	rfe := NewReverseDNSFlowEnhancer(100*time.Millisecond, time.Minute, 2)
+	defer rfe.Stop()
+	rfe.lookupAddr = func(addr string) ([]string, error) {
+		switch addr {
+		case "8.8.8.8":
+			return []string{"google-public-dns-a.google.com."}, nil
+		case "10.0.0.1":
+			// slower than the timeout
+			time.Sleep(time.Second)
+			return []string{"slow.example.com."}, nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	f := &flow.Flow{Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "192.168.0.1", B: "8.8.8.8"}}
+
+	// the first lookup is asynchronous
+	rfe.Enhance(f)
+	if f.BEndpoint != nil {
+		t.Errorf("Lookups should not block the enhancement, got %+v", f.BEndpoint)
+	}
+
+	if !enhanceUntil(rfe, f, func() bool { return f.BEndpoint != nil }) {
+		t.Fatal("Hostname not resolved")
+	}
+
+	if f.BEndpoint.Hostname != "google-public-dns-a.google.com" {
+		t.Errorf("Wrong hostname: %s", f.BEndpoint.Hostname)
+	}
+
+	if f.AEndpoint != nil {
+		t.Errorf("No hostname expected for an unknown address, got %+v", f.AEndpoint)
+	}
+
+	f = &flow.Flow{Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "10.0.0.1", B: "8.8.8.8"}}
+	enhanceUntil(rfe, f, func() bool {
+		_, cached := rfe.cache.Get("10.0.0.1")
+		return cached
+	})
+
+	if f.AEndpoint != nil {
+		t.Errorf("Lookups exceeding the timeout should fail, got %+v", f.AEndpoint)
+	}
+}
+
+func TestReverseDNSFlowEnhancerStop(t *testing.T) {
+	rfe := NewReverseDNSFlowEnhancer(100*time.Millisecond, time.Minute, 2)
+	rfe.lookupAddr = func(addr string) ([]string, error) {
+		return []string{"example.com."}, nil
+	}
+
+	done := make(chan bool)
+	go func() {
+		rfe.Stop()
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Workers not stopped")
+	}
+
+	// no lookup is queued once stopped
+	f := &flow.Flow{Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "192.168.0.1", B: "8.8.8.8"}}
+	rfe.Enhance(f)
+	if f.BEndpoint != nil || len(rfe.pending) != 0 {
+		t.Errorf("No lookup expected once stopped, got %+v", f.BEndpoint)
+	}
+
+	rfe.Stop()
+}
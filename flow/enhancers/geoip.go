@@ -0,0 +1,164 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package enhancers
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pmylund/go-cache"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+)
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+type asnRecord struct {
+	AutonomousSystemNumber       int64  `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+type geoIPInfo struct {
+	country        string
+	asn            int64
+	asOrganization string
+}
+
+// GeoIPFlowEnhancer fills the country and the autonomous system of the
+// network endpoints of the flows from MaxMind country and ASN databases
+type GeoIPFlowEnhancer struct {
+	countryDB *maxminddb.Reader
+	asnDB     *maxminddb.Reader
+	cache     *cache.Cache
+}
+
+func endpointInfo(e **flow.EndpointInfo) *flow.EndpointInfo {
+	if *e == nil {
+		*e = &flow.EndpointInfo{}
+	}
+	return *e
+}
+
+func (gfe *GeoIPFlowEnhancer) lookup(address string) *geoIPInfo {
+	key := "geoip/" + address
+	if gfe.cache != nil {
+		if info, f := gfe.cache.Get(key); f {
+			return info.(*geoIPInfo)
+		}
+	}
+
+	info := &geoIPInfo{}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return info
+	}
+
+	if gfe.countryDB != nil {
+		var record countryRecord
+		if err := gfe.countryDB.Lookup(ip, &record); err != nil {
+			logging.GetLogger().Warningf("Unable to lookup the country of %s: %s", address, err.Error())
+		}
+
+		if info.country = record.Country.ISOCode; info.country == "" {
+			info.country = record.RegisteredCountry.ISOCode
+		}
+	}
+
+	if gfe.asnDB != nil {
+		var record asnRecord
+		if err := gfe.asnDB.Lookup(ip, &record); err != nil {
+			logging.GetLogger().Warningf("Unable to lookup the autonomous system of %s: %s", address, err.Error())
+		}
+
+		info.asn = record.AutonomousSystemNumber
+		info.asOrganization = record.AutonomousSystemOrganization
+	}
+
+	if gfe.cache != nil {
+		gfe.cache.Set(key, info, cache.DefaultExpiration)
+	}
+
+	return info
+}
+
+func (gfe *GeoIPFlowEnhancer) enhanceEndpoint(e **flow.EndpointInfo, address string) {
+	info := gfe.lookup(address)
+	if info.country == "" && info.asn == 0 {
+		return
+	}
+
+	endpoint := endpointInfo(e)
+	endpoint.Country = info.country
+	endpoint.ASN = info.asn
+	endpoint.ASOrganization = info.asOrganization
+}
+
+func (gfe *GeoIPFlowEnhancer) Enhance(f *flow.Flow) {
+	if f.Network == nil {
+		return
+	}
+
+	gfe.enhanceEndpoint(&f.AEndpoint, f.Network.A)
+	gfe.enhanceEndpoint(&f.BEndpoint, f.Network.B)
+}
+
+// Stop closes the databases
+func (gfe *GeoIPFlowEnhancer) Stop() {
+	if gfe.countryDB != nil {
+		gfe.countryDB.Close()
+	}
+	if gfe.asnDB != nil {
+		gfe.asnDB.Close()
+	}
+}
+
+// NewGeoIPFlowEnhancer returns a GeoIP enhancer using MaxMind databases, for
+// instance GeoLite2-Country.mmdb and GeoLite2-ASN.mmdb. Any of the databases
+// can be omitted.
+func NewGeoIPFlowEnhancer(countryDB string, asnDB string, cache *cache.Cache) (*GeoIPFlowEnhancer, error) {
+	gfe := &GeoIPFlowEnhancer{cache: cache}
+
+	var err error
+	if countryDB != "" {
+		if gfe.countryDB, err = maxminddb.Open(countryDB); err != nil {
+			return nil, err
+		}
+	}
+
+	if asnDB != "" {
+		if gfe.asnDB, err = maxminddb.Open(asnDB); err != nil {
+			gfe.Stop()
+			return nil, err
+		}
+	}
+
+	return gfe, nil
+}
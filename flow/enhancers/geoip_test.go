@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package enhancers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+// data types and metadata marker of the MaxMind DB format, see
+// http://maxmind.github.io/MaxMind-DB/
+const (
+	mmdbString = 2
+	mmdbUint16 = 5
+	mmdbUint32 = 6
+	mmdbMap    = 7
+)
+
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbWriter writes minimal MaxMind databases of IPv4 networks with 24 bits
+// records, strings being shorter than 285 bytes
+type mmdbWriter struct {
+	root    *mmdbTestNode
+	records []map[string]interface{}
+}
+
+type mmdbTestNode struct {
+	children [2]*mmdbTestNode
+	record   int
+}
+
+func encodeMMDBValue(buf *bytes.Buffer, value interface{}) {
+	switch value := value.(type) {
+	case string:
+		if len(value) < 29 {
+			buf.WriteByte(mmdbString<<5 | byte(len(value)))
+		} else {
+			buf.Write([]byte{mmdbString<<5 | 29, byte(len(value) - 29)})
+		}
+		buf.WriteString(value)
+	case uint32:
+		buf.WriteByte(mmdbUint32<<5 | 4)
+		buf.Write([]byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)})
+	case uint16:
+		buf.WriteByte(mmdbUint16<<5 | 2)
+		buf.Write([]byte{byte(value >> 8), byte(value)})
+	case map[string]interface{}:
+		buf.WriteByte(mmdbMap<<5 | byte(len(value)))
+
+		var keys []string
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			encodeMMDBValue(buf, key)
+			encodeMMDBValue(buf, value[key])
+		}
+	}
+}
+
+func (w *mmdbWriter) insert(cidr string, record map[string]interface{}) {
+	_, ipnet, _ := net.ParseCIDR(cidr)
+	ones, _ := ipnet.Mask.Size()
+	ip := ipnet.IP.To4()
+
+	if w.root == nil {
+		w.root = &mmdbTestNode{record: -1}
+	}
+
+	node := w.root
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> uint(7-i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &mmdbTestNode{record: -1}
+		}
+		node = node.children[bit]
+	}
+	node.record = len(w.records)
+	w.records = append(w.records, record)
+}
+
+func (w *mmdbWriter) bytes() []byte {
+	// number the nodes having children
+	var nodes []*mmdbTestNode
+	numbers := make(map[*mmdbTestNode]int)
+	for queue := []*mmdbTestNode{w.root}; len(queue) > 0; queue = queue[1:] {
+		node := queue[0]
+		if node.record != -1 {
+			continue
+		}
+		numbers[node] = len(nodes)
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	var data bytes.Buffer
+	offsets := make([]int, len(w.records))
+	for i, record := range w.records {
+		offsets[i] = data.Len()
+		encodeMMDBValue(&data, record)
+	}
+
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		for _, child := range node.children {
+			value := len(nodes)
+			if child != nil {
+				if child.record == -1 {
+					value = numbers[child]
+				} else {
+					value = len(nodes) + 16 + offsets[child.record]
+				}
+			}
+			buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+
+	buf.Write(make([]byte, 16))
+	buf.Write(data.Bytes())
+	buf.Write(mmdbMetadataMarker)
+	encodeMMDBValue(&buf, map[string]interface{}{
+		"node_count":    uint32(len(nodes)),
+		"record_size":   uint16(24),
+		"ip_version":    uint16(4),
+		"database_type": "Skydive-Test",
+	})
+
+	return buf.Bytes()
+}
+
+func writeTestMMDB(t *testing.T, w *mmdbWriter) string {
+	file, err := ioutil.TempFile("", "skydive-mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(w.bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func TestGeoIPFlowEnhancer(t *testing.T) {
+	countries := &mmdbWriter{}
+	countries.insert("8.8.8.0/24", map[string]interface{}{"country": map[string]interface{}{"iso_code": "US"}})
+	countries.insert("82.0.0.0/8", map[string]interface{}{"registered_country": map[string]interface{}{"iso_code": "GB"}})
+	countryDB := writeTestMMDB(t, countries)
+	defer os.Remove(countryDB)
+
+	asns := &mmdbWriter{}
+	asns.insert("8.8.8.0/24", map[string]interface{}{
+		"autonomous_system_number":       uint32(15169),
+		"autonomous_system_organization": "Google Inc.",
+	})
+	asnDB := writeTestMMDB(t, asns)
+	defer os.Remove(asnDB)
+
+	gfe, err := NewGeoIPFlowEnhancer(countryDB, asnDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gfe.Stop()
+
+	f := &flow.Flow{Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "192.168.0.1", B: "8.8.8.8"}}
+	gfe.Enhance(f)
+
+	if f.AEndpoint != nil {
+		t.Errorf("No information expected for a private address, got %+v", f.AEndpoint)
+	}
+
+	if f.BEndpoint == nil || f.BEndpoint.Country != "US" || f.BEndpoint.ASN != 15169 || f.BEndpoint.ASOrganization != "Google Inc." {
+		t.Errorf("Wrong endpoint information: %+v", f.BEndpoint)
+	}
+
+	if country, err := f.GetFieldString("BEndpoint.Country"); err != nil || country != "US" {
+		t.Errorf("BEndpoint.Country field not found: %s, %v", country, err)
+	}
+
+	if asn, err := f.GetFieldInt64("BEndpoint.ASN"); err != nil || asn != 15169 {
+		t.Errorf("BEndpoint.ASN field not found: %d, %v", asn, err)
+	}
+
+	f = &flow.Flow{Network: &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "82.1.2.3", B: "192.168.0.1"}}
+	gfe.Enhance(f)
+
+	if f.AEndpoint == nil || f.AEndpoint.Country != "GB" || f.AEndpoint.ASN != 0 {
+		t.Errorf("Wrong registered country: %+v", f.AEndpoint)
+	}
+}
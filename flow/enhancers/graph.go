@@ -35,16 +35,45 @@ type GraphFlowEnhancer struct {
 	tidCache *tidCache
 }
 
-func (gfe *GraphFlowEnhancer) getNodeTID(mac string) string {
+// learntInterface returns the interface with the given MAC learnt by the
+// neighbor or forwarding database entries of the capture node or of the
+// interfaces it is connected to
+func (gfe *GraphFlowEnhancer) learntInterface(mac string, nodeTID string) *graph.Node {
+	node := gfe.Graph.LookupFirstNode(graph.Metadata{"TID": nodeTID})
+	if node == nil {
+		return nil
+	}
+
+	sources := []*graph.Node{node}
+	sources = append(sources, gfe.Graph.LookupParents(node, graph.Metadata{}, graph.Metadata{"RelationType": "layer2"})...)
+	sources = append(sources, gfe.Graph.LookupChildren(node, graph.Metadata{}, graph.Metadata{"RelationType": "layer2"})...)
+
+	var learnt *graph.Node
+	for _, source := range sources {
+		for _, relation := range []string{"neighbor", "fdb"} {
+			for _, intf := range gfe.Graph.LookupChildren(source, graph.Metadata{"MAC": mac}, graph.Metadata{"RelationType": relation}) {
+				if learnt != nil && learnt.ID != intf.ID {
+					return nil
+				}
+				learnt = intf
+			}
+		}
+	}
+
+	return learnt
+}
+
+func (gfe *GraphFlowEnhancer) getNodeTID(mac string, nodeTID string) string {
 	if packet.IsBroadcastMac(mac) || packet.IsMulticastMac(mac) {
 		return "*"
 	}
 
 	var ce *tidCacheEntry
 
+	key := tidCacheKey(nodeTID, mac)
 	if gfe.tidCache != nil {
 		var f bool
-		if ce, f = gfe.tidCache.get(mac); f {
+		if ce, f = gfe.tidCache.get(key); f {
 			return ce.tid
 		}
 	}
@@ -56,13 +85,17 @@ func (gfe *GraphFlowEnhancer) getNodeTID(mac string) string {
 
 	intfs := gfe.Graph.GetNodes(graph.Metadata{"MAC": mac})
 	if len(intfs) > 1 {
-		logging.GetLogger().Infof("GraphFlowEnhancer found more than one interface for the mac: %s", mac)
+		if intf := gfe.learntInterface(mac, nodeTID); intf != nil {
+			tid, _ = intf.GetFieldString("TID")
+		} else {
+			logging.GetLogger().Infof("GraphFlowEnhancer found more than one interface for the mac: %s", mac)
+		}
 	} else if len(intfs) == 1 {
 		tid, _ = intfs[0].GetFieldString("TID")
 	}
 
 	if gfe.tidCache != nil {
-		gfe.tidCache.set(ce, mac, tid)
+		gfe.tidCache.set(ce, key, tid)
 	}
 
 	return tid
@@ -73,10 +106,10 @@ func (gfe *GraphFlowEnhancer) Enhance(f *flow.Flow) {
 		return
 	}
 	if f.ANodeTID == "" {
-		f.ANodeTID = gfe.getNodeTID(f.Link.A)
+		f.ANodeTID = gfe.getNodeTID(f.Link.A, f.NodeTID)
 	}
 	if f.BNodeTID == "" {
-		f.BNodeTID = gfe.getNodeTID(f.Link.B)
+		f.BNodeTID = gfe.getNodeTID(f.Link.B, f.NodeTID)
 	}
 }
 
@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package enhancers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pmylund/go-cache"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestGraphFlowEnhancerLearntMAC(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"TID": "eth0-tid", "MAC": "00:11:22:33:44:55"}, "host1")
+	remote1 := g.NewNode(graph.GenID(), graph.Metadata{"TID": "remote1-tid", "MAC": "02:42:ac:11:00:02"}, "host2")
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "remote2-tid", "MAC": "02:42:ac:11:00:02"}, "host3")
+	g.Unlock()
+
+	f := &flow.Flow{
+		NodeTID: "eth0-tid",
+		Link:    &flow.FlowLayer{Protocol: flow.FlowProtocol_ETHERNET, A: "00:11:22:33:44:55", B: "02:42:ac:11:00:02"},
+	}
+
+	fe := NewGraphFlowEnhancer(g, nil)
+	fe.Enhance(f)
+	if f.ANodeTID != "eth0-tid" || f.BNodeTID != "" {
+		t.Fatalf("Ambiguous MAC shouldn't be resolved without neighbor entry, got %s/%s", f.ANodeTID, f.BNodeTID)
+	}
+
+	g.Lock()
+	g.Link(eth0, remote1, graph.Metadata{"RelationType": "neighbor", "IP": "10.0.0.2"})
+	g.Unlock()
+
+	f.ANodeTID, f.BNodeTID = "", ""
+	fe.Enhance(f)
+	if f.ANodeTID != "eth0-tid" || f.BNodeTID != "remote1-tid" {
+		t.Errorf("MAC should be resolved through the neighbor entry, got %s/%s", f.ANodeTID, f.BNodeTID)
+	}
+}
+
+func TestSharedTIDCache(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "tap-tid", "MAC": "fa:16:3e:00:00:01"})
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "qvo-tid", "PeerIntfMAC": "fa:16:3e:00:00:02", "Manager": "neutron"})
+	g.Unlock()
+
+	c := cache.New(time.Minute, time.Minute)
+	pipeline := flow.NewFlowEnhancerPipeline(NewGraphFlowEnhancer(g, c), NewNeutronFlowEnhancer(g, c))
+
+	newFlow := func() *flow.Flow {
+		return &flow.Flow{
+			NodeTID: "tap-tid",
+			Link:    &flow.FlowLayer{Protocol: flow.FlowProtocol_ETHERNET, A: "fa:16:3e:00:00:01", B: "fa:16:3e:00:00:02"},
+		}
+	}
+
+	f := newFlow()
+	pipeline.EnhanceFlow(f)
+	if f.ANodeTID != "tap-tid" || f.BNodeTID != "qvo-tid" {
+		t.Fatalf("Wrong TIDs: %s/%s", f.ANodeTID, f.BNodeTID)
+	}
+
+	// the TID found by the neutron enhancer is cached for the graph one
+	if _, f := c.Get(tidCacheKey("tap-tid", "fa:16:3e:00:00:02")); !f {
+		t.Error("The TID found by the neutron enhancer should be cached with the shared key")
+	}
+
+	f = newFlow()
+	NewGraphFlowEnhancer(g, c).Enhance(f)
+	if f.BNodeTID != "qvo-tid" {
+		t.Errorf("The cached TID should be used, got %s", f.BNodeTID)
+	}
+}
@@ -35,16 +35,17 @@ type NeutronFlowEnhancer struct {
 	tidCache *tidCache
 }
 
-func (nfe *NeutronFlowEnhancer) getNodeTID(mac string) string {
+func (nfe *NeutronFlowEnhancer) getNodeTID(mac string, nodeTID string) string {
 	if packet.IsBroadcastMac(mac) || packet.IsMulticastMac(mac) {
 		return "*"
 	}
 
 	var ce *tidCacheEntry
 
+	key := tidCacheKey(nodeTID, mac)
 	if nfe.tidCache != nil {
 		var f bool
-		if ce, f = nfe.tidCache.get(mac); f {
+		if ce, f = nfe.tidCache.get(key); f {
 			return ce.tid
 		}
 	}
@@ -64,7 +65,7 @@ func (nfe *NeutronFlowEnhancer) getNodeTID(mac string) string {
 	}
 
 	if nfe.tidCache != nil {
-		nfe.tidCache.set(ce, mac, tid)
+		nfe.tidCache.set(ce, key, tid)
 	}
 
 	return tid
@@ -75,10 +76,10 @@ func (nfe *NeutronFlowEnhancer) Enhance(f *flow.Flow) {
 		return
 	}
 	if f.ANodeTID == "" {
-		f.ANodeTID = nfe.getNodeTID(f.Link.A)
+		f.ANodeTID = nfe.getNodeTID(f.Link.A, f.NodeTID)
 	}
 	if f.BNodeTID == "" {
-		f.BNodeTID = nfe.getNodeTID(f.Link.B)
+		f.BNodeTID = nfe.getNodeTID(f.Link.B, f.NodeTID)
 	}
 }
 
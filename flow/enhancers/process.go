@@ -0,0 +1,584 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package enhancers
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pmylund/go-cache"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	// minimum delay between two reads of the sockets of a namespace
+	socketsRefreshInterval = time.Second
+	socketsTableTTL        = time.Minute
+	// delay before a flow whose socket was not found triggers another read
+	socketsMissTTL = 10 * time.Second
+)
+
+var containerIDRegexp = regexp.MustCompile("[0-9a-f]{64}")
+
+type netnsInfo struct {
+	ino           uint64
+	containerName string
+}
+
+type socketEntry struct {
+	local  string
+	remote string
+	inode  string
+}
+
+// socketTable maps the sockets of a network namespace to their process, the
+// keys are protocol/local or protocol/local/remote endpoints. The addresses
+// are the local addresses of the namespace.
+type socketTable struct {
+	sockets   map[string]*flow.ProcessInfo
+	addresses map[string]bool
+	refreshed time.Time
+}
+
+// ProcessFlowEnhancer finds the process owning the local socket of TCP and
+// UDP flows. The sockets listed in /proc/<pid>/net of a process of the
+// network namespace of the capture node are matched with the socket inodes
+// of /proc/<pid>/fd. The sockets are read in background so that the flow
+// processing is never blocked, the flows seen before the sockets of their
+// namespace are read being enhanced at their next updates. Only the flows
+// from or to an address of the namespace trigger a new read.
+type ProcessFlowEnhancer struct {
+	sync.RWMutex
+	Graph           *graph.Graph
+	procPath        string
+	cache           *cache.Cache
+	tables          map[uint64]*socketTable
+	misses          *cache.Cache
+	refresh         chan uint64
+	refreshInterval time.Duration
+	pending         map[uint64]bool
+	stopped         bool
+	wg              sync.WaitGroup
+}
+
+// parseProcNetAddr decodes the addresses of /proc/net/{tcp,udp}[6], the IP
+// being written as 32 bits words in host byte order
+func parseProcNetAddr(s string) (string, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("Invalid socket address: %s", s)
+	}
+
+	b, err := hex.DecodeString(parts[0])
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return "", fmt.Errorf("Invalid socket address: %s", s)
+	}
+
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("Invalid socket port: %s", s)
+	}
+
+	host := "*"
+	if !ip.IsUnspecified() {
+		host = ip.String()
+	}
+
+	return net.JoinHostPort(host, strconv.FormatUint(port, 10)), nil
+}
+
+func readProcNet(filename string) ([]socketEntry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []socketEntry
+
+	scanner := bufio.NewScanner(file)
+	// skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[9] == "0" {
+			continue
+		}
+
+		local, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+
+		remote, err := parseProcNetAddr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, socketEntry{local: local, remote: remote, inode: fields[9]})
+	}
+
+	return entries, scanner.Err()
+}
+
+// readLocalAddresses returns the local IPv4 addresses of a namespace from
+// the host LOCAL entries of /proc/net/fib_trie
+func readLocalAddresses(filename string, addresses map[string]bool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var last string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "|--":
+			last = fields[1]
+		case len(fields) == 3 && fields[0] == "/32" && fields[1] == "host" && fields[2] == "LOCAL":
+			if ip := net.ParseIP(last); ip != nil {
+				addresses[ip.String()] = true
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readLocalAddresses6 returns the IPv6 addresses of a namespace from
+// /proc/net/if_inet6
+func readLocalAddresses6(filename string, addresses map[string]bool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if b, err := hex.DecodeString(fields[0]); err == nil && len(b) == net.IPv6len {
+			addresses[net.IP(b).String()] = true
+		}
+	}
+
+	return scanner.Err()
+}
+
+func netnsInode(filename string) (uint64, error) {
+	// /proc/<pid>/ns/net links are like net:[4026531993]
+	if link, err := os.Readlink(filename); err == nil {
+		if strings.HasPrefix(link, "net:[") && strings.HasSuffix(link, "]") {
+			return strconv.ParseUint(link[5:len(link)-1], 10, 64)
+		}
+		return 0, fmt.Errorf("Invalid namespace link: %s", link)
+	}
+
+	// bind mounted namespaces
+	var stats syscall.Stat_t
+	if err := syscall.Stat(filename, &stats); err != nil {
+		return 0, err
+	}
+	return stats.Ino, nil
+}
+
+func (pfe *ProcessFlowEnhancer) getNetNS(tid string) *netnsInfo {
+	key := "netns/" + tid
+	if pfe.cache != nil {
+		if info, f := pfe.cache.Get(key); f {
+			return info.(*netnsInfo)
+		}
+	}
+
+	pfe.Graph.RLock()
+	node := pfe.Graph.LookupFirstNode(graph.Metadata{"TID": tid})
+	if node == nil {
+		pfe.Graph.RUnlock()
+		return nil
+	}
+
+	info := &netnsInfo{}
+	nsPath := path.Join(pfe.procPath, "self/ns/net")
+	if parents := pfe.Graph.LookupParents(node, graph.Metadata{"Type": "netns"}, graph.Metadata{"RelationType": "ownership"}); len(parents) > 0 {
+		nsPath, _ = parents[0].GetFieldString("Path")
+		if manager, _ := parents[0].GetFieldString("Manager"); manager == "docker" {
+			info.containerName, _ = parents[0].GetFieldString("Name")
+		}
+	}
+	pfe.Graph.RUnlock()
+
+	ino, err := netnsInode(nsPath)
+	if err != nil {
+		logging.GetLogger().Debugf("Unable to get the namespace of %s: %s", tid, err.Error())
+		return nil
+	}
+	info.ino = ino
+
+	if pfe.cache != nil {
+		pfe.cache.Set(key, info, cache.DefaultExpiration)
+	}
+
+	return info
+}
+
+func (pfe *ProcessFlowEnhancer) netnsPIDs(ino uint64) (pids []string) {
+	dir, err := os.Open(pfe.procPath)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+
+	names, _ := dir.Readdirnames(-1)
+	for _, name := range names {
+		if _, err := strconv.Atoi(name); err != nil {
+			continue
+		}
+
+		if pidIno, err := netnsInode(path.Join(pfe.procPath, name, "ns/net")); err == nil && pidIno == ino {
+			pids = append(pids, name)
+		}
+	}
+
+	return
+}
+
+func (pfe *ProcessFlowEnhancer) socketInodes(pid string) (inodes []string) {
+	fdPath := path.Join(pfe.procPath, pid, "fd")
+	dir, err := os.Open(fdPath)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+
+	fds, _ := dir.Readdirnames(-1)
+	for _, fd := range fds {
+		// sockets links are like socket:[12345]
+		link, err := os.Readlink(path.Join(fdPath, fd))
+		if err == nil && strings.HasPrefix(link, "socket:[") && strings.HasSuffix(link, "]") {
+			inodes = append(inodes, link[8:len(link)-1])
+		}
+	}
+
+	return
+}
+
+func (pfe *ProcessFlowEnhancer) processInfo(pid string) *flow.ProcessInfo {
+	id, _ := strconv.ParseInt(pid, 10, 64)
+	p := &flow.ProcessInfo{PID: id}
+
+	if comm, err := ioutil.ReadFile(path.Join(pfe.procPath, pid, "comm")); err == nil {
+		p.Name = strings.TrimSpace(string(comm))
+	}
+
+	if cmdline, err := ioutil.ReadFile(path.Join(pfe.procPath, pid, "cmdline")); err == nil {
+		p.Cmdline = strings.Replace(strings.TrimRight(string(cmdline), "\x00"), "\x00", " ", -1)
+	}
+
+	if cgroup, err := ioutil.ReadFile(path.Join(pfe.procPath, pid, "cgroup")); err == nil {
+		p.ContainerID = containerIDRegexp.FindString(string(cgroup))
+	}
+
+	return p
+}
+
+func (pfe *ProcessFlowEnhancer) readSockets(ino uint64) *socketTable {
+	table := &socketTable{
+		sockets:   make(map[string]*flow.ProcessInfo),
+		addresses: make(map[string]bool),
+		refreshed: time.Now(),
+	}
+
+	pids := pfe.netnsPIDs(ino)
+	if len(pids) == 0 {
+		return table
+	}
+
+	netPath := path.Join(pfe.procPath, pids[0], "net")
+	if err := readLocalAddresses(path.Join(netPath, "fib_trie"), table.addresses); err != nil && !os.IsNotExist(err) {
+		logging.GetLogger().Warningf("Unable to read the local addresses: %s", err.Error())
+	}
+	if err := readLocalAddresses6(path.Join(netPath, "if_inet6"), table.addresses); err != nil && !os.IsNotExist(err) {
+		logging.GetLogger().Warningf("Unable to read the local IPv6 addresses: %s", err.Error())
+	}
+
+	owners := make(map[string]string)
+	for _, pid := range pids {
+		for _, inode := range pfe.socketInodes(pid) {
+			if _, ok := owners[inode]; !ok {
+				owners[inode] = pid
+			}
+		}
+	}
+
+	processes := make(map[string]*flow.ProcessInfo)
+	for _, file := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		entries, err := readProcNet(path.Join(netPath, file))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logging.GetLogger().Warningf("Unable to read the %s sockets: %s", file, err.Error())
+			}
+			continue
+		}
+
+		proto := strings.TrimSuffix(file, "6")
+		for _, entry := range entries {
+			pid, ok := owners[entry.inode]
+			if !ok {
+				continue
+			}
+
+			p, ok := processes[pid]
+			if !ok {
+				p = pfe.processInfo(pid)
+				processes[pid] = p
+			}
+
+			// listening and unconnected sockets have no remote port
+			if strings.HasSuffix(entry.remote, ":0") {
+				table.sockets[proto+"/"+entry.local] = p
+			} else {
+				table.sockets[proto+"/"+entry.local+"/"+entry.remote] = p
+			}
+		}
+	}
+
+	return table
+}
+
+func endpoint(ip, port string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// socketKeys returns the keys of the sockets that may own a flow, in order of
+// preference: the connected sockets of both sides, then the listening
+// sockets.
+func socketKeys(f *flow.Flow) []string {
+	proto := "tcp"
+	if f.Transport.Protocol == flow.FlowProtocol_UDPPORT {
+		proto = "udp"
+	}
+
+	a := endpoint(f.Network.A, f.Transport.A)
+	b := endpoint(f.Network.B, f.Transport.B)
+
+	return []string{
+		proto + "/" + a + "/" + b,
+		proto + "/" + b + "/" + a,
+		proto + "/" + b,
+		proto + "/" + net.JoinHostPort("*", f.Transport.B),
+		proto + "/" + a,
+		proto + "/" + net.JoinHostPort("*", f.Transport.A),
+	}
+}
+
+// isLocal returns whether one of the endpoints of a flow is an address of
+// the namespace, the sockets of the forwarded flows being elsewhere. All the
+// flows are considered as local if the addresses couldn't be read.
+func (t *socketTable) isLocal(f *flow.Flow) bool {
+	if len(t.addresses) == 0 {
+		return true
+	}
+
+	for _, ip := range []string{f.Network.A, f.Network.B} {
+		if parsed := net.ParseIP(ip); parsed != nil && t.addresses[parsed.String()] {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *socketTable) lookup(keys []string) *flow.ProcessInfo {
+	for _, key := range keys {
+		if p, ok := t.sockets[key]; ok {
+			return p
+		}
+	}
+	return nil
+}
+
+func (pfe *ProcessFlowEnhancer) run() {
+	defer pfe.wg.Done()
+
+	for ino := range pfe.refresh {
+		table := pfe.readSockets(ino)
+
+		pfe.Lock()
+		for i, t := range pfe.tables {
+			if time.Since(t.refreshed) > socketsTableTTL {
+				delete(pfe.tables, i)
+			}
+		}
+		pfe.tables[ino] = table
+		delete(pfe.pending, ino)
+		pfe.Unlock()
+	}
+}
+
+// requestRefresh schedules a read of the sockets of a namespace, it returns
+// false if the sockets were read too recently
+func (pfe *ProcessFlowEnhancer) requestRefresh(ino uint64) bool {
+	pfe.Lock()
+	defer pfe.Unlock()
+
+	if pfe.stopped {
+		return false
+	}
+
+	if pfe.pending[ino] {
+		return true
+	}
+
+	if table, ok := pfe.tables[ino]; ok && time.Since(table.refreshed) < pfe.refreshInterval {
+		return false
+	}
+
+	select {
+	case pfe.refresh <- ino:
+		pfe.pending[ino] = true
+		return true
+	default:
+		// too many reads in progress, will be retried on the next update
+		return false
+	}
+}
+
+func (pfe *ProcessFlowEnhancer) lookup(ino uint64, f *flow.Flow) *flow.ProcessInfo {
+	pfe.RLock()
+	table, ok := pfe.tables[ino]
+	pfe.RUnlock()
+
+	keys := socketKeys(f)
+	if ok {
+		// the namespace only forwards this flow, none of its sockets, even
+		// listening on the same port, own it
+		if !table.isLocal(f) {
+			return nil
+		}
+
+		if p := table.lookup(keys); p != nil {
+			return p
+		}
+	}
+
+	// a flow whose socket is unknown triggers only one read of the sockets
+	miss := strconv.FormatUint(ino, 10) + "/" + keys[0]
+	if _, f := pfe.misses.Get(miss); f {
+		return nil
+	}
+
+	if pfe.requestRefresh(ino) {
+		pfe.misses.Set(miss, true, cache.DefaultExpiration)
+	}
+
+	return nil
+}
+
+func (pfe *ProcessFlowEnhancer) Enhance(f *flow.Flow) {
+	if f.Process != nil || f.Network == nil || f.Transport == nil {
+		return
+	}
+
+	if f.Transport.Protocol != flow.FlowProtocol_TCPPORT && f.Transport.Protocol != flow.FlowProtocol_UDPPORT {
+		return
+	}
+
+	ns := pfe.getNetNS(f.NodeTID)
+	if ns == nil {
+		return
+	}
+
+	p := pfe.lookup(ns.ino, f)
+	if p == nil {
+		return
+	}
+
+	process := *p
+	if process.ContainerName == "" {
+		process.ContainerName = ns.containerName
+	}
+	f.Process = &process
+}
+
+// EnhanceUpdate retries the flows whose socket was not yet read
+func (pfe *ProcessFlowEnhancer) EnhanceUpdate(f *flow.Flow) {
+	pfe.Enhance(f)
+}
+
+// Stop stops the reads of the sockets
+func (pfe *ProcessFlowEnhancer) Stop() {
+	pfe.Lock()
+	if pfe.stopped {
+		pfe.Unlock()
+		return
+	}
+	pfe.stopped = true
+	close(pfe.refresh)
+	pfe.Unlock()
+
+	pfe.wg.Wait()
+}
+
+func NewProcessFlowEnhancer(g *graph.Graph, nsCache *cache.Cache) *ProcessFlowEnhancer {
+	pfe := &ProcessFlowEnhancer{
+		Graph:           g,
+		procPath:        "/proc",
+		cache:           nsCache,
+		tables:          make(map[uint64]*socketTable),
+		misses:          cache.New(socketsMissTTL, socketsMissTTL),
+		refresh:         make(chan uint64, 100),
+		refreshInterval: socketsRefreshInterval,
+		pending:         make(map[uint64]bool),
+	}
+
+	pfe.wg.Add(1)
+	go pfe.run()
+
+	return pfe
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package enhancers
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	testContainerID = "3f4e9f3ee1cb5d4c4d2a0bd4c0a0b8e3a1ce2f0f7b5b3a5e42e6c8b2a6d0e9f1"
+	procNetHeader   = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+)
+
+func writeProcFile(t *testing.T, filename string, content string) {
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeProcLink(t *testing.T, filename string, target string) {
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filename); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newFakeProc(t *testing.T) string {
+	procPath, err := ioutil.TempDir("", "skydive-proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeProcLink(t, path.Join(procPath, "self/ns/net"), "net:[4026531993]")
+
+	// nginx listening on *:80 with a connection from 10.0.0.2:54321
+	writeProcLink(t, path.Join(procPath, "1234/ns/net"), "net:[4026531993]")
+	writeProcLink(t, path.Join(procPath, "1234/fd/3"), "socket:[5555]")
+	writeProcLink(t, path.Join(procPath, "1234/fd/4"), "socket:[6666]")
+	writeProcLink(t, path.Join(procPath, "1234/fd/5"), "/dev/null")
+	writeProcFile(t, path.Join(procPath, "1234/comm"), "nginx\n")
+	writeProcFile(t, path.Join(procPath, "1234/cmdline"), "nginx\x00-g\x00daemon off;\x00")
+	writeProcFile(t, path.Join(procPath, "1234/cgroup"), "11:cpu:/docker/"+testContainerID+"\n")
+	writeProcFile(t, path.Join(procPath, "1234/net/tcp"), procNetHeader+
+		"   0: 00000000:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 5555 1 0000000000000000 100 0 0 10 0\n"+
+		"   1: 0100000A:0050 0200000A:D431 01 00000000:00000000 00:00000000 00000000     0        0 6666 1 0000000000000000 20 4 30 10 -1\n"+
+		"   2: 0100000A:0050 0500000A:D432 06 00000000:00000000 00:00000000 00000000     0        0 0 3 0000000000000000\n")
+	writeProcFile(t, path.Join(procPath, "1234/net/fib_trie"), "Local:\n"+
+		"  +-- 10.0.0.0/24 2 0 2\n"+
+		"     |-- 10.0.0.0\n"+
+		"        /24 link UNICAST\n"+
+		"     |-- 10.0.0.1\n"+
+		"        /32 host LOCAL\n"+
+		"     |-- 10.0.0.255\n"+
+		"        /32 link BROADCAST\n")
+	writeProcFile(t, path.Join(procPath, "1234/net/if_inet6"),
+		"fe800000000000000000000000000001 02 40 20 80     eth0\n")
+
+	// a process of another namespace
+	writeProcLink(t, path.Join(procPath, "5678/ns/net"), "net:[4026532000]")
+	writeProcLink(t, path.Join(procPath, "5678/fd/3"), "socket:[7777]")
+	writeProcFile(t, path.Join(procPath, "5678/comm"), "dnsmasq\n")
+
+	return procPath
+}
+
+func newProcessTestEnhancer(t *testing.T, procPath string) *ProcessFlowEnhancer {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "probe-tid", "Name": "eth0", "Type": "device"})
+	g.Unlock()
+
+	pfe := NewProcessFlowEnhancer(g, nil)
+	pfe.procPath = procPath
+	pfe.refreshInterval = 100 * time.Millisecond
+	return pfe
+}
+
+// processEnhanceUntil enhances the flow until the sockets of its namespace
+// were read in background
+func processEnhanceUntil(pfe *ProcessFlowEnhancer, f *flow.Flow) {
+	for i := 0; i < 100; i++ {
+		pfe.EnhanceUpdate(f)
+		if f.Process != nil {
+			return
+		}
+
+		pfe.RLock()
+		pending := len(pfe.pending)
+		pfe.RUnlock()
+		if i > 0 && pending == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTCPFlow(a, portA, b, portB string) *flow.Flow {
+	return &flow.Flow{
+		NodeTID:   "probe-tid",
+		Network:   &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: a, B: b},
+		Transport: &flow.FlowLayer{Protocol: flow.FlowProtocol_TCPPORT, A: portA, B: portB},
+	}
+}
+
+func TestParseProcNetAddr(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"0100007F:0277":                         "127.0.0.1:631",
+		"00000000:0050":                         "*:80",
+		"00000000000000000000000001000000:0035": "[::1]:53",
+		"0000000000000000FFFF00000100000A:01BB": "10.0.0.1:443",
+	} {
+		endpoint, err := parseProcNetAddr(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endpoint != expected {
+			t.Errorf("Wrong endpoint for %s, expected %s, got %s", addr, expected, endpoint)
+		}
+	}
+}
+
+func TestProcessFlowEnhancer(t *testing.T) {
+	procPath := newFakeProc(t)
+	defer os.RemoveAll(procPath)
+
+	pfe := newProcessTestEnhancer(t, procPath)
+	defer pfe.Stop()
+
+	// the sockets are read asynchronously
+	f := newTCPFlow("10.0.0.2", "54321", "10.0.0.1", "80")
+	pfe.Enhance(f)
+	if f.Process != nil {
+		t.Errorf("Reading the sockets should not block the enhancement, got %+v", f.Process)
+	}
+
+	processEnhanceUntil(pfe, f)
+	if f.Process == nil {
+		t.Fatal("Process of the connected socket not found")
+	}
+
+	expected := &flow.ProcessInfo{PID: 1234, Name: "nginx", Cmdline: "nginx -g daemon off;", ContainerID: testContainerID}
+	if !reflect.DeepEqual(f.Process, expected) {
+		t.Errorf("Wrong process, expected %+v, got %+v", expected, f.Process)
+	}
+
+	// accepted on the listening socket
+	f = newTCPFlow("10.0.0.3", "40000", "10.0.0.1", "80")
+	pfe.Enhance(f)
+	if f.Process == nil || f.Process.PID != 1234 {
+		t.Errorf("Process of the listening socket not found: %+v", f.Process)
+	}
+
+	if pid, err := f.GetFieldInt64("Process.PID"); err != nil || pid != 1234 {
+		t.Errorf("Process.PID field not found: %d, %v", pid, err)
+	}
+
+	if name, err := f.GetFieldString("Process.Name"); err != nil || !strings.HasPrefix(name, "nginx") {
+		t.Errorf("Process.Name field not found: %s, %v", name, err)
+	}
+
+	// no socket of the namespace on this port
+	f = newTCPFlow("10.0.0.3", "40000", "10.0.0.1", "8080")
+	time.Sleep(2 * pfe.refreshInterval)
+	processEnhanceUntil(pfe, f)
+	if f.Process != nil {
+		t.Errorf("No process expected, got %+v", f.Process)
+	}
+
+	// the miss is cached, the next updates don't read the sockets again
+	time.Sleep(2 * pfe.refreshInterval)
+	pfe.EnhanceUpdate(f)
+
+	pfe.RLock()
+	pending := len(pfe.pending)
+	pfe.RUnlock()
+	if pending != 0 {
+		t.Error("The sockets should not be read again for a known miss")
+	}
+
+	// forwarded by the namespace, its sockets won't own the flow
+	f = newTCPFlow("10.0.1.2", "40000", "10.0.2.2", "80")
+	pfe.Enhance(f)
+
+	pfe.RLock()
+	pending = len(pfe.pending)
+	pfe.RUnlock()
+	if pending != 0 || f.Process != nil {
+		t.Error("The sockets should not be read for a forwarded flow")
+	}
+}
+
+func TestReadLocalAddresses(t *testing.T) {
+	procPath := newFakeProc(t)
+	defer os.RemoveAll(procPath)
+
+	addresses := make(map[string]bool)
+	if err := readLocalAddresses(path.Join(procPath, "1234/net/fib_trie"), addresses); err != nil {
+		t.Fatal(err)
+	}
+	if err := readLocalAddresses6(path.Join(procPath, "1234/net/if_inet6"), addresses); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{"10.0.0.1": true, "fe80::1": true}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("Wrong local addresses, expected %v, got %v", expected, addresses)
+	}
+}
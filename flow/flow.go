@@ -56,6 +56,8 @@ type FlowPacket struct {
 type FlowPackets struct {
 	Packets   []FlowPacket
 	Timestamp int64
+	// original packet, before being split
+	packet *gopacket.Packet
 }
 
 func (x FlowProtocol) Value() int32 {
@@ -297,6 +299,15 @@ func getLinkLayerLength(packet *layers.Ethernet) int64 {
 	return 14 + int64(len(packet.Payload))
 }
 
+// scaleMetric returns the number of packets and bytes accounted for a single
+// packet of the given length according to the sampling rate of the flow
+func (f *Flow) scaleMetric(length int64) (int64, int64) {
+	if f.SamplingRate > 1 {
+		return f.SamplingRate, length * f.SamplingRate
+	}
+	return 1, length
+}
+
 func (f *Flow) updateMetricsWithLinkLayer(packet *gopacket.Packet, length int64) bool {
 	ethernetLayer := (*packet).Layer(layers.LayerTypeEthernet)
 	ethernetPacket, ok := ethernetLayer.(*layers.Ethernet)
@@ -310,11 +321,12 @@ func (f *Flow) updateMetricsWithLinkLayer(packet *gopacket.Packet, length int64)
 		length = getLinkLayerLength(ethernetPacket)
 	}
 
+	packets, length := f.scaleMetric(length)
 	if f.Link.A == ethernetPacket.SrcMAC.String() {
-		f.Metric.ABPackets++
+		f.Metric.ABPackets += packets
 		f.Metric.ABBytes += length
 	} else {
-		f.Metric.BAPackets++
+		f.Metric.BAPackets += packets
 		f.Metric.BABytes += length
 	}
 
@@ -356,23 +368,25 @@ func (f *Flow) updateMetricsWithNetworkLayer(packet *gopacket.Packet) error {
 
 	ipv4Layer := (*packet).Layer(layers.LayerTypeIPv4)
 	if ipv4Packet, ok := ipv4Layer.(*layers.IPv4); ok {
+		packets, length := f.scaleMetric(int64(ipv4Packet.Length))
 		if f.Network.A == ipv4Packet.SrcIP.String() {
-			f.Metric.ABPackets++
-			f.Metric.ABBytes += int64(ipv4Packet.Length)
+			f.Metric.ABPackets += packets
+			f.Metric.ABBytes += length
 		} else {
-			f.Metric.BAPackets++
-			f.Metric.BABytes += int64(ipv4Packet.Length)
+			f.Metric.BAPackets += packets
+			f.Metric.BABytes += length
 		}
 		return nil
 	}
 	ipv6Layer := (*packet).Layer(layers.LayerTypeIPv6)
 	if ipv6Packet, ok := ipv6Layer.(*layers.IPv6); ok {
+		packets, length := f.scaleMetric(int64(ipv6Packet.Length))
 		if f.Network.A == ipv6Packet.SrcIP.String() {
-			f.Metric.ABPackets++
-			f.Metric.ABBytes += int64(ipv6Packet.Length)
+			f.Metric.ABPackets += packets
+			f.Metric.ABBytes += length
 		} else {
-			f.Metric.BAPackets++
-			f.Metric.BABytes += int64(ipv6Packet.Length)
+			f.Metric.BAPackets += packets
+			f.Metric.BABytes += length
 		}
 		return nil
 	}
@@ -423,7 +437,7 @@ func (f *Flow) newTransportLayer(packet *gopacket.Packet) error {
 // FlowPacketsFromGoPacket split original packet into multiple packets in
 // case of encapsulation like GRE, VXLAN, etc.
 func FlowPacketsFromGoPacket(packet *gopacket.Packet, outerLength int64, t int64) *FlowPackets {
-	flowPackets := &FlowPackets{Timestamp: t}
+	flowPackets := &FlowPackets{Timestamp: t, packet: packet}
 
 	if (*packet).Layer(gopacket.LayerTypeDecodeFailure) != nil {
 		logging.GetLogger().Errorf("Decoding failure on layerpath %s", layerPathFromGoPacket(packet))
@@ -590,6 +604,18 @@ func (f *Flow) GetFieldString(field string) (string, error) {
 		return f.Network.GetField(fields[1])
 	case "ETHERNET":
 		return f.Link.GetField(fields[1])
+	case "HTTP":
+		return f.HTTP.GetField(fields[1])
+	case "TLS":
+		return f.TLS.GetField(fields[1])
+	case "Process":
+		return f.Process.GetField(fields[1])
+	case "Conntrack":
+		return f.Conntrack.GetField(fields[1])
+	case "AEndpoint":
+		return f.AEndpoint.GetField(fields[1])
+	case "BEndpoint":
+		return f.BEndpoint.GetField(fields[1])
 	}
 	return "", common.ErrFieldNotFound
 }
@@ -600,6 +626,8 @@ func (f *Flow) GetFieldInt64(field string) (_ int64, err error) {
 		return f.Last, nil
 	case "Start":
 		return f.Start, nil
+	case "SamplingRate":
+		return f.SamplingRate, nil
 	}
 
 	fields := strings.Split(field, ".")
@@ -618,6 +646,16 @@ func (f *Flow) GetFieldInt64(field string) (_ int64, err error) {
 		return f.Network.GetFieldInt64(fields[1])
 	case "Transport":
 		return f.Transport.GetFieldInt64(fields[1])
+	case "HTTP":
+		return f.HTTP.GetFieldInt64(fields[1])
+	case "Process":
+		return f.Process.GetFieldInt64(fields[1])
+	case "Conntrack":
+		return f.Conntrack.GetFieldInt64(fields[1])
+	case "AEndpoint":
+		return f.AEndpoint.GetFieldInt64(fields[1])
+	case "BEndpoint":
+		return f.BEndpoint.GetFieldInt64(fields[1])
 	default:
 		return 0, common.ErrFieldNotFound
 	}
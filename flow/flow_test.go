@@ -25,6 +25,7 @@ package flow
 import (
 	"encoding/json"
 	"io"
+	"net"
 	"reflect"
 	"strings"
 	"testing"
@@ -705,3 +706,101 @@ func TestPCAPVlansQinQ(t *testing.T) {
 
 	validatePCAP(t, "pcaptraces/icmpv4-4vlanQinQ-id-8-10-20-30.pcap", layers.LinkTypeEthernet, expected)
 }
+
+func forgeTestTCPPacket(t *testing.T, swap bool, payload []byte) *gopacket.Packet {
+	ethernetLayer := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x01},
+		DstMAC:       net.HardwareAddr{0x00, 0x0D, 0xBD, 0xBD, 0x02, 0xBD},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ipv4Layer := &layers.IPv4{
+		Version:  4,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IP{192, 168, 0, 1},
+		DstIP:    net.IP{192, 168, 0, 2},
+	}
+	tcpLayer := &layers.TCP{
+		SrcPort: layers.TCPPort(43210),
+		DstPort: layers.TCPPort(80),
+	}
+	if swap {
+		ethernetLayer.SrcMAC, ethernetLayer.DstMAC = ethernetLayer.DstMAC, ethernetLayer.SrcMAC
+		ipv4Layer.SrcIP, ipv4Layer.DstIP = ipv4Layer.DstIP, ipv4Layer.SrcIP
+		tcpLayer.SrcPort, tcpLayer.DstPort = tcpLayer.DstPort, tcpLayer.SrcPort
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true}
+	if err := gopacket.SerializeLayers(buffer, options, ethernetLayer, ipv4Layer, tcpLayer, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	p := gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	return &p
+}
+
+func TestFlowApplicationHTTP(t *testing.T) {
+	table := NewTable(nil, nil, NewFlowEnhancerPipeline(), TableOpts{AppDissectionPackets: 10})
+
+	request := []byte("GET /index.html?user=1 HTTP/1.1\r\nUser-Agent: curl\r\nHost: www.example.com\r\n\r\n")
+	table.flowPacketsToFlow(FlowPacketsFromGoPacket(forgeTestTCPPacket(t, false, request), 0, -1))
+
+	response := []byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+	table.flowPacketsToFlow(FlowPacketsFromGoPacket(forgeTestTCPPacket(t, true, response), 0, -1))
+
+	flows := table.getFlows(nil).GetFlows()
+	if len(flows) != 1 {
+		t.Fatalf("Request and response should generate 1 flow, got %d", len(flows))
+	}
+
+	expected := &HTTPLayer{Method: "GET", Host: "www.example.com", Path: "/index.html", StatusCode: 404}
+	if !reflect.DeepEqual(flows[0].HTTP, expected) {
+		t.Errorf("Wrong HTTP layer, expected %+v, got %+v", expected, flows[0].HTTP)
+	}
+
+	filter := filters.NewAndFilter(filters.NewTermStringFilter("HTTP.Host", "www.example.com"), filters.NewTermInt64Filter("HTTP.StatusCode", 404))
+	if !filter.Eval(flows[0]) {
+		t.Error("HTTP fields should be filterable")
+	}
+}
+
+func TestFlowApplicationTLS(t *testing.T) {
+	sni := []byte("www.example.com")
+
+	var exts []byte
+	exts = append(exts, 0x00, 0x00, 0x00, byte(len(sni)+5), 0x00, byte(len(sni)+3), 0x00, 0x00, byte(len(sni)))
+	exts = append(exts, sni...)
+	exts = append(exts, 0x00, 0x2b, 0x00, 0x05, 0x04, 0x03, 0x04, 0x03, 0x03)
+
+	hello := []byte{0x03, 0x03}
+	hello = append(hello, make([]byte, 32)...)
+	hello = append(hello, 0x00, 0x00, 0x02, 0x13, 0x01, 0x01, 0x00, 0x00, byte(len(exts)))
+	hello = append(hello, exts...)
+
+	payload := []byte{0x16, 0x03, 0x01, 0x00, byte(len(hello) + 4), 0x01, 0x00, 0x00, byte(len(hello))}
+	payload = append(payload, hello...)
+
+	table := NewTable(nil, nil, NewFlowEnhancerPipeline(), TableOpts{AppDissectionPackets: 10})
+	table.flowPacketsToFlow(FlowPacketsFromGoPacket(forgeTestTCPPacket(t, false, payload), 0, -1))
+
+	flows := table.getFlows(nil).GetFlows()
+	if len(flows) != 1 {
+		t.Fatalf("A single packet must generate 1 flow, got %d", len(flows))
+	}
+
+	expected := &TLSLayer{ServerName: "www.example.com", Version: "TLS1.3"}
+	if !reflect.DeepEqual(flows[0].TLS, expected) {
+		t.Errorf("Wrong TLS layer, expected %+v, got %+v", expected, flows[0].TLS)
+	}
+}
+
+func TestFlowApplicationDisabled(t *testing.T) {
+	table := NewTable(nil, nil, NewFlowEnhancerPipeline())
+
+	request := []byte("GET / HTTP/1.1\r\nHost: www.example.com\r\n\r\n")
+	table.flowPacketsToFlow(FlowPacketsFromGoPacket(forgeTestTCPPacket(t, false, request), 0, -1))
+
+	if flows := table.getFlows(nil).GetFlows(); len(flows) != 1 || flows[0].HTTP != nil {
+		t.Error("Application dissection should be disabled by default")
+	}
+}
@@ -27,9 +27,12 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/skydive-project/skydive/api"
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/flow/ondemand"
 	"github.com/skydive-project/skydive/flow/probes"
@@ -48,6 +51,10 @@ type OnDemandProbeServer struct {
 	fta               *flow.TableAllocator
 	activeProbes      map[graph.Identifier]*flow.Table
 	captures          map[graph.Identifier]*api.Capture
+	recorders         map[graph.Identifier]*flow.Recorder
+	quit              chan bool
+	state             int64
+	wg                sync.WaitGroup
 }
 
 func (o *OnDemandProbeServer) isActive(n *graph.Node) bool {
@@ -126,17 +133,47 @@ func (o *OnDemandProbeServer) registerProbe(n *graph.Node, capture *api.Capture)
 		return false
 	}
 
-	ft := o.fta.Alloc(fprobe.AsyncFlowPipeline)
+	opts := flow.TableOpts{
+		FlowSamplingRate: int64(capture.FlowSamplingRate),
+		MaxFlows:         config.GetConfig().GetInt64("agent.flow.table_max_flows"),
+		EvictionPolicy:   config.GetConfig().GetString("agent.flow.eviction_policy"),
+		Shards:           config.GetConfig().GetInt("agent.flow.table_shards"),
+		RingPackets:      int64(capture.RingPackets),
+		RingBytes:        int64(capture.RingBytes),
+	}
+	if capture.AppDissection {
+		opts.AppDissectionPackets = config.GetConfig().GetInt64("agent.flow.app_dissection_packets")
+	}
+
+	ft := o.fta.Alloc(fprobe.AsyncFlowPipeline, opts)
 	ft.SetNodeTID(tid)
 
+	var recorder *flow.Recorder
+	if capture.Recording {
+		recorderOpts := newRecorderOptsFromConfig()
+		recorderOpts.SnapLen = capture.RecordingSnapLen
+		if recorder, err = flow.NewRecorder(capture.UUID, tid, name, recorderOpts); err != nil {
+			logging.GetLogger().Errorf("Unable to record capture %s: %s", capture.UUID, err.Error())
+			o.fta.Release(ft)
+			return false
+		}
+		ft.SetRecorder(recorder)
+	}
+
 	if err := fprobe.RegisterProbe(n, capture, ft); err != nil {
 		logging.GetLogger().Debugf("Failed to register flow probe: %s", err.Error())
+		if recorder != nil {
+			recorder.Close()
+		}
 		o.fta.Release(ft)
 		return false
 	}
 
 	o.activeProbes[n.ID] = ft
 	o.captures[n.ID] = capture
+	if recorder != nil {
+		o.recorders[n.ID] = recorder
+	}
 
 	logging.GetLogger().Debugf("New active probe on: %v", n)
 	return true
@@ -164,6 +201,10 @@ func (o *OnDemandProbeServer) unregisterProbe(n *graph.Node) bool {
 
 	o.Lock()
 	o.fta.Release(o.activeProbes[n.ID])
+	if recorder, ok := o.recorders[n.ID]; ok {
+		recorder.Close()
+		delete(o.recorders, n.ID)
+	}
 	delete(o.activeProbes, n.ID)
 	delete(o.captures, n.ID)
 	o.Unlock()
@@ -171,6 +212,17 @@ func (o *OnDemandProbeServer) unregisterProbe(n *graph.Node) bool {
 	return true
 }
 
+func newRecorderOptsFromConfig() flow.RecorderOpts {
+	cfg := config.GetConfig()
+	return flow.RecorderOpts{
+		Path:      cfg.GetString("agent.flow.recording.path"),
+		MaxSize:   cfg.GetInt64("agent.flow.recording.max_size") * 1024 * 1024,
+		MaxAge:    time.Duration(cfg.GetInt("agent.flow.recording.max_age")) * time.Second,
+		MaxFiles:  cfg.GetInt("agent.flow.recording.max_files"),
+		Retention: time.Duration(cfg.GetInt("agent.flow.recording.retention")) * time.Second,
+	}
+}
+
 func (o *OnDemandProbeServer) OnMessage(c *shttp.WSAsyncClient, msg shttp.WSMessage) {
 	if msg.Namespace != ondemand.Namespace {
 		return
@@ -219,6 +271,9 @@ func (o *OnDemandProbeServer) OnMessage(c *shttp.WSAsyncClient, msg shttp.WSMess
 			delete(metadata, "Capture/PacketsReceived")
 			delete(metadata, "Capture/PacketsDropped")
 			delete(metadata, "Capture/PacketsIfDropped")
+			delete(metadata, "Capture/PacketsLimited")
+			delete(metadata, "Capture/FlowsEvicted")
+			delete(metadata, "Capture/FlowsDropped")
 			o.Graph.SetMetadata(n, metadata)
 		}
 	default:
@@ -240,15 +295,76 @@ func (o *OnDemandProbeServer) OnNodeDeleted(n *graph.Node) {
 	o.unregisterProbe(n)
 }
 
+// updateTableStats reports the flow table counters of the active captures in
+// the metadata of the capture nodes
+func (o *OnDemandProbeServer) updateTableStats() {
+	o.RLock()
+	stats := make(map[graph.Identifier]flow.TableStats)
+	for id, ft := range o.activeProbes {
+		if s := ft.GetStats(); s.FlowsEvicted != 0 || s.FlowsDropped != 0 {
+			stats[id] = s
+		}
+	}
+	o.RUnlock()
+
+	if len(stats) == 0 {
+		return
+	}
+
+	o.Graph.Lock()
+	defer o.Graph.Unlock()
+
+	for id, s := range stats {
+		if n := o.Graph.GetNode(id); n != nil {
+			t := o.Graph.StartMetadataTransaction(n)
+			t.AddMetadata("Capture/FlowsEvicted", s.FlowsEvicted)
+			t.AddMetadata("Capture/FlowsDropped", s.FlowsDropped)
+			t.Commit()
+		}
+	}
+}
+
+func (o *OnDemandProbeServer) run() {
+	defer o.wg.Done()
+
+	statsUpdate := config.GetConfig().GetInt("agent.flow.stats_update")
+	ticker := time.NewTicker(time.Duration(statsUpdate) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			o.updateTableStats()
+		case <-o.quit:
+			return
+		}
+	}
+}
+
 func (o *OnDemandProbeServer) Start() error {
+	if !atomic.CompareAndSwapInt64(&o.state, common.StoppedState, common.RunningState) {
+		return nil
+	}
+
 	o.Graph.AddEventListener(o)
 	o.WSAsyncClientPool.AddEventHandler(o)
 
+	o.wg.Add(1)
+	go o.run()
+
 	return nil
 }
 
 func (o *OnDemandProbeServer) Stop() {
+	if !atomic.CompareAndSwapInt64(&o.state, common.RunningState, common.StoppingState) {
+		return
+	}
+
 	o.Graph.RemoveEventListener(o)
+	o.quit <- true
+	o.wg.Wait()
+
+	atomic.StoreInt64(&o.state, common.StoppedState)
 }
 
 func NewOnDemandProbeServer(fb *probes.FlowProbeBundle, g *graph.Graph, wspool *shttp.WSAsyncClientPool) (*OnDemandProbeServer, error) {
@@ -259,5 +375,8 @@ func NewOnDemandProbeServer(fb *probes.FlowProbeBundle, g *graph.Graph, wspool *
 		fta:               fb.FlowTableAllocator,
 		activeProbes:      make(map[graph.Identifier]*flow.Table),
 		captures:          make(map[graph.Identifier]*api.Capture),
+		recorders:         make(map[graph.Identifier]*flow.Recorder),
+		quit:              make(chan bool),
+		state:             common.StoppedState,
 	}, nil
 }
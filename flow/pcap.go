@@ -82,6 +82,7 @@ func (p *PcapWriter) FeedFlowTable() {
 		}
 
 		packet := gopacket.NewPacket(data, p.handleRead.LinkType(), gopacket.NoCopy)
+		packet.Metadata().CaptureInfo = ci
 		if p.replay {
 			timestamp = -1
 			intervalInCapture := ci.Timestamp.Sub(lastTS)
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// PCAP-NG block types and options, see
+// https://github.com/pcapng/pcapng
+const (
+	pcapNGSectionHeaderBlock  = 0x0A0D0D0A
+	pcapNGInterfaceBlock      = 0x00000001
+	pcapNGEnhancedPacketBlock = 0x00000006
+	pcapNGByteOrderMagic      = 0x1A2B3C4D
+	pcapNGOptEndOfOpt         = 0
+	pcapNGOptSHBUserAppl      = 4
+	pcapNGOptIfName           = 2
+	pcapNGOptIfDescription    = 3
+	pcapNGDefaultSnapLen      = 65536
+)
+
+// PcapNGWriter writes packets in the PCAP-NG format. Timestamps are written
+// with the default resolution, microseconds.
+type PcapNGWriter struct {
+	w          io.Writer
+	interfaces int
+}
+
+func pcapNGPad(l int) int {
+	return (4 - l%4) % 4
+}
+
+func (p *PcapNGWriter) writeBlock(blockType uint32, body []byte) (int, error) {
+	length := uint32(12 + len(body))
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, blockType)
+	binary.Write(&b, binary.LittleEndian, length)
+	b.Write(body)
+	binary.Write(&b, binary.LittleEndian, length)
+
+	return p.w.Write(b.Bytes())
+}
+
+func writePcapNGOption(b *bytes.Buffer, code uint16, value string) {
+	binary.Write(b, binary.LittleEndian, code)
+	binary.Write(b, binary.LittleEndian, uint16(len(value)))
+	b.WriteString(value)
+	b.Write(make([]byte, pcapNGPad(len(value))))
+}
+
+func writePcapNGEndOfOpt(b *bytes.Buffer) {
+	binary.Write(b, binary.LittleEndian, uint32(pcapNGOptEndOfOpt))
+}
+
+// WriteSectionHeader starts a new section, it has to be the first block of
+// a file
+func (p *PcapNGWriter) WriteSectionHeader() (int, error) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(pcapNGByteOrderMagic))
+	binary.Write(&b, binary.LittleEndian, uint16(1))
+	binary.Write(&b, binary.LittleEndian, uint16(0))
+	// section length not specified
+	binary.Write(&b, binary.LittleEndian, int64(-1))
+	writePcapNGOption(&b, pcapNGOptSHBUserAppl, "skydive")
+	writePcapNGEndOfOpt(&b)
+
+	p.interfaces = 0
+	return p.writeBlock(pcapNGSectionHeaderBlock, b.Bytes())
+}
+
+// WriteInterface describes a new interface and returns its index to be used
+// when writing packets, packets are truncated to snapLen bytes
+func (p *PcapNGWriter) WriteInterface(linkType layers.LinkType, snapLen int, name string, description string) (int, int, error) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint16(linkType))
+	binary.Write(&b, binary.LittleEndian, uint16(0))
+	binary.Write(&b, binary.LittleEndian, uint32(snapLen))
+	if name != "" {
+		writePcapNGOption(&b, pcapNGOptIfName, name)
+	}
+	if description != "" {
+		writePcapNGOption(&b, pcapNGOptIfDescription, description)
+	}
+	writePcapNGEndOfOpt(&b)
+
+	n, err := p.writeBlock(pcapNGInterfaceBlock, b.Bytes())
+	if err != nil {
+		return 0, n, err
+	}
+
+	index := p.interfaces
+	p.interfaces++
+	return index, n, nil
+}
+
+// WritePacket writes a packet captured on the interface of the given index
+func (p *PcapNGWriter) WritePacket(index int, ci gopacket.CaptureInfo, data []byte) (int, error) {
+	ts := uint64(ci.Timestamp.UnixNano() / 1000)
+
+	length := ci.Length
+	if length < len(data) {
+		length = len(data)
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(index))
+	binary.Write(&b, binary.LittleEndian, uint32(ts>>32))
+	binary.Write(&b, binary.LittleEndian, uint32(ts))
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	binary.Write(&b, binary.LittleEndian, uint32(length))
+	b.Write(data)
+	b.Write(make([]byte, pcapNGPad(len(data))))
+
+	return p.writeBlock(pcapNGEnhancedPacketBlock, b.Bytes())
+}
+
+func NewPcapNGWriter(w io.Writer) *PcapNGWriter {
+	return &PcapNGWriter{w: w}
+}
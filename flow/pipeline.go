@@ -26,6 +26,17 @@ type FlowEnhancer interface {
 	Enhance(flow *Flow)
 }
 
+// FlowUpdateEnhancer is implemented by the enhancers refreshing the flows at
+// each update as the information they add changes during the flow life
+type FlowUpdateEnhancer interface {
+	EnhanceUpdate(flow *Flow)
+}
+
+// FlowEnhancerStopper is implemented by the enhancers running goroutines
+type FlowEnhancerStopper interface {
+	Stop()
+}
+
 type FlowEnhancerPipeline struct {
 	Enhancers []FlowEnhancer
 }
@@ -36,6 +47,14 @@ func (fe *FlowEnhancerPipeline) EnhanceFlow(flow *Flow) {
 	}
 }
 
+func (fe *FlowEnhancerPipeline) EnhanceFlowUpdate(flow *Flow) {
+	for _, enhancer := range fe.Enhancers {
+		if e, ok := enhancer.(FlowUpdateEnhancer); ok {
+			e.EnhanceUpdate(flow)
+		}
+	}
+}
+
 func (fe *FlowEnhancerPipeline) Enhance(flows []*Flow) {
 	for _, flow := range flows {
 		fe.EnhanceFlow(flow)
@@ -46,6 +65,15 @@ func (fe *FlowEnhancerPipeline) AddEnhancer(e FlowEnhancer) {
 	fe.Enhancers = append(fe.Enhancers, e)
 }
 
+// Stop stops the enhancers running goroutines
+func (fe *FlowEnhancerPipeline) Stop() {
+	for _, enhancer := range fe.Enhancers {
+		if e, ok := enhancer.(FlowEnhancerStopper); ok {
+			e.Stop()
+		}
+	}
+}
+
 func NewFlowEnhancerPipeline(enhancers ...FlowEnhancer) *FlowEnhancerPipeline {
 	return &FlowEnhancerPipeline{
 		Enhancers: enhancers,
@@ -49,11 +49,13 @@ type packetHandle interface {
 }
 
 type GoPacketProbe struct {
-	handle       packetHandle
-	packetSource *gopacket.PacketSource
-	NodeTID      string
-	flowTable    *flow.Table
-	state        int64
+	handle     packetHandle
+	dataSource gopacket.PacketDataSource
+	decoder    gopacket.Decoder
+	sampler    *packetSampler
+	NodeTID    string
+	flowTable  *flow.Table
+	state      int64
 }
 
 type GoPacketProbesHandler struct {
@@ -92,9 +94,16 @@ func pcapUpdateStats(g *graph.Graph, n *graph.Node, handle *pcap.Handle, ticker
 
 func (p *GoPacketProbe) feedFlowTable(packetsChan chan *flow.FlowPackets) {
 	for atomic.LoadInt64(&p.state) == common.RunningState {
-		packet, err := p.packetSource.NextPacket()
+		data, ci, err := p.dataSource.ReadPacketData()
 		switch err {
 		case nil:
+			// sampling is done before decoding to save as much cpu as possible
+			if !p.sampler.keep(ci.Timestamp) {
+				continue
+			}
+
+			packet := gopacket.NewPacket(data, p.decoder, gopacket.Default)
+			packet.Metadata().CaptureInfo = ci
 			if flowPackets := flow.FlowPacketsFromGoPacket(&packet, 0, -1); len(flowPackets.Packets) > 0 {
 				packetsChan <- flowPackets
 			}
@@ -144,7 +153,8 @@ func (p *GoPacketProbe) run(g *graph.Graph, n *graph.Node, capture *api.Capture)
 		}
 
 		p.handle = handle
-		p.packetSource = gopacket.NewPacketSource(handle, handle.LinkType())
+		p.dataSource = handle
+		p.decoder = handle.LinkType()
 
 		// Go routine to update the interface statistics
 		statsUpdate := config.GetConfig().GetInt("agent.flow.stats_update")
@@ -170,7 +180,8 @@ func (p *GoPacketProbe) run(g *graph.Graph, n *graph.Node, capture *api.Capture)
 		}
 
 		p.handle = handle
-		p.packetSource = gopacket.NewPacketSource(handle, firstLayerType)
+		p.dataSource = handle
+		p.decoder = firstLayerType
 
 		logging.GetLogger().Infof("AfPacket Capture started on %s with First layer: %s", ifName, firstLayerType)
 	}
@@ -202,16 +213,27 @@ func (p *GoPacketProbe) run(g *graph.Graph, n *graph.Node, capture *api.Capture)
 		return
 	}
 
+	var samplerTicker *time.Ticker
+	if p.sampler.maxPPS > 0 {
+		samplerTicker = time.NewTicker(time.Second)
+
+		wg.Add(1)
+		go samplerUpdateStats(g, n, p.sampler, samplerTicker, statsDone, &wg)
+	}
+
 	packetsChan := p.flowTable.Start()
 	defer p.flowTable.Stop()
 
 	p.feedFlowTable(packetsChan)
 
+	close(statsDone)
+	wg.Wait()
 	if statsTicker != nil {
-		close(statsDone)
-		wg.Wait()
 		statsTicker.Stop()
 	}
+	if samplerTicker != nil {
+		samplerTicker.Stop()
+	}
 	p.handle.Close()
 	atomic.StoreInt64(&p.state, common.StoppedState)
 }
@@ -278,10 +300,13 @@ func (p *GoPacketProbesHandler) RegisterProbe(n *graph.Node, capture *api.Captur
 		logging.GetLogger().Infof("MPLSoUDP port: %v", port)
 	}
 
+	ft.SetSamplingRate(int64(capture.SamplingRate))
+
 	probe := &GoPacketProbe{
 		NodeTID:   tid,
 		state:     common.StoppedState,
 		flowTable: ft,
+		sampler:   newPacketSampler(capture),
 	}
 
 	p.probesLock.Lock()
@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2017 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skydive-project/skydive/api"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// packetSampler selects the packets of a capture that will be decoded and
+// pushed to the flow table. 1 packet out of rate is kept and at most maxPPS
+// packets are kept per second.
+type packetSampler struct {
+	rate     int64
+	maxPPS   int64
+	count    int64
+	second   int64
+	inSecond int64
+	limited  int64
+}
+
+// keep returns whether the packet captured at the given time has to be
+// processed
+func (s *packetSampler) keep(t time.Time) bool {
+	if s.rate > 1 {
+		s.count++
+		if s.count%s.rate != 0 {
+			return false
+		}
+	}
+
+	if s.maxPPS > 0 {
+		if second := t.Unix(); second != s.second {
+			s.second = second
+			s.inSecond = 0
+		}
+
+		if s.inSecond >= s.maxPPS {
+			atomic.AddInt64(&s.limited, 1)
+			return false
+		}
+		s.inSecond++
+	}
+
+	return true
+}
+
+func (s *packetSampler) packetsLimited() int64 {
+	return atomic.LoadInt64(&s.limited)
+}
+
+func samplerUpdateStats(g *graph.Graph, n *graph.Node, sampler *packetSampler, ticker *time.Ticker, done chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	var last int64
+	for {
+		select {
+		case <-ticker.C:
+			if limited := sampler.packetsLimited(); limited != last {
+				if last == 0 {
+					logging.GetLogger().Warningf("Packets per second budget reached on capture node %s", n.ID)
+				}
+				last = limited
+
+				g.Lock()
+				t := g.StartMetadataTransaction(n)
+				t.AddMetadata("Capture/PacketsLimited", limited)
+				t.Commit()
+				g.Unlock()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func newPacketSampler(capture *api.Capture) *packetSampler {
+	return &packetSampler{
+		rate:   int64(capture.SamplingRate),
+		maxPPS: int64(capture.MaxPPS),
+	}
+}
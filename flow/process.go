@@ -0,0 +1,55 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import "github.com/skydive-project/skydive/common"
+
+func (p *ProcessInfo) GetField(field string) (string, error) {
+	if p == nil {
+		return "", common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "Name":
+		return p.Name, nil
+	case "Cmdline":
+		return p.Cmdline, nil
+	case "ContainerID":
+		return p.ContainerID, nil
+	case "ContainerName":
+		return p.ContainerName, nil
+	}
+	return "", common.ErrFieldNotFound
+}
+
+func (p *ProcessInfo) GetFieldInt64(field string) (int64, error) {
+	if p == nil {
+		return 0, common.ErrFieldNotFound
+	}
+
+	switch field {
+	case "PID":
+		return p.PID, nil
+	}
+	return 0, common.ErrFieldNotFound
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	tableFlows = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "skydive",
+			Subsystem: "flow_table",
+			Name:      "flows",
+			Help:      "Number of flows in the flow table of a capture node",
+		},
+		[]string{"node"},
+	)
+	tablePackets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "skydive",
+			Subsystem: "flow_table",
+			Name:      "packets_total",
+			Help:      "Number of packets processed by the flow table of a capture node",
+		},
+		[]string{"node"},
+	)
+)
+
+// tableMetrics holds the metrics of the capture node of a table, resolved once
+// so that the packet path doesn't look up the labels
+type tableMetrics struct {
+	flows   prometheus.Gauge
+	packets prometheus.Counter
+}
+
+func newTableMetrics(nodeTID string) *tableMetrics {
+	return &tableMetrics{
+		flows:   tableFlows.WithLabelValues(nodeTID),
+		packets: tablePackets.WithLabelValues(nodeTID),
+	}
+}
+
+// deleteTableMetrics removes the metrics of a table once stopped
+func deleteTableMetrics(nodeTID string) {
+	tableFlows.DeleteLabelValues(nodeTID)
+	tablePackets.DeleteLabelValues(nodeTID)
+}
+
+func init() {
+	prometheus.MustRegister(tableFlows)
+	prometheus.MustRegister(tablePackets)
+}
@@ -0,0 +1,334 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+// RecordingExt is the extension of the recording files
+const RecordingExt = ".pcapng"
+
+const (
+	recorderQueueSize     = 1000
+	recorderFlushInterval = time.Second
+)
+
+// RecorderOpts defines when the recording files are rotated and how long they
+// are kept. 0 means no limit. Packets are truncated to SnapLen bytes, 0
+// meaning the default snap length.
+type RecorderOpts struct {
+	Path      string
+	MaxSize   int64
+	MaxAge    time.Duration
+	MaxFiles  int
+	Retention time.Duration
+	SnapLen   int
+}
+
+// Recorder continuously writes the packets of a capture node to PCAP-NG
+// files, one directory per capture. Files are rotated according to their size
+// and age, the oldest files are removed according to the retention options.
+// Packets are written by a dedicated goroutine so that the disk latency
+// doesn't stall the flow table, packets are dropped if it can't keep up.
+type Recorder struct {
+	sync.Mutex
+	opts       RecorderOpts
+	dir        string
+	nodeTID    string
+	nodeName   string
+	packets    chan *gopacket.Packet
+	closed     bool
+	dropped    int64
+	wg         sync.WaitGroup
+	file       *os.File
+	buffer     *bufio.Writer
+	writer     *PcapNGWriter
+	interfaces map[layers.LinkType]int
+	size       int64
+	opened     time.Time
+}
+
+// RecordingFile describes a recording file of a capture
+type RecordingFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+func (r *Recorder) close() {
+	if r.file == nil {
+		return
+	}
+
+	if err := r.buffer.Flush(); err != nil {
+		logging.GetLogger().Errorf("Error while flushing recording file %s: %s", r.file.Name(), err.Error())
+	}
+
+	if err := r.file.Close(); err != nil {
+		logging.GetLogger().Errorf("Error while closing recording file %s: %s", r.file.Name(), err.Error())
+	}
+	r.file = nil
+}
+
+func (r *Recorder) rotate(now time.Time) error {
+	r.close()
+
+	name := fmt.Sprintf("%s-%s%s", r.nodeTID, now.UTC().Format("20060102T150405.000"), RecordingExt)
+	file, err := os.Create(filepath.Join(r.dir, name))
+	if err != nil {
+		return err
+	}
+
+	r.file = file
+	r.buffer = bufio.NewWriter(file)
+	r.writer = NewPcapNGWriter(r.buffer)
+	r.interfaces = make(map[layers.LinkType]int)
+	r.opened = now
+
+	n, err := r.writer.WriteSectionHeader()
+	r.size = int64(n)
+	if err != nil {
+		return err
+	}
+
+	r.applyRetention(now)
+
+	return nil
+}
+
+// applyRetention removes the oldest recording files of the node
+func (r *Recorder) applyRetention(now time.Time) {
+	files, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to read recording directory %s: %s", r.dir, err.Error())
+		return
+	}
+
+	var names []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), r.nodeTID+"-") && strings.HasSuffix(f.Name(), RecordingExt) {
+			if r.opts.Retention > 0 && now.Sub(f.ModTime()) > r.opts.Retention {
+				r.remove(f.Name())
+				continue
+			}
+			names = append(names, f.Name())
+		}
+	}
+
+	// names contain the timestamp of the file so the oldest files come first
+	sort.Strings(names)
+	if r.opts.MaxFiles > 0 {
+		for len(names) > r.opts.MaxFiles {
+			r.remove(names[0])
+			names = names[1:]
+		}
+	}
+}
+
+func (r *Recorder) remove(name string) {
+	if r.file != nil && filepath.Base(r.file.Name()) == name {
+		return
+	}
+
+	if err := os.Remove(filepath.Join(r.dir, name)); err != nil {
+		logging.GetLogger().Errorf("Unable to remove recording file %s: %s", name, err.Error())
+	}
+}
+
+func (r *Recorder) needRotate(now time.Time) bool {
+	return r.file == nil ||
+		(r.opts.MaxSize > 0 && r.size >= r.opts.MaxSize) ||
+		(r.opts.MaxAge > 0 && now.Sub(r.opened) >= r.opts.MaxAge)
+}
+
+// Record queues a packet to be written to the current recording file
+func (r *Recorder) Record(packet *gopacket.Packet) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.packets <- packet:
+	default:
+		if r.dropped++; r.dropped == 1 {
+			logging.GetLogger().Errorf("Recording of %s too slow, dropping packets", r.nodeTID)
+		}
+	}
+}
+
+func (r *Recorder) flush() {
+	if r.file == nil {
+		return
+	}
+
+	if err := r.buffer.Flush(); err != nil {
+		logging.GetLogger().Errorf("Unable to write recording of %s: %s", r.nodeTID, err.Error())
+	}
+}
+
+func (r *Recorder) write(packet *gopacket.Packet) {
+	ci := (*packet).Metadata().CaptureInfo
+	now := ci.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+		ci.Timestamp = now
+	}
+
+	if r.needRotate(now) {
+		if err := r.rotate(now); err != nil {
+			logging.GetLogger().Errorf("Unable to rotate recording of %s: %s", r.nodeTID, err.Error())
+			r.close()
+			return
+		}
+	}
+
+	linkType := linkTypeFromPacket(packet)
+	index, ok := r.interfaces[linkType]
+	if !ok {
+		var n int
+		var err error
+		if index, n, err = r.writer.WriteInterface(linkType, r.opts.SnapLen, r.nodeName, r.nodeTID); err != nil {
+			logging.GetLogger().Errorf("Unable to write recording of %s: %s", r.nodeTID, err.Error())
+			return
+		}
+		r.interfaces[linkType] = index
+		r.size += int64(n)
+	}
+
+	data := (*packet).Data()
+	if ci.Length < len(data) {
+		ci.Length = len(data)
+	}
+	if len(data) > r.opts.SnapLen {
+		data = data[:r.opts.SnapLen]
+	}
+
+	n, err := r.writer.WritePacket(index, ci, data)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to write recording of %s: %s", r.nodeTID, err.Error())
+		return
+	}
+	r.size += int64(n)
+}
+
+func (r *Recorder) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(recorderFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case packet, ok := <-r.packets:
+			if !ok {
+				r.close()
+				return
+			}
+			r.write(packet)
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+// Close writes the queued packets and closes the current recording file
+func (r *Recorder) Close() {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.packets)
+	r.Unlock()
+
+	r.wg.Wait()
+}
+
+// RecordingDir returns the directory of the recording files of a capture
+func RecordingDir(path string, captureID string) string {
+	return filepath.Join(path, captureID)
+}
+
+// ListRecordingFiles returns the recording files of a capture
+func ListRecordingFiles(path string, captureID string) ([]*RecordingFile, error) {
+	files, err := ioutil.ReadDir(RecordingDir(path, captureID))
+	if err != nil {
+		return nil, err
+	}
+
+	var recordings []*RecordingFile
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), RecordingExt) {
+			recordings = append(recordings, &RecordingFile{
+				Name:    f.Name(),
+				Size:    f.Size(),
+				ModTime: f.ModTime(),
+			})
+		}
+	}
+
+	return recordings, nil
+}
+
+// NewRecorder returns a recorder writing the packets of a capture node
+func NewRecorder(captureID string, nodeTID string, nodeName string, opts RecorderOpts) (*Recorder, error) {
+	dir := RecordingDir(opts.Path, captureID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if opts.SnapLen <= 0 {
+		opts.SnapLen = pcapNGDefaultSnapLen
+	}
+
+	r := &Recorder{
+		opts:     opts,
+		dir:      dir,
+		nodeTID:  nodeTID,
+		nodeName: nodeName,
+		packets:  make(chan *gopacket.Packet, recorderQueueSize),
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r, nil
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// rotate on every packet
+	opts := RecorderOpts{Path: dir, MaxSize: 1, MaxFiles: 2}
+	recorder, err := NewRecorder("capture-id", "node-tid", "eth0", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(0); i < 4; i++ {
+		packet := forgeTestPacket(t, i, false, ETH, IPv4, TCP)
+		(*packet).Metadata().Timestamp = time.Unix(1000+i, 0)
+		recorder.Record(packet)
+	}
+	recorder.Close()
+
+	files, err := ListRecordingFiles(dir, "capture-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("Only 2 recording files should be kept, got %d", len(files))
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(RecordingDir(dir, "capture-id"), files[1].Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) < 4 || binary.LittleEndian.Uint32(data) != pcapNGSectionHeaderBlock {
+		t.Fatal("Recording file should start with a section header block")
+	}
+
+	// the interface block carries the node name and TID
+	if !bytes.Contains(data, []byte("eth0")) || !bytes.Contains(data, []byte("node-tid")) {
+		t.Error("Interface block should contain the node name and TID")
+	}
+
+	if files[1].Name != "node-tid-19700101T001643.000.pcapng" {
+		t.Errorf("Last packet should be in the last file, got %s", files[1].Name)
+	}
+}
+
+func TestRecorderSnapLen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recorder, err := NewRecorder("capture-id", "node-tid", "eth0", RecorderOpts{Path: dir, SnapLen: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := forgeTestPacket(t, 1, false, ETH, IPv4, TCP)
+	(*packet).Metadata().Timestamp = time.Unix(1000, 0)
+	recorder.Record(packet)
+	recorder.Close()
+
+	files, err := ListRecordingFiles(dir, "capture-id")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one recording file, got %v: %v", files, err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(RecordingDir(dir, "capture-id"), files[0].Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// section header, interface and enhanced packet blocks
+	idb := binary.LittleEndian.Uint32(data[4:])
+	if snapLen := binary.LittleEndian.Uint32(data[idb+12:]); snapLen != 20 {
+		t.Errorf("Interface snap length should be 20, got %d", snapLen)
+	}
+
+	epb := idb + binary.LittleEndian.Uint32(data[idb+4:])
+	captured := binary.LittleEndian.Uint32(data[epb+20:])
+	original := binary.LittleEndian.Uint32(data[epb+24:])
+	if captured != 20 || original != uint32(len((*packet).Data())) {
+		t.Errorf("Packet should be truncated to 20 bytes, got %d of %d", captured, original)
+	}
+}
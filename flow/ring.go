@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const ringSnapLen = 65536
+
+// rawPacket is a packet as captured on the wire, it can be shared by the
+// rings of the outer and the inner flows of an encapsulated packet
+type rawPacket struct {
+	ci   gopacket.CaptureInfo
+	data []byte
+}
+
+// packetRing keeps the last packets of a flow, the oldest packets are
+// removed when either the packets limit or the bytes limit is reached
+type packetRing struct {
+	linkType layers.LinkType
+	packets  []*rawPacket
+	bytes    int64
+}
+
+func linkTypeFromPacket(packet *gopacket.Packet) layers.LinkType {
+	packetLayers := (*packet).Layers()
+	if len(packetLayers) == 0 {
+		return layers.LinkTypeEthernet
+	}
+
+	switch packetLayers[0].LayerType() {
+	case layers.LayerTypeIPv4, layers.LayerTypeIPv6:
+		return layers.LinkTypeRaw
+	case layers.LayerTypeLinuxSLL:
+		return layers.LinkTypeLinuxSLL
+	}
+	return layers.LinkTypeEthernet
+}
+
+func newRawPacket(packet *gopacket.Packet) *rawPacket {
+	src := (*packet).Data()
+	data := make([]byte, len(src))
+	copy(data, src)
+
+	ci := (*packet).Metadata().CaptureInfo
+	if ci.Timestamp.IsZero() {
+		ci.Timestamp = time.Now()
+	}
+	ci.CaptureLength = len(data)
+	if ci.Length < ci.CaptureLength {
+		ci.Length = ci.CaptureLength
+	}
+
+	return &rawPacket{ci: ci, data: data}
+}
+
+func (r *packetRing) push(p *rawPacket, maxPackets, maxBytes int64) {
+	r.packets = append(r.packets, p)
+	r.bytes += int64(len(p.data))
+
+	for len(r.packets) > 1 && ((maxPackets > 0 && int64(len(r.packets)) > maxPackets) || (maxBytes > 0 && r.bytes > maxBytes)) {
+		r.bytes -= int64(len(r.packets[0].data))
+		r.packets[0] = nil
+		r.packets = r.packets[1:]
+	}
+}
+
+func (r *packetRing) writePCAP(w io.Writer) error {
+	writer := pcapgo.NewWriter(w)
+	if err := writer.WriteFileHeader(ringSnapLen, r.linkType); err != nil {
+		return err
+	}
+
+	for _, p := range r.packets {
+		if err := writer.WritePacket(p.ci, p.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *packetRing) pcap() ([]byte, error) {
+	var b bytes.Buffer
+	if err := r.writePCAP(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
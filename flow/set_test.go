@@ -114,3 +114,62 @@ func TestMergeDedup(t *testing.T) {
 		t.Errorf("Flowset mismatch, expected: \n\n%s\n\ngot: \n\n%s", string(e), string(f))
 	}
 }
+
+func TestConversation(t *testing.T) {
+	flows := []*Flow{
+		{
+			UUID: "c", TrackingID: "aaa", NodeTID: "333", Start: 1002,
+			Network: &FlowLayer{A: "192.168.0.2", B: "192.168.0.1"},
+			Metric:  &FlowMetric{ABPackets: 8, BAPackets: 7},
+		},
+		{
+			UUID: "a", TrackingID: "aaa", NodeTID: "111", Start: 1000,
+			Network: &FlowLayer{A: "192.168.0.1", B: "192.168.0.2"},
+			Metric:  &FlowMetric{ABPackets: 10, BAPackets: 10},
+		},
+		{
+			UUID: "b", TrackingID: "aaa", NodeTID: "222", Start: 1005,
+			Network: &FlowLayer{A: "192.168.0.1", B: "192.168.0.2"},
+			Metric:  &FlowMetric{ABPackets: 9, BAPackets: 9},
+		},
+		{
+			UUID: "d", TrackingID: "bbb", NodeTID: "111", Start: 1000,
+		},
+	}
+
+	distances := func(first *Flow, flows []*Flow) map[string]int {
+		if first.TrackingID != "aaa" || first.UUID != "a" {
+			return nil
+		}
+		return map[string]int{"111": 0, "222": 2, "333": 4}
+	}
+
+	conversations := Conversations(flows, distances)
+	if len(conversations) != 2 {
+		t.Fatalf("Should get 2 conversations, got %d", len(conversations))
+	}
+
+	c := conversations[0]
+	if c.TrackingID != "aaa" || len(c.Hops) != 3 {
+		t.Fatalf("Wrong conversation: %+v", c)
+	}
+
+	for i, uuid := range []string{"a", "b", "c"} {
+		if c.Hops[i].FlowUUID != uuid {
+			t.Errorf("Hop %d should be flow %s, got %s", i, uuid, c.Hops[i].FlowUUID)
+		}
+	}
+
+	if hop := c.Hops[1]; hop.ABPacketsDelta != -1 || hop.BAPacketsDelta != -1 || hop.LatencyDelta != 5 {
+		t.Errorf("Wrong deltas for the second hop: %+v", hop)
+	}
+
+	// metric of the last hop has to be swapped as A and B are reversed
+	if hop := c.Hops[2]; hop.Metric.ABPackets != 7 || hop.ABPacketsDelta != -2 || hop.BAPacketsDelta != -1 {
+		t.Errorf("Wrong deltas for the last hop: %+v", hop)
+	}
+
+	if c := conversations[1]; c.TrackingID != "bbb" || len(c.Hops) != 1 || c.Hops[0].Distance != -1 {
+		t.Errorf("Wrong conversation: %+v", c)
+	}
+}
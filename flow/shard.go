@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+type tableTickKind int
+
+const (
+	tickUpdate tableTickKind = iota
+	tickExpire
+	tickClock
+)
+
+// tableTick is sent by a sharded table to all its shards so that they share
+// the same clock for the update and the expire of their flows
+type tableTick struct {
+	kind tableTickKind
+	now  time.Time
+}
+
+func (ft *Table) newShards(updateHandler *FlowHandler, expireHandler *FlowHandler, pipeline *FlowEnhancerPipeline) {
+	n := ft.opts.Shards
+
+	opts := ft.opts
+	opts.Shards = 0
+	if opts.MaxFlows > 0 {
+		opts.MaxFlows = (opts.MaxFlows + int64(n) - 1) / int64(n)
+	}
+
+	for i := 0; i < n; i++ {
+		shard := NewTable(updateHandler, expireHandler, pipeline, opts)
+		shard.ticks = make(chan tableTick, 10)
+		ft.shards = append(ft.shards, shard)
+	}
+}
+
+func (ft *Table) onTick(tick tableTick) {
+	switch tick.kind {
+	case tickUpdate:
+		ft.updateAt(tick.now)
+	case tickExpire:
+		ft.expireAt(tick.now)
+	case tickClock:
+		ft.tableClock = common.UnixMillis(tick.now)
+		ft.flushEvicted()
+	}
+}
+
+// shardFor returns the shard handling the flows of the given packets. The
+// hash is the one of the flow key of the outer packet so that both directions
+// of a flow and all its inner flows end up in the same shard.
+func (ft *Table) shardFor(packets *FlowPackets) *Table {
+	if len(packets.Packets) == 0 {
+		return ft.shards[0]
+	}
+
+	p := packets.Packets[0].gopacket
+	hash := layerFlow((*p).NetworkLayer()).FastHash() ^ layerFlow((*p).TransportLayer()).FastHash()
+	return ft.shards[hash%uint64(len(ft.shards))]
+}
+
+func (ft *Table) broadcast(tick tableTick) {
+	for _, shard := range ft.shards {
+		shard.ticks <- tick
+	}
+}
+
+func (ft *Table) queryShards(query *TableQuery) *TableReply {
+	var replies []*TableReply
+	for _, shard := range ft.shards {
+		if reply := shard.Query(query); reply != nil {
+			replies = append(replies, reply)
+		}
+	}
+
+	if len(replies) == 0 {
+		return nil
+	}
+	return aggregateReplies(replies)
+}
+
+// dispatch is the main loop of a sharded table, packets are forwarded to the
+// shards and the ticks are broadcasted to all of them
+func (ft *Table) dispatch() {
+	ft.wg.Add(1)
+	defer ft.wg.Done()
+
+	updateTicker := time.NewTicker(ft.updateHandler.every)
+	defer updateTicker.Stop()
+
+	expireTicker := time.NewTicker(ft.expireHandler.every)
+	defer expireTicker.Stop()
+
+	nowTicker := time.NewTicker(time.Second * 1)
+	defer nowTicker.Stop()
+
+	atomic.StoreInt64(&ft.state, common.RunningState)
+	for atomic.LoadInt64(&ft.state) == common.RunningState {
+		select {
+		case now := <-expireTicker.C:
+			ft.broadcast(tableTick{kind: tickExpire, now: now})
+		case now := <-updateTicker.C:
+			ft.broadcast(tableTick{kind: tickUpdate, now: now})
+		case now := <-nowTicker.C:
+			ft.broadcast(tableTick{kind: tickClock, now: now})
+		case packets := <-ft.PacketsChan:
+			ft.shardFor(packets).PacketsChan <- packets
+		}
+	}
+}
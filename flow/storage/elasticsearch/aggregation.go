@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+)
+
+type aggregationBucket struct {
+	Key      interface{} `json:"key"`
+	DocCount float64     `json:"doc_count"`
+	Value    *struct {
+		Value float64 `json:"value"`
+	} `json:"value"`
+	Histogram *aggregationResult `json:"histogram"`
+}
+
+type aggregationResult struct {
+	Value   float64              `json:"value"`
+	Buckets []*aggregationBucket `json:"buckets"`
+}
+
+// metricAggregation returns the aggregation computing the value of a group
+// or of the whole set of flows
+func metricAggregation(a *flow.Aggregation) (map[string]interface{}, error) {
+	switch a.Type {
+	case flow.SumAggregation, flow.TopKAggregation:
+		return map[string]interface{}{
+			"value": map[string]interface{}{
+				"sum": map[string]interface{}{"field": a.Field},
+			},
+		}, nil
+	case flow.HistogramAggregation:
+		if a.Interval <= 0 {
+			return nil, errors.New("Histogram interval has to be strictly positive")
+		}
+		return map[string]interface{}{
+			"histogram": map[string]interface{}{
+				"histogram": map[string]interface{}{
+					"field":         a.Field,
+					"interval":      a.Interval,
+					"min_doc_count": 1,
+				},
+			},
+		}, nil
+	case flow.CountAggregation:
+		return nil, nil
+	}
+	return nil, flow.ErrInvalidAggregation
+}
+
+// aggregationRequest translates a flow aggregation to an ES aggregation
+func aggregationRequest(a *flow.Aggregation) (map[string]interface{}, error) {
+	aggs, err := metricAggregation(a)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.GroupBy == "" {
+		if a.Type == flow.TopKAggregation || a.Type == flow.CountAggregation {
+			return nil, flow.ErrInvalidAggregation
+		}
+		return aggs, nil
+	}
+
+	// a size of 0 returns all the groups
+	terms := map[string]interface{}{"field": a.GroupBy, "size": 0}
+	if a.Type == flow.TopKAggregation {
+		terms["size"] = a.K
+		terms["order"] = map[string]string{"value": "desc"}
+	}
+
+	groups := map[string]interface{}{"terms": terms}
+	if aggs != nil {
+		groups["aggs"] = aggs
+	}
+
+	return map[string]interface{}{"groups": groups}, nil
+}
+
+// groupKey formats the key of a terms bucket like flow.FlowSet.GroupBy does
+func groupKey(key interface{}) string {
+	if k, ok := key.(float64); ok {
+		return strconv.FormatInt(int64(k), 10)
+	}
+	return fmt.Sprintf("%v", key)
+}
+
+func histogramBuckets(result *aggregationResult) []*flow.AggregationBucket {
+	buckets := make([]*flow.AggregationBucket, len(result.Buckets))
+	for i, b := range result.Buckets {
+		var key int64
+		if k, ok := b.Key.(float64); ok {
+			key = int64(k)
+		}
+		buckets[i] = &flow.AggregationBucket{Key: key, Value: b.DocCount}
+	}
+	return buckets
+}
+
+// aggregationValue returns the value computed by metricAggregation
+func aggregationValue(a *flow.Aggregation, bucket *aggregationBucket) interface{} {
+	switch a.Type {
+	case flow.HistogramAggregation:
+		if bucket.Histogram == nil {
+			return []*flow.AggregationBucket{}
+		}
+		return histogramBuckets(bucket.Histogram)
+	case flow.CountAggregation:
+		return bucket.DocCount
+	}
+
+	if bucket.Value == nil {
+		return float64(0)
+	}
+	return bucket.Value.Value
+}
+
+// aggregationResponse converts the ES aggregations to the format of
+// flow.FlowSet.Aggregate
+func aggregationResponse(a *flow.Aggregation, data []byte) (interface{}, error) {
+	var response struct {
+		Aggregations struct {
+			Value     *aggregationResult `json:"value"`
+			Histogram *aggregationResult `json:"histogram"`
+			Groups    *aggregationResult `json:"groups"`
+		} `json:"aggregations"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+
+	aggs := response.Aggregations
+	if a.GroupBy == "" {
+		switch {
+		case aggs.Value != nil:
+			return aggs.Value.Value, nil
+		case aggs.Histogram != nil:
+			return histogramBuckets(aggs.Histogram), nil
+		}
+		return nil, errors.New("No aggregation in the response")
+	}
+
+	if aggs.Groups == nil {
+		return nil, errors.New("No aggregation in the response")
+	}
+
+	if a.Type == flow.TopKAggregation {
+		buckets := make([]*flow.AggregationBucket, len(aggs.Groups.Buckets))
+		for i, b := range aggs.Groups.Buckets {
+			buckets[i] = &flow.AggregationBucket{Key: groupKey(b.Key), Value: aggregationValue(a, b).(float64)}
+		}
+		return buckets, nil
+	}
+
+	values := make(map[string]interface{}, len(aggs.Groups.Buckets))
+	for _, b := range aggs.Groups.Buckets {
+		values[groupKey(b.Key)] = aggregationValue(a, b)
+	}
+	return values, nil
+}
+
+// AggregateFlows computes the aggregation with the ES aggregations
+func (c *ElasticSearchStorage) AggregateFlows(fsq filters.SearchQuery, a *flow.Aggregation) (interface{}, error) {
+	if !c.client.Started() {
+		return nil, errors.New("ElasticSearchStorage is not yet started")
+	}
+
+	aggs, err := aggregationRequest(a)
+	if err != nil {
+		return nil, err
+	}
+
+	request := map[string]interface{}{
+		"size":  0,
+		"query": c.client.FormatFilter(fsq.Filter, "", false),
+		"aggs":  aggs,
+	}
+
+	data, err := c.client.SearchRaw("flow", request)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregationResponse(a, data)
+}
@@ -25,10 +25,13 @@ package elasticsearch
 import (
 	"encoding/json"
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/lebauce/elastigo/lib"
 	"github.com/mitchellh/mapstructure"
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/logging"
@@ -124,7 +127,12 @@ const metricMapping = `
 }`
 
 type ElasticSearchStorage struct {
-	client *esclient.ElasticSearchClient
+	client              *esclient.ElasticSearchClient
+	retention           map[string]time.Duration
+	rollups             []rollupLevel
+	maintenanceInterval time.Duration
+	quit                chan bool
+	wg                  sync.WaitGroup
 }
 
 func (c *ElasticSearchStorage) StoreFlows(flows []*flow.Flow) error {
@@ -148,7 +156,7 @@ func (c *ElasticSearchStorage) StoreFlows(flows []*flow.Flow) error {
 				"Start":     f.LastUpdateStart,
 				"Last":      f.LastUpdateLast,
 			}
-			if err := c.client.IndexChild("metric", f.UUID, "", metric); err != nil {
+			if err := c.client.IndexChild(metricType, f.UUID, "", metric); err != nil {
 				logging.GetLogger().Errorf("Error while indexing: %s", err.Error())
 				continue
 			}
@@ -217,7 +225,7 @@ func (c *ElasticSearchStorage) SearchMetrics(fsq filters.SearchQuery, metricFilt
 		}
 	}
 
-	out, err := c.sendRequest("metric", request)
+	out, err := c.sendRequest(c.metricTypes(metricFilter, time.Now()), request)
 	if err != nil {
 		return nil, err
 	}
@@ -298,12 +306,23 @@ func (c *ElasticSearchStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowS
 
 func (c *ElasticSearchStorage) Start() {
 	go c.client.Start([]map[string][]byte{
-		{"metric": []byte(metricMapping)},
+		{metricType: []byte(metricMapping)},
+		{metricMinuteType: []byte(metricMapping)},
+		{metricHourType: []byte(metricMapping)},
 		{"flow": []byte(flowMapping)}},
 	)
+
+	if len(c.rollups) > 0 || len(c.retention) > 0 {
+		c.wg.Add(1)
+		go c.runMaintenance()
+	}
 }
 
 func (c *ElasticSearchStorage) Stop() {
+	if len(c.rollups) > 0 || len(c.retention) > 0 {
+		c.quit <- true
+		c.wg.Wait()
+	}
 	c.client.Stop()
 }
 
@@ -313,5 +332,43 @@ func New() (*ElasticSearchStorage, error) {
 		return nil, err
 	}
 
-	return &ElasticSearchStorage{client: client}, nil
+	cfg := config.GetConfig()
+	storage := &ElasticSearchStorage{
+		client:              client,
+		retention:           make(map[string]time.Duration),
+		maintenanceInterval: time.Duration(cfg.GetInt("storage.elasticsearch.rollup.interval")) * time.Second,
+		quit:                make(chan bool),
+	}
+
+	if storage.maintenanceInterval <= 0 {
+		return nil, errors.New("storage.elasticsearch.rollup.interval has to be strictly positive")
+	}
+
+	// retention in seconds per document type, 0 to keep the documents forever
+	for _, obj := range []string{"flow", metricType, metricMinuteType, metricHourType} {
+		if retention := cfg.GetInt("storage.elasticsearch.retention." + obj); retention > 0 {
+			storage.retention[obj] = time.Duration(retention) * time.Second
+		}
+	}
+
+	source := metricType
+	if after := cfg.GetInt("storage.elasticsearch.rollup.minute_after"); after > 0 {
+		storage.rollups = append(storage.rollups, rollupLevel{
+			source: source,
+			target: metricMinuteType,
+			bucket: int64(time.Minute / time.Millisecond),
+			after:  time.Duration(after) * time.Second,
+		})
+		source = metricMinuteType
+	}
+	if after := cfg.GetInt("storage.elasticsearch.rollup.hour_after"); after > 0 {
+		storage.rollups = append(storage.rollups, rollupLevel{
+			source: source,
+			target: metricHourType,
+			bucket: int64(time.Hour / time.Millisecond),
+			after:  time.Duration(after) * time.Second,
+		})
+	}
+
+	return storage, nil
 }
@@ -0,0 +1,361 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	esclient "github.com/skydive-project/skydive/storage/elasticsearch"
+)
+
+// document types of the metrics according to their resolution
+const (
+	metricType       = "metric"
+	metricMinuteType = "metric_1m"
+	metricHourType   = "metric_1h"
+)
+
+// rollupLevel aggregates the metrics of the source type older than after
+// into buckets of the target type
+type rollupLevel struct {
+	source string
+	target string
+	bucket int64
+	after  time.Duration
+}
+
+type rollupKey struct {
+	parent string
+	start  int64
+}
+
+// id returns the identifier of the rolled up document of the bucket, a
+// rerun of the rollup updates the same document
+func (k rollupKey) id() string {
+	return fmt.Sprintf("%s-%d", k.parent, k.start)
+}
+
+// rollupMetric is a metric document, LastSourceStart is the start of the
+// last source metric aggregated into a rolled up document. The source
+// metrics of a flow are rolled up by ascending start, the ones starting
+// before are already counted.
+type rollupMetric struct {
+	ABPackets       int64
+	ABBytes         int64
+	BAPackets       int64
+	BABytes         int64
+	Start           int64
+	Last            int64
+	LastSourceStart int64 `json:",omitempty"`
+}
+
+// rollupBucket is a rolled up document with the version it was read at, 0 if
+// it doesn't exist yet
+type rollupBucket struct {
+	metric  rollupMetric
+	version int64
+	updated bool
+}
+
+type sourceMetric struct {
+	key    rollupKey
+	metric rollupMetric
+}
+
+// decodeMetrics decodes the metric documents and returns them with the key of
+// the bucket of the given duration in milliseconds they belong to, a metric
+// belongs to the bucket of its start
+func decodeMetrics(hits []esclient.Hit, bucket int64) ([]sourceMetric, error) {
+	metrics := make([]sourceMetric, 0, len(hits))
+	for _, hit := range hits {
+		if hit.Source == nil {
+			continue
+		}
+
+		var m rollupMetric
+		if err := json.Unmarshal([]byte(*hit.Source), &m); err != nil {
+			return nil, err
+		}
+
+		key := rollupKey{parent: hit.Parent, start: m.Start - m.Start%bucket}
+		metrics = append(metrics, sourceMetric{key: key, metric: m})
+	}
+
+	return metrics, nil
+}
+
+// rollupMetrics aggregates the metrics into the buckets, the metrics already
+// counted by a previous run are skipped
+func rollupMetrics(metrics []sourceMetric, bucket int64, buckets map[rollupKey]*rollupBucket) {
+	watermarks := make(map[rollupKey]int64)
+	for key, b := range buckets {
+		watermarks[key] = b.metric.LastSourceStart
+	}
+
+	for _, s := range metrics {
+		b, ok := buckets[s.key]
+		if !ok {
+			b = &rollupBucket{metric: rollupMetric{Start: s.key.start, Last: s.key.start + bucket}}
+			buckets[s.key] = b
+		}
+
+		if s.metric.Start <= watermarks[s.key] {
+			continue
+		}
+
+		b.metric.ABPackets += s.metric.ABPackets
+		b.metric.ABBytes += s.metric.ABBytes
+		b.metric.BAPackets += s.metric.BAPackets
+		b.metric.BABytes += s.metric.BABytes
+		b.metric.LastSourceStart = common.MaxInt64(b.metric.LastSourceStart, s.metric.Start)
+		b.updated = true
+	}
+}
+
+// getBuckets returns the documents already rolled up for the given keys
+func (c *ElasticSearchStorage) getBuckets(level rollupLevel, metrics []sourceMetric) (map[rollupKey]*rollupBucket, error) {
+	var keys []rollupKey
+	var hits []esclient.Hit
+	seen := make(map[rollupKey]bool)
+	for _, s := range metrics {
+		if !seen[s.key] {
+			seen[s.key] = true
+			keys = append(keys, s.key)
+			hits = append(hits, esclient.Hit{ID: s.key.id(), Parent: s.key.parent})
+		}
+	}
+
+	docs, err := c.client.MultiGet(level.target, hits)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[rollupKey]*rollupBucket)
+	for i, doc := range docs {
+		if !doc.Found || doc.Source == nil {
+			continue
+		}
+
+		b := &rollupBucket{version: doc.Version}
+		if err := json.Unmarshal([]byte(*doc.Source), &b.metric); err != nil {
+			return nil, err
+		}
+		buckets[keys[i]] = b
+	}
+
+	return buckets, nil
+}
+
+// rollupPage aggregates a page of source documents into the target documents
+// then deletes them. The target documents have a fixed identifier and keep
+// track of the source metrics they count so that a page processed again
+// after a failure is not counted twice.
+func (c *ElasticSearchStorage) rollupPage(level rollupLevel, hits []esclient.Hit) (int, error) {
+	metrics, err := decodeMetrics(hits, level.bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	buckets, err := c.getBuckets(level, metrics)
+	if err != nil {
+		return 0, err
+	}
+
+	rollupMetrics(metrics, level.bucket, buckets)
+
+	var docs []esclient.BulkDocument
+	for key, b := range buckets {
+		if b.updated {
+			docs = append(docs, esclient.BulkDocument{
+				Hit:  esclient.Hit{ID: key.id(), Type: level.target, Parent: key.parent, Version: b.version},
+				Data: b.metric,
+			})
+		}
+	}
+
+	if err := c.client.BulkIndex(docs); err != nil {
+		return 0, err
+	}
+
+	return len(docs), c.client.BulkDelete(hits)
+}
+
+func (c *ElasticSearchStorage) rollup(level rollupLevel, now time.Time) error {
+	// only complete buckets are aggregated
+	before := common.UnixMillis(now.Add(-level.after))
+	before -= before % level.bucket
+
+	query := c.client.FormatFilter(filters.NewLtInt64Filter("Start", before), "", false)
+
+	var rolled, updated int
+	err := c.client.Scroll(level.source, query, "Start", true, func(hits []esclient.Hit) error {
+		n, err := c.rollupPage(level, hits)
+		if err != nil {
+			return err
+		}
+		rolled += len(hits)
+		updated += n
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.GetLogger().Debugf("%d %s documents rolled up into %d %s documents", rolled, level.source, updated, level.target)
+	return nil
+}
+
+// retentionQuery selects the documents of a type to delete
+type retentionQuery struct {
+	obj   string
+	query map[string]interface{}
+}
+
+// retentionQueries returns the queries selecting the documents of a type
+// ended before the given time. The metrics of the expired flows come first
+// as they are selected through their parent and would be left orphaned once
+// the flows are deleted.
+func (c *ElasticSearchStorage) retentionQueries(obj string, before int64) []retentionQuery {
+	query := c.client.FormatFilter(filters.NewLtInt64Filter("Last", before), "", false)
+	if obj != "flow" {
+		return []retentionQuery{{obj: obj, query: query}}
+	}
+
+	var queries []retentionQuery
+	for _, metric := range []string{metricType, metricMinuteType, metricHourType} {
+		queries = append(queries, retentionQuery{
+			obj: metric,
+			query: map[string]interface{}{
+				"has_parent": map[string]interface{}{
+					"type":  "flow",
+					"query": query,
+				},
+			},
+		})
+	}
+
+	return append(queries, retentionQuery{obj: obj, query: query})
+}
+
+func (c *ElasticSearchStorage) applyRetention(obj string, retention time.Duration, now time.Time) error {
+	before := common.UnixMillis(now.Add(-retention))
+
+	for _, q := range c.retentionQueries(obj, before) {
+		var expired int
+		err := c.client.Scroll(q.obj, q.query, "_doc", false, func(hits []esclient.Hit) error {
+			if err := c.client.BulkDelete(hits); err != nil {
+				return err
+			}
+			expired += len(hits)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if expired > 0 {
+			logging.GetLogger().Debugf("Deleted %d %s documents of %s older than %s", expired, q.obj, obj, retention)
+		}
+	}
+
+	return nil
+}
+
+func (c *ElasticSearchStorage) maintain(now time.Time) {
+	for _, level := range c.rollups {
+		if err := c.rollup(level, now); err != nil {
+			logging.GetLogger().Errorf("Error while rolling up %s documents: %s", level.source, err.Error())
+		}
+	}
+
+	for obj, retention := range c.retention {
+		if err := c.applyRetention(obj, retention, now); err != nil {
+			logging.GetLogger().Errorf("Error while applying the retention of %s documents: %s", obj, err.Error())
+		}
+	}
+}
+
+func (c *ElasticSearchStorage) runMaintenance() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if c.client.Started() {
+				c.maintain(now)
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// timeSliceStart returns the start of the time slice of a metric filter, 0
+// if the filter has no lower bound on the start of the metrics
+func timeSliceStart(f *filters.Filter) int64 {
+	if f == nil {
+		return 0
+	}
+
+	if f.GteInt64Filter != nil && f.GteInt64Filter.Key == "Start" {
+		return f.GteInt64Filter.Value
+	}
+	if f.GtInt64Filter != nil && f.GtInt64Filter.Key == "Start" {
+		return f.GtInt64Filter.Value
+	}
+
+	if f.BoolFilter != nil && f.BoolFilter.Op == filters.BoolFilterOp_AND {
+		var start int64
+		for _, item := range f.BoolFilter.Filters {
+			start = common.MaxInt64(start, timeSliceStart(item))
+		}
+		return start
+	}
+
+	return 0
+}
+
+// metricTypes returns the document types holding the metrics of the time
+// slice of the filter. Once rolled up the metrics of a period only exist in
+// one resolution so the finer types are always queried.
+func (c *ElasticSearchStorage) metricTypes(metricFilter *filters.Filter, now time.Time) string {
+	types := []string{metricType}
+
+	start := timeSliceStart(metricFilter)
+	for _, level := range c.rollups {
+		if start == 0 || start < common.UnixMillis(now.Add(-level.after)) {
+			types = append(types, level.target)
+		}
+	}
+
+	return strings.Join(types, ",")
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	esclient "github.com/skydive-project/skydive/storage/elasticsearch"
+)
+
+func metricHit(parent string, start, last, abBytes int64) esclient.Hit {
+	data, _ := json.Marshal(&rollupMetric{ABBytes: abBytes, ABPackets: 1, Start: start, Last: last})
+	raw := json.RawMessage(data)
+	return esclient.Hit{ID: parent + "-metric", Type: metricType, Parent: parent, Source: &raw}
+}
+
+func TestRollupMetrics(t *testing.T) {
+	hits := []esclient.Hit{
+		metricHit("flow1", 60000, 70000, 10),
+		metricHit("flow1", 110000, 119000, 20),
+		metricHit("flow1", 120000, 130000, 40),
+		metricHit("flow2", 60000, 70000, 80),
+	}
+
+	metrics, err := decodeMetrics(hits, 60000)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buckets := make(map[rollupKey]*rollupBucket)
+	rollupMetrics(metrics, 60000, buckets)
+
+	if len(buckets) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d", len(buckets))
+	}
+
+	b := buckets[rollupKey{parent: "flow1", start: 60000}]
+	if b == nil || b.metric.ABBytes != 30 || b.metric.ABPackets != 2 || b.metric.Start != 60000 || b.metric.Last != 120000 || b.metric.LastSourceStart != 110000 {
+		t.Errorf("Wrong bucket: %+v", b)
+	}
+
+	if b := buckets[rollupKey{parent: "flow1", start: 120000}]; b == nil || b.metric.ABBytes != 40 {
+		t.Errorf("Wrong bucket: %+v", b)
+	}
+
+	if b := buckets[rollupKey{parent: "flow2", start: 60000}]; b == nil || b.metric.ABBytes != 80 {
+		t.Errorf("Wrong bucket: %+v", b)
+	}
+
+	if id := (rollupKey{parent: "flow1", start: 60000}).id(); id != "flow1-60000" {
+		t.Errorf("Wrong bucket identifier: %s", id)
+	}
+}
+
+func TestRollupMetricsRerun(t *testing.T) {
+	hits := []esclient.Hit{
+		metricHit("flow1", 60000, 70000, 10),
+		metricHit("flow1", 110000, 119000, 20),
+	}
+
+	metrics, err := decodeMetrics(hits, 60000)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the first metric was already rolled up but not deleted
+	key := rollupKey{parent: "flow1", start: 60000}
+	buckets := map[rollupKey]*rollupBucket{
+		key: {metric: rollupMetric{ABBytes: 10, ABPackets: 1, Start: 60000, Last: 120000, LastSourceStart: 60000}, version: 1},
+	}
+	rollupMetrics(metrics, 60000, buckets)
+
+	if b := buckets[key]; !b.updated || b.metric.ABBytes != 30 || b.metric.ABPackets != 2 || b.metric.LastSourceStart != 110000 {
+		t.Errorf("Wrong bucket: %+v", b)
+	}
+
+	// a rerun once everything was rolled up changes nothing
+	buckets[key].updated = false
+	rollupMetrics(metrics, 60000, buckets)
+
+	if b := buckets[key]; b.updated || b.metric.ABBytes != 30 {
+		t.Errorf("Bucket updated twice: %+v", b)
+	}
+}
+
+func TestMetricTypes(t *testing.T) {
+	c := &ElasticSearchStorage{
+		rollups: []rollupLevel{
+			{source: metricType, target: metricMinuteType, bucket: 60000, after: time.Hour},
+			{source: metricMinuteType, target: metricHourType, bucket: 3600000, after: 24 * time.Hour},
+		},
+	}
+
+	now := time.Now()
+	slice := func(d time.Duration) *filters.Filter {
+		fr := filters.Range{From: common.UnixMillis(now.Add(-d)), To: common.UnixMillis(now)}
+		return filters.NewFilterIncludedIn(fr, "")
+	}
+
+	if types := c.metricTypes(slice(time.Minute), now); types != "metric" {
+		t.Errorf("Wrong types for the last minute: %s", types)
+	}
+
+	if types := c.metricTypes(slice(2*time.Hour), now); types != "metric,metric_1m" {
+		t.Errorf("Wrong types for the last 2 hours: %s", types)
+	}
+
+	if types := c.metricTypes(slice(48*time.Hour), now); types != "metric,metric_1m,metric_1h" {
+		t.Errorf("Wrong types for the last 2 days: %s", types)
+	}
+
+	if types := c.metricTypes(nil, now); types != "metric,metric_1m,metric_1h" {
+		t.Errorf("Wrong types without time slice: %s", types)
+	}
+}
+
+func TestRetentionQueries(t *testing.T) {
+	c := &ElasticSearchStorage{}
+
+	queries := c.retentionQueries(metricType, 1000)
+	if len(queries) != 1 || queries[0].obj != metricType {
+		t.Fatalf("Only the metrics should expire: %+v", queries)
+	}
+
+	queries = c.retentionQueries("flow", 1000)
+	if len(queries) != 4 {
+		t.Fatalf("The flows and their metrics should expire: %+v", queries)
+	}
+
+	for i, obj := range []string{metricType, metricMinuteType, metricHourType} {
+		if queries[i].obj != obj {
+			t.Errorf("The %s documents should be deleted before the flows, got %s", obj, queries[i].obj)
+		}
+		if _, ok := queries[i].query["has_parent"]; !ok {
+			t.Errorf("The %s documents should be selected by their parent flow: %+v", obj, queries[i].query)
+		}
+	}
+
+	if queries[3].obj != "flow" {
+		t.Errorf("The flows should be deleted last, got %s", queries[3].obj)
+	}
+}
@@ -0,0 +1,430 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+)
+
+const measurement = "flow_metric"
+
+// ErrFlowSearchNotSupported is returned as only the metrics of the flows are
+// stored in InfluxDB
+var ErrFlowSearchNotSupported = errors.New("Flow search is not supported by the InfluxDB storage")
+
+// tags are the flow fields stored as InfluxDB tags and that can be used in
+// the filters of the metric requests. The points of a flow all share the
+// same timestamp precision, the UUID is then a tag so that two flows with
+// the same endpoints don't overwrite each other, at the cost of one series
+// per flow.
+var tags = []string{
+	"UUID",
+	"NodeTID",
+	"Application",
+	"Network.Protocol",
+	"Transport.Protocol",
+	"Link.A",
+	"Link.B",
+	"Network.A",
+	"Network.B",
+	"Transport.A",
+	"Transport.B",
+}
+
+// stringFields are the flow fields stored as InfluxDB string fields
+var stringFields = []string{
+	"TrackingID",
+}
+
+var metricFields = []string{"ABPackets", "ABBytes", "BAPackets", "BABytes"}
+
+// InfluxDBStorage stores the metrics of the flows, LastUpdateMetric, to
+// InfluxDB using the line protocol. As the flows themselves are not stored
+// it is used as a metrics storage next to the flow storage.
+type InfluxDBStorage struct {
+	addr     string
+	database string
+	username string
+	password string
+	client   *http.Client
+}
+
+type queryResult struct {
+	Results []struct {
+		Series []struct {
+			Name    string          `json:"name"`
+			Columns []string        `json:"columns"`
+			Values  [][]interface{} `json:"values"`
+		} `json:"series"`
+		Error string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var fieldEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+
+// isStringKey returns whether a flow field is stored as a tag or a string
+// field and can be compared to a string
+func isStringKey(key string) bool {
+	for _, tag := range tags {
+		if tag == key {
+			return true
+		}
+	}
+	for _, field := range stringFields {
+		if field == key {
+			return true
+		}
+	}
+	return false
+}
+
+// flowToLine returns the line protocol representation of the last update
+// metric of a flow
+func flowToLine(f *flow.Flow) string {
+	var b bytes.Buffer
+	b.WriteString(measurement)
+
+	for _, tag := range tags {
+		if value, err := f.GetFieldString(tag); err == nil && value != "" {
+			fmt.Fprintf(&b, ",%s=%s", tagEscaper.Replace(tag), tagEscaper.Replace(value))
+		}
+	}
+
+	m := f.LastUpdateMetric
+	fmt.Fprintf(&b, " ABPackets=%di,ABBytes=%di,BAPackets=%di,BABytes=%di,Start=%di",
+		m.ABPackets, m.ABBytes, m.BAPackets, m.BABytes, f.LastUpdateStart)
+
+	for _, field := range stringFields {
+		if value, err := f.GetFieldString(field); err == nil && value != "" {
+			fmt.Fprintf(&b, `,%s="%s"`, field, fieldEscaper.Replace(value))
+		}
+	}
+
+	fmt.Fprintf(&b, " %d", f.LastUpdateLast)
+
+	return b.String()
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func quoteString(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+// fieldToIdent returns the InfluxDB column of a flow field, the Last time of
+// a metric is the timestamp of the point
+func fieldToIdent(key string) (string, error) {
+	switch key {
+	case "Last":
+		return "time", nil
+	case "Start":
+		return quoteIdent("Start"), nil
+	}
+	return "", fmt.Errorf("Filter on %s not supported by the InfluxDB storage", key)
+}
+
+func int64Expression(key string, op string, value int64) (string, error) {
+	ident, err := fieldToIdent(key)
+	if err != nil {
+		return "", err
+	}
+
+	if ident == "time" {
+		return fmt.Sprintf("time %s %dms", op, value), nil
+	}
+	return fmt.Sprintf("%s %s %d", ident, op, value), nil
+}
+
+// filterToExpression translates a filter to an InfluxQL condition. Only the
+// tags, the string fields and the Start/Last time fields can be used.
+func filterToExpression(f *filters.Filter) (string, error) {
+	if f == nil {
+		return "", nil
+	}
+
+	if f.BoolFilter != nil {
+		keyword := ""
+		switch f.BoolFilter.Op {
+		case filters.BoolFilterOp_NOT:
+			expr, err := filterToExpression(f.BoolFilter.Filters[0])
+			if err != nil || expr == "" {
+				return "", err
+			}
+			// InfluxQL has no NOT operator, only simple terms can be negated
+			if tf := f.BoolFilter.Filters[0].TermStringFilter; tf != nil && isStringKey(tf.Key) {
+				return fmt.Sprintf("%s != %s", quoteIdent(tf.Key), quoteString(tf.Value)), nil
+			}
+			return "", errors.New("Only terms can be negated with the InfluxDB storage")
+		case filters.BoolFilterOp_OR:
+			keyword = "OR"
+		case filters.BoolFilterOp_AND:
+			keyword = "AND"
+		}
+
+		var conditions []string
+		for _, item := range f.BoolFilter.Filters {
+			expr, err := filterToExpression(item)
+			if err != nil {
+				return "", err
+			}
+			if expr != "" {
+				conditions = append(conditions, "("+expr+")")
+			}
+		}
+		return strings.Join(conditions, " "+keyword+" "), nil
+	}
+
+	if f.TermStringFilter != nil {
+		if !isStringKey(f.TermStringFilter.Key) {
+			return "", fmt.Errorf("Filter on %s not supported by the InfluxDB storage", f.TermStringFilter.Key)
+		}
+		return fmt.Sprintf("%s = %s", quoteIdent(f.TermStringFilter.Key), quoteString(f.TermStringFilter.Value)), nil
+	}
+
+	if f.RegexFilter != nil {
+		if !isStringKey(f.RegexFilter.Key) {
+			return "", fmt.Errorf("Filter on %s not supported by the InfluxDB storage", f.RegexFilter.Key)
+		}
+		return fmt.Sprintf("%s =~ /%s/", quoteIdent(f.RegexFilter.Key), strings.Replace(f.RegexFilter.Value, "/", `\/`, -1)), nil
+	}
+
+	if f.TermInt64Filter != nil {
+		return int64Expression(f.TermInt64Filter.Key, "=", f.TermInt64Filter.Value)
+	}
+
+	if f.GtInt64Filter != nil {
+		return int64Expression(f.GtInt64Filter.Key, ">", f.GtInt64Filter.Value)
+	}
+
+	if f.LtInt64Filter != nil {
+		return int64Expression(f.LtInt64Filter.Key, "<", f.LtInt64Filter.Value)
+	}
+
+	if f.GteInt64Filter != nil {
+		return int64Expression(f.GteInt64Filter.Key, ">=", f.GteInt64Filter.Value)
+	}
+
+	if f.LteInt64Filter != nil {
+		return int64Expression(f.LteInt64Filter.Key, "<=", f.LteInt64Filter.Value)
+	}
+
+	return "", nil
+}
+
+func (c *InfluxDBStorage) endpoint(path string, params url.Values) string {
+	if c.username != "" {
+		params.Set("u", c.username)
+		params.Set("p", c.password)
+	}
+	return fmt.Sprintf("%s/%s?%s", c.addr, path, params.Encode())
+}
+
+func (c *InfluxDBStorage) query(q string) (*queryResult, error) {
+	params := url.Values{}
+	params.Set("db", c.database)
+	params.Set("epoch", "ms")
+	params.Set("q", q)
+
+	resp, err := c.client.Post(c.endpoint("query", params), "application/x-www-form-urlencoded", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result queryResult
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("Unable to decode InfluxDB reply, status %d: %s", resp.StatusCode, err.Error())
+	}
+
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	for _, r := range result.Results {
+		if r.Error != "" {
+			return nil, errors.New(r.Error)
+		}
+	}
+
+	return &result, nil
+}
+
+func (c *InfluxDBStorage) StoreFlows(flows []*flow.Flow) error {
+	var lines []string
+	for _, f := range flows {
+		if f.LastUpdateStart != 0 && f.LastUpdateMetric != nil {
+			lines = append(lines, flowToLine(f))
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	params := url.Values{}
+	params.Set("db", c.database)
+	params.Set("precision", "ms")
+
+	body := bytes.NewBufferString(strings.Join(lines, "\n"))
+	resp, err := c.client.Post(c.endpoint("write", params), "text/plain", body)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while writing flow metrics to InfluxDB: %s", err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		content, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("Error while writing flow metrics to InfluxDB, status %d: %s", resp.StatusCode, string(content))
+		logging.GetLogger().Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (c *InfluxDBStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+	return nil, ErrFlowSearchNotSupported
+}
+
+func (c *InfluxDBStorage) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]*common.TimedMetric, error) {
+	var conditions []string
+	for _, f := range []*filters.Filter{metricFilter, fsq.Filter} {
+		expr, err := filterToExpression(f)
+		if err != nil {
+			return nil, err
+		}
+		if expr != "" {
+			conditions = append(conditions, "("+expr+")")
+		}
+	}
+
+	columns := make([]string, 0, len(metricFields)+2)
+	for _, field := range append(metricFields, "Start", "UUID") {
+		columns = append(columns, quoteIdent(field))
+	}
+
+	q := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), quoteIdent(measurement))
+	if len(conditions) > 0 {
+		q += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	q += " ORDER BY time ASC"
+
+	result, err := c.query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make(map[string][]*common.TimedMetric)
+	for _, r := range result.Results {
+		for _, serie := range r.Series {
+			index := make(map[string]int)
+			for i, column := range serie.Columns {
+				index[column] = i
+			}
+
+			for _, values := range serie.Values {
+				value := func(column string) int64 {
+					if i, ok := index[column]; ok && i < len(values) {
+						if n, ok := values[i].(json.Number); ok {
+							v, _ := n.Int64()
+							return v
+						}
+					}
+					return 0
+				}
+
+				var uuid string
+				if i, ok := index["UUID"]; ok && i < len(values) {
+					uuid, _ = values[i].(string)
+				}
+
+				metric := &common.TimedMetric{
+					TimeSlice: common.TimeSlice{
+						Start: value("Start"),
+						Last:  value("time"),
+					},
+					Metric: &flow.FlowMetric{
+						ABPackets: value("ABPackets"),
+						ABBytes:   value("ABBytes"),
+						BAPackets: value("BAPackets"),
+						BABytes:   value("BABytes"),
+					},
+				}
+				metrics[uuid] = append(metrics[uuid], metric)
+			}
+		}
+	}
+
+	return metrics, nil
+}
+
+func (c *InfluxDBStorage) Start() {
+}
+
+func (c *InfluxDBStorage) Stop() {
+}
+
+func newInfluxDBStorage(addr, database, username, password string) (*InfluxDBStorage, error) {
+	c := &InfluxDBStorage{
+		addr:     strings.TrimRight(addr, "/"),
+		database: database,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if _, err := c.query("CREATE DATABASE " + quoteIdent(database)); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func New() (*InfluxDBStorage, error) {
+	addr := config.GetConfig().GetString("storage.influxdb.addr")
+	database := config.GetConfig().GetString("storage.influxdb.database")
+	username := config.GetConfig().GetString("storage.influxdb.username")
+	password := config.GetConfig().GetString("storage.influxdb.password")
+
+	return newInfluxDBStorage(addr, database, username, password)
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package influxdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+)
+
+// influxDBStandIn records the writes and the queries received
+type influxDBStandIn struct {
+	writes  []string
+	queries []string
+	reply   string
+}
+
+func (s *influxDBStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/write":
+		if r.URL.Query().Get("precision") != "ms" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		s.writes = append(s.writes, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	case "/query":
+		s.queries = append(s.queries, r.URL.Query().Get("q"))
+		reply := s.reply
+		if reply == "" {
+			reply = `{"results":[{}]}`
+		}
+		fmt.Fprint(w, reply)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestStorage(t *testing.T) (*InfluxDBStorage, *influxDBStandIn, *httptest.Server) {
+	standIn := &influxDBStandIn{}
+	server := httptest.NewServer(standIn)
+
+	storage, err := newInfluxDBStorage(server.URL, "skydive", "", "")
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return storage, standIn, server
+}
+
+func TestStoreFlows(t *testing.T) {
+	storage, standIn, server := newTestStorage(t)
+	defer server.Close()
+
+	flows := []*flow.Flow{
+		{
+			UUID:             "uuid1",
+			TrackingID:       "tracking1",
+			NodeTID:          "node 1",
+			Application:      "TCP",
+			Network:          &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "192.168.0.1", B: "192.168.0.2"},
+			Transport:        &flow.FlowLayer{Protocol: flow.FlowProtocol_TCPPORT, A: "34567", B: "80"},
+			LastUpdateStart:  1000,
+			LastUpdateLast:   2000,
+			LastUpdateMetric: &flow.FlowMetric{ABPackets: 1, ABBytes: 2, BAPackets: 3, BABytes: 4},
+		},
+		// not yet updated, no metric to store
+		{UUID: "uuid2"},
+	}
+
+	if err := storage.StoreFlows(flows); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(standIn.writes) != 1 {
+		t.Fatalf("Should get one write, got %d", len(standIn.writes))
+	}
+
+	expected := `flow_metric,UUID=uuid1,NodeTID=node\ 1,Application=TCP,Network.Protocol=IPV4,Transport.Protocol=TCPPORT,Network.A=192.168.0.1,Network.B=192.168.0.2,Transport.A=34567,Transport.B=80 ABPackets=1i,ABBytes=2i,BAPackets=3i,BABytes=4i,Start=1000i,TrackingID="tracking1" 2000`
+	if standIn.writes[0] != expected {
+		t.Errorf("Wrong line protocol, expected:\n%s\ngot:\n%s", expected, standIn.writes[0])
+	}
+}
+
+// seriesKey returns the measurement and the tags of a line, the points of a
+// series sharing a timestamp overwrite each other
+func seriesKey(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ' ':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func TestStoreFlowsSeries(t *testing.T) {
+	storage, standIn, server := newTestStorage(t)
+	defer server.Close()
+
+	newFlow := func(uuid string) *flow.Flow {
+		return &flow.Flow{
+			UUID:             uuid,
+			NodeTID:          "node1",
+			Network:          &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "192.168.0.1", B: "192.168.0.2"},
+			LastUpdateStart:  1000,
+			LastUpdateLast:   2000,
+			LastUpdateMetric: &flow.FlowMetric{ABPackets: 1, ABBytes: 2},
+		}
+	}
+
+	if err := storage.StoreFlows([]*flow.Flow{newFlow("uuid1"), newFlow("uuid2")}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(standIn.writes[0], "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Should get 2 lines, got %d", len(lines))
+	}
+
+	if seriesKey(lines[0]) == seriesKey(lines[1]) {
+		t.Errorf("Flows differing only by their UUID should be different series: %s", seriesKey(lines[0]))
+	}
+}
+
+func TestSearchMetrics(t *testing.T) {
+	storage, standIn, server := newTestStorage(t)
+	defer server.Close()
+
+	standIn.reply = `{"results":[{"series":[{"name":"flow_metric","columns":["time","ABPackets","ABBytes","BAPackets","BABytes","Start","UUID"],"values":[[2000,1,2,3,4,1000,"uuid1"],[3000,5,6,7,8,2000,"uuid1"]]}]}]}`
+
+	fsq := filters.SearchQuery{
+		Filter: filters.NewAndFilter(
+			filters.NewTermStringFilter("NodeTID", "node1"),
+			filters.NewTermStringFilter("TrackingID", "tracking1"),
+		),
+	}
+	metricFilter := filters.NewFilterIncludedIn(filters.Range{From: 1000, To: 3000}, "")
+
+	metrics, err := storage.SearchMetrics(fsq, metricFilter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := standIn.queries[len(standIn.queries)-1]
+	for _, expr := range []string{`"Start" >= 1000`, `time <= 3000ms`, `"NodeTID" = 'node1'`, `"TrackingID" = 'tracking1'`} {
+		if !strings.Contains(q, expr) {
+			t.Errorf("Query should contain %s: %s", expr, q)
+		}
+	}
+
+	if len(metrics["uuid1"]) != 2 {
+		t.Fatalf("Should get 2 metrics, got %+v", metrics)
+	}
+
+	m := metrics["uuid1"][1]
+	if m.Start != 2000 || m.Last != 3000 || m.Metric.(*flow.FlowMetric).BABytes != 8 {
+		t.Errorf("Wrong metric: %+v", m)
+	}
+
+	fsq = filters.SearchQuery{Filter: filters.NewGtInt64Filter("Metric.ABBytes", 10)}
+	if _, err := storage.SearchMetrics(fsq, metricFilter); err == nil {
+		t.Error("Filters on fields not stored should return an error")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package storage
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+)
+
+var (
+	storeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "skydive",
+			Subsystem: "storage",
+			Name:      "write_duration_seconds",
+			Help:      "Latency of the flow writes to the storage backend",
+		},
+		[]string{"backend"},
+	)
+	storeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "skydive",
+			Subsystem: "storage",
+			Name:      "write_errors_total",
+			Help:      "Number of failed flow writes to the storage backend",
+		},
+		[]string{"backend"},
+	)
+)
+
+// instrumentedStorage records the latency and the errors of the writes
+// done on the wrapped storage
+type instrumentedStorage struct {
+	Storage
+	backend string
+}
+
+func (s *instrumentedStorage) StoreFlows(flows []*flow.Flow) error {
+	start := time.Now()
+	err := s.Storage.StoreFlows(flows)
+	storeDuration.WithLabelValues(s.backend).Observe(time.Since(start).Seconds())
+	if err != nil {
+		storeErrors.WithLabelValues(s.backend).Inc()
+	}
+	return err
+}
+
+func (s *instrumentedStorage) AggregateFlows(fsq filters.SearchQuery, a *flow.Aggregation) (interface{}, error) {
+	if aggregator, ok := s.Storage.(FlowAggregator); ok {
+		return aggregator.AggregateFlows(fsq, a)
+	}
+	return nil, ErrAggregationNotSupported
+}
+
+func init() {
+	prometheus.MustRegister(storeDuration)
+	prometheus.MustRegister(storeErrors)
+}
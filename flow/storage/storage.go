@@ -31,12 +31,14 @@ import (
 	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/flow/storage/elasticsearch"
+	"github.com/skydive-project/skydive/flow/storage/influxdb"
 	"github.com/skydive-project/skydive/flow/storage/orientdb"
 	"github.com/skydive-project/skydive/logging"
 )
 
 var (
-	NoStorageConfigured error = errors.New("No storage backend has been configured")
+	NoStorageConfigured        error = errors.New("No storage backend has been configured")
+	ErrAggregationNotSupported error = errors.New("Flow aggregations are not supported by the storage backend")
 )
 
 type Storage interface {
@@ -47,6 +49,13 @@ type Storage interface {
 	Stop()
 }
 
+// FlowAggregator is implemented by the storages able to compute the flow
+// aggregations themselves, over all the flows matching the filter of the
+// query, its deduplication and pagination being ignored
+type FlowAggregator interface {
+	AggregateFlows(fsq filters.SearchQuery, a *flow.Aggregation) (interface{}, error)
+}
+
 func NewStorage(backend string) (s Storage, err error) {
 	switch backend {
 	case "elasticsearch":
@@ -59,6 +68,11 @@ func NewStorage(backend string) (s Storage, err error) {
 		if err != nil {
 			logging.GetLogger().Fatalf("Can't connect to OrientDB server: %v", err)
 		}
+	case "influxdb":
+		s, err = influxdb.New()
+		if err != nil {
+			logging.GetLogger().Fatalf("Can't connect to InfluxDB server: %v", err)
+		}
 	case "":
 		logging.GetLogger().Infof("Using no storage")
 		return
@@ -69,9 +83,52 @@ func NewStorage(backend string) (s Storage, err error) {
 	}
 
 	logging.GetLogger().Infof("Using %s as storage", backend)
-	return
+	return &instrumentedStorage{Storage: s, backend: backend}, nil
+}
+
+// metricsStorage writes the flows to both the flow storage and a storage
+// keeping only their metrics, the requests being served by the flow storage
+type metricsStorage struct {
+	Storage
+	metrics Storage
+}
+
+func (s *metricsStorage) Start() {
+	s.Storage.Start()
+	s.metrics.Start()
+}
+
+func (s *metricsStorage) StoreFlows(flows []*flow.Flow) error {
+	if err := s.metrics.StoreFlows(flows); err != nil {
+		logging.GetLogger().Errorf("Error while storing flow metrics: %s", err.Error())
+	}
+	return s.Storage.StoreFlows(flows)
+}
+
+func (s *metricsStorage) AggregateFlows(fsq filters.SearchQuery, a *flow.Aggregation) (interface{}, error) {
+	if aggregator, ok := s.Storage.(FlowAggregator); ok {
+		return aggregator.AggregateFlows(fsq, a)
+	}
+	return nil, ErrAggregationNotSupported
+}
+
+func (s *metricsStorage) Stop() {
+	s.Storage.Stop()
+	s.metrics.Stop()
 }
 
 func NewStorageFromConfig() (s Storage, err error) {
-	return NewStorage(config.GetConfig().GetString("analyzer.storage"))
+	if s, err = NewStorage(config.GetConfig().GetString("analyzer.storage")); err != nil || s == nil {
+		return
+	}
+
+	if backend := config.GetConfig().GetString("analyzer.metrics_storage"); backend != "" {
+		metrics, err := NewStorage(backend)
+		if err != nil {
+			return nil, err
+		}
+		s = &metricsStorage{Storage: s, metrics: metrics}
+	}
+
+	return s, nil
 }
@@ -23,12 +23,15 @@
 package flow
 
 import (
+	"container/list"
+	"hash/fnv"
 	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/google/gopacket"
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/logging"
@@ -62,6 +65,41 @@ func NewFlowHandler(callback ExpireUpdateFunc, every time.Duration) *FlowHandler
 	}
 }
 
+// TableOpts defines the optional processing stages of a flow table
+type TableOpts struct {
+	// AppDissectionPackets is the number of first packets of a flow inspected
+	// to extract HTTP/TLS information, 0 disables the dissection
+	AppDissectionPackets int64
+	// FlowSamplingRate keeps only 1 out of FlowSamplingRate new flows
+	FlowSamplingRate int64
+	// MaxFlows is the maximum number of flows of the table, 0 means no limit
+	MaxFlows int64
+	// EvictionPolicy selects the flow evicted when a limit is reached, either
+	// EvictLRU or EvictOldest
+	EvictionPolicy string
+	// Shards is the number of workers the flows are dispatched to according
+	// to their key, 0 or 1 means that all the flows are processed by the table
+	Shards int
+	// RingPackets and RingBytes are the limits of the ring buffer keeping the
+	// last packets of each flow, 0 for both disables the ring buffer
+	RingPackets int64
+	RingBytes   int64
+}
+
+// TableStats contains the counters of the flows that couldn't be kept by a
+// table because of the flow limits
+type TableStats struct {
+	FlowsEvicted int64
+	FlowsDropped int64
+}
+
+const (
+	// EvictLRU evicts the least recently updated flow
+	EvictLRU = "lru"
+	// EvictOldest evicts the oldest flow
+	EvictOldest = "oldest"
+)
+
 type Table struct {
 	PacketsChan   chan *FlowPackets
 	table         map[string]*Flow
@@ -79,10 +117,23 @@ type Table struct {
 	lastExpire    int64
 	tableClock    int64
 	nodeTID       string
+	metrics       *tableMetrics
 	pipeline      *FlowEnhancerPipeline
+	opts          TableOpts
+	samplingRate  int64
+	evictList     *list.List
+	evictElements map[string]*list.Element
+	evicted       []*Flow
+	agentFlows    *int64
+	agentMaxFlows int64
+	counters      TableStats
+	shards        []*Table
+	ticks         chan tableTick
+	rings         map[string]*packetRing
+	recorder      *Recorder
 }
 
-func NewTable(updateHandler *FlowHandler, expireHandler *FlowHandler, pipeline *FlowEnhancerPipeline) *Table {
+func NewTable(updateHandler *FlowHandler, expireHandler *FlowHandler, pipeline *FlowEnhancerPipeline, opts ...TableOpts) *Table {
 	t := &Table{
 		PacketsChan:   make(chan *FlowPackets, 1000),
 		table:         make(map[string]*Flow),
@@ -93,14 +144,55 @@ func NewTable(updateHandler *FlowHandler, expireHandler *FlowHandler, pipeline *
 		updateHandler: updateHandler,
 		expireHandler: expireHandler,
 		pipeline:      pipeline,
+		evictList:     list.New(),
+		evictElements: make(map[string]*list.Element),
+		rings:         make(map[string]*packetRing),
+	}
+	if len(opts) > 0 {
+		t.opts = opts[0]
 	}
 	t.tableClock = common.UnixMillis(time.Now())
 	t.lastUpdate = t.tableClock
+
+	if t.opts.Shards > 1 {
+		t.newShards(updateHandler, expireHandler, pipeline)
+	}
+
 	return t
 }
 
 func (ft *Table) SetNodeTID(tid string) {
 	ft.nodeTID = tid
+	ft.metrics = newTableMetrics(tid)
+	for _, shard := range ft.shards {
+		shard.SetNodeTID(tid)
+	}
+}
+
+// SetSamplingRate sets the packet sampling rate of the probe feeding the
+// table so that the flow metrics are scaled accordingly
+func (ft *Table) SetSamplingRate(rate int64) {
+	ft.samplingRate = rate
+	for _, shard := range ft.shards {
+		shard.SetSamplingRate(rate)
+	}
+}
+
+// SetRecorder sets the recorder writing all the packets processed by the
+// table to PCAP-NG files
+func (ft *Table) SetRecorder(recorder *Recorder) {
+	ft.recorder = recorder
+	for _, shard := range ft.shards {
+		shard.SetRecorder(recorder)
+	}
+}
+
+func (ft *Table) setAgentLimit(flows *int64, maxFlows int64) {
+	ft.agentFlows = flows
+	ft.agentMaxFlows = maxFlows
+	for _, shard := range ft.shards {
+		shard.setAgentLimit(flows, maxFlows)
+	}
 }
 
 func (ft *Table) getFlows(query *filters.SearchQuery) *FlowSet {
@@ -119,21 +211,124 @@ func (ft *Table) getFlows(query *filters.SearchQuery) *FlowSet {
 	return flowset
 }
 
+// GetStats returns the eviction counters of the table
+func (ft *Table) GetStats() TableStats {
+	stats := TableStats{
+		FlowsEvicted: atomic.LoadInt64(&ft.counters.FlowsEvicted),
+		FlowsDropped: atomic.LoadInt64(&ft.counters.FlowsDropped),
+	}
+	for _, shard := range ft.shards {
+		s := shard.GetStats()
+		stats.FlowsEvicted += s.FlowsEvicted
+		stats.FlowsDropped += s.FlowsDropped
+	}
+	return stats
+}
+
+func (ft *Table) isFull() bool {
+	if ft.opts.MaxFlows > 0 && int64(len(ft.table)) >= ft.opts.MaxFlows {
+		return true
+	}
+	return ft.agentMaxFlows > 0 && atomic.LoadInt64(ft.agentFlows) >= ft.agentMaxFlows
+}
+
+// evict removes the flow with the lowest priority according to the eviction
+// policy. Evicted flows are sent to the expire handler on the next flush.
+func (ft *Table) evict() bool {
+	e := ft.evictList.Back()
+	if e == nil {
+		return false
+	}
+
+	key := e.Value.(string)
+	f := ft.table[key]
+	if f.Last >= ft.lastUpdate {
+		ft.updateMetric(f, ft.lastUpdate, f.Last)
+	}
+
+	logging.GetLogger().Debugf("Evict flow %s", f.UUID)
+	f.Expired = true
+	ft.evicted = append(ft.evicted, f)
+	ft.removeFlow(key, f)
+	atomic.AddInt64(&ft.counters.FlowsEvicted, 1)
+
+	return true
+}
+
+func (ft *Table) flushEvicted() {
+	if len(ft.evicted) == 0 {
+		return
+	}
+
+	if ft.expireHandler != nil && ft.expireHandler.callback != nil {
+		ft.expireHandler.callback(ft.evicted)
+	}
+	ft.evicted = nil
+}
+
+func (ft *Table) removeFlow(key string, f *Flow) {
+	// need to use the key as the key could be not equal to the UUID
+	delete(ft.table, key)
+
+	// stats and rings are always indexed by UUID
+	delete(ft.stats, f.UUID)
+	delete(ft.rings, f.UUID)
+
+	if ft.metrics != nil {
+		ft.metrics.flows.Dec()
+	}
+
+	if e, ok := ft.evictElements[key]; ok {
+		ft.evictList.Remove(e)
+		delete(ft.evictElements, key)
+
+		if ft.agentFlows != nil {
+			atomic.AddInt64(ft.agentFlows, -1)
+		}
+	}
+}
+
+// getOrCreateFlow returns the flow for the given key, nil is returned if the
+// flow can't be created because of the flow limits
 func (ft *Table) getOrCreateFlow(key string) (*Flow, bool) {
 	if flow, found := ft.table[key]; found {
+		if e, ok := ft.evictElements[key]; ok && ft.opts.EvictionPolicy != EvictOldest {
+			ft.evictList.MoveToFront(e)
+		}
 		return flow, false
 	}
 
+	for ft.isFull() {
+		if !ft.evict() {
+			atomic.AddInt64(&ft.counters.FlowsDropped, 1)
+			return nil, false
+		}
+	}
+
 	new := &Flow{
 		Metric:           &FlowMetric{},
 		LastUpdateMetric: &FlowMetric{},
 	}
+	if ft.samplingRate > 1 {
+		new.SamplingRate = ft.samplingRate
+	}
 	ft.table[key] = new
+	ft.evictElements[key] = ft.evictList.PushFront(key)
+
+	if ft.metrics != nil {
+		ft.metrics.flows.Inc()
+	}
+
+	if ft.agentFlows != nil {
+		atomic.AddInt64(ft.agentFlows, 1)
+	}
 
 	return new, true
 }
 
 func (ft *Table) expire(expireBefore int64) {
+	ft.flushEvicted()
+
 	var expiredFlows []*Flow
 	flowTableSzBefore := len(ft.table)
 	for k, f := range ft.table {
@@ -144,13 +339,10 @@ func (ft *Table) expire(expireBefore int64) {
 			}
 
 			logging.GetLogger().Debugf("Expire flow %s Duration %v", f.UUID, duration)
+			f.Expired = true
 			expiredFlows = append(expiredFlows, f)
 
-			// need to use the key as the key could be not equal to the UUID
-			delete(ft.table, k)
-
-			// stats are always indexed by UUID
-			delete(ft.stats, f.UUID)
+			ft.removeFlow(k, f)
 		}
 	}
 	/* Advise Clients */
@@ -196,6 +388,7 @@ func (ft *Table) update(updateFrom, updateTime int64) {
 	for _, f := range ft.table {
 		if f.Last >= updateFrom {
 			ft.updateMetric(f, updateFrom, updateTime)
+			ft.pipeline.EnhanceFlowUpdate(f)
 			updatedFlows = append(updatedFlows, f)
 		} else {
 			f.LastUpdateMetric = &FlowMetric{}
@@ -249,6 +442,43 @@ func (ft *Table) onSearchQueryMessage(fsq *filters.SearchQuery) (*FlowSearchRepl
 	}, http.StatusOK
 }
 
+func (ft *Table) ringEnabled() bool {
+	return ft.opts.RingPackets > 0 || ft.opts.RingBytes > 0
+}
+
+// pushRawPacket adds the whole captured packet to the ring of the flow, inner
+// flows of an encapsulated packet get the outer packet as well
+func (ft *Table) pushRawPacket(f *Flow, packet *gopacket.Packet, raw *rawPacket) {
+	ring, ok := ft.rings[f.UUID]
+	if !ok {
+		ring = &packetRing{linkType: linkTypeFromPacket(packet)}
+		ft.rings[f.UUID] = ring
+	}
+	ring.push(raw, ft.opts.RingPackets, ft.opts.RingBytes)
+}
+
+func (ft *Table) onPCAPQuery(uuid string) (*TableReply, error) {
+	reply := &TableReply{
+		status: http.StatusNoContent,
+		Obj:    make([][]byte, 0),
+	}
+
+	ring, ok := ft.rings[uuid]
+	if !ok {
+		return reply, nil
+	}
+
+	pcap, err := ring.pcap()
+	if err != nil {
+		return nil, err
+	}
+
+	reply.Obj = append(reply.Obj, pcap)
+	reply.status = http.StatusOK
+
+	return reply, nil
+}
+
 func (ft *Table) onQuery(query *TableQuery) *TableReply {
 	reply := &TableReply{
 		status: http.StatusBadRequest,
@@ -275,12 +505,23 @@ func (ft *Table) onQuery(query *TableQuery) *TableReply {
 		reply.Obj = append(reply.Obj, pb)
 
 		reply.status = http.StatusOK
+	case "PCAPQuery":
+		r, err := ft.onPCAPQuery(string(query.Obj))
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to generate the pcap of flow %s: %s", string(query.Obj), err.Error())
+			break
+		}
+		return r
 	}
 
 	return reply
 }
 
 func (ft *Table) Query(query *TableQuery) *TableReply {
+	if len(ft.shards) > 0 {
+		return ft.queryShards(query)
+	}
+
 	ft.lockState.Lock()
 	defer ft.lockState.Unlock()
 
@@ -304,15 +545,50 @@ func (ft *Table) Query(query *TableQuery) *TableReply {
 	return nil
 }
 
+// sampleFlow returns whether a flow has to be tracked according to the flow
+// sampling rate. The decision only depends on the key so that all the packets
+// of a flow get the same decision.
+func (ft *Table) sampleFlow(key string) bool {
+	if ft.opts.FlowSamplingRate <= 1 {
+		return true
+	}
+
+	hasher := fnv.New32a()
+	hasher.Write([]byte(key))
+	return int64(hasher.Sum32())%ft.opts.FlowSamplingRate == 0
+}
+
 func (ft *Table) flowPacketToFlow(packet *FlowPacket, parentUUID string, t int64, L2ID int64, L3ID int64) *Flow {
 	key := FlowKeyFromGoPacket(packet.gopacket, parentUUID).String()
+
+	// only the outer flow is sampled, inner flows follow their parent
+	if parentUUID == "" && !ft.sampleFlow(key) {
+		return nil
+	}
+
 	flow, new := ft.getOrCreateFlow(key)
+	if flow == nil {
+		return nil
+	}
+
 	if new {
 		flow.Init(key, t, packet.gopacket, packet.length, ft.nodeTID, parentUUID, L2ID, L3ID)
 		ft.pipeline.EnhanceFlow(flow)
 	} else {
 		flow.Update(t, packet.gopacket, packet.length)
 	}
+
+	if ft.opts.AppDissectionPackets > 0 && !flow.applicationDissected() {
+		packets := flow.Metric.ABPackets + flow.Metric.BAPackets
+		if flow.SamplingRate > 1 {
+			packets /= flow.SamplingRate
+		}
+
+		if packets <= ft.opts.AppDissectionPackets {
+			flow.dissectApplication(packet.gopacket)
+		}
+	}
+
 	return flow
 }
 
@@ -322,12 +598,31 @@ func (ft *Table) flowPacketsToFlow(flowPackets *FlowPackets) {
 		t = ft.tableClock
 	}
 
+	if ft.metrics != nil {
+		ft.metrics.packets.Inc()
+	}
+
+	if ft.recorder != nil && flowPackets.packet != nil {
+		ft.recorder.Record(flowPackets.packet)
+	}
+
+	var raw *rawPacket
+	if ft.ringEnabled() && flowPackets.packet != nil {
+		raw = newRawPacket(flowPackets.packet)
+	}
+
 	var parentUUID string
 	var L2ID int64
 	var L3ID int64
 	logging.GetLogger().Debugf("%d FlowPackets received for capture node %s", len(flowPackets.Packets), ft.nodeTID)
 	for _, packet := range flowPackets.Packets {
 		f := ft.flowPacketToFlow(&packet, parentUUID, t, L2ID, L3ID)
+		if f == nil {
+			return
+		}
+		if raw != nil {
+			ft.pushRawPacket(f, flowPackets.packet, raw)
+		}
 		parentUUID = f.UUID
 		if f.Link != nil {
 			L2ID = f.Link.ID
@@ -342,14 +637,22 @@ func (ft *Table) Run() {
 	ft.wg.Add(1)
 	defer ft.wg.Done()
 
-	updateTicker := time.NewTicker(ft.updateHandler.every)
-	defer updateTicker.Stop()
-
-	expireTicker := time.NewTicker(ft.expireHandler.every)
-	defer expireTicker.Stop()
-
-	nowTicker := time.NewTicker(time.Second * 1)
-	defer nowTicker.Stop()
+	// shards get their ticks from the parent table so that the update and
+	// the expire are done at the same time on all the shards
+	var updateC, expireC, nowC <-chan time.Time
+	if ft.ticks == nil {
+		updateTicker := time.NewTicker(ft.updateHandler.every)
+		defer updateTicker.Stop()
+		updateC = updateTicker.C
+
+		expireTicker := time.NewTicker(ft.expireHandler.every)
+		defer expireTicker.Stop()
+		expireC = expireTicker.C
+
+		nowTicker := time.NewTicker(time.Second * 1)
+		defer nowTicker.Stop()
+		nowC = nowTicker.C
+	}
 
 	ft.query = make(chan *TableQuery, 100)
 	ft.reply = make(chan *TableReply, 100)
@@ -357,10 +660,14 @@ func (ft *Table) Run() {
 	atomic.StoreInt64(&ft.state, common.RunningState)
 	for atomic.LoadInt64(&ft.state) == common.RunningState {
 		select {
-		case now := <-expireTicker.C:
+		case now := <-expireC:
 			ft.expireAt(now)
-		case now := <-updateTicker.C:
+		case now := <-updateC:
 			ft.updateAt(now)
+		case tick, ok := <-ft.ticks:
+			if ok {
+				ft.onTick(tick)
+			}
 		case <-ft.flush:
 			ft.expireNow()
 			ft.flushDone <- true
@@ -368,8 +675,9 @@ func (ft *Table) Run() {
 			if ok {
 				ft.reply <- ft.onQuery(query)
 			}
-		case now := <-nowTicker.C:
+		case now := <-nowC:
 			ft.tableClock = common.UnixMillis(now)
+			ft.flushEvicted()
 		case packets := <-ft.PacketsChan:
 			ft.flowPacketsToFlow(packets)
 		}
@@ -377,7 +685,14 @@ func (ft *Table) Run() {
 }
 
 func (ft *Table) Start() chan *FlowPackets {
-	go ft.Run()
+	if len(ft.shards) > 0 {
+		for _, shard := range ft.shards {
+			go shard.Run()
+		}
+		go ft.dispatch()
+	} else {
+		go ft.Run()
+	}
 	return ft.PacketsChan
 }
 
@@ -386,12 +701,29 @@ func (ft *Table) Stop() {
 	defer ft.lockState.Unlock()
 
 	if atomic.CompareAndSwapInt64(&ft.state, common.RunningState, common.StoppingState) {
+		// shards don't have tickers, wake them up so that they see the new state
+		if ft.ticks != nil {
+			close(ft.ticks)
+		}
 		ft.wg.Wait()
 
-		close(ft.query)
-		close(ft.reply)
+		// query channels are only used by non sharded tables
+		if len(ft.shards) == 0 {
+			close(ft.query)
+			close(ft.reply)
+		}
 		close(ft.PacketsChan)
 	}
 
-	ft.expireNow()
+	if len(ft.shards) > 0 {
+		for _, shard := range ft.shards {
+			shard.Stop()
+		}
+	} else {
+		ft.expireNow()
+	}
+
+	if ft.ticks == nil {
+		deleteTableMetrics(ft.nodeTID)
+	}
 }
@@ -23,9 +23,15 @@
 package flow
 
 import (
+	"bytes"
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+
 	"github.com/skydive-project/skydive/filters"
 )
 
@@ -211,3 +217,214 @@ func TestTable_SymmetricHash(t *testing.T) {
 		}
 	}
 }
+
+func TestTable_SamplingRate(t *testing.T) {
+	ft := NewTable(nil, nil, NewFlowEnhancerPipeline())
+	ft.SetSamplingRate(10)
+
+	packet := forgeTestPacket(t, 64, false, ETH, IPv4, TCP)
+	ft.flowPacketsToFlow(FlowPacketsFromGoPacket(packet, 0, -1))
+	ft.flowPacketsToFlow(FlowPacketsFromGoPacket(packet, 0, -1))
+
+	flows := ft.getFlows(nil).GetFlows()
+	if len(flows) != 1 {
+		t.Fatalf("Should get 1 flow, got %d", len(flows))
+	}
+
+	length := int64(len((*packet).Data()))
+	if flows[0].SamplingRate != 10 || flows[0].Metric.ABPackets != 20 || flows[0].Metric.ABBytes != 20*length {
+		t.Errorf("Metrics should be scaled by the sampling rate: %+v", flows[0].Metric)
+	}
+}
+
+func TestTable_FlowSamplingRate(t *testing.T) {
+	ft := NewTable(nil, nil, NewFlowEnhancerPipeline(), TableOpts{FlowSamplingRate: 4})
+
+	var packets []*gopacket.Packet
+	for i := int64(0); i < 100; i++ {
+		packets = append(packets, forgeTestPacket(t, i, false, ETH, IPv4, TCP))
+	}
+
+	for _, packet := range packets {
+		ft.flowPacketsToFlow(FlowPacketsFromGoPacket(packet, 0, -1))
+	}
+
+	sampled := len(ft.table)
+	if sampled == 0 || sampled >= 100 {
+		t.Fatalf("Only a part of the flows should be tracked, got %d", sampled)
+	}
+
+	// the same flows should get the same sampling decision
+	for _, packet := range packets {
+		ft.flowPacketsToFlow(FlowPacketsFromGoPacket(packet, 0, -1))
+	}
+
+	if len(ft.table) != sampled {
+		t.Errorf("Sampling decision should not change for a flow, got %d flows, expected %d", len(ft.table), sampled)
+	}
+}
+
+func TestTable_MaxFlows(t *testing.T) {
+	fc := MyTestFlowCounter{}
+	ft := NewTable(nil, &FlowHandler{callback: fc.countFlowsCallback}, NewFlowEnhancerPipeline(), TableOpts{MaxFlows: 5})
+
+	var keys []string
+	for i := int64(0); i < 10; i++ {
+		packet := forgeTestPacket(t, i, false, ETH, IPv4, TCP)
+		keys = append(keys, FlowKeyFromGoPacket(packet, "").String())
+		ft.flowPacketsToFlow(FlowPacketsFromGoPacket(packet, 0, -1))
+	}
+
+	if len(ft.table) != 5 {
+		t.Fatalf("Table should be limited to 5 flows, got %d", len(ft.table))
+	}
+
+	for _, key := range keys[5:] {
+		if _, ok := ft.table[key]; !ok {
+			t.Errorf("Most recent flows should be kept, %s not found", key)
+		}
+	}
+
+	if stats := ft.GetStats(); stats.FlowsEvicted != 5 || stats.FlowsDropped != 0 {
+		t.Errorf("Wrong table stats: %+v", stats)
+	}
+
+	ft.flushEvicted()
+	if fc.NbFlow != 5 {
+		t.Errorf("Evicted flows should be sent to the expire handler, got %d", fc.NbFlow)
+	}
+}
+
+func TestTable_EvictionPolicy(t *testing.T) {
+	var packets []*gopacket.Packet
+	for i := int64(0); i < 3; i++ {
+		packets = append(packets, forgeTestPacket(t, i, false, ETH, IPv4, TCP))
+	}
+	first := FlowKeyFromGoPacket(packets[0], "").String()
+
+	for _, policy := range []string{EvictLRU, EvictOldest} {
+		ft := NewTable(nil, nil, NewFlowEnhancerPipeline(), TableOpts{MaxFlows: 2, EvictionPolicy: policy})
+
+		ft.flowPacketsToFlow(FlowPacketsFromGoPacket(packets[0], 0, -1))
+		ft.flowPacketsToFlow(FlowPacketsFromGoPacket(packets[1], 0, -1))
+		// update the first flow so that it becomes the most recently used one
+		ft.flowPacketsToFlow(FlowPacketsFromGoPacket(packets[0], 0, -1))
+		ft.flowPacketsToFlow(FlowPacketsFromGoPacket(packets[2], 0, -1))
+
+		if _, ok := ft.table[first]; ok != (policy == EvictLRU) {
+			t.Errorf("Wrong flow evicted with the %s policy", policy)
+		}
+	}
+}
+
+func TestTable_AgentMaxFlows(t *testing.T) {
+	fta := NewTableAllocator(time.Minute, time.Minute, 3, NewFlowEnhancerPipeline())
+
+	ft1 := fta.Alloc(nil, TableOpts{})
+	ft2 := fta.Alloc(nil, TableOpts{})
+
+	for i := int64(0); i < 3; i++ {
+		ft1.flowPacketsToFlow(FlowPacketsFromGoPacket(forgeTestPacket(t, i, false, ETH, IPv4, TCP), 0, -1))
+	}
+
+	// no flow can be evicted from the second table, the new flow is dropped
+	ft2.flowPacketsToFlow(FlowPacketsFromGoPacket(forgeTestPacket(t, 10, false, ETH, IPv4, TCP), 0, -1))
+	if len(ft2.table) != 0 || ft2.GetStats().FlowsDropped != 1 {
+		t.Errorf("Flow should be dropped when the agent limit is reached: %+v", ft2.GetStats())
+	}
+
+	ft1.expireNow()
+	ft2.flowPacketsToFlow(FlowPacketsFromGoPacket(forgeTestPacket(t, 10, false, ETH, IPv4, TCP), 0, -1))
+	if len(ft2.table) != 1 {
+		t.Error("Flow should be created once flows have been expired from other tables")
+	}
+}
+
+func TestTable_Shards(t *testing.T) {
+	handler := NewFlowHandler(nil, time.Minute)
+	ft := NewTable(handler, handler, NewFlowEnhancerPipeline(), TableOpts{Shards: 4})
+	packetsChan := ft.Start()
+	defer ft.Stop()
+
+	for i := int64(0); i < 20; i++ {
+		packetsChan <- FlowPacketsFromGoPacket(forgeTestPacket(t, i, false, ETH, IPv4, TCP), 0, -1)
+		packetsChan <- FlowPacketsFromGoPacket(forgeTestPacket(t, i, true, ETH, IPv4, TCP), 0, -1)
+	}
+
+	obj, _ := proto.Marshal(&filters.SearchQuery{})
+	query := &TableQuery{Type: "SearchQuery", Obj: obj}
+
+	var nbFlows, used int
+	for retry := 0; retry < 20; retry++ {
+		nbFlows, used = 0, 0
+		if reply := ft.Query(query); reply != nil {
+			for _, b := range reply.Obj {
+				var fsr FlowSearchReply
+				if err := proto.Unmarshal(b, &fsr); err != nil {
+					t.Fatal(err)
+				}
+				nbFlows += len(fsr.FlowSet.Flows)
+			}
+			// only the shards holding flows reply with a flow set
+			used = len(reply.Obj)
+		}
+
+		if nbFlows == 20 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if nbFlows != 20 {
+		t.Fatalf("Should get 20 flows from the shards, got %d", nbFlows)
+	}
+
+	if used < 2 {
+		t.Errorf("Flows should be dispatched to several shards")
+	}
+}
+
+func TestTable_PacketsRing(t *testing.T) {
+	handler := NewFlowHandler(nil, time.Minute)
+	ft := NewTable(handler, handler, NewFlowEnhancerPipeline(), TableOpts{RingPackets: 2})
+
+	for i := 0; i < 3; i++ {
+		ft.flowPacketsToFlow(FlowPacketsFromGoPacket(forgeTestPacket(t, 64, i%2 == 1, ETH, IPv4, TCP), 0, -1))
+	}
+
+	if len(ft.table) != 1 {
+		t.Fatalf("Should get only one flow, got %d", len(ft.table))
+	}
+
+	var uuid string
+	for _, f := range ft.table {
+		uuid = f.UUID
+	}
+
+	reply := ft.onQuery(&TableQuery{Type: "PCAPQuery", Obj: []byte(uuid)})
+	if reply.status != http.StatusOK || len(reply.Obj) != 1 {
+		t.Fatalf("Should get the pcap of the flow, got status %d", reply.status)
+	}
+
+	r, err := pcapgo.NewReader(bytes.NewReader(reply.Obj[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packets int
+	for {
+		if _, _, err := r.ReadPacketData(); err != nil {
+			break
+		}
+		packets++
+	}
+
+	if packets != 2 {
+		t.Errorf("Only the last 2 packets should be kept, got %d", packets)
+	}
+
+	ft.expireNow()
+	if reply := ft.onQuery(&TableQuery{Type: "PCAPQuery", Obj: []byte(uuid)}); reply.status != http.StatusNoContent {
+		t.Errorf("Packets should be released with the flow, got status %d", reply.status)
+	}
+}
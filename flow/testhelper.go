@@ -27,14 +27,17 @@ package flow
 import (
 	"encoding/hex"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 	"github.com/op/go-logging"
 	"github.com/skydive-project/skydive/common"
 )
@@ -259,6 +262,41 @@ func GenerateTestFlowsSymmetric(t *testing.T, ft *Table, baseSeed int64, tid str
 	return generateTestFlows(t, ft, baseSeed, true, tid, timestamp)
 }
 
+// FlowsFromPCAPFile returns the flows of the packets of a PCAP file as seen
+// by a capture node
+func FlowsFromPCAPFile(t *testing.T, filename string, nodeTID string) []*Flow {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ft := NewTable(nil, nil, NewFlowEnhancerPipeline())
+	ft.SetNodeTID(nodeTID)
+
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		packet := gopacket.NewPacket(data, reader.LinkType(), gopacket.Default)
+		packet.Metadata().CaptureInfo = ci
+		if flowPackets := FlowPacketsFromGoPacket(&packet, 0, common.UnixMillis(ci.Timestamp)); flowPackets != nil {
+			ft.flowPacketsToFlow(flowPackets)
+		}
+	}
+
+	return ft.getFlows(nil).Flows
+}
+
 func randomizeLayerStats(t *testing.T, seed int64, now int64, f *Flow) {
 	rnd := rand.New(rand.NewSource(seed))
 	f.Metric.ABPackets = int64(rnd.Int63n(0x10000))
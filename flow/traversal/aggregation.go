@@ -0,0 +1,380 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+type GroupByGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+}
+
+type TopKGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+}
+
+type HistogramGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+}
+
+// FlowGroupTraversalStep holds flows grouped by the value of a field
+type FlowGroupTraversalStep struct {
+	GraphTraversal *traversal.GraphTraversal
+	flowset        *flow.FlowSet
+	groupBy        string
+	error          error
+}
+
+func (f *FlowTraversalStep) aggregate(a *flow.Aggregation) traversal.GraphTraversalStep {
+	if f.error != nil {
+		return traversal.NewGraphTraversalValue(f.GraphTraversal, nil, f.error)
+	}
+
+	// groups not followed by an aggregation step
+	if a.Type == 0 {
+		return f.GroupBy(a.GroupBy)
+	}
+
+	// TopK of flows, not groups, keeps the flows
+	if a.GroupBy == "" && a.Type == flow.TopKAggregation {
+		f.flowset.Sort(common.SortDescending, a.Field)
+		f.flowset.Slice(0, int(a.K))
+		return &FlowTraversalStep{GraphTraversal: f.GraphTraversal, Storage: f.Storage, flowset: f.flowset}
+	}
+
+	value, err := f.flowset.Aggregate(a)
+	return traversal.NewGraphTraversalValue(f.GraphTraversal, value, err)
+}
+
+// GroupBy groups the flows by the value of the given field
+func (f *FlowTraversalStep) GroupBy(keys ...interface{}) *FlowGroupTraversalStep {
+	if f.error != nil {
+		return &FlowGroupTraversalStep{error: f.error}
+	}
+
+	if len(keys) != 1 {
+		return &FlowGroupTraversalStep{error: errors.New("GroupBy requires 1 parameter")}
+	}
+
+	key, ok := keys[0].(string)
+	if !ok {
+		return &FlowGroupTraversalStep{error: errors.New("GroupBy parameter has to be a string key")}
+	}
+
+	return &FlowGroupTraversalStep{GraphTraversal: f.GraphTraversal, flowset: f.flowset, groupBy: key}
+}
+
+// TopK returns the k flows with the highest values of the given field
+func (f *FlowTraversalStep) TopK(params ...interface{}) *FlowTraversalStep {
+	if f.error != nil {
+		return f
+	}
+
+	a, err := topKAggregation("", params...)
+	if err != nil {
+		return &FlowTraversalStep{error: err}
+	}
+
+	return f.aggregate(a).(*FlowTraversalStep)
+}
+
+// Histogram counts the flows per bucket of values of the given field
+func (f *FlowTraversalStep) Histogram(params ...interface{}) *traversal.GraphTraversalValue {
+	if f.error != nil {
+		return traversal.NewGraphTraversalValue(f.GraphTraversal, nil, f.error)
+	}
+
+	a, err := histogramAggregation("", params...)
+	if err != nil {
+		return traversal.NewGraphTraversalValue(f.GraphTraversal, nil, err)
+	}
+
+	return f.aggregate(a).(*traversal.GraphTraversalValue)
+}
+
+func (g *FlowGroupTraversalStep) aggregate(a *flow.Aggregation) *traversal.GraphTraversalValue {
+	value, err := g.flowset.Aggregate(a)
+	return traversal.NewGraphTraversalValue(g.GraphTraversal, value, err)
+}
+
+// Sum sums the values of the given field for each group
+func (g *FlowGroupTraversalStep) Sum(keys ...interface{}) *traversal.GraphTraversalValue {
+	if g.error != nil {
+		return traversal.NewGraphTraversalValue(g.GraphTraversal, nil, g.error)
+	}
+
+	if len(keys) != 1 {
+		return traversal.NewGraphTraversalValue(g.GraphTraversal, nil, errors.New("Sum requires 1 parameter"))
+	}
+
+	key, ok := keys[0].(string)
+	if !ok {
+		return traversal.NewGraphTraversalValue(g.GraphTraversal, nil, errors.New("Sum parameter has to be a string key"))
+	}
+
+	return g.aggregate(&flow.Aggregation{GroupBy: g.groupBy, Type: flow.SumAggregation, Field: key})
+}
+
+// Count returns the number of flows of each group
+func (g *FlowGroupTraversalStep) Count(s ...interface{}) *traversal.GraphTraversalValue {
+	if g.error != nil {
+		return traversal.NewGraphTraversalValue(g.GraphTraversal, nil, g.error)
+	}
+
+	return g.aggregate(&flow.Aggregation{GroupBy: g.groupBy, Type: flow.CountAggregation})
+}
+
+// TopK returns the k groups with the highest sums of the given field
+func (g *FlowGroupTraversalStep) TopK(params ...interface{}) *traversal.GraphTraversalValue {
+	if g.error != nil {
+		return traversal.NewGraphTraversalValue(g.GraphTraversal, nil, g.error)
+	}
+
+	a, err := topKAggregation(g.groupBy, params...)
+	if err != nil {
+		return traversal.NewGraphTraversalValue(g.GraphTraversal, nil, err)
+	}
+
+	return g.aggregate(a)
+}
+
+// Histogram counts the flows per bucket of values of the given field for
+// each group
+func (g *FlowGroupTraversalStep) Histogram(params ...interface{}) *traversal.GraphTraversalValue {
+	if g.error != nil {
+		return traversal.NewGraphTraversalValue(g.GraphTraversal, nil, g.error)
+	}
+
+	a, err := histogramAggregation(g.groupBy, params...)
+	if err != nil {
+		return traversal.NewGraphTraversalValue(g.GraphTraversal, nil, err)
+	}
+
+	return g.aggregate(a)
+}
+
+func (g *FlowGroupTraversalStep) Values() []interface{} {
+	groups := make(map[string]interface{})
+	for key, group := range g.flowset.GroupBy(g.groupBy) {
+		groups[key] = group.Flows
+	}
+	return []interface{}{groups}
+}
+
+func (g *FlowGroupTraversalStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.Values())
+}
+
+func (g *FlowGroupTraversalStep) Error() error {
+	return g.error
+}
+
+func topKAggregation(groupBy string, params ...interface{}) (*flow.Aggregation, error) {
+	if len(params) != 2 {
+		return nil, errors.New("TopK requires 2 parameters")
+	}
+
+	k, ok := params[0].(int64)
+	if !ok {
+		return nil, errors.New("TopK first parameter has to be an integer")
+	}
+
+	field, ok := params[1].(string)
+	if !ok {
+		return nil, errors.New("TopK second parameter has to be a string key")
+	}
+
+	return &flow.Aggregation{GroupBy: groupBy, Type: flow.TopKAggregation, Field: field, K: k}, nil
+}
+
+func histogramAggregation(groupBy string, params ...interface{}) (*flow.Aggregation, error) {
+	if len(params) != 2 {
+		return nil, errors.New("Histogram requires 2 parameters")
+	}
+
+	field, ok := params[0].(string)
+	if !ok {
+		return nil, errors.New("Histogram first parameter has to be a string key")
+	}
+
+	interval, ok := params[1].(int64)
+	if !ok || interval <= 0 {
+		return nil, errors.New("Histogram second parameter has to be a strictly positive integer")
+	}
+
+	return &flow.Aggregation{GroupBy: groupBy, Type: flow.HistogramAggregation, Field: field, Interval: interval}, nil
+}
+
+// reduceAggregation merges the aggregation steps following the Flows step so
+// that they can be computed by the storage when searching stored flows
+func (s *FlowGremlinTraversalStep) reduceAggregation(next traversal.GremlinTraversalStep) bool {
+	if s.metricsNextStep {
+		return false
+	}
+
+	a := s.aggregation
+	switch step := next.(type) {
+	case *GroupByGremlinTraversalStep:
+		if a != nil {
+			return false
+		}
+		s.aggregation = &flow.Aggregation{GroupBy: step.context.Params[0].(string)}
+	case *traversal.GremlinTraversalStepSum:
+		if a != nil && a.Type != 0 || len(step.Params) != 1 {
+			return false
+		}
+		field, ok := step.Params[0].(string)
+		if !ok {
+			return false
+		}
+		if a == nil {
+			s.aggregation = &flow.Aggregation{}
+		}
+		s.aggregation.Type = flow.SumAggregation
+		s.aggregation.Field = field
+	case *traversal.GremlinTraversalStepCount:
+		if a == nil || a.Type != 0 {
+			return false
+		}
+		a.Type = flow.CountAggregation
+	case *TopKGremlinTraversalStep:
+		if a != nil && a.Type != 0 {
+			return false
+		}
+		var groupBy string
+		if a != nil {
+			groupBy = a.GroupBy
+		} else if s.sort || s.context.StepContext.PaginationRange != nil {
+			// the TopK of flows is a sort and a limit
+			return false
+		}
+		topK, _ := topKAggregation(groupBy, step.context.Params...)
+		s.aggregation = topK
+	case *HistogramGremlinTraversalStep:
+		if a != nil && a.Type != 0 {
+			return false
+		}
+		var groupBy string
+		if a != nil {
+			groupBy = a.GroupBy
+		}
+		histogram, _ := histogramAggregation(groupBy, step.context.Params...)
+		s.aggregation = histogram
+	default:
+		return false
+	}
+
+	return true
+}
+
+// storageAggregation computes the aggregation with the storage if supported,
+// nil is returned otherwise so that the aggregation is done in memory. The
+// storages aggregate all the flows matching the filter, the queries
+// deduplicating or limiting the flows are then aggregated in memory.
+func (s *FlowGremlinTraversalStep) storageAggregation(gt *traversal.GraphTraversal, fsq filters.SearchQuery, a *flow.Aggregation) (traversal.GraphTraversalStep, error) {
+	if a == nil || a.Type == 0 || (a.GroupBy == "" && a.Type == flow.TopKAggregation) {
+		return nil, nil
+	}
+
+	if fsq.Dedup || fsq.PaginationRange != nil {
+		return nil, nil
+	}
+
+	aggregator, ok := s.Storage.(storage.FlowAggregator)
+	if !ok {
+		return nil, nil
+	}
+
+	value, err := aggregator.AggregateFlows(fsq, a)
+	if err == storage.ErrAggregationNotSupported {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return traversal.NewGraphTraversalValue(gt, value), nil
+}
+
+func (s *GroupByGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch last := last.(type) {
+	case *FlowTraversalStep:
+		return last.GroupBy(s.context.Params...), nil
+	}
+
+	return nil, traversal.ExecutionError
+}
+
+func (s *GroupByGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+func (s *GroupByGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
+
+func (s *TopKGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch last := last.(type) {
+	case *FlowTraversalStep:
+		return last.TopK(s.context.Params...), nil
+	case *FlowGroupTraversalStep:
+		return last.TopK(s.context.Params...), nil
+	}
+
+	return nil, traversal.ExecutionError
+}
+
+func (s *TopKGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+func (s *TopKGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
+
+func (s *HistogramGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch last := last.(type) {
+	case *FlowTraversalStep:
+		return last.Histogram(s.context.Params...), nil
+	case *FlowGroupTraversalStep:
+		return last.Histogram(s.context.Params...), nil
+	}
+
+	return nil, traversal.ExecutionError
+}
+
+func (s *HistogramGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+func (s *HistogramGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
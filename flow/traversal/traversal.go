@@ -26,6 +26,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
@@ -44,17 +47,29 @@ const (
 	NODES_TOKEN        traversal.Token = 1003
 	CAPTURE_NODE_TOKEN traversal.Token = 1004
 	AGGREGATES_TOKEN   traversal.Token = 1005
+	CONVERSATION_TOKEN traversal.Token = 1006
+	GROUPBY_TOKEN      traversal.Token = 1007
+	TOPK_TOKEN         traversal.Token = 1008
+	HISTOGRAM_TOKEN    traversal.Token = 1009
+	RULES_TOKEN        traversal.Token = 1010
+	OPENFLOW_TOKEN     traversal.Token = 1011
 )
 
 type FlowTraversalExtension struct {
-	FlowToken        traversal.Token
-	BandwidthToken   traversal.Token
-	HopsToken        traversal.Token
-	NodesToken       traversal.Token
-	CaptureNodeToken traversal.Token
-	AggregatesToken  traversal.Token
-	TableClient      *flow.TableClient
-	Storage          storage.Storage
+	FlowToken         traversal.Token
+	BandwidthToken    traversal.Token
+	HopsToken         traversal.Token
+	NodesToken        traversal.Token
+	CaptureNodeToken  traversal.Token
+	AggregatesToken   traversal.Token
+	ConversationToken traversal.Token
+	GroupByToken      traversal.Token
+	TopKToken         traversal.Token
+	HistogramToken    traversal.Token
+	RulesToken        traversal.Token
+	OpenFlowToken     traversal.Token
+	TableClient       *flow.TableClient
+	Storage           storage.Storage
 }
 
 type FlowGremlinTraversalStep struct {
@@ -68,6 +83,7 @@ type FlowGremlinTraversalStep struct {
 	sort            bool
 	sortBy          string
 	sortOrder       string
+	aggregation     *flow.Aggregation
 }
 
 type FlowTraversalStep struct {
@@ -94,6 +110,17 @@ type AggregatesGremlinTraversalStep struct {
 	context traversal.GremlinTraversalContext
 }
 
+type ConversationGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+}
+
+// RulesGremlinTraversalStep looks up the rules matching the flows, either
+// the netfilter or the OpenFlow ones
+type RulesGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+	rules   func(f *FlowTraversalStep, s ...interface{}) *traversal.GraphTraversalV
+}
+
 func (f *FlowTraversalStep) Out(s ...interface{}) *traversal.GraphTraversalV {
 	var nodes []*graph.Node
 
@@ -344,6 +371,198 @@ func (f *FlowTraversalStep) CaptureNode(s ...interface{}) *traversal.GraphTraver
 	return traversal.NewGraphTraversalV(f.GraphTraversal, nodes)
 }
 
+// flowTuples returns the 5-tuples of both directions of a flow
+func flowTuples(fl *flow.Flow) []topology.FiveTuple {
+	if fl.Network == nil {
+		return nil
+	}
+
+	t := topology.FiveTuple{
+		Source:      net.ParseIP(fl.Network.A),
+		Destination: net.ParseIP(fl.Network.B),
+	}
+	if t.Source == nil || t.Destination == nil {
+		return nil
+	}
+
+	if fl.Transport != nil {
+		switch fl.Transport.Protocol {
+		case flow.FlowProtocol_TCPPORT:
+			t.Protocol = "tcp"
+		case flow.FlowProtocol_UDPPORT:
+			t.Protocol = "udp"
+		case flow.FlowProtocol_SCTPPORT:
+			t.Protocol = "sctp"
+		}
+		t.SourcePort, _ = strconv.ParseInt(fl.Transport.A, 10, 64)
+		t.DestinationPort, _ = strconv.ParseInt(fl.Transport.B, 10, 64)
+	} else if strings.Contains(fl.LayersPath, "ICMPv4") {
+		t.Protocol = "icmp"
+	} else if strings.Contains(fl.LayersPath, "ICMPv6") {
+		t.Protocol = "ipv6-icmp"
+	}
+
+	reply := topology.FiveTuple{
+		Protocol:        t.Protocol,
+		Source:          t.Destination,
+		Destination:     t.Source,
+		SourcePort:      t.DestinationPort,
+		DestinationPort: t.SourcePort,
+	}
+
+	return []topology.FiveTuple{t, reply}
+}
+
+// lookupRules returns the rules matching the flows found by lookup from the
+// capture node of each flow
+func (f *FlowTraversalStep) lookupRules(s []interface{}, lookup func(node *graph.Node, m graph.Metadata, fl *flow.Flow) []*graph.Node) *traversal.GraphTraversalV {
+	var nodes []*graph.Node
+
+	if f.error != nil {
+		return traversal.NewGraphTraversalV(f.GraphTraversal, nodes, f.error)
+	}
+
+	m, err := traversal.SliceToMetadata(s...)
+	if err != nil {
+		return traversal.NewGraphTraversalV(f.GraphTraversal, nodes, err)
+	}
+
+	f.GraphTraversal.RLock()
+	defer f.GraphTraversal.RUnlock()
+
+	seen := make(map[graph.Identifier]bool)
+	for _, fl := range f.flowset.Flows {
+		node := f.GraphTraversal.Graph.LookupFirstNode(graph.Metadata{"TID": fl.NodeTID})
+		if node == nil {
+			continue
+		}
+
+		for _, rule := range lookup(node, m, fl) {
+			if !seen[rule.ID] {
+				seen[rule.ID] = true
+				nodes = append(nodes, rule)
+			}
+		}
+	}
+
+	return traversal.NewGraphTraversalV(f.GraphTraversal, nodes)
+}
+
+// Rules returns the netfilter rules of the namespace of the capture nodes
+// matching the 5-tuple of the flows in either direction
+func (f *FlowTraversalStep) Rules(s ...interface{}) *traversal.GraphTraversalV {
+	return f.lookupRules(s, func(node *graph.Node, m graph.Metadata, fl *flow.Flow) (rules []*graph.Node) {
+		for _, t := range flowTuples(fl) {
+			rules = append(rules, topology.LookupNetfilterRules(f.GraphTraversal.Graph, node, m, t)...)
+		}
+		return
+	})
+}
+
+// openFlowPackets returns the packets of both directions of a flow as
+// matched against the OpenFlow rules
+func openFlowPackets(fl *flow.Flow) []topology.OpenFlowPacket {
+	var forward, reply topology.OpenFlowPacket
+
+	if fl.Link != nil {
+		forward.EthSrc, forward.EthDst = fl.Link.A, fl.Link.B
+		reply.EthSrc, reply.EthDst = fl.Link.B, fl.Link.A
+	}
+
+	if tuples := flowTuples(fl); tuples != nil {
+		forward.FiveTuple, reply.FiveTuple = tuples[0], tuples[1]
+	}
+
+	switch {
+	case fl.Network != nil && fl.Network.Protocol == flow.FlowProtocol_IPV4:
+		forward.EtherType = "ip"
+	case fl.Network != nil && fl.Network.Protocol == flow.FlowProtocol_IPV6:
+		forward.EtherType = "ipv6"
+	case strings.Contains(fl.LayersPath, "ARP"):
+		forward.EtherType = "arp"
+	}
+	reply.EtherType = forward.EtherType
+
+	return []topology.OpenFlowPacket{forward, reply}
+}
+
+// OpenFlowRules returns the OpenFlow rules of the bridges of the capture
+// nodes matching the packets of the flows, in either direction, entering
+// the bridges through the capture nodes
+func (f *FlowTraversalStep) OpenFlowRules(s ...interface{}) *traversal.GraphTraversalV {
+	return f.lookupRules(s, func(node *graph.Node, m graph.Metadata, fl *flow.Flow) (rules []*graph.Node) {
+		for _, p := range openFlowPackets(fl) {
+			rules = append(rules, topology.LookupOpenFlowRules(f.GraphTraversal.Graph, node, m, p)...)
+		}
+		return
+	})
+}
+
+// edges followed by the packets between two capture points
+var layer2Metadata = graph.Metadata{"RelationType": "layer2"}
+
+// topologyDistances returns the number of layer2 hops between the capture
+// node of the first flow and the capture nodes of the other flows, following
+// the path of the packets rather than the ownership tree
+func (f *FlowTraversalStep) topologyDistances(first *flow.Flow, flows []*flow.Flow) map[string]int {
+	g := f.GraphTraversal.Graph
+
+	origin := g.LookupFirstNode(graph.Metadata{"TID": first.NodeTID})
+	if origin == nil {
+		return nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, fl := range flows {
+		wanted[fl.NodeTID] = true
+	}
+
+	distances := make(map[string]int)
+	visited := map[graph.Identifier]bool{origin.ID: true}
+	nodes := []*graph.Node{origin}
+	for distance := 0; len(nodes) > 0 && len(distances) < len(wanted); distance++ {
+		var next []*graph.Node
+		for _, n := range nodes {
+			if tid, _ := n.GetFieldString("TID"); wanted[tid] {
+				if _, ok := distances[tid]; !ok {
+					distances[tid] = distance
+				}
+			}
+
+			for _, e := range g.GetNodeEdges(n, layer2Metadata) {
+				parents, children := g.GetEdgeNodes(e, nil, nil)
+				for _, neighbor := range append(parents, children...) {
+					if !visited[neighbor.ID] {
+						visited[neighbor.ID] = true
+						next = append(next, neighbor)
+					}
+				}
+			}
+		}
+		nodes = next
+	}
+
+	return distances
+}
+
+// Conversation returns, for each TrackingID, the capture points where the
+// flow has been seen ordered by topology and first seen time
+func (f *FlowTraversalStep) Conversation() *traversal.GraphTraversalValue {
+	if f.error != nil {
+		return traversal.NewGraphTraversalValue(f.GraphTraversal, nil, f.error)
+	}
+
+	f.GraphTraversal.RLock()
+	defer f.GraphTraversal.RUnlock()
+
+	var values []interface{}
+	for _, c := range flow.Conversations(f.flowset.Flows, f.topologyDistances) {
+		values = append(values, c)
+	}
+
+	return traversal.NewGraphTraversalValue(f.GraphTraversal, values)
+}
+
 func (f *FlowTraversalStep) Sort(keys ...interface{}) *FlowTraversalStep {
 	if f.error != nil {
 		return f
@@ -529,13 +748,19 @@ func (f *FlowTraversalStep) Error() error {
 
 func NewFlowTraversalExtension(client *flow.TableClient, storage storage.Storage) *FlowTraversalExtension {
 	return &FlowTraversalExtension{
-		FlowToken:        FLOW_TOKEN,
-		HopsToken:        HOPS_TOKEN,
-		NodesToken:       NODES_TOKEN,
-		CaptureNodeToken: CAPTURE_NODE_TOKEN,
-		AggregatesToken:  AGGREGATES_TOKEN,
-		TableClient:      client,
-		Storage:          storage,
+		FlowToken:         FLOW_TOKEN,
+		HopsToken:         HOPS_TOKEN,
+		NodesToken:        NODES_TOKEN,
+		CaptureNodeToken:  CAPTURE_NODE_TOKEN,
+		AggregatesToken:   AGGREGATES_TOKEN,
+		ConversationToken: CONVERSATION_TOKEN,
+		GroupByToken:      GROUPBY_TOKEN,
+		TopKToken:         TOPK_TOKEN,
+		HistogramToken:    HISTOGRAM_TOKEN,
+		RulesToken:        RULES_TOKEN,
+		OpenFlowToken:     OPENFLOW_TOKEN,
+		TableClient:       client,
+		Storage:           storage,
 	}
 }
 
@@ -553,6 +778,18 @@ func (e *FlowTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
 		return e.CaptureNodeToken, true
 	case "AGGREGATES":
 		return e.AggregatesToken, true
+	case "CONVERSATION":
+		return e.ConversationToken, true
+	case "GROUPBY":
+		return e.GroupByToken, true
+	case "TOPK":
+		return e.TopKToken, true
+	case "HISTOGRAM":
+		return e.HistogramToken, true
+	case "RULES":
+		return e.RulesToken, true
+	case "OPENFLOWRULES":
+		return e.OpenFlowToken, true
 	}
 	return traversal.IDENT, false
 }
@@ -569,6 +806,42 @@ func (e *FlowTraversalExtension) ParseStep(t traversal.Token, p traversal.Gremli
 		return &CaptureNodeGremlinTraversalStep{context: p}, nil
 	case e.AggregatesToken:
 		return &AggregatesGremlinTraversalStep{context: p}, nil
+	case e.ConversationToken:
+		return &ConversationGremlinTraversalStep{context: p}, nil
+	case e.GroupByToken:
+		if len(p.Params) != 1 {
+			return nil, errors.New("GroupBy requires 1 parameter")
+		}
+		if _, ok := p.Params[0].(string); !ok {
+			return nil, errors.New("GroupBy parameter has to be a string key")
+		}
+		return &GroupByGremlinTraversalStep{context: p}, nil
+	case e.TopKToken:
+		if len(p.Params) != 2 {
+			return nil, errors.New("TopK requires 2 parameters")
+		}
+		if _, ok := p.Params[0].(int64); !ok {
+			return nil, errors.New("TopK first parameter has to be an integer")
+		}
+		if _, ok := p.Params[1].(string); !ok {
+			return nil, errors.New("TopK second parameter has to be a string key")
+		}
+		return &TopKGremlinTraversalStep{context: p}, nil
+	case e.HistogramToken:
+		if len(p.Params) != 2 {
+			return nil, errors.New("Histogram requires 2 parameters")
+		}
+		if _, ok := p.Params[0].(string); !ok {
+			return nil, errors.New("Histogram first parameter has to be a string key")
+		}
+		if interval, ok := p.Params[1].(int64); !ok || interval <= 0 {
+			return nil, errors.New("Histogram second parameter has to be a strictly positive integer")
+		}
+		return &HistogramGremlinTraversalStep{context: p}, nil
+	case e.RulesToken:
+		return &RulesGremlinTraversalStep{context: p, rules: (*FlowTraversalStep).Rules}, nil
+	case e.OpenFlowToken:
+		return &RulesGremlinTraversalStep{context: p, rules: (*FlowTraversalStep).OpenFlowRules}, nil
 	}
 
 	return nil, nil
@@ -640,6 +913,16 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 		return nil, err
 	}
 
+	// the aggregation is built by Reduce before each execution
+	aggregation := s.aggregation
+	s.aggregation = nil
+	if aggregation != nil && aggregation.GroupBy == "" && aggregation.Type == flow.TopKAggregation {
+		flowSearchQuery.Sort = true
+		flowSearchQuery.SortBy = aggregation.Field
+		flowSearchQuery.SortOrder = common.SortDescending
+		flowSearchQuery.PaginationRange = &filters.Range{To: aggregation.K}
+	}
+
 	flowset := &flow.FlowSet{}
 
 	switch tv := last.(type) {
@@ -662,6 +945,10 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 				return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowSearchQuery: flowSearchQuery}, nil
 			}
 
+			if step, err := s.storageAggregation(graphTraversal, flowSearchQuery, aggregation); step != nil || err != nil {
+				return step, err
+			}
+
 			if flowset, err = s.Storage.SearchFlows(flowSearchQuery); err != nil {
 				return nil, err
 			}
@@ -697,6 +984,10 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 					return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowSearchQuery: flowSearchQuery}, nil
 				}
 
+				if step, err := s.storageAggregation(graphTraversal, flowSearchQuery, aggregation); step != nil || err != nil {
+					return step, err
+				}
+
 				if flowset, err = s.Storage.SearchFlows(flowSearchQuery); err != nil {
 					return nil, err
 				}
@@ -720,10 +1011,23 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 		flowset.Slice(int(r[0]), int(r[1]))
 	}
 
-	return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowset: flowset, flowSearchQuery: flowSearchQuery}, nil
+	fs := &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowset: flowset, flowSearchQuery: flowSearchQuery}
+	if aggregation != nil {
+		return fs.aggregate(aggregation), nil
+	}
+
+	return fs, nil
 }
 
 func (s *FlowGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	// nothing but other aggregation steps can follow an aggregation
+	if s.aggregation != nil {
+		if s.reduceAggregation(next) {
+			return s
+		}
+		return next
+	}
+
 	if hasStep, ok := next.(*traversal.GremlinTraversalStepHas); ok {
 		// merge has parameters, useful in case of multiple Has reduce
 		s.hasParams = append(s.hasParams, hasStep.Params...)
@@ -749,6 +1053,10 @@ func (s *FlowGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) t
 		return s
 	}
 
+	if s.reduceAggregation(next) {
+		return s
+	}
+
 	if _, ok := next.(*traversal.GremlinTraversalStepMetrics); ok {
 		s.metricsNextStep = true
 	}
@@ -846,3 +1154,43 @@ func (a *AggregatesGremlinTraversalStep) Reduce(next traversal.GremlinTraversalS
 func (a *AggregatesGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
 	return &a.context
 }
+
+func (c *ConversationGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch last.(type) {
+	case *FlowTraversalStep:
+		fs := last.(*FlowTraversalStep)
+		return fs.Conversation(), nil
+	}
+
+	return nil, traversal.ExecutionError
+}
+
+func (c *ConversationGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+func (c *ConversationGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &c.context
+}
+
+func (r *RulesGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch last.(type) {
+	case *FlowTraversalStep:
+		fs := last.(*FlowTraversalStep)
+		return r.rules(fs, r.context.Params...), nil
+	}
+
+	return nil, traversal.ExecutionError
+}
+
+func (r *RulesGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	if hasStep, ok := next.(*traversal.GremlinTraversalStepHas); ok {
+		r.context.Params = append(r.context.Params, hasStep.Params...)
+		return r
+	}
+	return next
+}
+
+func (r *RulesGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &r.context
+}
@@ -27,10 +27,94 @@ import (
 	"testing"
 
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
 	"github.com/skydive-project/skydive/topology/graph/traversal"
 )
 
+// fakeAggregator is a storage able to compute the aggregations
+type fakeAggregator struct {
+	aggregations int
+}
+
+func (f *fakeAggregator) Start() {
+}
+
+func (f *fakeAggregator) StoreFlows(flows []*flow.Flow) error {
+	return nil
+}
+
+func (f *fakeAggregator) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+	return &flow.FlowSet{}, nil
+}
+
+func (f *fakeAggregator) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]*common.TimedMetric, error) {
+	return nil, nil
+}
+
+func (f *fakeAggregator) AggregateFlows(fsq filters.SearchQuery, a *flow.Aggregation) (interface{}, error) {
+	f.aggregations++
+	return map[string]interface{}{}, nil
+}
+
+func (f *fakeAggregator) Stop() {
+}
+
+func TestStorageAggregation(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	gt := traversal.NewGraphTraversal(graph.NewGraph("analyzer", b), false)
+
+	aggregator := &fakeAggregator{}
+	s := &FlowGremlinTraversalStep{Storage: aggregator}
+	a := &flow.Aggregation{Type: flow.SumAggregation, Field: "Metric.ABBytes", GroupBy: "Network.A"}
+
+	for _, fsq := range []filters.SearchQuery{
+		{Dedup: true, DedupBy: "TrackingID"},
+		{PaginationRange: &filters.Range{To: 10}},
+	} {
+		step, err := s.storageAggregation(gt, fsq, a)
+		if step != nil || err != nil {
+			t.Errorf("%+v should be aggregated in memory, got %v, %v", fsq, step, err)
+		}
+	}
+
+	if aggregator.aggregations != 0 {
+		t.Errorf("The storage shouldn't aggregate deduplicated or paginated flows")
+	}
+
+	if step, err := s.storageAggregation(gt, filters.SearchQuery{}, a); step == nil || err != nil {
+		t.Errorf("The storage should aggregate the flows, got %v, %v", step, err)
+	}
+}
+
+func TestTopologyDistances(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("analyzer", b)
+
+	g.Lock()
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host"})
+	tap1 := g.NewNode(graph.GenID(), graph.Metadata{"TID": "tap1"})
+	br1 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsbridge"})
+	br2 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsbridge"})
+	tap2 := g.NewNode(graph.GenID(), graph.Metadata{"TID": "tap2"})
+	for _, n := range []*graph.Node{tap1, br1, br2, tap2} {
+		g.Link(host, n, graph.Metadata{"RelationType": "ownership"})
+	}
+	g.Link(br1, tap1, layer2Metadata)
+	g.Link(br1, br2, layer2Metadata)
+	g.Link(br2, tap2, layer2Metadata)
+	g.Unlock()
+
+	fs := &FlowTraversalStep{GraphTraversal: traversal.NewGraphTraversal(g, false)}
+
+	first := &flow.Flow{NodeTID: "tap1"}
+	distances := fs.topologyDistances(first, []*flow.Flow{first, &flow.Flow{NodeTID: "tap2"}})
+	if distances["tap1"] != 0 || distances["tap2"] != 3 {
+		t.Errorf("Distances should follow the layer2 links, got %v", distances)
+	}
+}
+
 func TestFlowMetricsAggregates(t *testing.T) {
 	metrics := map[string][]*common.TimedMetric{
 		"aa": []*common.TimedMetric{
@@ -36,6 +36,7 @@ import (
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 	"github.com/hydrogen18/stoppableListener"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
@@ -45,6 +46,8 @@ import (
 
 type PathPrefix string
 
+var metricsHandler = prometheus.Handler()
+
 type Route struct {
 	Name        string
 	Method      string
@@ -178,6 +181,11 @@ func unauthorized(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("401 Unauthorized\n"))
 }
 
+// serveMetrics exposes the registered Prometheus metrics
+func (s *Server) serveMetrics(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	metricsHandler.ServeHTTP(w, &r.Request)
+}
+
 func (s *Server) HandleFunc(path string, f auth.AuthenticatedHandlerFunc) {
 	s.Router.HandleFunc(path, s.Auth.Wrap(f))
 }
@@ -198,6 +206,7 @@ func NewServer(host string, serviceType common.ServiceType, addr string, port in
 	}
 
 	router.HandleFunc("/login", server.serveLogin)
+	router.HandleFunc("/metrics", auth.Wrap(server.serveMetrics))
 	router.HandleFunc("/", server.serveIndex)
 
 	return server
@@ -32,6 +32,7 @@ import (
 	"github.com/abbot/go-http-auth"
 	"github.com/gorilla/websocket"
 	"github.com/nu7hatch/gouuid"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
@@ -45,6 +46,55 @@ const (
 	maxMessageSize = 0
 )
 
+var (
+	wsClients = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "skydive",
+			Subsystem: "ws",
+			Name:      "clients",
+			Help:      "Number of connected websocket clients by client type",
+		},
+		[]string{"type"},
+	)
+	wsMessagesReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "skydive",
+			Subsystem: "ws",
+			Name:      "messages_received_total",
+			Help:      "Number of websocket messages received by namespace",
+		},
+		[]string{"namespace"},
+	)
+	wsMessagesSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "skydive",
+			Subsystem: "ws",
+			Name:      "messages_sent_total",
+			Help:      "Number of websocket messages sent or broadcasted by namespace",
+		},
+		[]string{"namespace"},
+	)
+)
+
+// wsNamespaces are the namespaces exchanged by the skydive services, the
+// other ones, set by the clients, are counted under a single label so that
+// they can't grow the number of series
+var wsNamespaces = map[string]bool{
+	Namespace:          true,
+	"Graph":            true,
+	"Flow":             true,
+	"OnDemand":         true,
+	"Alert":            true,
+	"Packet_Injection": true,
+}
+
+func namespaceLabel(ns string) string {
+	if wsNamespaces[ns] {
+		return ns
+	}
+	return "other"
+}
+
 type WSClient struct {
 	Host       string
 	ClientType common.ServiceType
@@ -142,6 +192,7 @@ func (d *DefaultWSServerEventHandler) OnUnregisterClient(c *WSClient) {
 }
 
 func (c *WSClient) SendWSMessage(msg *WSMessage) {
+	wsMessagesSent.WithLabelValues(namespaceLabel(msg.Namespace)).Inc()
 	c.send <- []byte(msg.String())
 }
 
@@ -152,6 +203,8 @@ func (c *WSClient) processMessage(m []byte) {
 		return
 	}
 
+	wsMessagesReceived.WithLabelValues(namespaceLabel(msg.Namespace)).Inc()
+
 	if msg.Namespace != Namespace {
 		for _, e := range c.server.eventHandlers {
 			e.OnMessage(c, msg)
@@ -264,6 +317,7 @@ func (s *WSServer) listenAndServe() {
 			for c := range s.clients {
 				c.conn.Close()
 				delete(s.clients, c)
+				wsClients.WithLabelValues(c.ClientType.String()).Dec()
 			}
 			s.Unlock()
 			return
@@ -271,6 +325,7 @@ func (s *WSServer) listenAndServe() {
 			s.Lock()
 			s.clients[c] = true
 			s.Unlock()
+			wsClients.WithLabelValues(c.ClientType.String()).Inc()
 			for _, e := range s.eventHandlers {
 				e.OnRegisterClient(c)
 			}
@@ -280,7 +335,10 @@ func (s *WSServer) listenAndServe() {
 			}
 			s.Lock()
 			c.conn.Close()
-			delete(s.clients, c)
+			if _, found := s.clients[c]; found {
+				delete(s.clients, c)
+				wsClients.WithLabelValues(c.ClientType.String()).Dec()
+			}
 			s.Unlock()
 		case m := <-s.broadcast:
 			s.broadcastMessage(m)
@@ -358,6 +416,7 @@ func (s *WSServer) serveMessages(w http.ResponseWriter, r *auth.AuthenticatedReq
 }
 
 func (s *WSServer) BroadcastWSMessage(msg *WSMessage) {
+	wsMessagesSent.WithLabelValues(namespaceLabel(msg.Namespace)).Inc()
 	s.broadcast <- msg.String()
 }
 
@@ -426,3 +485,9 @@ func NewWSServerFromConfig(serviceType common.ServiceType, server *Server, endpo
 
 	return NewWSServer(host, serviceType, server, time.Duration(w)*time.Second, endpoint)
 }
+
+func init() {
+	prometheus.MustRegister(wsClients)
+	prometheus.MustRegister(wsMessagesReceived)
+	prometheus.MustRegister(wsMessagesSent)
+}
@@ -51,18 +51,30 @@ type OvsMonitorHandler interface {
 	OnOvsPortUpdate(monitor *OvsMonitor, uuid string, row *libovsdb.RowUpdate)
 }
 
+// OvsTableMonitorHandler is notified of the changes of the rows of any
+// monitored table, including the ones of databases other than Open_vSwitch
+type OvsTableMonitorHandler interface {
+	OnOvsRowAdd(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate)
+	OnOvsRowDel(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate)
+	OnOvsRowUpdate(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate)
+}
+
 type OvsMonitor struct {
 	sync.RWMutex
-	Protocol        string
-	Target          string
-	OvsClient       *OvsClient
-	MonitorHandlers []OvsMonitorHandler
-	bridgeCache     map[string]string
-	interfaceCache  map[string]string
-	portCache       map[string]string
-	columnsExcluded map[string]bool
-	ticker          *time.Ticker
-	done            chan struct{}
+	Protocol             string
+	Target               string
+	Database             string
+	OvsClient            *OvsClient
+	MonitorHandlers      []OvsMonitorHandler
+	TableMonitorHandlers []OvsTableMonitorHandler
+	tables               []string
+	bridgeCache          map[string]string
+	interfaceCache       map[string]string
+	portCache            map[string]string
+	rowCache             map[string]map[string]bool
+	columnsExcluded      map[string]bool
+	ticker               *time.Ticker
+	done                 chan struct{}
 }
 
 const ConnectionPollInterval time.Duration = 4 * time.Second
@@ -274,21 +286,60 @@ func (o *OvsMonitor) portUpdateHandler(updates *libovsdb.TableUpdate) {
 	}
 }
 
+func (o *OvsMonitor) rowUpdateHandler(table string, updates *libovsdb.TableUpdate) {
+	empty := libovsdb.Row{}
+
+	o.Lock()
+	defer o.Unlock()
+
+	cache, ok := o.rowCache[table]
+	if !ok {
+		cache = make(map[string]bool)
+		o.rowCache[table] = cache
+	}
+
+	for uuid, row := range updates.Rows {
+		if !reflect.DeepEqual(row.New, empty) {
+			if _, ok := cache[uuid]; ok {
+				for _, handler := range o.TableMonitorHandlers {
+					handler.OnOvsRowUpdate(o, table, uuid, &row)
+				}
+			} else {
+				cache[uuid] = true
+				for _, handler := range o.TableMonitorHandlers {
+					handler.OnOvsRowAdd(o, table, uuid, &row)
+				}
+			}
+		} else {
+			delete(cache, uuid)
+			for _, handler := range o.TableMonitorHandlers {
+				handler.OnOvsRowDel(o, table, uuid, &row)
+			}
+		}
+	}
+}
+
 func (o *OvsMonitor) updateHandler(updates *libovsdb.TableUpdates) {
 	for name, tableUpdate := range updates.Updates {
-		switch name {
-		case "Interface":
-			o.interfaceUpdateHandler(&tableUpdate)
-		case "Bridge":
-			o.bridgeUpdateHandler(&tableUpdate)
-		case "Port":
-			o.portUpdateHandler(&tableUpdate)
+		if o.Database == "Open_vSwitch" {
+			switch name {
+			case "Interface":
+				o.interfaceUpdateHandler(&tableUpdate)
+			case "Bridge":
+				o.bridgeUpdateHandler(&tableUpdate)
+			case "Port":
+				o.portUpdateHandler(&tableUpdate)
+			}
+		}
+
+		if len(o.TableMonitorHandlers) > 0 {
+			o.rowUpdateHandler(name, &tableUpdate)
 		}
 	}
 }
 
 func (o *OvsMonitor) setMonitorRequests(table string, r *map[string]libovsdb.MonitorRequest) error {
-	schema, ok := o.OvsClient.ovsdb.Schema["Open_vSwitch"]
+	schema, ok := o.OvsClient.ovsdb.Schema[o.Database]
 	if !ok {
 		return errors.New("invalid Database Schema")
 	}
@@ -321,6 +372,15 @@ func (o *OvsMonitor) AddMonitorHandler(handler OvsMonitorHandler) {
 	o.MonitorHandlers = append(o.MonitorHandlers, handler)
 }
 
+// AddTableMonitorHandler registers a handler notified of the changes of the
+// rows of all the monitored tables
+func (o *OvsMonitor) AddTableMonitorHandler(handler OvsTableMonitorHandler) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.TableMonitorHandlers = append(o.TableMonitorHandlers, handler)
+}
+
 func (o *OvsMonitor) ExcludeColumn(column string) {
 	o.columnsExcluded[column] = true
 }
@@ -337,22 +397,13 @@ func (o *OvsMonitor) monitorOvsdb() error {
 	ovsdb.Register(notifier)
 
 	requests := make(map[string]libovsdb.MonitorRequest)
-	err = o.setMonitorRequests("Bridge", &requests)
-	if err != nil {
-		return err
-	}
-
-	err = o.setMonitorRequests("Interface", &requests)
-	if err != nil {
-		return err
-	}
-
-	err = o.setMonitorRequests("Port", &requests)
-	if err != nil {
-		return err
+	for _, table := range o.tables {
+		if err = o.setMonitorRequests(table, &requests); err != nil {
+			return err
+		}
 	}
 
-	updates, err := ovsdb.Monitor("Open_vSwitch", "", requests)
+	updates, err := ovsdb.Monitor(o.Database, "", requests)
 	if err != nil {
 		return err
 	}
@@ -395,16 +446,25 @@ func (o *OvsMonitor) StopMonitoring() {
 	}
 }
 
-func NewOvsMonitor(protcol string, target string) *OvsMonitor {
+// NewOvsDatabaseMonitor returns a monitor of the given tables of a database,
+// the changes being notified to the table monitor handlers
+func NewOvsDatabaseMonitor(protcol string, target string, database string, tables []string) *OvsMonitor {
 	return &OvsMonitor{
 		Protocol:        protcol,
 		Target:          target,
+		Database:        database,
 		OvsClient:       &OvsClient{ovsdb: nil, connected: 0},
+		tables:          tables,
 		bridgeCache:     make(map[string]string),
 		interfaceCache:  make(map[string]string),
 		portCache:       make(map[string]string),
+		rowCache:        make(map[string]map[string]bool),
 		columnsExcluded: make(map[string]bool),
 		ticker:          nil,
 		done:            make(chan struct{}),
 	}
 }
+
+func NewOvsMonitor(protcol string, target string) *OvsMonitor {
+	return NewOvsDatabaseMonitor(protcol, target, "Open_vSwitch", []string{"Bridge", "Interface", "Port"})
+}
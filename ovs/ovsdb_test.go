@@ -127,4 +127,66 @@ func TestBridgeDeleted(t *testing.T) {
 	}
 }
 
+type FakeRowHandler struct {
+	Events []string
+}
+
+func (r *FakeRowHandler) OnOvsRowAdd(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	r.Events = append(r.Events, "add "+table+" "+uuid)
+}
+
+func (r *FakeRowHandler) OnOvsRowDel(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	r.Events = append(r.Events, "del "+table+" "+uuid)
+}
+
+func (r *FakeRowHandler) OnOvsRowUpdate(monitor *OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	r.Events = append(r.Events, "update "+table+" "+uuid)
+}
+
+func getRowUpdates(table string, uuid string, op string) *libovsdb.TableUpdates {
+	row := libovsdb.Row{Fields: map[string]interface{}{"name": uuid + "-name"}}
+
+	rowUpdate := libovsdb.RowUpdate{UUID: libovsdb.UUID{GoUUID: uuid}, New: row}
+	if op == "del" {
+		rowUpdate = libovsdb.RowUpdate{UUID: libovsdb.UUID{GoUUID: uuid}, Old: row}
+	}
+
+	return &libovsdb.TableUpdates{
+		Updates: map[string]libovsdb.TableUpdate{
+			table: {Rows: map[string]libovsdb.RowUpdate{uuid: rowUpdate}},
+		},
+	}
+}
+
+func TestTableMonitorHandler(t *testing.T) {
+	monitor := NewOvsDatabaseMonitor("tcp", "127.0.0.1:6641", "OVN_Northbound", []string{"Logical_Switch"})
+
+	bridgeHandler := NewFakeBridgeHandler()
+	monitor.AddMonitorHandler(&bridgeHandler)
+
+	handler := &FakeRowHandler{}
+	monitor.AddTableMonitorHandler(handler)
+
+	monitor.updateHandler(getRowUpdates("Logical_Switch", "ls1", "add"))
+	monitor.updateHandler(getRowUpdates("Logical_Switch", "ls1", "add"))
+	monitor.updateHandler(getRowUpdates("Logical_Switch", "ls1", "del"))
+
+	// only the Open_vSwitch database reports bridges
+	monitor.updateHandler(getTableUpdates("bridge1", "add"))
+
+	expected := []string{"add Logical_Switch ls1", "update Logical_Switch ls1", "del Logical_Switch ls1", "add Bridge bridge1-uuid"}
+	if len(handler.Events) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, handler.Events)
+	}
+	for i := range expected {
+		if handler.Events[i] != expected[i] {
+			t.Errorf("Expected event %s, got %s", expected[i], handler.Events[i])
+		}
+	}
+
+	if bridgeHandler.Added {
+		t.Error("Bridge handler shouldn't be called for another database")
+	}
+}
+
 /* TODO(safchain) Add UT for interface adding */
@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package sink
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type kafkaRecord struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+// KafkaRESTProducer publishes messages to Kafka through a Kafka REST proxy
+// using the binary embedded format.
+type KafkaRESTProducer struct {
+	url    string
+	client *http.Client
+}
+
+func (p *KafkaRESTProducer) Publish(topic string, messages []Message) error {
+	records := kafkaRecords{Records: make([]kafkaRecord, len(messages))}
+	for i, m := range messages {
+		records.Records[i] = kafkaRecord{
+			Key:   base64.StdEncoding.EncodeToString([]byte(m.Key)),
+			Value: base64.StdEncoding.EncodeToString(m.Value),
+		}
+	}
+
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", p.url+"/topics/"+url.QueryEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.binary.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Kafka REST proxy returned %d: %s", resp.StatusCode, string(data))
+	}
+
+	return nil
+}
+
+func (p *KafkaRESTProducer) Close() {
+}
+
+func NewKafkaRESTProducer(addr string, timeout time.Duration) *KafkaRESTProducer {
+	return &KafkaRESTProducer{
+		url:    strings.TrimSuffix(addr, "/"),
+		client: &http.Client{Timeout: timeout},
+	}
+}
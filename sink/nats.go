@@ -0,0 +1,153 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package sink
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+// NATSProducer publishes messages on a NATS server using the text protocol.
+// The subject is the topic, the key isn't part of the NATS messages.
+type NATSProducer struct {
+	sync.Mutex
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+	writer  *bufio.Writer
+}
+
+func (p *NATSProducer) write(data string) error {
+	p.conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	_, err := p.writer.WriteString(data)
+	return err
+}
+
+// readLoop answers the keep alive requests of the server
+func (p *NATSProducer) readLoop(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			p.Lock()
+			if p.conn == conn {
+				p.write("PONG\r\n")
+				p.writer.Flush()
+			}
+			p.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			logging.GetLogger().Errorf("NATS server error: %s", line)
+		}
+	}
+}
+
+func (p *NATSProducer) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return err
+	}
+
+	// the server starts by sending its INFO
+	conn.SetReadDeadline(time.Now().Add(p.timeout))
+	reader := bufio.NewReader(conn)
+	info, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO") {
+		conn.Close()
+		return fmt.Errorf("Unexpected NATS greeting: %s", strings.TrimSpace(info))
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	p.conn = conn
+	p.writer = bufio.NewWriter(conn)
+	if err := p.write(`CONNECT {"verbose":false,"pedantic":false,"name":"skydive"}` + "\r\n"); err != nil {
+		p.close()
+		return err
+	}
+
+	go p.readLoop(conn, reader)
+
+	return nil
+}
+
+func (p *NATSProducer) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+func (p *NATSProducer) Publish(topic string, messages []Message) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range messages {
+		if err := p.write(fmt.Sprintf("PUB %s %d\r\n", topic, len(m.Value))); err != nil {
+			p.close()
+			return err
+		}
+		if err := p.write(string(m.Value) + "\r\n"); err != nil {
+			p.close()
+			return err
+		}
+	}
+
+	if err := p.writer.Flush(); err != nil {
+		p.close()
+		return err
+	}
+
+	return nil
+}
+
+func (p *NATSProducer) Close() {
+	p.Lock()
+	p.close()
+	p.Unlock()
+}
+
+func NewNATSProducer(addr string, timeout time.Duration) *NATSProducer {
+	return &NATSProducer{
+		addr:    addr,
+		timeout: timeout,
+	}
+}
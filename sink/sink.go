@@ -0,0 +1,250 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	FlowUpdatedMsgType = "FlowUpdated"
+	FlowExpiredMsgType = "FlowExpired"
+)
+
+const maxBatchSize = 500
+
+// Message is a message published on a topic of the bus, the key is the ID of
+// the node, edge or flow the message is about.
+type Message struct {
+	Key   string
+	Value []byte
+}
+
+// Producer publishes messages on a message bus
+type Producer interface {
+	Publish(topic string, messages []Message) error
+	Close()
+}
+
+// Event is the JSON representation of the published events
+type Event struct {
+	Type string
+	Obj  interface{}
+}
+
+type topicMessage struct {
+	topic   string
+	message Message
+}
+
+// EventSink publishes the topology events and the flow updates and
+// expirations received by the analyzer to a Producer. Topology events are
+// always encoded in JSON, flows are encoded in JSON or as protobuf Flow
+// messages according to the encoding.
+type EventSink struct {
+	graph.DefaultGraphListener
+	producer      Producer
+	encoding      string
+	topologyTopic string
+	flowTopic     string
+	queue         chan topicMessage
+	quit          chan bool
+	state         int64
+	wg            sync.WaitGroup
+	dropped       int64
+}
+
+func (s *EventSink) enqueue(topic string, key string, value []byte) {
+	select {
+	case s.queue <- topicMessage{topic: topic, message: Message{Key: key, Value: value}}:
+	default:
+		if atomic.AddInt64(&s.dropped, 1)%1000 == 1 {
+			logging.GetLogger().Warningf("Event sink queue full, %d messages dropped", atomic.LoadInt64(&s.dropped))
+		}
+	}
+}
+
+// the graph listeners are called with the graph lock held, so the events are
+// encoded here and sent asynchronously
+func (s *EventSink) publishGraphEvent(kind string, id graph.Identifier, obj interface{}) {
+	data, err := json.Marshal(&Event{Type: kind, Obj: obj})
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to encode %s event: %s", kind, err.Error())
+		return
+	}
+	s.enqueue(s.topologyTopic, string(id), data)
+}
+
+func (s *EventSink) OnNodeUpdated(n *graph.Node) {
+	s.publishGraphEvent(graph.NodeUpdatedMsgType, n.ID, n)
+}
+
+func (s *EventSink) OnNodeAdded(n *graph.Node) {
+	s.publishGraphEvent(graph.NodeAddedMsgType, n.ID, n)
+}
+
+func (s *EventSink) OnNodeDeleted(n *graph.Node) {
+	s.publishGraphEvent(graph.NodeDeletedMsgType, n.ID, n)
+}
+
+func (s *EventSink) OnEdgeUpdated(e *graph.Edge) {
+	s.publishGraphEvent(graph.EdgeUpdatedMsgType, e.ID, e)
+}
+
+func (s *EventSink) OnEdgeAdded(e *graph.Edge) {
+	s.publishGraphEvent(graph.EdgeAddedMsgType, e.ID, e)
+}
+
+func (s *EventSink) OnEdgeDeleted(e *graph.Edge) {
+	s.publishGraphEvent(graph.EdgeDeletedMsgType, e.ID, e)
+}
+
+func (s *EventSink) encodeFlow(f *flow.Flow) ([]byte, error) {
+	if s.encoding == "protobuf" {
+		return proto.Marshal(f)
+	}
+
+	kind := FlowUpdatedMsgType
+	if f.Expired {
+		kind = FlowExpiredMsgType
+	}
+	return json.Marshal(&Event{Type: kind, Obj: f})
+}
+
+// PublishFlows publishes flow updates and expirations
+func (s *EventSink) PublishFlows(flows []*flow.Flow) {
+	for _, f := range flows {
+		data, err := s.encodeFlow(f)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to encode flow %s: %s", f.UUID, err.Error())
+			continue
+		}
+		s.enqueue(s.flowTopic, f.UUID, data)
+	}
+}
+
+func (s *EventSink) publish(batch map[string][]Message) {
+	for topic, messages := range batch {
+		if err := s.producer.Publish(topic, messages); err != nil {
+			logging.GetLogger().Errorf("Unable to publish %d messages on %s: %s", len(messages), topic, err.Error())
+		}
+	}
+}
+
+func (s *EventSink) run() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case m := <-s.queue:
+			batch := map[string][]Message{m.topic: {m.message}}
+
+		drain:
+			for i := 1; i < maxBatchSize; i++ {
+				select {
+				case m = <-s.queue:
+					batch[m.topic] = append(batch[m.topic], m.message)
+				default:
+					break drain
+				}
+			}
+
+			s.publish(batch)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *EventSink) Start() {
+	if atomic.CompareAndSwapInt64(&s.state, common.StoppedState, common.RunningState) {
+		s.wg.Add(1)
+		go s.run()
+	}
+}
+
+func (s *EventSink) Stop() {
+	if atomic.CompareAndSwapInt64(&s.state, common.RunningState, common.StoppedState) {
+		s.quit <- true
+		s.wg.Wait()
+	}
+	s.producer.Close()
+}
+
+func NewEventSink(producer Producer, encoding string, topologyTopic string, flowTopic string, queueSize int) (*EventSink, error) {
+	switch encoding {
+	case "json", "protobuf":
+	default:
+		return nil, fmt.Errorf("Unknown event sink encoding: %s", encoding)
+	}
+
+	return &EventSink{
+		producer:      producer,
+		encoding:      encoding,
+		topologyTopic: topologyTopic,
+		flowTopic:     flowTopic,
+		queue:         make(chan topicMessage, queueSize),
+		quit:          make(chan bool),
+		state:         common.StoppedState,
+	}, nil
+}
+
+// NewEventSinkFromConfig returns the event sink defined in the configuration,
+// nil is returned if no sink is configured.
+func NewEventSinkFromConfig() (*EventSink, error) {
+	cfg := config.GetConfig()
+
+	var producer Producer
+	switch kind := cfg.GetString("analyzer.sink.type"); kind {
+	case "":
+		return nil, nil
+	case "kafka":
+		timeout := time.Duration(cfg.GetInt("analyzer.sink.kafka.timeout")) * time.Second
+		producer = NewKafkaRESTProducer(cfg.GetString("analyzer.sink.kafka.rest_proxy"), timeout)
+	case "nats":
+		timeout := time.Duration(cfg.GetInt("analyzer.sink.nats.timeout")) * time.Second
+		producer = NewNATSProducer(cfg.GetString("analyzer.sink.nats.addr"), timeout)
+	default:
+		return nil, fmt.Errorf("Unknown event sink type: %s", kind)
+	}
+
+	logging.GetLogger().Infof("Using %s as event sink", cfg.GetString("analyzer.sink.type"))
+
+	return NewEventSink(producer,
+		cfg.GetString("analyzer.sink.encoding"),
+		cfg.GetString("analyzer.sink.topology_topic"),
+		cfg.GetString("analyzer.sink.flow_topic"),
+		cfg.GetInt("analyzer.sink.queue_size"))
+}
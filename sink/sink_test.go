@@ -0,0 +1,234 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package sink
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+type topicMessages struct {
+	topic    string
+	messages []Message
+}
+
+// memoryProducer is an in-process stand-in of a message bus
+type memoryProducer struct {
+	published chan topicMessages
+}
+
+func (p *memoryProducer) Publish(topic string, messages []Message) error {
+	p.published <- topicMessages{topic: topic, messages: messages}
+	return nil
+}
+
+func (p *memoryProducer) Close() {
+}
+
+func newMemoryProducer() *memoryProducer {
+	return &memoryProducer{published: make(chan topicMessages, 100)}
+}
+
+func (p *memoryProducer) next(t *testing.T) (string, Message) {
+	select {
+	case tm := <-p.published:
+		if len(tm.messages) != 1 {
+			t.Fatalf("Expected one message, got %d", len(tm.messages))
+		}
+		return tm.topic, tm.messages[0]
+	case <-time.After(5 * time.Second):
+		t.Fatal("No message published")
+	}
+	return "", Message{}
+}
+
+func TestEventSink_Topology(t *testing.T) {
+	producer := newMemoryProducer()
+	s, err := NewEventSink(producer, "json", "topology", "flows", 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.Start()
+	defer s.Stop()
+
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	g := graph.NewGraphFromConfig(b)
+	g.AddEventListener(s)
+
+	g.Lock()
+	n := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0"})
+	g.Unlock()
+
+	topic, m := producer.next(t)
+	if topic != "topology" || m.Key != string(n.ID) {
+		t.Fatalf("Wrong message: %s %+v", topic, m)
+	}
+
+	var event struct {
+		Type string
+		Obj  struct {
+			ID       string
+			Metadata map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(m.Value, &event); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if event.Type != graph.NodeAddedMsgType || event.Obj.ID != string(n.ID) || event.Obj.Metadata["Name"] != "eth0" {
+		t.Errorf("Wrong event: %+v", event)
+	}
+}
+
+func TestEventSink_Flows(t *testing.T) {
+	producer := newMemoryProducer()
+	s, err := NewEventSink(producer, "protobuf", "topology", "flows", 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.Start()
+	defer s.Stop()
+
+	s.PublishFlows([]*flow.Flow{{UUID: "flow1", Expired: true}})
+
+	topic, m := producer.next(t)
+	if topic != "flows" || m.Key != "flow1" {
+		t.Fatalf("Wrong message: %s %+v", topic, m)
+	}
+
+	var f flow.Flow
+	if err := proto.Unmarshal(m.Value, &f); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if f.UUID != "flow1" || !f.Expired {
+		t.Errorf("Wrong flow: %+v", f)
+	}
+}
+
+func TestKafkaRESTProducer(t *testing.T) {
+	var records kafkaRecords
+	var path string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&records)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	producer := NewKafkaRESTProducer(server.URL, time.Second)
+	if err := producer.Publish("flows", []Message{{Key: "flow1", Value: []byte("data")}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if path != "/topics/flows" || len(records.Records) != 1 {
+		t.Fatalf("Wrong request: %s %+v", path, records)
+	}
+
+	value, _ := base64.StdEncoding.DecodeString(records.Records[0].Value)
+	if string(value) != "data" {
+		t.Errorf("Wrong value: %s", string(value))
+	}
+}
+
+func TestNATSProducer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+
+	// fake NATS server recording the protocol lines sent by the client
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte(`INFO {"server_id":"test","max_payload":1048576}` + "\r\n"))
+
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			line = strings.TrimSpace(line)
+			lines <- line
+
+			// keep alive request once a message has been published
+			if line == "data" {
+				conn.Write([]byte("PING\r\n"))
+			}
+		}
+	}()
+
+	producer := NewNATSProducer(listener.Addr().String(), time.Second)
+	defer producer.Close()
+
+	if err := producer.Publish("flows", []Message{{Key: "flow1", Value: []byte("data")}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	next := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(5 * time.Second):
+			t.Fatal("No line received by the server")
+		}
+		return ""
+	}
+
+	if line := next(); !strings.HasPrefix(line, "CONNECT {") {
+		t.Errorf("Expected CONNECT, got: %s", line)
+	}
+	if line := next(); line != "PUB flows 4" {
+		t.Errorf("Expected PUB, got: %s", line)
+	}
+	if line := next(); line != "data" {
+		t.Errorf("Expected payload, got: %s", line)
+	}
+	if line := next(); line != "PONG" {
+		t.Errorf("Expected PONG, got: %s", line)
+	}
+}
@@ -41,11 +41,58 @@ import (
 
 const indexVersion = 3
 
+const scrollSize = 1000
+
 const (
 	AscendingOrder = iota
 	DescendingOrder
 )
 
+// Hit is a document returned by a scroll search or a multi get
+type Hit struct {
+	ID      string           `json:"_id"`
+	Type    string           `json:"_type"`
+	Parent  string           `json:"_parent,omitempty"`
+	Version int64            `json:"_version,omitempty"`
+	Found   bool             `json:"found,omitempty"`
+	Source  *json.RawMessage `json:"_source,omitempty"`
+}
+
+// BulkDocument is a document indexed by a bulk request. The document is
+// created if Version is 0, otherwise it is only replaced if it is still at
+// this version.
+type BulkDocument struct {
+	Hit
+	Data interface{}
+}
+
+type bulkAction struct {
+	ID      string `json:"_id"`
+	Type    string `json:"_type"`
+	Parent  string `json:"_parent,omitempty"`
+	Version int64  `json:"_version,omitempty"`
+}
+
+type bulkResult struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error,omitempty"`
+	} `json:"items"`
+}
+
+type multiGetResult struct {
+	Docs []Hit `json:"docs"`
+}
+
+type scrollResult struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []Hit `json:"hits"`
+	} `json:"hits"`
+}
+
 type ElasticSearchClient struct {
 	connection *elastigo.Conn
 	indexer    *elastigo.BulkIndexer
@@ -290,6 +337,248 @@ func (c *ElasticSearchClient) Search(obj string, query string) (elastigo.SearchR
 	return c.connection.Search("skydive", obj, nil, query)
 }
 
+func (c *ElasticSearchClient) scrollRequest(method string, path string, query string, body interface{}) (*scrollResult, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	code, data, err := c.request(method, path, query, string(b))
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("Scroll request failed with status %d: %s", code, string(data))
+	}
+
+	var result scrollResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Scroll iterates over all the documents of the given types matching the
+// query sorted by the given field, the callback is called for each page of
+// documents.
+func (c *ElasticSearchClient) Scroll(obj string, query map[string]interface{}, sort string, source bool, callback func(hits []Hit) error) error {
+	request := map[string]interface{}{
+		"query":   query,
+		"size":    scrollSize,
+		"sort":    []string{sort},
+		"_source": source,
+	}
+
+	result, err := c.scrollRequest("POST", "/skydive/"+obj+"/_search", "scroll=1m", request)
+	if err != nil {
+		return err
+	}
+
+	// release the scroll context once done
+	defer func() {
+		if result != nil && result.ScrollID != "" {
+			c.request("DELETE", "/_search/scroll", "", `{"scroll_id": ["`+result.ScrollID+`"]}`)
+		}
+	}()
+
+	for len(result.Hits.Hits) > 0 {
+		if err := callback(result.Hits.Hits); err != nil {
+			return err
+		}
+
+		request := map[string]interface{}{
+			"scroll":    "1m",
+			"scroll_id": result.ScrollID,
+		}
+		next, err := c.scrollRequest("POST", "/_search/scroll", "", request)
+		if err != nil {
+			return err
+		}
+		result = next
+	}
+
+	return nil
+}
+
+// checkBulkResult returns an error if any operation of a bulk request failed
+func checkBulkResult(data []byte) error {
+	var result bulkResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+
+	if !result.Errors {
+		return nil
+	}
+
+	var failed int
+	var first string
+	for _, item := range result.Items {
+		for op, status := range item {
+			if len(status.Error) == 0 {
+				continue
+			}
+			if failed == 0 {
+				first = fmt.Sprintf("%s of %s failed with status %d: %s", op, status.ID, status.Status, string(status.Error))
+			}
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d bulk operations failed, %s", failed, len(result.Items), first)
+}
+
+func (c *ElasticSearchClient) bulk(body []byte) error {
+	code, data, err := c.request("POST", "/skydive/_bulk", "", string(body))
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Bulk request failed with status %d: %s", code, string(data))
+	}
+
+	return checkBulkResult(data)
+}
+
+func appendBulkLine(body []byte, line interface{}) ([]byte, error) {
+	b, err := json.Marshal(line)
+	if err != nil {
+		return nil, err
+	}
+	body = append(body, b...)
+	return append(body, '\n'), nil
+}
+
+// BulkDelete deletes the given documents, the parent of the child documents
+// has to be set for the routing.
+func (c *ElasticSearchClient) BulkDelete(hits []Hit) error {
+	for len(hits) > 0 {
+		n := len(hits)
+		if n > scrollSize {
+			n = scrollSize
+		}
+
+		var body []byte
+		var err error
+		for _, hit := range hits[:n] {
+			action := map[string]interface{}{
+				"delete": bulkAction{ID: hit.ID, Type: hit.Type, Parent: hit.Parent},
+			}
+			if body, err = appendBulkLine(body, action); err != nil {
+				return err
+			}
+		}
+
+		if err := c.bulk(body); err != nil {
+			return err
+		}
+
+		hits = hits[n:]
+	}
+
+	return nil
+}
+
+// BulkIndex indexes the given documents, the parent of the child documents
+// has to be set for the routing. A document is created if its version is 0,
+// otherwise the request fails if the document changed since it was read.
+func (c *ElasticSearchClient) BulkIndex(docs []BulkDocument) error {
+	for len(docs) > 0 {
+		n := len(docs)
+		if n > scrollSize {
+			n = scrollSize
+		}
+
+		var body []byte
+		var err error
+		for _, doc := range docs[:n] {
+			op := "index"
+			if doc.Version == 0 {
+				op = "create"
+			}
+			action := map[string]interface{}{
+				op: bulkAction{ID: doc.ID, Type: doc.Type, Parent: doc.Parent, Version: doc.Version},
+			}
+			if body, err = appendBulkLine(body, action); err != nil {
+				return err
+			}
+			if body, err = appendBulkLine(body, doc.Data); err != nil {
+				return err
+			}
+		}
+
+		if err := c.bulk(body); err != nil {
+			return err
+		}
+
+		docs = docs[n:]
+	}
+
+	return nil
+}
+
+// MultiGet returns the given documents of a type in the same order, the
+// parent of the child documents has to be set for the routing. Found is
+// false for the documents that don't exist.
+func (c *ElasticSearchClient) MultiGet(obj string, hits []Hit) ([]Hit, error) {
+	if len(hits) == 0 {
+		return nil, nil
+	}
+
+	docs := make([]map[string]string, len(hits))
+	for i, hit := range hits {
+		docs[i] = map[string]string{"_id": hit.ID}
+		if hit.Parent != "" {
+			docs[i]["_routing"] = hit.Parent
+		}
+	}
+
+	b, err := json.Marshal(map[string]interface{}{"docs": docs})
+	if err != nil {
+		return nil, err
+	}
+
+	code, data, err := c.request("POST", "/skydive/"+obj+"/_mget", "", string(b))
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("Multi get failed with status %d: %s", code, string(data))
+	}
+
+	var result multiGetResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Docs) != len(hits) {
+		return nil, fmt.Errorf("Multi get returned %d documents instead of %d", len(result.Docs), len(hits))
+	}
+
+	return result.Docs, nil
+}
+
+// SearchRaw runs the search request and returns the raw response, useful
+// to get the aggregations
+func (c *ElasticSearchClient) SearchRaw(obj string, request map[string]interface{}) ([]byte, error) {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	code, data, err := c.request("POST", "/skydive/"+obj+"/_search", "", string(b))
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("Search request failed with status %d: %s", code, string(data))
+	}
+
+	return data, nil
+}
+
 func (c *ElasticSearchClient) Start(mappings []map[string][]byte) {
 	for {
 		err := c.start(mappings)
@@ -0,0 +1,43 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"testing"
+)
+
+func TestCheckBulkResult(t *testing.T) {
+	ok := `{"took":3,"errors":false,"items":[{"delete":{"_id":"1","status":200,"found":true}},{"delete":{"_id":"2","status":404,"found":false}}]}`
+	if err := checkBulkResult([]byte(ok)); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+
+	failed := `{"took":3,"errors":true,"items":[{"delete":{"_id":"1","status":200}},{"index":{"_id":"2","status":409,"error":{"type":"version_conflict_engine_exception"}}}]}`
+	err := checkBulkResult([]byte(failed))
+	if err == nil {
+		t.Fatal("Expected an error for the failed item")
+	}
+	if expected := `1 of 2 bulk operations failed, index of 2 failed with status 409: {"type":"version_conflict_engine_exception"}`; err.Error() != expected {
+		t.Errorf("Wrong error: %s", err.Error())
+	}
+}
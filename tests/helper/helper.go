@@ -49,6 +49,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
 	"github.com/gorilla/websocket"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
@@ -370,6 +372,38 @@ func SendPCAPFile(filename string, socket string) error {
 	return nil
 }
 
+func ReplayPCAPFile(filename string, ifName string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to open file %s: %s", filename, err.Error())
+	}
+	defer file.Close()
+
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read pcap file %s: %s", filename, err.Error())
+	}
+
+	handle, err := pcap.OpenLive(ifName, 65535, false, time.Second)
+	if err != nil {
+		return fmt.Errorf("Failed to open device %s: %s", ifName, err.Error())
+	}
+	defer handle.Close()
+
+	for {
+		data, _, err := reader.ReadPacketData()
+		if err != nil {
+			break
+		}
+
+		if err = handle.WritePacketData(data); err != nil {
+			return fmt.Errorf("Failed to write packet on %s: %s", ifName, err.Error())
+		}
+	}
+
+	return nil
+}
+
 func FlowsToString(flows []*flow.Flow) string {
 	s := fmt.Sprintf("%d flows:\n", len(flows))
 	b, _ := json.MarshalIndent(flows, "", "\t")
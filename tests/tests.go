@@ -63,8 +63,12 @@ agent:
       - netns
       - ovsdb
       - docker
+      - lldp
     netlink:
       metrics_update: 15
+    lldp:
+      interfaces:
+        - lldp-vm1
 
   flow:
     probes:
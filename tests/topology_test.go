@@ -610,3 +610,47 @@ func TestInterfaceMetrics(t *testing.T) {
 
 	RunTest(t, test)
 }
+
+func TestLLDP(t *testing.T) {
+	test := &Test{
+		setupCmds: []helper.Cmd{
+			{"ip link add lldp-vm1 type veth peer name lldp-vm1-eth0", true},
+			{"ip link set lldp-vm1 up", true},
+			{"ip link set lldp-vm1-eth0 up", true},
+		},
+
+		tearDownCmds: []helper.Cmd{
+			{"ip link del lldp-vm1", true},
+		},
+
+		check: func(c *TestContext) error {
+			gh := c.gh
+			gremlin := "g"
+			if !c.time.IsZero() {
+				gremlin += fmt.Sprintf(".Context(%d)", common.UnixMillis(c.time))
+			} else {
+				// replay the switch announcements until the probe listens
+				if err := helper.ReplayPCAPFile("pcaptraces/lldp-cdp.pcap", "lldp-vm1-eth0"); err != nil {
+					return err
+				}
+			}
+
+			gremlin += `.V().Has("Name", "lldp-vm1", "Type", "veth", "CDP/DeviceID", "tor2")`
+			gremlin += `.In("Type", "switchport", "Name", "Ethernet1/12")`
+			gremlin += `.In("Type", "switch", "Name", "tor1", "ChassisID", "00:11:22:33:44:00")`
+
+			nodes, err := gh.GetNodes(gremlin)
+			if err != nil {
+				return err
+			}
+
+			if len(nodes) != 1 {
+				return fmt.Errorf("Expected 1 node, got %+v", nodes)
+			}
+
+			return nil
+		},
+	}
+
+	RunTest(t, test)
+}
@@ -131,3 +131,40 @@ func (im *InterfaceMetric) Add(m common.Metric) common.Metric {
 
 	return im
 }
+
+// TrafficControlMetric is the metric of a qdisc or a traffic control class
+type TrafficControlMetric struct {
+	Bytes      int64
+	Packets    int64
+	Drops      int64
+	Overlimits int64
+	Requeues   int64
+}
+
+func (tm *TrafficControlMetric) GetField(field string) (int64, error) {
+	switch field {
+	case "Bytes":
+		return tm.Bytes, nil
+	case "Packets":
+		return tm.Packets, nil
+	case "Drops":
+		return tm.Drops, nil
+	case "Overlimits":
+		return tm.Overlimits, nil
+	case "Requeues":
+		return tm.Requeues, nil
+	}
+	return 0, common.ErrFieldNotFound
+}
+
+func (tm *TrafficControlMetric) Add(m common.Metric) common.Metric {
+	tm2 := m.(*TrafficControlMetric)
+
+	tm.Bytes += tm2.Bytes
+	tm.Packets += tm2.Packets
+	tm.Drops += tm2.Drops
+	tm.Overlimits += tm2.Overlimits
+	tm.Requeues += tm2.Requeues
+
+	return tm
+}
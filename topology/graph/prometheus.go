@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+var interfaceMetricFields = []string{
+	"RxPackets", "TxPackets", "RxBytes", "TxBytes", "RxErrors", "TxErrors",
+	"RxDropped", "TxDropped", "Multicast", "Collisions", "RxLengthErrors",
+	"RxOverErrors", "RxCrcErrors", "RxFrameErrors", "RxFifoErrors",
+	"RxMissedErrors", "TxAbortedErrors", "TxCarrierErrors", "TxFifoErrors",
+	"TxHeartbeatErrors", "TxWindowErrors", "RxCompressed", "TxCompressed",
+}
+
+// interfaceMetricLabels are the labels of the interface metrics, the first
+// two are the node host and ID, the others are taken from the node metadata
+var interfaceMetricLabels = []string{"host", "id", "name", "type", "tid"}
+
+// PrometheusCollector exports the node and edge counts per host as well as
+// the interface statistics of the nodes of a graph
+type PrometheusCollector struct {
+	graph            *Graph
+	nodesDesc        *prometheus.Desc
+	edgesDesc        *prometheus.Desc
+	interfaceMetrics map[string]*prometheus.Desc
+}
+
+// metricName converts a field name like RxCrcErrors to rx_crc_errors
+func metricName(field string) string {
+	var name []rune
+	for i, r := range field {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				name = append(name, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		name = append(name, r)
+	}
+	return string(name)
+}
+
+func (c *PrometheusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nodesDesc
+	ch <- c.edgesDesc
+	for _, desc := range c.interfaceMetrics {
+		ch <- desc
+	}
+}
+
+func (c *PrometheusCollector) collectInterfaceMetrics(ch chan<- prometheus.Metric, n *Node) {
+	m := n.Metadata()
+	if _, ok := m["Statistics/RxPackets"]; !ok {
+		return
+	}
+
+	labels := []string{n.Host(), string(n.ID)}
+	for _, key := range []string{"Name", "Type", "TID"} {
+		value, _ := m[key].(string)
+		labels = append(labels, value)
+	}
+
+	for field, desc := range c.interfaceMetrics {
+		value, err := common.ToInt64(m["Statistics/"+field])
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), labels...)
+	}
+}
+
+func (c *PrometheusCollector) Collect(ch chan<- prometheus.Metric) {
+	c.graph.RLock()
+	defer c.graph.RUnlock()
+
+	nodes := make(map[string]int)
+	for _, n := range c.graph.GetNodes(Metadata{}) {
+		nodes[n.Host()]++
+		c.collectInterfaceMetrics(ch, n)
+	}
+
+	edges := make(map[string]int)
+	for _, e := range c.graph.GetEdges(Metadata{}) {
+		edges[e.Host()]++
+	}
+
+	for host, count := range nodes {
+		ch <- prometheus.MustNewConstMetric(c.nodesDesc, prometheus.GaugeValue, float64(count), host)
+	}
+	for host, count := range edges {
+		ch <- prometheus.MustNewConstMetric(c.edgesDesc, prometheus.GaugeValue, float64(count), host)
+	}
+}
+
+// NewPrometheusCollector returns a collector for the given graph, it has to
+// be registered with prometheus.Register. The metrics are labelled with the
+// service owning the graph so that the agent and the analyzer graphs can be
+// registered by the same process.
+func NewPrometheusCollector(g *Graph, service common.ServiceType) *PrometheusCollector {
+	labels := prometheus.Labels{"service": string(service)}
+
+	c := &PrometheusCollector{
+		graph: g,
+		nodesDesc: prometheus.NewDesc("skydive_graph_nodes",
+			"Number of nodes in the graph by host", []string{"host"}, labels),
+		edgesDesc: prometheus.NewDesc("skydive_graph_edges",
+			"Number of edges in the graph by host", []string{"host"}, labels),
+		interfaceMetrics: make(map[string]*prometheus.Desc),
+	}
+
+	for _, field := range interfaceMetricFields {
+		name := "skydive_interface_" + metricName(field) + "_total"
+		help := "Interface statistic " + field + " as reported by the probes"
+		c.interfaceMetrics[field] = prometheus.NewDesc(name, help, interfaceMetricLabels, labels)
+	}
+
+	return c
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+func TestPrometheusCollector(t *testing.T) {
+	g := newGraph(t)
+
+	g.Lock()
+	n1 := g.NewNode(GenID(), Metadata{"Name": "eth0", "Type": "device", "TID": "123", "Statistics/RxPackets": uint64(42)}, "host1")
+	n2 := g.NewNode(GenID(), Metadata{"Name": "ns1", "Type": "netns"}, "host1")
+	g.NewNode(GenID(), Metadata{"Name": "ns2", "Type": "netns"}, "host2")
+	g.Link(n1, n2, Metadata{})
+	g.Unlock()
+
+	ch := make(chan prometheus.Metric, 100)
+	NewPrometheusCollector(g, common.AgentService).Collect(ch)
+	close(ch)
+
+	nodes := make(map[string]float64)
+	var edges, rxPackets float64
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		desc := metric.Desc().String()
+		switch {
+		case strings.Contains(desc, `"skydive_graph_nodes"`):
+			nodes[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		case strings.Contains(desc, `"skydive_graph_edges"`):
+			edges += m.GetGauge().GetValue()
+		case strings.Contains(desc, `"skydive_interface_rx_packets_total"`):
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "name" && label.GetValue() != "eth0" {
+					t.Errorf("Wrong name label: %s", label.GetValue())
+				}
+			}
+			rxPackets = m.GetCounter().GetValue()
+		}
+	}
+
+	if nodes["host1"] != 2 || nodes["host2"] != 1 {
+		t.Errorf("Wrong node counts: %v", nodes)
+	}
+
+	if edges != 1 {
+		t.Errorf("Expected 1 edge, got %f", edges)
+	}
+
+	if rxPackets != 42 {
+		t.Errorf("Expected 42 rx packets, got %f", rxPackets)
+	}
+}
+
+func TestPrometheusCollectorServices(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	// an all-in-one process registers both the agent and the analyzer graphs
+	if err := registry.Register(NewPrometheusCollector(newGraph(t), common.AgentService)); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(NewPrometheusCollector(newGraph(t), common.AnalyzerService)); err != nil {
+		t.Fatalf("Collectors of different services should be registered: %s", err.Error())
+	}
+}
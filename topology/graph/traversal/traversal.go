@@ -941,36 +941,48 @@ nodeloop:
 		start, hasStart := m["LastMetric/Start"]
 		last, hasLast := m["LastMetric/Last"]
 		if hasStart && hasLast && (gslice == nil || (start.(int64) > gslice.Start && last.(int64) < gslice.Last)) {
-			im := &graph.InterfaceMetric{
-				RxPackets:         m["LastMetric/RxPackets"].(int64),
-				TxPackets:         m["LastMetric/TxPackets"].(int64),
-				RxBytes:           m["LastMetric/RxBytes"].(int64),
-				TxBytes:           m["LastMetric/TxBytes"].(int64),
-				RxErrors:          m["LastMetric/RxErrors"].(int64),
-				TxErrors:          m["LastMetric/TxErrors"].(int64),
-				RxDropped:         m["LastMetric/RxDropped"].(int64),
-				TxDropped:         m["LastMetric/TxDropped"].(int64),
-				Multicast:         m["LastMetric/Multicast"].(int64),
-				Collisions:        m["LastMetric/Collisions"].(int64),
-				RxLengthErrors:    m["LastMetric/RxLengthErrors"].(int64),
-				RxOverErrors:      m["LastMetric/RxOverErrors"].(int64),
-				RxCrcErrors:       m["LastMetric/RxCrcErrors"].(int64),
-				RxFrameErrors:     m["LastMetric/RxFrameErrors"].(int64),
-				RxFifoErrors:      m["LastMetric/RxFifoErrors"].(int64),
-				RxMissedErrors:    m["LastMetric/RxMissedErrors"].(int64),
-				TxAbortedErrors:   m["LastMetric/TxAbortedErrors"].(int64),
-				TxCarrierErrors:   m["LastMetric/TxCarrierErrors"].(int64),
-				TxFifoErrors:      m["LastMetric/TxFifoErrors"].(int64),
-				TxHeartbeatErrors: m["LastMetric/TxHeartbeatErrors"].(int64),
-				TxWindowErrors:    m["LastMetric/TxWindowErrors"].(int64),
-				RxCompressed:      m["LastMetric/RxCompressed"].(int64),
-				TxCompressed:      m["LastMetric/TxCompressed"].(int64),
+			var metric common.Metric
+			switch tp, _ := n.GetFieldString("Type"); tp {
+			case "qdisc", "tcclass":
+				metric = &graph.TrafficControlMetric{
+					Bytes:      m["LastMetric/Bytes"].(int64),
+					Packets:    m["LastMetric/Packets"].(int64),
+					Drops:      m["LastMetric/Drops"].(int64),
+					Overlimits: m["LastMetric/Overlimits"].(int64),
+					Requeues:   m["LastMetric/Requeues"].(int64),
+				}
+			default:
+				metric = &graph.InterfaceMetric{
+					RxPackets:         m["LastMetric/RxPackets"].(int64),
+					TxPackets:         m["LastMetric/TxPackets"].(int64),
+					RxBytes:           m["LastMetric/RxBytes"].(int64),
+					TxBytes:           m["LastMetric/TxBytes"].(int64),
+					RxErrors:          m["LastMetric/RxErrors"].(int64),
+					TxErrors:          m["LastMetric/TxErrors"].(int64),
+					RxDropped:         m["LastMetric/RxDropped"].(int64),
+					TxDropped:         m["LastMetric/TxDropped"].(int64),
+					Multicast:         m["LastMetric/Multicast"].(int64),
+					Collisions:        m["LastMetric/Collisions"].(int64),
+					RxLengthErrors:    m["LastMetric/RxLengthErrors"].(int64),
+					RxOverErrors:      m["LastMetric/RxOverErrors"].(int64),
+					RxCrcErrors:       m["LastMetric/RxCrcErrors"].(int64),
+					RxFrameErrors:     m["LastMetric/RxFrameErrors"].(int64),
+					RxFifoErrors:      m["LastMetric/RxFifoErrors"].(int64),
+					RxMissedErrors:    m["LastMetric/RxMissedErrors"].(int64),
+					TxAbortedErrors:   m["LastMetric/TxAbortedErrors"].(int64),
+					TxCarrierErrors:   m["LastMetric/TxCarrierErrors"].(int64),
+					TxFifoErrors:      m["LastMetric/TxFifoErrors"].(int64),
+					TxHeartbeatErrors: m["LastMetric/TxHeartbeatErrors"].(int64),
+					TxWindowErrors:    m["LastMetric/TxWindowErrors"].(int64),
+					RxCompressed:      m["LastMetric/RxCompressed"].(int64),
+					TxCompressed:      m["LastMetric/TxCompressed"].(int64),
+				}
 			}
-			metric := &common.TimedMetric{
+			timedMetric := &common.TimedMetric{
 				TimeSlice: *common.NewTimeSlice(start.(int64), last.(int64)),
-				Metric:    im,
+				Metric:    metric,
 			}
-			metrics[string(n.ID)] = append(metrics[string(n.ID)], metric)
+			metrics[string(n.ID)] = append(metrics[string(n.ID)], timedMetric)
 		}
 	}
 
@@ -323,6 +323,31 @@ func TestTraversalCount(t *testing.T) {
 	}
 }
 
+func TestTraversalTrafficControlMetrics(t *testing.T) {
+	g := newGraph(t)
+
+	for i, drops := range []int64{3, 7} {
+		g.NewNode(graph.GenID(), graph.Metadata{
+			"Type":                  "qdisc",
+			"Name":                  "netem 1:",
+			"LastMetric/Bytes":      int64(1000 * (i + 1)),
+			"LastMetric/Packets":    int64(10 * (i + 1)),
+			"LastMetric/Drops":      drops,
+			"LastMetric/Overlimits": int64(0),
+			"LastMetric/Requeues":   int64(0),
+			"LastMetric/Start":      int64(1000),
+			"LastMetric/Last":       int64(2000),
+		})
+	}
+
+	tr := NewGraphTraversal(g, false)
+
+	tv := tr.V().Has("Type", "qdisc").Metrics().Sum("Drops")
+	if tv.Values()[0] != int64(10) {
+		t.Fatalf("Should return 10 drops, returned: %v", tv.Values())
+	}
+}
+
 func TestTraversalShortestPathTo(t *testing.T) {
 	g := newTransversalGraph(t)
 
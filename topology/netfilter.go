@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package topology
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// FiveTuple identifies the traffic matched against the netfilter rules
+type FiveTuple struct {
+	Protocol        string
+	Source          net.IP
+	Destination     net.IP
+	SourcePort      int64
+	DestinationPort int64
+}
+
+var protocolNumbers = map[string]string{
+	"1":   "icmp",
+	"6":   "tcp",
+	"17":  "udp",
+	"58":  "ipv6-icmp",
+	"132": "sctp",
+}
+
+// negated returns the value of a rule field without its negation mark
+func negated(value string) (string, bool) {
+	if strings.HasPrefix(value, "!") {
+		return strings.TrimSpace(value[1:]), true
+	}
+	return value, false
+}
+
+// matchProtocol returns whether a protocol is matched by a rule, the nft
+// sets of protocols being separated by commas like the addresses and ports
+func matchProtocol(value string, protocol string) bool {
+	value, invert := negated(strings.ToLower(value))
+	for _, p := range strings.Split(strings.Trim(value, "{} "), ",") {
+		p = strings.TrimSpace(p)
+		if name, ok := protocolNumbers[p]; ok {
+			p = name
+		}
+
+		switch p {
+		case "", "all", "0":
+			return !invert
+		case "icmpv6":
+			p = "ipv6-icmp"
+		}
+
+		if p == protocol {
+			return !invert
+		}
+	}
+	return invert
+}
+
+func matchAddress(value string, ip net.IP) bool {
+	value, invert := negated(value)
+	for _, addr := range strings.Split(value, ",") {
+		if !strings.Contains(addr, "/") {
+			if a := net.ParseIP(addr); a != nil && a.Equal(ip) {
+				return !invert
+			}
+			continue
+		}
+
+		if prefixContains(addr, ip) {
+			return !invert
+		}
+	}
+	return invert
+}
+
+func matchPort(value string, port int64) bool {
+	value, invert := negated(value)
+	if port == 0 {
+		return false
+	}
+
+	for _, r := range strings.Split(value, ",") {
+		bounds := strings.SplitN(r, ":", 2)
+		min, err := strconv.ParseInt(bounds[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		max := min
+		if len(bounds) == 2 {
+			if max, err = strconv.ParseInt(bounds[1], 10, 64); err != nil {
+				continue
+			}
+		}
+
+		if port >= min && port <= max {
+			return !invert
+		}
+	}
+	return invert
+}
+
+// MatchNetfilterRule returns whether the traffic can be matched by the rule.
+// Only the protocol, addresses and ports of the rule are taken into account.
+func MatchNetfilterRule(rule *graph.Node, t FiveTuple) bool {
+	if family, _ := rule.GetFieldString("Family"); family != "" && family != ipFamily(t.Source) {
+		return false
+	}
+
+	if protocol, _ := rule.GetFieldString("Protocol"); protocol != "" && !matchProtocol(protocol, t.Protocol) {
+		return false
+	}
+
+	if source, _ := rule.GetFieldString("Source"); source != "" && !matchAddress(source, t.Source) {
+		return false
+	}
+
+	if destination, _ := rule.GetFieldString("Destination"); destination != "" && !matchAddress(destination, t.Destination) {
+		return false
+	}
+
+	if port, _ := rule.GetFieldString("SourcePort"); port != "" && !matchPort(port, t.SourcePort) {
+		return false
+	}
+
+	if port, _ := rule.GetFieldString("DestinationPort"); port != "" && !matchPort(port, t.DestinationPort) {
+		return false
+	}
+
+	return true
+}
+
+// LookupNetfilterRules returns the netfilter rules, of the namespace of the
+// given node, matching the traffic and the metadata filter
+func LookupNetfilterRules(g *graph.Graph, n *graph.Node, m graph.Metadata, t FiveTuple) (rules []*graph.Node) {
+	ns := routingNamespace(g, n)
+	if ns == nil {
+		return nil
+	}
+
+	filter := graph.Metadata{}
+	for k, v := range m {
+		filter[k] = v
+	}
+	filter["Type"] = "netfilterrule"
+
+	for _, rule := range g.LookupChildren(ns, filter, ownershipMetadata) {
+		if MatchNetfilterRule(rule, t) {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package topology
+
+import (
+	"net"
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestLookupNetfilterRules(t *testing.T) {
+	g := newGraph(t)
+
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "localhost"})
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth0"})
+	g.Link(host, eth0, ownershipMetadata)
+
+	rules := []graph.Metadata{
+		{"Name": "ip filter INPUT policy", "Family": "IPV4", "Policy": true, "Target": "ACCEPT"},
+		{"Name": "ip filter INPUT 1", "Family": "IPV4", "Target": "DROP", "Protocol": "tcp", "Source": "10.0.0.0/8", "DestinationPort": "22"},
+		{"Name": "ip filter INPUT 2", "Family": "IPV4", "Target": "ACCEPT", "Protocol": "6", "DestinationPort": "8000:8080,443"},
+		{"Name": "ip filter INPUT 3", "Family": "IPV4", "Target": "DROP", "Protocol": "!udp", "Destination": "!192.168.0.1"},
+		{"Name": "ip6 filter INPUT 1", "Family": "IPV6", "Target": "DROP"},
+		{"Name": "inet firewall input 1", "Target": "REJECT", "Protocol": "tcp", "SourcePort": "22"},
+		{"Name": "inet firewall input 2", "Target": "ACCEPT", "Protocol": "udp,tcp", "DestinationPort": "53"},
+		{"Name": "inet firewall input 3", "Target": "DROP", "Protocol": "!udp,tcp", "DestinationPort": "53"},
+	}
+	for _, m := range rules {
+		m["Type"] = "netfilterrule"
+		g.Link(host, g.NewNode(graph.GenID(), m), ownershipMetadata)
+	}
+
+	names := func(nodes []*graph.Node) map[string]bool {
+		m := make(map[string]bool)
+		for _, node := range nodes {
+			name, _ := node.GetFieldString("Name")
+			m[name] = true
+		}
+		return m
+	}
+
+	ssh := FiveTuple{Protocol: "tcp", Source: net.ParseIP("10.1.2.3"), Destination: net.ParseIP("192.168.0.1"), SourcePort: 40000, DestinationPort: 22}
+	matched := names(LookupNetfilterRules(g, eth0, graph.Metadata{}, ssh))
+	if len(matched) != 2 || !matched["ip filter INPUT policy"] || !matched["ip filter INPUT 1"] {
+		t.Errorf("Wrong rules matched: %v", matched)
+	}
+
+	matched = names(LookupNetfilterRules(g, eth0, graph.Metadata{"Target": "DROP"}, ssh))
+	if len(matched) != 1 || !matched["ip filter INPUT 1"] {
+		t.Errorf("Wrong DROP rules matched: %v", matched)
+	}
+
+	dns := FiveTuple{Protocol: "tcp", Source: net.ParseIP("172.16.0.1"), Destination: net.ParseIP("192.168.0.2"), SourcePort: 40000, DestinationPort: 53}
+	matched = names(LookupNetfilterRules(g, eth0, graph.Metadata{"Target": "ACCEPT"}, dns))
+	if len(matched) != 2 || !matched["inet firewall input 2"] || !matched["ip filter INPUT policy"] {
+		t.Errorf("Rules with a set of protocols should match, got %v", matched)
+	}
+
+	matched = names(LookupNetfilterRules(g, eth0, graph.Metadata{"Target": "DROP"}, dns))
+	if matched["inet firewall input 3"] {
+		t.Errorf("Rules excluding a set of protocols should not match, got %v", matched)
+	}
+
+	https := FiveTuple{Protocol: "tcp", Source: net.ParseIP("172.16.0.1"), Destination: net.ParseIP("192.168.0.2"), SourcePort: 22, DestinationPort: 443}
+	matched = names(LookupNetfilterRules(g, eth0, graph.Metadata{}, https))
+	if len(matched) != 4 || !matched["ip filter INPUT 2"] || !matched["ip filter INPUT 3"] || !matched["inet firewall input 1"] {
+		t.Errorf("Wrong rules matched: %v", matched)
+	}
+
+	icmp6 := FiveTuple{Protocol: "ipv6-icmp", Source: net.ParseIP("fe80::1"), Destination: net.ParseIP("fe80::2")}
+	matched = names(LookupNetfilterRules(g, eth0, graph.Metadata{}, icmp6))
+	if len(matched) != 1 || !matched["ip6 filter INPUT 1"] {
+		t.Errorf("Wrong rules matched: %v", matched)
+	}
+}
@@ -0,0 +1,286 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package topology
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// OpenFlowPacket describes the traffic matched against the OpenFlow rules,
+// the zero value of a field meaning that it is unknown
+type OpenFlowPacket struct {
+	FiveTuple
+	InPort    int64
+	EtherType string
+	EthSrc    string
+	EthDst    string
+}
+
+// protocols implied by the ovs-ofctl shorthand match fields
+var openFlowShorthands = map[string]struct {
+	etherType string
+	protocol  string
+}{
+	"ip":    {"ip", ""},
+	"ipv6":  {"ipv6", ""},
+	"arp":   {"arp", ""},
+	"tcp":   {"ip", "tcp"},
+	"tcp6":  {"ipv6", "tcp"},
+	"udp":   {"ip", "udp"},
+	"udp6":  {"ipv6", "udp"},
+	"sctp":  {"ip", "sctp"},
+	"sctp6": {"ipv6", "sctp"},
+	"icmp":  {"ip", "icmp"},
+	"icmp6": {"ipv6", "ipv6-icmp"},
+}
+
+var openFlowEtherTypes = map[uint64]string{
+	0x0800: "ip",
+	0x86dd: "ipv6",
+	0x0806: "arp",
+}
+
+// openFlowUint parses an unsigned match value and its optional mask
+func openFlowUint(value string) (v uint64, mask uint64, ok bool) {
+	mask = ^uint64(0)
+
+	fields := strings.SplitN(value, "/", 2)
+	v, err := strconv.ParseUint(fields[0], 0, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if len(fields) == 2 {
+		if mask, err = strconv.ParseUint(fields[1], 0, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return v, mask, true
+}
+
+func matchOpenFlowMAC(value string, mac string) bool {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return true
+	}
+
+	fields := strings.SplitN(value, "/", 2)
+	expected, err := net.ParseMAC(fields[0])
+	if err != nil || len(expected) != len(hw) {
+		return true
+	}
+
+	mask := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if len(fields) == 2 {
+		if mask, err = net.ParseMAC(fields[1]); err != nil || len(mask) != len(hw) {
+			return true
+		}
+	}
+
+	for i := range hw {
+		if hw[i]&mask[i] != expected[i]&mask[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func matchOpenFlowAddress(value string, ip net.IP) bool {
+	if ip == nil {
+		return true
+	}
+
+	if !strings.Contains(value, "/") {
+		expected := net.ParseIP(value)
+		return expected == nil || expected.Equal(ip)
+	}
+
+	// dotted netmasks are reported for non prefix masks
+	fields := strings.SplitN(value, "/", 2)
+	if mask := net.ParseIP(fields[1]); mask != nil {
+		if ip4 := mask.To4(); ip4 != nil {
+			mask = ip4
+		}
+		expected := net.ParseIP(fields[0])
+		if expected == nil {
+			return true
+		}
+		return expected.Mask(net.IPMask(mask)).Equal(ip.Mask(net.IPMask(mask)))
+	}
+
+	return prefixContains(value, ip)
+}
+
+func matchOpenFlowPort(value string, port int64) bool {
+	if port == 0 {
+		return true
+	}
+
+	expected, mask, ok := openFlowUint(value)
+	if !ok {
+		return true
+	}
+	return uint64(port)&mask == expected&mask
+}
+
+// MatchOpenFlowRule returns whether the traffic can be matched by the rule.
+// Only the input port, the ethernet, network and transport fields are taken
+// into account, the other fields like the registers being ignored.
+func MatchOpenFlowRule(rule *graph.Node, p OpenFlowPacket) bool {
+	match, _ := rule.GetFieldString("Match")
+	if match == "" {
+		return true
+	}
+
+	for _, field := range strings.Split(match, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		key := kv[0]
+
+		if len(kv) == 1 {
+			shorthand, ok := openFlowShorthands[key]
+			if !ok {
+				continue
+			}
+			if p.EtherType != "" && p.EtherType != shorthand.etherType {
+				return false
+			}
+			if shorthand.protocol != "" && p.Protocol != "" && p.Protocol != shorthand.protocol {
+				return false
+			}
+			continue
+		}
+
+		value := kv[1]
+		switch key {
+		case "in_port":
+			if port, err := strconv.ParseInt(value, 10, 64); err == nil && p.InPort != 0 && port != p.InPort {
+				return false
+			}
+		case "dl_type", "eth_type":
+			if v, _, ok := openFlowUint(value); ok && p.EtherType != "" && openFlowEtherTypes[v] != p.EtherType {
+				return false
+			}
+		case "nw_proto", "ip_proto":
+			if v, _, ok := openFlowUint(value); ok && p.Protocol != "" && !matchProtocol(strconv.FormatUint(v, 10), p.Protocol) {
+				return false
+			}
+		case "dl_src", "eth_src":
+			if !matchOpenFlowMAC(value, p.EthSrc) {
+				return false
+			}
+		case "dl_dst", "eth_dst":
+			if !matchOpenFlowMAC(value, p.EthDst) {
+				return false
+			}
+		case "nw_src", "ip_src", "ipv6_src":
+			if !matchOpenFlowAddress(value, p.Source) {
+				return false
+			}
+		case "nw_dst", "ip_dst", "ipv6_dst":
+			if !matchOpenFlowAddress(value, p.Destination) {
+				return false
+			}
+		case "tp_src", "tcp_src", "udp_src", "sctp_src":
+			if !matchOpenFlowPort(value, p.SourcePort) {
+				return false
+			}
+		case "tp_dst", "tcp_dst", "udp_dst", "sctp_dst":
+			if !matchOpenFlowPort(value, p.DestinationPort) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// openFlowRules sorts the rules the way they are evaluated, by table and
+// decreasing priority
+type openFlowRules []*graph.Node
+
+func (r openFlowRules) Len() int {
+	return len(r)
+}
+
+func (r openFlowRules) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r openFlowRules) Less(i, j int) bool {
+	ti, _ := r[i].GetFieldInt64("Table")
+	tj, _ := r[j].GetFieldInt64("Table")
+	if ti != tj {
+		return ti < tj
+	}
+
+	pi, _ := r[i].GetFieldInt64("Priority")
+	pj, _ := r[j].GetFieldInt64("Priority")
+	return pi > pj
+}
+
+// openFlowBridges returns the bridges the node is a part of, along with the
+// OpenFlow port number of the node if known
+func openFlowBridges(g *graph.Graph, n *graph.Node) (bridges []*graph.Node, ofport int64) {
+	if tp, _ := n.GetFieldString("Type"); tp == "ovsbridge" {
+		return []*graph.Node{n}, 0
+	}
+
+	ofport, _ = n.GetFieldInt64("OfPort")
+	for _, port := range g.LookupParents(n, graph.Metadata{"Type": "ovsport"}, graph.Metadata{}) {
+		bridges = append(bridges, g.LookupParents(port, graph.Metadata{"Type": "ovsbridge"}, ownershipMetadata)...)
+	}
+	return bridges, ofport
+}
+
+// LookupOpenFlowRules returns the OpenFlow rules, of the bridges the given
+// node is a part of, matching the traffic entering the bridges through the
+// node and the metadata filter. Rules are sorted by table and priority.
+func LookupOpenFlowRules(g *graph.Graph, n *graph.Node, m graph.Metadata, p OpenFlowPacket) (rules []*graph.Node) {
+	bridges, ofport := openFlowBridges(g, n)
+	if p.InPort == 0 {
+		p.InPort = ofport
+	}
+
+	filter := graph.Metadata{}
+	for k, v := range m {
+		filter[k] = v
+	}
+	filter["Type"] = "ofrule"
+
+	for _, bridge := range bridges {
+		for _, rule := range g.LookupChildren(bridge, filter, ownershipMetadata) {
+			if MatchOpenFlowRule(rule, p) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	sort.Sort(openFlowRules(rules))
+
+	return rules
+}
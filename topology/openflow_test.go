@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package topology
+
+import (
+	"net"
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestLookupOpenFlowRules(t *testing.T) {
+	g := newGraph(t)
+
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "localhost"})
+	bridge := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsbridge", "Name": "br-int"})
+	port := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsport", "Name": "tap1"})
+	tap := g.NewNode(graph.GenID(), graph.Metadata{"Type": "tap", "Name": "tap1", "OfPort": int64(5)})
+	g.Link(host, bridge, ownershipMetadata)
+	g.Link(bridge, port, ownershipMetadata)
+	g.Link(port, tap, graph.Metadata{"RelationType": "layer2"})
+
+	rules := []graph.Metadata{
+		{"Name": "table=0 priority=0", "Table": int64(0), "Priority": int64(0)},
+		{"Name": "table=0 priority=10 in_port=5", "Table": int64(0), "Priority": int64(10), "Match": "in_port=5"},
+		{"Name": "table=0 priority=10 in_port=6", "Table": int64(0), "Priority": int64(10), "Match": "in_port=6"},
+		{"Name": "table=24 priority=2 arp,in_port=5", "Table": int64(24), "Priority": int64(2), "Match": "arp,in_port=5"},
+		{"Name": "table=24 priority=2 ip,dl_src=fa:16:3e:00:00:01,nw_src=10.0.0.0/24", "Table": int64(24), "Priority": int64(2), "Match": "ip,dl_src=fa:16:3e:00:00:01,nw_src=10.0.0.0/24"},
+		{"Name": "table=71 priority=70 tcp,tp_dst=0x50/0xfff0", "Table": int64(71), "Priority": int64(70), "Match": "tcp,tp_dst=0x50/0xfff0"},
+		{"Name": "table=71 priority=70 udp6", "Table": int64(71), "Priority": int64(70), "Match": "udp6"},
+	}
+	for _, m := range rules {
+		m["Type"] = "ofrule"
+		g.Link(bridge, g.NewNode(graph.GenID(), m), ownershipMetadata)
+	}
+
+	packet := OpenFlowPacket{
+		FiveTuple: FiveTuple{Protocol: "tcp", Source: net.ParseIP("10.0.0.5"), Destination: net.ParseIP("192.168.0.1"), SourcePort: 40000, DestinationPort: 88},
+		EtherType: "ip",
+		EthSrc:    "fa:16:3e:00:00:01",
+		EthDst:    "fa:16:3e:00:00:02",
+	}
+
+	matched := LookupOpenFlowRules(g, tap, graph.Metadata{}, packet)
+
+	var names []string
+	for _, node := range matched {
+		name, _ := node.GetFieldString("Name")
+		names = append(names, name)
+	}
+
+	expected := []string{
+		"table=0 priority=10 in_port=5",
+		"table=0 priority=0",
+		"table=24 priority=2 ip,dl_src=fa:16:3e:00:00:01,nw_src=10.0.0.0/24",
+		"table=71 priority=70 tcp,tp_dst=0x50/0xfff0",
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("Wrong rules matched: %v", names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected rule %s at position %d, got: %v", expected[i], i, names)
+		}
+	}
+
+	matched = LookupOpenFlowRules(g, tap, graph.Metadata{"Table": int64(24)}, packet)
+	if len(matched) != 1 {
+		t.Errorf("Expected only one rule matched in table 24, got %d", len(matched))
+	}
+
+	// the input port isn't known for the traffic captured on the bridge
+	arp := OpenFlowPacket{EtherType: "arp", EthSrc: "fa:16:3e:00:00:03"}
+	if matched = LookupOpenFlowRules(g, bridge, graph.Metadata{"Table": int64(24)}, arp); len(matched) != 1 {
+		t.Errorf("Expected the ARP rule to be matched, got %d rules", len(matched))
+	}
+}
@@ -0,0 +1,696 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// ctnetlink messages, groups and attributes from
+// linux/netfilter/nfnetlink_conntrack.h
+const (
+	nfnlSubsysCtnetlink = 1
+
+	ipctnlMsgCtNew    = 0
+	ipctnlMsgCtGet    = 1
+	ipctnlMsgCtDelete = 2
+
+	nfnlgrpConntrackNew     = 1
+	nfnlgrpConntrackUpdate  = 2
+	nfnlgrpConntrackDestroy = 3
+
+	ctaTupleOrig  = 1
+	ctaTupleReply = 2
+	ctaStatus     = 3
+	ctaProtoinfo  = 4
+	ctaMark       = 8
+	ctaID         = 12
+	ctaZone       = 18
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	ctaProtoinfoTCP      = 1
+	ctaProtoinfoTCPState = 1
+
+	nlaTypeMask = 0x3fff
+
+	sizeofNfgenMsg = 4
+)
+
+var (
+	errConntrackMessage = errors.New("invalid conntrack message")
+
+	conntrackStatus = []string{
+		"EXPECTED", "SEEN_REPLY", "ASSURED", "CONFIRMED", "SRC_NAT", "DST_NAT",
+		"SEQ_ADJUST", "SRC_NAT_DONE", "DST_NAT_DONE", "DYING", "FIXED_TIMEOUT",
+		"TEMPLATE", "UNTRACKED",
+	}
+
+	conntrackTCPStates = []string{
+		"NONE", "SYN_SENT", "SYN_RECV", "ESTABLISHED", "FIN_WAIT", "CLOSE_WAIT",
+		"LAST_ACK", "TIME_WAIT", "CLOSE", "SYN_SENT2",
+	}
+
+	ipProtocols = map[uint8]string{
+		syscall.IPPROTO_ICMP:   "icmp",
+		syscall.IPPROTO_TCP:    "tcp",
+		syscall.IPPROTO_UDP:    "udp",
+		syscall.IPPROTO_ICMPV6: "ipv6-icmp",
+		syscall.IPPROTO_SCTP:   "sctp",
+	}
+)
+
+// nfgenMsg is the netfilter netlink message header
+type nfgenMsg struct {
+	family uint8
+}
+
+func (msg *nfgenMsg) Len() int {
+	return sizeofNfgenMsg
+}
+
+func (msg *nfgenMsg) Serialize() []byte {
+	return []byte{msg.family, 0, 0, 0}
+}
+
+// ConntrackTuple is one direction of a tracked connection
+type ConntrackTuple struct {
+	Source          net.IP
+	Destination     net.IP
+	SourcePort      int64
+	DestinationPort int64
+}
+
+func (t *ConntrackTuple) endpoints() (string, string) {
+	src := net.JoinHostPort(t.Source.String(), strconv.FormatInt(t.SourcePort, 10))
+	dst := net.JoinHostPort(t.Destination.String(), strconv.FormatInt(t.DestinationPort, 10))
+	return src, dst
+}
+
+// ConntrackEntry is a connection tracked by netfilter along with the
+// translated addresses of its reply direction
+type ConntrackEntry struct {
+	ID       int64
+	Protocol string
+	Original ConntrackTuple
+	Reply    ConntrackTuple
+	State    string
+	Status   string
+	Mark     int64
+	Zone     int64
+}
+
+// SNAT returns whether the source of the connection is translated
+func (e *ConntrackEntry) SNAT() bool {
+	return !e.Reply.Destination.Equal(e.Original.Source) || e.Reply.DestinationPort != e.Original.SourcePort
+}
+
+// DNAT returns whether the destination of the connection is translated
+func (e *ConntrackEntry) DNAT() bool {
+	return !e.Reply.Source.Equal(e.Original.Destination) || e.Reply.SourcePort != e.Original.DestinationPort
+}
+
+// TrackingID identifies the connection whatever the direction and the side
+// of the address translation it has been seen
+func (e *ConntrackEntry) TrackingID() string {
+	src, dst := e.Original.endpoints()
+
+	hasher := sha1.New()
+	hasher.Write([]byte(fmt.Sprintf("%s/%s/%s/%d", e.Protocol, src, dst, e.Zone)))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func conntrackKey(protocol string, src, dst net.IP, srcPort, dstPort int64) string {
+	t := ConntrackTuple{Source: src, Destination: dst, SourcePort: srcPort, DestinationPort: dstPort}
+	s, d := t.endpoints()
+	return protocol + "/" + s + "/" + d
+}
+
+func (e *ConntrackEntry) keys() []string {
+	return []string{
+		conntrackKey(e.Protocol, e.Original.Source, e.Original.Destination, e.Original.SourcePort, e.Original.DestinationPort),
+		conntrackKey(e.Protocol, e.Reply.Source, e.Reply.Destination, e.Reply.SourcePort, e.Reply.DestinationPort),
+	}
+}
+
+func parseNestedAttrs(b []byte) ([]syscall.NetlinkRouteAttr, error) {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return nil, err
+	}
+	for i := range attrs {
+		attrs[i].Attr.Type &= nlaTypeMask
+	}
+	return attrs, nil
+}
+
+// parseConntrackTuple returns a tuple along with its protocol, ports are only
+// reported for the protocols using them
+func parseConntrackTuple(b []byte) (protocol string, t ConntrackTuple, err error) {
+	attrs, err := parseNestedAttrs(b)
+	if err != nil {
+		return "", t, err
+	}
+
+	var srcPort, dstPort int64
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case ctaTupleIP:
+			ips, err := parseNestedAttrs(attr.Value)
+			if err != nil {
+				return "", t, err
+			}
+			for _, ip := range ips {
+				switch ip.Attr.Type {
+				case ctaIPv4Src, ctaIPv6Src:
+					t.Source = net.IP(ip.Value)
+				case ctaIPv4Dst, ctaIPv6Dst:
+					t.Destination = net.IP(ip.Value)
+				}
+			}
+		case ctaTupleProto:
+			protos, err := parseNestedAttrs(attr.Value)
+			if err != nil {
+				return "", t, err
+			}
+			for _, proto := range protos {
+				switch proto.Attr.Type {
+				case ctaProtoNum:
+					if len(proto.Value) < 1 {
+						return "", t, errConntrackMessage
+					}
+					if name, ok := ipProtocols[proto.Value[0]]; ok {
+						protocol = name
+					} else {
+						protocol = strconv.Itoa(int(proto.Value[0]))
+					}
+				case ctaProtoSrcPort, ctaProtoDstPort:
+					if len(proto.Value) < 2 {
+						return "", t, errConntrackMessage
+					}
+					port := int64(binary.BigEndian.Uint16(proto.Value))
+					if proto.Attr.Type == ctaProtoSrcPort {
+						srcPort = port
+					} else {
+						dstPort = port
+					}
+				}
+			}
+		}
+	}
+
+	if t.Source == nil || t.Destination == nil || protocol == "" {
+		return "", t, errConntrackMessage
+	}
+
+	switch protocol {
+	case "tcp", "udp", "sctp":
+		t.SourcePort, t.DestinationPort = srcPort, dstPort
+	}
+
+	return protocol, t, nil
+}
+
+func conntrackStatusName(status uint32) string {
+	var names []string
+	for i, name := range conntrackStatus {
+		if status&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// parseConntrackEntry parses a ctnetlink message, nfgenmsg header included
+func parseConntrackEntry(b []byte) (*ConntrackEntry, error) {
+	if len(b) < sizeofNfgenMsg {
+		return nil, errConntrackMessage
+	}
+
+	attrs, err := parseNestedAttrs(b[sizeofNfgenMsg:])
+	if err != nil {
+		return nil, err
+	}
+
+	e := &ConntrackEntry{}
+
+	var status uint32
+	var original, reply bool
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case ctaTupleOrig:
+			if e.Protocol, e.Original, err = parseConntrackTuple(attr.Value); err != nil {
+				return nil, err
+			}
+			original = true
+		case ctaTupleReply:
+			if _, e.Reply, err = parseConntrackTuple(attr.Value); err != nil {
+				return nil, err
+			}
+			reply = true
+		case ctaStatus:
+			if len(attr.Value) >= 4 {
+				status = binary.BigEndian.Uint32(attr.Value)
+				e.Status = conntrackStatusName(status)
+			}
+		case ctaMark:
+			if len(attr.Value) >= 4 {
+				e.Mark = int64(binary.BigEndian.Uint32(attr.Value))
+			}
+		case ctaID:
+			if len(attr.Value) >= 4 {
+				e.ID = int64(binary.BigEndian.Uint32(attr.Value))
+			}
+		case ctaZone:
+			if len(attr.Value) >= 2 {
+				e.Zone = int64(binary.BigEndian.Uint16(attr.Value))
+			}
+		case ctaProtoinfo:
+			infos, err := parseNestedAttrs(attr.Value)
+			if err != nil {
+				return nil, err
+			}
+			for _, info := range infos {
+				if info.Attr.Type != ctaProtoinfoTCP {
+					continue
+				}
+				tcp, err := parseNestedAttrs(info.Value)
+				if err != nil {
+					return nil, err
+				}
+				for _, a := range tcp {
+					if a.Attr.Type == ctaProtoinfoTCPState && len(a.Value) > 0 && int(a.Value[0]) < len(conntrackTCPStates) {
+						e.State = conntrackTCPStates[a.Value[0]]
+					}
+				}
+			}
+		}
+	}
+
+	if !original || !reply {
+		return nil, errConntrackMessage
+	}
+
+	// connections without protocol state are reported like conntrack does
+	if e.State == "" {
+		if status&(1<<1) != 0 {
+			e.State = "REPLIED"
+		} else {
+			e.State = "UNREPLIED"
+		}
+	}
+
+	return e, nil
+}
+
+// conntrackTable holds the connections tracked in a network namespace
+// delays before following again the events of a namespace once its netlink
+// socket failed, doubled while the failures repeat
+var (
+	conntrackRetryDelay    = time.Second
+	conntrackMaxRetryDelay = time.Minute
+)
+
+type conntrackTable struct {
+	sync.RWMutex
+	path    string
+	entries map[string]*ConntrackEntry
+	state   int64
+}
+
+func (t *conntrackTable) handleMessage(msgType uint16, data []byte) {
+	if msgType>>8 != nfnlSubsysCtnetlink {
+		return
+	}
+
+	e, err := parseConntrackEntry(data)
+	if err != nil {
+		logging.GetLogger().Debugf("Failed to parse conntrack message: %s", err.Error())
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	for _, key := range e.keys() {
+		switch msgType & 0xff {
+		case ipctnlMsgCtNew:
+			t.entries[key] = e
+		case ipctnlMsgCtDelete:
+			delete(t.entries, key)
+		}
+	}
+}
+
+func (t *conntrackTable) lookup(protocol string, src, dst net.IP, srcPort, dstPort int64) *ConntrackEntry {
+	t.RLock()
+	defer t.RUnlock()
+
+	if e, ok := t.entries[conntrackKey(protocol, src, dst, srcPort, dstPort)]; ok {
+		c := *e
+		return &c
+	}
+	return nil
+}
+
+func dumpConntrackTable() ([][]byte, error) {
+	req := nl.NewNetlinkRequest(nfnlSubsysCtnetlink<<8|ipctnlMsgCtGet, syscall.NLM_F_DUMP)
+	req.AddData(&nfgenMsg{family: syscall.AF_UNSPEC})
+
+	return req.Execute(syscall.NETLINK_NETFILTER, 0)
+}
+
+// run follows the conntrack events of the namespace until the table is
+// stopped or the netlink socket fails
+func (t *conntrackTable) run() {
+	var context *common.NetNSContext
+	var err error
+
+	if t.path != "" {
+		if context, err = common.NewNetNsContext(t.path); err != nil {
+			logging.GetLogger().Errorf("Failed to switch namespace: %s", err.Error())
+			return
+		}
+	}
+
+	// subscribe before dumping the table to not miss any event
+	s, err := nl.Subscribe(syscall.NETLINK_NETFILTER, nfnlgrpConntrackNew, nfnlgrpConntrackUpdate, nfnlgrpConntrackDestroy)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to subscribe to conntrack events: %s", err.Error())
+		context.Close()
+		return
+	}
+	defer s.Close()
+
+	entries, err := dumpConntrackTable()
+	context.Close()
+
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to dump conntrack table: %s", err.Error())
+	}
+
+	for _, data := range entries {
+		t.handleMessage(nfnlSubsysCtnetlink<<8|ipctnlMsgCtNew, data)
+	}
+
+	fd := s.GetFd()
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		logging.GetLogger().Errorf("Failed to set the netlink fd as non-blocking: %s", err.Error())
+		return
+	}
+
+	epfd, err := syscall.EpollCreate1(0)
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to create epoll: %s", err.Error())
+		return
+	}
+	defer syscall.Close(epfd)
+
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+		logging.GetLogger().Errorf("Failed to control epoll: %s", err.Error())
+		return
+	}
+	events := make([]syscall.EpollEvent, maxEpollEvents)
+
+	for atomic.LoadInt64(&t.state) == common.RunningState {
+		n, err := syscall.EpollWait(epfd, events[:], 1000)
+		if err != nil || n == 0 {
+			continue
+		}
+
+		msgs, err := s.Receive()
+		if err != nil {
+			// events are lost when the socket buffer overflows
+			if errno, ok := err.(syscall.Errno); ok && errno == syscall.ENOBUFS {
+				logging.GetLogger().Warningf("Conntrack events lost in namespace %s", t.path)
+				continue
+			}
+			if errno, ok := err.(syscall.Errno); !ok || !errno.Temporary() {
+				logging.GetLogger().Errorf("Failed to receive conntrack events in namespace %s: %s", t.path, err.Error())
+				return
+			}
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		for _, msg := range msgs {
+			t.handleMessage(msg.Header.Type, msg.Data)
+		}
+	}
+}
+
+// sleep waits for the given delay, it returns false if the table was stopped
+// meanwhile
+func (t *conntrackTable) sleep(delay time.Duration) bool {
+	for deadline := time.Now().Add(delay); time.Now().Before(deadline); {
+		if atomic.LoadInt64(&t.state) != common.RunningState {
+			return false
+		}
+
+		step := deadline.Sub(time.Now())
+		if step > 100*time.Millisecond {
+			step = 100 * time.Millisecond
+		}
+		time.Sleep(step)
+	}
+	return atomic.LoadInt64(&t.state) == common.RunningState
+}
+
+// follow calls run, following the events of the namespace, until the table
+// is stopped. A failed run is retried with a backoff, the entries being
+// dumped again.
+func (t *conntrackTable) follow(run func()) {
+	delay := conntrackRetryDelay
+	for {
+		started := time.Now()
+		run()
+
+		if atomic.LoadInt64(&t.state) != common.RunningState {
+			return
+		}
+
+		// a table that ran for a while failed for another reason
+		if time.Since(started) > conntrackMaxRetryDelay {
+			delay = conntrackRetryDelay
+		}
+
+		logging.GetLogger().Warningf("Conntrack events of namespace %s not followed anymore, retrying in %s", t.path, delay)
+		if !t.sleep(delay) {
+			return
+		}
+
+		t.Lock()
+		t.entries = make(map[string]*ConntrackEntry)
+		t.Unlock()
+
+		if delay *= 2; delay > conntrackMaxRetryDelay {
+			delay = conntrackMaxRetryDelay
+		}
+	}
+}
+
+// ConntrackProbe tracks the connections of the host and of its network
+// namespaces by listening to the netfilter conntrack events so that the
+// flows can be correlated with their NAT translated counterpart.
+type ConntrackProbe struct {
+	sync.RWMutex
+	graph.DefaultGraphListener
+	Graph  *graph.Graph
+	Root   *graph.Node
+	tables map[graph.Identifier]*conntrackTable
+	state  int64
+	wg     sync.WaitGroup
+}
+
+// namespaceNode returns the host or netns node owning the given node
+func namespaceNode(g *graph.Graph, n *graph.Node) *graph.Node {
+	for n != nil {
+		if tp, _ := n.GetFieldString("Type"); tp == "host" || tp == "netns" {
+			return n
+		}
+
+		parents := g.LookupParents(n, graph.Metadata{}, ownershipMetadata)
+		if len(parents) == 0 {
+			return nil
+		}
+		n = parents[0]
+	}
+	return nil
+}
+
+// Namespace returns the ID of the host or netns node owning the given node.
+// Graph lock has to be held.
+func (probe *ConntrackProbe) Namespace(n *graph.Node) (graph.Identifier, bool) {
+	if ns := namespaceNode(probe.Graph, n); ns != nil {
+		return ns.ID, true
+	}
+	return "", false
+}
+
+// Lookup returns the conntrack entry of the traffic, in either direction,
+// seen in the namespace of the given node. Graph lock has to be held.
+func (probe *ConntrackProbe) Lookup(n *graph.Node, protocol string, src, dst net.IP, srcPort, dstPort int64) *ConntrackEntry {
+	ns, ok := probe.Namespace(n)
+	if !ok {
+		return nil
+	}
+	return probe.LookupNamespace(ns, protocol, src, dst, srcPort, dstPort)
+}
+
+// LookupNamespace returns the conntrack entry of the traffic, in either
+// direction, seen in the namespace of the given host or netns node
+func (probe *ConntrackProbe) LookupNamespace(ns graph.Identifier, protocol string, src, dst net.IP, srcPort, dstPort int64) *ConntrackEntry {
+	probe.RLock()
+	t, ok := probe.tables[ns]
+	probe.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	if e := t.lookup(protocol, src, dst, srcPort, dstPort); e != nil {
+		return e
+	}
+	return t.lookup(protocol, dst, src, dstPort, srcPort)
+}
+
+func (probe *ConntrackProbe) startTable(id graph.Identifier, path string) {
+	probe.Lock()
+	defer probe.Unlock()
+
+	if _, ok := probe.tables[id]; ok || atomic.LoadInt64(&probe.state) != common.RunningState {
+		return
+	}
+
+	t := &conntrackTable{
+		path:    path,
+		entries: make(map[string]*ConntrackEntry),
+		state:   common.RunningState,
+	}
+	probe.tables[id] = t
+
+	probe.wg.Add(1)
+	go func() {
+		defer probe.wg.Done()
+		t.follow(t.run)
+	}()
+}
+
+func (probe *ConntrackProbe) OnNodeAdded(n *graph.Node) {
+	if n.ID == probe.Root.ID {
+		probe.startTable(n.ID, "")
+		return
+	}
+
+	if tp, _ := n.GetFieldString("Type"); tp == "netns" {
+		if path, _ := n.GetFieldString("Path"); path != "" {
+			probe.startTable(n.ID, path)
+		}
+	}
+}
+
+func (probe *ConntrackProbe) OnNodeUpdated(n *graph.Node) {
+	probe.OnNodeAdded(n)
+}
+
+func (probe *ConntrackProbe) OnNodeDeleted(n *graph.Node) {
+	probe.Lock()
+	defer probe.Unlock()
+
+	if t, ok := probe.tables[n.ID]; ok {
+		atomic.StoreInt64(&t.state, common.StoppingState)
+		delete(probe.tables, n.ID)
+	}
+}
+
+func (probe *ConntrackProbe) Start() {
+	if !atomic.CompareAndSwapInt64(&probe.state, common.StoppedState, common.RunningState) {
+		return
+	}
+
+	probe.startTable(probe.Root.ID, "")
+
+	probe.Graph.AddEventListener(probe)
+
+	probe.Graph.RLock()
+	for _, n := range probe.Graph.GetNodes(graph.Metadata{"Type": "netns"}) {
+		probe.OnNodeAdded(n)
+	}
+	probe.Graph.RUnlock()
+}
+
+func (probe *ConntrackProbe) Stop() {
+	if !atomic.CompareAndSwapInt64(&probe.state, common.RunningState, common.StoppingState) {
+		return
+	}
+
+	probe.Graph.RemoveEventListener(probe)
+
+	probe.Lock()
+	for id, t := range probe.tables {
+		atomic.StoreInt64(&t.state, common.StoppingState)
+		delete(probe.tables, id)
+	}
+	probe.Unlock()
+
+	probe.wg.Wait()
+
+	atomic.StoreInt64(&probe.state, common.StoppedState)
+}
+
+func NewConntrackProbe(g *graph.Graph, n *graph.Node) *ConntrackProbe {
+	return &ConntrackProbe{
+		Graph:  g,
+		Root:   n,
+		tables: make(map[graph.Identifier]*conntrackTable),
+		state:  common.StoppedState,
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func testNested(kind int, attrs ...[]byte) []byte {
+	var value []byte
+	for _, attr := range attrs {
+		value = append(value, attr...)
+	}
+	return testRtAttr(kind|0x8000, value)
+}
+
+func testBigEndian16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func testBigEndian32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func testConntrackTuple(kind int, proto byte, src, dst string, srcPort, dstPort uint16) []byte {
+	return testNested(kind,
+		testNested(ctaTupleIP,
+			testRtAttr(ctaIPv4Src, net.ParseIP(src).To4()),
+			testRtAttr(ctaIPv4Dst, net.ParseIP(dst).To4())),
+		testNested(ctaTupleProto,
+			testRtAttr(ctaProtoNum, []byte{proto}),
+			testRtAttr(ctaProtoSrcPort, testBigEndian16(srcPort)),
+			testRtAttr(ctaProtoDstPort, testBigEndian16(dstPort))))
+}
+
+func testConntrackMsg(attrs ...[]byte) []byte {
+	b := []byte{syscall.AF_INET, 0, 0, 0}
+	for _, attr := range attrs {
+		b = append(b, attr...)
+	}
+	return b
+}
+
+// connection from a VM to 8.8.8.8 going through a floating IP
+var testConntrackSNAT = testConntrackMsg(
+	testConntrackTuple(ctaTupleOrig, syscall.IPPROTO_TCP, "10.0.0.5", "8.8.8.8", 40000, 53),
+	testConntrackTuple(ctaTupleReply, syscall.IPPROTO_TCP, "8.8.8.8", "172.24.4.10", 53, 40000),
+	testRtAttr(ctaStatus, testBigEndian32(1<<1|1<<2|1<<3|1<<4|1<<7)),
+	testRtAttr(ctaMark, testBigEndian32(42)),
+	testRtAttr(ctaID, testBigEndian32(1234)),
+	testNested(ctaProtoinfo, testNested(ctaProtoinfoTCP, testRtAttr(ctaProtoinfoTCPState, []byte{3}))),
+)
+
+func TestParseConntrackEntry(t *testing.T) {
+	e, err := parseConntrackEntry(testConntrackSNAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.ID != 1234 || e.Protocol != "tcp" || e.State != "ESTABLISHED" || e.Mark != 42 {
+		t.Errorf("Wrong conntrack entry: %+v", e)
+	}
+
+	if e.Status != "SEEN_REPLY,ASSURED,CONFIRMED,SRC_NAT,SRC_NAT_DONE" {
+		t.Errorf("Wrong status: %s", e.Status)
+	}
+
+	if !e.SNAT() || e.DNAT() {
+		t.Errorf("Connection should only be source translated: %+v", e)
+	}
+
+	if e.Reply.Destination.String() != "172.24.4.10" || e.Reply.DestinationPort != 40000 {
+		t.Errorf("Wrong reply tuple: %+v", e.Reply)
+	}
+
+	udp := testConntrackMsg(
+		testConntrackTuple(ctaTupleOrig, syscall.IPPROTO_UDP, "10.0.0.5", "10.0.0.1", 5353, 53),
+		testConntrackTuple(ctaTupleReply, syscall.IPPROTO_UDP, "10.0.0.1", "10.0.0.5", 53, 5353),
+		testRtAttr(ctaStatus, testBigEndian32(1<<3)),
+	)
+	if e, err = parseConntrackEntry(udp); err != nil {
+		t.Fatal(err)
+	}
+	if e.State != "UNREPLIED" || e.SNAT() || e.DNAT() {
+		t.Errorf("Wrong conntrack entry: %+v", e)
+	}
+
+	if _, err := parseConntrackEntry(testConntrackMsg(testRtAttr(ctaStatus, testBigEndian32(0)))); err == nil {
+		t.Error("Entry without tuples should be rejected")
+	}
+}
+
+func TestConntrackLookup(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	root := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	router := g.NewNode(graph.GenID(), graph.Metadata{"Type": "netns", "Name": "qrouter", "Path": "/var/run/netns/qrouter"})
+	qr := g.NewNode(graph.GenID(), graph.Metadata{"Type": "internal", "Name": "qr-1"})
+	qg := g.NewNode(graph.GenID(), graph.Metadata{"Type": "internal", "Name": "qg-1"})
+	g.Link(root, router, ownershipMetadata)
+	g.Link(router, qr, ownershipMetadata)
+	g.Link(router, qg, ownershipMetadata)
+	g.Unlock()
+
+	probe := NewConntrackProbe(g, root)
+
+	table := &conntrackTable{entries: make(map[string]*ConntrackEntry), state: common.StoppedState}
+	probe.tables[router.ID] = table
+
+	table.handleMessage(nfnlSubsysCtnetlink<<8|ipctnlMsgCtNew, testConntrackSNAT)
+
+	g.RLock()
+	defer g.RUnlock()
+
+	// flow seen before the translation on the internal port
+	before := probe.Lookup(qr, "tcp", net.ParseIP("10.0.0.5"), net.ParseIP("8.8.8.8"), 40000, 53)
+	// reply flow seen after the translation on the external port
+	after := probe.Lookup(qg, "tcp", net.ParseIP("8.8.8.8"), net.ParseIP("172.24.4.10"), 53, 40000)
+
+	if before == nil || after == nil {
+		t.Fatal("Both sides of the translation should be found")
+	}
+
+	if before.TrackingID() != after.TrackingID() {
+		t.Error("Both sides of the translation should share the same tracking ID")
+	}
+
+	if e := probe.Lookup(root, "tcp", net.ParseIP("10.0.0.5"), net.ParseIP("8.8.8.8"), 40000, 53); e != nil {
+		t.Error("Entry shouldn't be found in another namespace")
+	}
+
+	table.handleMessage(nfnlSubsysCtnetlink<<8|ipctnlMsgCtDelete, testConntrackSNAT)
+	if e := probe.Lookup(qg, "tcp", net.ParseIP("8.8.8.8"), net.ParseIP("172.24.4.10"), 53, 40000); e != nil {
+		t.Error("Destroyed entry shouldn't be found")
+	}
+}
+
+func TestConntrackTableRetry(t *testing.T) {
+	retryDelay, maxRetryDelay := conntrackRetryDelay, conntrackMaxRetryDelay
+	conntrackRetryDelay, conntrackMaxRetryDelay = 10*time.Millisecond, 20*time.Millisecond
+	defer func() {
+		conntrackRetryDelay, conntrackMaxRetryDelay = retryDelay, maxRetryDelay
+	}()
+
+	table := &conntrackTable{entries: make(map[string]*ConntrackEntry), state: common.RunningState}
+	table.entries["stale"] = &ConntrackEntry{}
+
+	// the netlink socket fails twice then the table is stopped
+	var runs int
+	done := make(chan struct{})
+	go func() {
+		table.follow(func() {
+			if runs++; runs == 3 {
+				atomic.StoreInt64(&table.state, common.StoppingState)
+			}
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The table should stop following the events once stopped")
+	}
+
+	if runs != 3 {
+		t.Errorf("A failed table should be run again until stopped, got %d runs", runs)
+	}
+
+	if len(table.entries) != 0 {
+		t.Errorf("The entries should be dumped again on retry, got %v", table.entries)
+	}
+}
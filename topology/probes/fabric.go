@@ -45,8 +45,19 @@ type fabricLink struct {
 
 type FabricProbe struct {
 	graph.DefaultGraphListener
-	Graph *graph.Graph
-	links map[*graph.Node][]fabricLink
+	Graph  *graph.Graph
+	links  map[*graph.Node][]fabricLink
+	learnt map[graph.Identifier]bool
+}
+
+// fabricNeighbor is the remote switch port learnt by an interface through
+// LLDP or CDP
+type fabricNeighbor struct {
+	discovery      string
+	switchName     string
+	switchMetadata graph.Metadata
+	portName       string
+	portMetadata   graph.Metadata
 }
 
 type FabricRegisterLinkWSMessage struct {
@@ -71,15 +82,229 @@ func (fb *FabricProbe) OnEdgeAdded(e *graph.Edge) {
 	}
 }
 
+func (fb *FabricProbe) OnEdgeDeleted(e *graph.Edge) {
+	if discovery, _ := e.GetFieldString("Discovery"); discovery != "" {
+		if parent := fb.Graph.GetNode(e.GetParent()); parent != nil {
+			fb.delUnusedLearntNode(parent)
+		}
+	}
+}
+
+func (fb *FabricProbe) OnNodeAdded(n *graph.Node) {
+	if !isFabricNode(n) {
+		fb.updateLearntLinks(n)
+	}
+}
+
+func (fb *FabricProbe) OnNodeUpdated(n *graph.Node) {
+	if !isFabricNode(n) {
+		fb.updateLearntLinks(n)
+	}
+}
+
 func (fb *FabricProbe) OnNodeDeleted(n *graph.Node) {
-	if probe, _ := n.GetFieldString("Probe"); probe != "fabric" {
+	if !isFabricNode(n) {
 		delete(fb.links, n)
+		delete(fb.learnt, n.ID)
+	}
+}
+
+func isFabricNode(n *graph.Node) bool {
+	probe, _ := n.GetFieldString("Probe")
+	return probe == "fabric"
+}
+
+func copyNeighborFields(n *graph.Node, m graph.Metadata, fields map[string]string) {
+	for from, to := range fields {
+		if v, ok := n.Metadata()[from]; ok {
+			m[to] = v
+		}
+	}
+}
+
+// learntNeighbor returns the switch port reported by the LLDP or CDP
+// metadata of an interface
+func learntNeighbor(n *graph.Node) *fabricNeighbor {
+	var neighbor *fabricNeighbor
+
+	if port, _ := n.GetFieldString("LLDP/PortID"); port != "" {
+		chassis, _ := n.GetFieldString("LLDP/ChassisID")
+		name, _ := n.GetFieldString("LLDP/SysName")
+		if name == "" {
+			name = chassis
+		}
+
+		neighbor = &fabricNeighbor{
+			discovery:      "LLDP",
+			switchName:     name,
+			switchMetadata: graph.Metadata{},
+			portName:       port,
+			portMetadata:   graph.Metadata{},
+		}
+		copyNeighborFields(n, neighbor.switchMetadata, map[string]string{
+			"LLDP/ChassisID":      "ChassisID",
+			"LLDP/SysDescription": "Description",
+			"LLDP/MgmtAddress":    "MgmtAddress",
+		})
+		copyNeighborFields(n, neighbor.portMetadata, map[string]string{
+			"LLDP/PortDescription": "Description",
+		})
+	} else if port, _ := n.GetFieldString("CDP/PortID"); port != "" {
+		name, _ := n.GetFieldString("CDP/DeviceID")
+
+		neighbor = &fabricNeighbor{
+			discovery:      "CDP",
+			switchName:     name,
+			switchMetadata: graph.Metadata{},
+			portName:       port,
+			portMetadata:   graph.Metadata{},
+		}
+		copyNeighborFields(n, neighbor.switchMetadata, map[string]string{
+			"CDP/Platform":    "Platform",
+			"CDP/Version":     "Description",
+			"CDP/MgmtAddress": "MgmtAddress",
+		})
+		copyNeighborFields(n, neighbor.portMetadata, map[string]string{
+			"CDP/NativeVLAN": "NativeVLAN",
+		})
+	}
+
+	if neighbor == nil || neighbor.switchName == "" {
+		return nil
+	}
+
+	neighbor.switchMetadata["Name"] = neighbor.switchName
+	neighbor.switchMetadata["Type"] = "switch"
+	neighbor.portMetadata["Name"] = neighbor.portName
+	neighbor.portMetadata["Type"] = "switchport"
+
+	for _, m := range []graph.Metadata{neighbor.switchMetadata, neighbor.portMetadata} {
+		m["Probe"] = "fabric"
+		m["Discovery"] = neighbor.discovery
+	}
+
+	return neighbor
+}
+
+// updateLearntNode creates or updates a node learnt through LLDP or CDP,
+// the nodes statically defined being kept as is
+func (fb *FabricProbe) updateLearntNode(node *graph.Node, nodeName string, metadata graph.Metadata) *graph.Node {
+	if node == nil {
+		u, _ := uuid.NewV5(uuid.NamespaceOID, []byte("fabric"+nodeName))
+		id := graph.Identifier(u.String())
+
+		if node = fb.Graph.GetNode(id); node == nil {
+			return fb.Graph.NewNode(id, metadata, "")
+		}
+	}
+
+	if discovery, _ := node.GetFieldString("Discovery"); discovery != "" {
+		m := node.Metadata()
+		for k, v := range metadata {
+			m[k] = v
+		}
+		fb.Graph.SetMetadata(node, m)
+	}
+
+	return node
+}
+
+func (fb *FabricProbe) getOrCreateLearntPort(neighbor *fabricNeighbor) *graph.Node {
+	sw := fb.Graph.LookupFirstNode(graph.Metadata{"Probe": "fabric", "Name": neighbor.switchName})
+	sw = fb.updateLearntNode(sw, neighbor.switchName, neighbor.switchMetadata)
+
+	var port *graph.Node
+	if ports := fb.Graph.LookupChildren(sw, graph.Metadata{"Name": neighbor.portName}, graph.Metadata{"Type": "fabric"}); len(ports) > 0 {
+		port = ports[0]
+	}
+	port = fb.updateLearntNode(port, neighbor.switchName+"/"+neighbor.portName, neighbor.portMetadata)
+
+	if !fb.Graph.AreLinked(sw, port, graph.Metadata{"RelationType": "layer2", "Type": "fabric"}) {
+		fb.Graph.Link(sw, port, graph.Metadata{"RelationType": "layer2", "Type": "fabric", "Discovery": neighbor.discovery})
+	}
+
+	return port
+}
+
+// updateLearntLinks links an interface to the switch port learnt through
+// LLDP or CDP, unless a static fabric link is defined for this interface
+func (fb *FabricProbe) updateLearntLinks(n *graph.Node) {
+	neighbor := learntNeighbor(n)
+	if neighbor == nil && !fb.learnt[n.ID] {
+		return
+	}
+
+	var learnt []*graph.Edge
+	static := false
+	for _, e := range fb.Graph.GetNodeEdges(n, graph.Metadata{"Type": "fabric"}) {
+		if e.GetChild() != n.ID {
+			continue
+		}
+
+		if discovery, _ := e.GetFieldString("Discovery"); discovery != "" {
+			learnt = append(learnt, e)
+		} else {
+			static = true
+		}
+	}
+
+	var port *graph.Node
+	if neighbor != nil && !static {
+		port = fb.getOrCreateLearntPort(neighbor)
+	}
+
+	linked := false
+	for _, e := range learnt {
+		discovery, _ := e.GetFieldString("Discovery")
+		if port != nil && e.GetParent() == port.ID && discovery == neighbor.discovery {
+			linked = true
+			continue
+		}
+		fb.Graph.DelEdge(e)
+
+		if parent := fb.Graph.GetNode(e.GetParent()); parent != nil {
+			fb.delUnusedLearntNode(parent)
+		}
+	}
+
+	if port == nil {
+		delete(fb.learnt, n.ID)
+		return
+	}
+
+	if !linked {
+		fb.Graph.Link(port, n, graph.Metadata{"RelationType": "layer2", "Type": "fabric", "Discovery": neighbor.discovery})
+	}
+	fb.learnt[n.ID] = true
+}
+
+// delUnusedLearntNode removes a learnt switch or port not linked anymore
+func (fb *FabricProbe) delUnusedLearntNode(n *graph.Node) {
+	if discovery, _ := n.GetFieldString("Discovery"); discovery == "" {
+		return
+	}
+
+	if len(fb.Graph.LookupChildren(n, graph.Metadata{}, graph.Metadata{"Type": "fabric"})) > 0 {
+		return
+	}
+
+	// the graph doesn't notify the listener of its own changes
+	parents := fb.Graph.LookupParents(n, graph.Metadata{}, graph.Metadata{"Type": "fabric"})
+	fb.Graph.DelNode(n)
+
+	for _, parent := range parents {
+		fb.delUnusedLearntNode(parent)
 	}
 }
 
 func (fb *FabricProbe) LinkNodes(parent *graph.Node, child *graph.Node, linkMetadata *graph.Metadata) {
 	if !fb.Graph.AreLinked(child, parent, *linkMetadata) {
 		fb.Graph.Link(parent, child, *linkMetadata)
+
+		// static links override the learnt ones
+		if !isFabricNode(child) {
+			fb.updateLearntLinks(child)
+		}
 	}
 }
 
@@ -153,8 +378,9 @@ func (fb *FabricProbe) Stop() {
 
 func NewFabricProbe(g *graph.Graph) *FabricProbe {
 	fb := &FabricProbe{
-		Graph: g,
-		links: make(map[*graph.Node][]fabricLink),
+		Graph:  g,
+		links:  make(map[*graph.Node][]fabricLink),
+		learnt: make(map[graph.Identifier]bool),
 	}
 
 	g.AddEventListener(fb)
@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestFabricLearntLinks(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("analyzer", b)
+
+	fb := NewFabricProbe(g)
+	defer g.RemoveEventListener(fb)
+
+	g.Lock()
+	defer g.Unlock()
+
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{
+		"Name":           "eth0",
+		"LLDP/ChassisID": "00:11:22:33:44:00",
+		"LLDP/PortID":    "Ethernet1/12",
+		"LLDP/SysName":   "tor1",
+	}, "host1")
+	eth1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth1"}, "host1")
+	g.AddMetadata(eth1, "CDP/DeviceID", "tor1")
+	g.AddMetadata(eth1, "CDP/PortID", "Ethernet1/13")
+
+	sw := g.LookupFirstNode(graph.Metadata{"Type": "switch", "Name": "tor1"})
+	if sw == nil {
+		t.Fatal("Switch node not learnt")
+	}
+
+	port := g.LookupFirstNode(graph.Metadata{"Type": "switchport", "Name": "Ethernet1/12", "Discovery": "LLDP"})
+	if port == nil {
+		t.Fatal("Switch port not learnt")
+	}
+	if !g.AreLinked(sw, port, graph.Metadata{"Type": "fabric"}) || !g.AreLinked(port, eth0, graph.Metadata{"Type": "fabric", "Discovery": "LLDP"}) {
+		t.Error("Learnt port should be linked to the switch and the interface")
+	}
+
+	port2 := g.LookupFirstNode(graph.Metadata{"Type": "switchport", "Name": "Ethernet1/13", "Discovery": "CDP"})
+	if port2 == nil || !g.AreLinked(sw, port2, graph.Metadata{"Type": "fabric"}) {
+		t.Fatal("CDP port should be learnt on the same switch")
+	}
+
+	// static links override the learnt ones
+	static, err := fb.getOrCreateFabricNodeFromDef("TOR2_PORT1[Name=port1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.LinkNodes(static, eth0, &graph.Metadata{"RelationType": "layer2", "Type": "fabric"})
+
+	if g.GetNode(port.ID) != nil {
+		t.Error("Learnt port should be removed when a static link is defined")
+	}
+	if !g.AreLinked(static, eth0, graph.Metadata{"Type": "fabric"}) {
+		t.Error("Static link should be kept")
+	}
+
+	// learnt nodes are removed with the last interface linked to them
+	g.DelMetadata(eth1, "CDP/PortID")
+	if g.GetNode(port2.ID) != nil || g.GetNode(sw.ID) != nil {
+		t.Error("Learnt switch and port should be removed once not linked anymore")
+	}
+}
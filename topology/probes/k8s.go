@@ -0,0 +1,702 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+var (
+	k8sOwnershipMetadata  = graph.Metadata{"RelationType": "k8s", "Type": "ownership"}
+	k8sEndpointMetadata   = graph.Metadata{"RelationType": "k8s", "Type": "endpoint"}
+	k8sPolicyMetadata     = graph.Metadata{"RelationType": "k8s", "Type": "policy"}
+	k8sSchedulingMetadata = graph.Metadata{"RelationType": "k8s", "Type": "scheduling"}
+	k8sHostMetadata       = graph.Metadata{"RelationType": "k8s", "Type": "host"}
+	k8sContainerMetadata  = graph.Metadata{"RelationType": "k8s", "Type": "container"}
+	k8sNetworkMetadata    = graph.Metadata{"RelationType": "k8s", "Type": "network"}
+)
+
+// Docker label set by the kubelet on every container of a pod, including
+// the infrastructure one holding the pod network namespace
+const k8sPodUIDLabel = "Docker/Labels/io.kubernetes.pod.uid"
+
+var errK8sResourceExpired = errors.New("resource version expired")
+
+// delays before watching again a stream closed by the server, the delay is
+// doubled each time the stream is closed right away
+const (
+	k8sWatchMinBackoff = time.Second
+	k8sWatchMaxBackoff = 30 * time.Second
+)
+
+// k8sKinds lists the watched resources with their API path
+var k8sKinds = []struct {
+	kind string
+	path string
+}{
+	{"namespace", "/api/v1/namespaces"},
+	{"node", "/api/v1/nodes"},
+	{"pod", "/api/v1/pods"},
+	{"service", "/api/v1/services"},
+	{"endpoints", "/api/v1/endpoints"},
+	{"networkpolicy", "/apis/networking.k8s.io/v1/networkpolicies"},
+}
+
+type k8sPort struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+type k8sObjectReference struct {
+	Kind string `json:"kind"`
+	UID  string `json:"uid"`
+}
+
+type k8sObject struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		UID             string            `json:"uid"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Labels          map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName    string            `json:"nodeName"`
+		ClusterIP   string            `json:"clusterIP"`
+		Type        string            `json:"type"`
+		Selector    map[string]string `json:"selector"`
+		Ports       []k8sPort         `json:"ports"`
+		PodSelector struct {
+			MatchLabels map[string]string `json:"matchLabels"`
+		} `json:"podSelector"`
+		PolicyTypes []string          `json:"policyTypes"`
+		Ingress     []json.RawMessage `json:"ingress"`
+		Egress      []json.RawMessage `json:"egress"`
+	} `json:"spec"`
+	Status struct {
+		Phase     string `json:"phase"`
+		PodIP     string `json:"podIP"`
+		HostIP    string `json:"hostIP"`
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+		NodeInfo struct {
+			KubeletVersion string `json:"kubeletVersion"`
+		} `json:"nodeInfo"`
+	} `json:"status"`
+	Subsets []struct {
+		Addresses []struct {
+			IP        string              `json:"ip"`
+			TargetRef *k8sObjectReference `json:"targetRef"`
+		} `json:"addresses"`
+		Ports []k8sPort `json:"ports"`
+	} `json:"subsets"`
+}
+
+type k8sList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []*k8sObject `json:"items"`
+}
+
+type k8sWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type k8sStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// k8sResource binds a Kubernetes object to its graph node
+type k8sResource struct {
+	kind   string
+	object *k8sObject
+	node   *graph.Node
+}
+
+// K8sProbe maps the Kubernetes objects of a cluster to graph nodes by
+// listing and watching the API server. Pods are linked to the containers,
+// network namespaces and interfaces created by the Docker probe of the
+// agents. The resources are protected by the graph lock.
+type K8sProbe struct {
+	graph.DefaultGraphListener
+	graph     *graph.Graph
+	url       string
+	token     string
+	client    *http.Client
+	state     int64
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	resources map[string]map[string]*k8sResource
+}
+
+func (probe *K8sProbe) request(path string, query string) (*http.Response, error) {
+	url := probe.url + path
+	if query != "" {
+		url += "?" + query
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if probe.token != "" {
+		req.Header.Set("Authorization", "Bearer "+probe.token)
+	}
+	req.Cancel = probe.quit
+
+	resp, err := probe.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusGone {
+			return nil, errK8sResourceExpired
+		}
+		return nil, fmt.Errorf("GET %s returned %s", path, resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (probe *K8sProbe) list(kind, path string) (string, error) {
+	resp, err := probe.request(path, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list k8sList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", err
+	}
+
+	probe.graph.Lock()
+	defer probe.graph.Unlock()
+
+	listed := make(map[string]bool)
+	for _, object := range list.Items {
+		listed[object.Metadata.UID] = true
+		probe.onObjectUpdated(kind, object)
+	}
+
+	for uid := range probe.resources[kind] {
+		if !listed[uid] {
+			probe.onObjectDeleted(kind, uid)
+		}
+	}
+
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch handles the events of a kind starting at the given resource version
+// and returns the last seen one when the server closes the stream
+func (probe *K8sProbe) watch(kind, path, resourceVersion string) (string, error) {
+	resp, err := probe.request(path, "watch=true&resourceVersion="+resourceVersion)
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event k8sWatchEvent
+		if err := decoder.Decode(&event); err != nil {
+			return resourceVersion, nil
+		}
+
+		if event.Type == "ERROR" {
+			var status k8sStatus
+			if err := json.Unmarshal(event.Object, &status); err == nil && status.Code == http.StatusGone {
+				return resourceVersion, errK8sResourceExpired
+			}
+			return resourceVersion, fmt.Errorf("watch error on %s: %s", path, status.Message)
+		}
+
+		var object k8sObject
+		if err := json.Unmarshal(event.Object, &object); err != nil {
+			return resourceVersion, err
+		}
+		resourceVersion = object.Metadata.ResourceVersion
+
+		probe.graph.Lock()
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			probe.onObjectUpdated(kind, &object)
+		case "DELETED":
+			probe.onObjectDeleted(kind, object.Metadata.UID)
+		}
+		probe.graph.Unlock()
+	}
+}
+
+func (probe *K8sProbe) listAndWatch(kind, path string) error {
+	resourceVersion, err := probe.list(kind, path)
+	if err != nil {
+		return err
+	}
+
+	backoff := k8sWatchMinBackoff
+	for atomic.LoadInt64(&probe.state) == common.RunningState {
+		start := time.Now()
+		if resourceVersion, err = probe.watch(kind, path, resourceVersion); err != nil {
+			return err
+		}
+
+		if time.Since(start) > k8sWatchMaxBackoff {
+			backoff = k8sWatchMinBackoff
+		}
+
+		select {
+		case <-probe.quit:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > k8sWatchMaxBackoff {
+			backoff = k8sWatchMaxBackoff
+		}
+	}
+
+	return nil
+}
+
+func (probe *K8sProbe) run(kind, path string) {
+	defer probe.wg.Done()
+
+	for atomic.LoadInt64(&probe.state) == common.RunningState {
+		err := probe.listAndWatch(kind, path)
+		if err == nil || err == errK8sResourceExpired {
+			continue
+		}
+
+		if atomic.LoadInt64(&probe.state) != common.RunningState {
+			return
+		}
+		logging.GetLogger().Errorf("Failed to watch Kubernetes %s: %s", kind, err.Error())
+
+		select {
+		case <-probe.quit:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func k8sPortsString(ports []k8sPort) string {
+	var s []string
+	for _, port := range ports {
+		s = append(s, strconv.Itoa(port.Port)+"/"+port.Protocol)
+	}
+	return strings.Join(s, ",")
+}
+
+func k8sMetadata(kind string, object *k8sObject) graph.Metadata {
+	m := graph.Metadata{
+		"Type":    kind,
+		"Manager": "k8s",
+		"Name":    object.Metadata.Name,
+		"K8s/UID": object.Metadata.UID,
+	}
+
+	if object.Metadata.Namespace != "" {
+		m["K8s/Namespace"] = object.Metadata.Namespace
+	}
+
+	for k, v := range object.Metadata.Labels {
+		m["K8s/Labels/"+k] = v
+	}
+
+	switch kind {
+	case "namespace":
+		m["K8s/Phase"] = object.Status.Phase
+	case "node":
+		for _, address := range object.Status.Addresses {
+			if address.Type == "InternalIP" {
+				m["K8s/IP"] = address.Address
+			}
+		}
+		m["K8s/KubeletVersion"] = object.Status.NodeInfo.KubeletVersion
+	case "pod":
+		m["K8s/Node"] = object.Spec.NodeName
+		m["K8s/IP"] = object.Status.PodIP
+		m["K8s/HostIP"] = object.Status.HostIP
+		m["K8s/Phase"] = object.Status.Phase
+	case "service":
+		m["K8s/ClusterIP"] = object.Spec.ClusterIP
+		m["K8s/ServiceType"] = object.Spec.Type
+		m["K8s/Ports"] = k8sPortsString(object.Spec.Ports)
+		for k, v := range object.Spec.Selector {
+			m["K8s/Selector/"+k] = v
+		}
+	case "endpoints":
+		var endpoints []string
+		for _, subset := range object.Subsets {
+			for _, address := range subset.Addresses {
+				for _, port := range subset.Ports {
+					endpoints = append(endpoints, fmt.Sprintf("%s:%d/%s", address.IP, port.Port, port.Protocol))
+				}
+			}
+		}
+		sort.Strings(endpoints)
+		m["K8s/Endpoints"] = strings.Join(endpoints, ",")
+	case "networkpolicy":
+		for k, v := range object.Spec.PodSelector.MatchLabels {
+			m["K8s/PodSelector/"+k] = v
+		}
+		m["K8s/PolicyTypes"] = strings.Join(object.Spec.PolicyTypes, ",")
+		m["K8s/IngressRules"] = len(object.Spec.Ingress)
+		m["K8s/EgressRules"] = len(object.Spec.Egress)
+	}
+
+	return m
+}
+
+// k8sMatchLabels returns whether all the selector labels are set, an empty
+// selector matching everything
+func k8sMatchLabels(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (probe *K8sProbe) lookup(kind, namespace, name string) *k8sResource {
+	for _, r := range probe.resources[kind] {
+		if r.object.Metadata.Namespace == namespace && r.object.Metadata.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// link makes sure the node is linked to the given children with the relation
+// and removes the edges of this relation to other nodes
+func (probe *K8sProbe) link(parent *graph.Node, children []*graph.Node, m graph.Metadata) {
+	existing := make(map[graph.Identifier]*graph.Edge)
+	for _, e := range probe.graph.GetNodeEdges(parent, m) {
+		if e.GetParent() == parent.ID {
+			existing[e.GetChild()] = e
+		}
+	}
+
+	for _, child := range children {
+		if _, ok := existing[child.ID]; ok {
+			delete(existing, child.ID)
+			continue
+		}
+		probe.graph.Link(parent, child, m)
+		existing[child.ID] = nil
+	}
+
+	for _, e := range existing {
+		if e != nil {
+			probe.graph.DelEdge(e)
+		}
+	}
+}
+
+func (probe *K8sProbe) linkOnce(parent, child *graph.Node, m graph.Metadata) {
+	if !probe.graph.AreLinked(parent, child, m) {
+		probe.graph.Link(parent, child, m)
+	}
+}
+
+// linkPod links a pod to the containers of the agents topology and to their
+// network namespace and interfaces
+func (probe *K8sProbe) linkPod(pod *k8sResource) {
+	var containers, network []*graph.Node
+
+	for _, container := range probe.graph.GetNodes(graph.Metadata{k8sPodUIDLabel: pod.object.Metadata.UID}) {
+		containers = append(containers, container)
+
+		for _, netns := range probe.graph.LookupParents(container, graph.Metadata{"Type": "netns"}, ownershipMetadata) {
+			network = append(network, netns)
+			network = append(network, probe.graph.LookupChildren(netns, graph.Metadata{"Type": "veth"}, ownershipMetadata)...)
+		}
+	}
+
+	probe.link(pod.node, containers, k8sContainerMetadata)
+	probe.link(pod.node, network, k8sNetworkMetadata)
+}
+
+func (probe *K8sProbe) linkEndpoints(endpoints *k8sResource) {
+	var pods []*graph.Node
+	for _, subset := range endpoints.object.Subsets {
+		for _, address := range subset.Addresses {
+			if ref := address.TargetRef; ref != nil && ref.Kind == "Pod" {
+				if pod, ok := probe.resources["pod"][ref.UID]; ok {
+					pods = append(pods, pod.node)
+				}
+			}
+		}
+	}
+	probe.link(endpoints.node, pods, k8sEndpointMetadata)
+}
+
+func (probe *K8sProbe) linkPolicy(policy *k8sResource) {
+	var pods []*graph.Node
+	for _, pod := range probe.resources["pod"] {
+		if pod.object.Metadata.Namespace == policy.object.Metadata.Namespace &&
+			k8sMatchLabels(policy.object.Spec.PodSelector.MatchLabels, pod.object.Metadata.Labels) {
+			pods = append(pods, pod.node)
+		}
+	}
+	probe.link(policy.node, pods, k8sPolicyMetadata)
+}
+
+func (probe *K8sProbe) linkHost(node *k8sResource) {
+	if host := probe.graph.LookupFirstNode(graph.Metadata{"Type": "host", "Name": node.object.Metadata.Name}); host != nil {
+		probe.linkOnce(node.node, host, k8sHostMetadata)
+	}
+}
+
+// linkResource creates the links of a new or updated resource
+func (probe *K8sProbe) linkResource(r *k8sResource) {
+	namespace := r.object.Metadata.Namespace
+	if namespace != "" {
+		if ns := probe.lookup("namespace", "", namespace); ns != nil {
+			probe.linkOnce(ns.node, r.node, k8sOwnershipMetadata)
+		}
+	}
+
+	switch r.kind {
+	case "namespace":
+		for kind, resources := range probe.resources {
+			if kind == "namespace" || kind == "node" {
+				continue
+			}
+			for _, child := range resources {
+				if child.object.Metadata.Namespace == r.object.Metadata.Name {
+					probe.linkOnce(r.node, child.node, k8sOwnershipMetadata)
+				}
+			}
+		}
+	case "node":
+		probe.linkHost(r)
+		for _, pod := range probe.resources["pod"] {
+			if pod.object.Spec.NodeName == r.object.Metadata.Name {
+				probe.linkOnce(r.node, pod.node, k8sSchedulingMetadata)
+			}
+		}
+	case "pod":
+		if node := probe.lookup("node", "", r.object.Spec.NodeName); node != nil {
+			probe.linkOnce(node.node, r.node, k8sSchedulingMetadata)
+		}
+		probe.linkPod(r)
+		for _, endpoints := range probe.resources["endpoints"] {
+			if endpoints.object.Metadata.Namespace == namespace {
+				probe.linkEndpoints(endpoints)
+			}
+		}
+		for _, policy := range probe.resources["networkpolicy"] {
+			if policy.object.Metadata.Namespace == namespace {
+				probe.linkPolicy(policy)
+			}
+		}
+	case "service":
+		if endpoints := probe.lookup("endpoints", namespace, r.object.Metadata.Name); endpoints != nil {
+			probe.linkOnce(r.node, endpoints.node, k8sOwnershipMetadata)
+		}
+	case "endpoints":
+		if service := probe.lookup("service", namespace, r.object.Metadata.Name); service != nil {
+			probe.linkOnce(service.node, r.node, k8sOwnershipMetadata)
+		}
+		probe.linkEndpoints(r)
+	case "networkpolicy":
+		probe.linkPolicy(r)
+	}
+}
+
+func (probe *K8sProbe) onObjectUpdated(kind string, object *k8sObject) {
+	uid := object.Metadata.UID
+	metadata := k8sMetadata(kind, object)
+
+	r, ok := probe.resources[kind][uid]
+	if ok {
+		r.object = object
+		probe.graph.SetMetadata(r.node, metadata)
+	} else {
+		logging.GetLogger().Debugf("Adding Kubernetes %s %s/%s", kind, object.Metadata.Namespace, object.Metadata.Name)
+		r = &k8sResource{
+			kind:   kind,
+			object: object,
+			node:   probe.graph.NewNode(graph.GenID(), metadata),
+		}
+		probe.resources[kind][uid] = r
+	}
+
+	probe.linkResource(r)
+}
+
+func (probe *K8sProbe) onObjectDeleted(kind string, uid string) {
+	if r, ok := probe.resources[kind][uid]; ok {
+		logging.GetLogger().Debugf("Removing Kubernetes %s %s/%s", kind, r.object.Metadata.Namespace, r.object.Metadata.Name)
+		probe.graph.DelNode(r.node)
+		delete(probe.resources[kind], uid)
+	}
+}
+
+func (probe *K8sProbe) podByUID(uid string) *k8sResource {
+	if uid == "" {
+		return nil
+	}
+	return probe.resources["pod"][uid]
+}
+
+// OnNodeAdded links the hosts of the agents to their Kubernetes node
+func (probe *K8sProbe) OnNodeAdded(n *graph.Node) {
+	if tp, _ := n.GetFieldString("Type"); tp == "host" {
+		name, _ := n.GetFieldString("Name")
+		if node := probe.lookup("node", "", name); node != nil {
+			probe.linkHost(node)
+		}
+	}
+}
+
+// OnEdgeAdded links pods to the containers and interfaces when they are
+// attached to the topology of an agent
+func (probe *K8sProbe) OnEdgeAdded(e *graph.Edge) {
+	if rl, _ := e.GetFieldString("RelationType"); rl != "ownership" {
+		return
+	}
+
+	parents, children := probe.graph.GetEdgeNodes(e, graph.Metadata{}, graph.Metadata{})
+	if len(parents) == 0 || len(children) == 0 {
+		return
+	}
+
+	uid, _ := children[0].GetFieldString(k8sPodUIDLabel)
+	if pod := probe.podByUID(uid); pod != nil {
+		probe.linkPod(pod)
+		return
+	}
+
+	if tp, _ := parents[0].GetFieldString("Type"); tp == "netns" {
+		for _, pod := range probe.graph.LookupParents(parents[0], graph.Metadata{"Type": "pod"}, k8sNetworkMetadata) {
+			uid, _ := pod.GetFieldString("K8s/UID")
+			if r := probe.podByUID(uid); r != nil {
+				probe.linkPod(r)
+			}
+		}
+	}
+}
+
+func (probe *K8sProbe) Start() {
+	if !atomic.CompareAndSwapInt64(&probe.state, common.StoppedState, common.RunningState) {
+		return
+	}
+
+	probe.graph.AddEventListener(probe)
+
+	probe.quit = make(chan struct{})
+	for _, k := range k8sKinds {
+		probe.wg.Add(1)
+		go probe.run(k.kind, k.path)
+	}
+}
+
+func (probe *K8sProbe) Stop() {
+	if !atomic.CompareAndSwapInt64(&probe.state, common.RunningState, common.StoppingState) {
+		return
+	}
+
+	close(probe.quit)
+	probe.wg.Wait()
+	probe.graph.RemoveEventListener(probe)
+
+	atomic.StoreInt64(&probe.state, common.StoppedState)
+}
+
+func NewK8sProbe(g *graph.Graph, url string, token string, client *http.Client) *K8sProbe {
+	probe := &K8sProbe{
+		graph:     g,
+		url:       strings.TrimSuffix(url, "/"),
+		token:     token,
+		client:    client,
+		state:     common.StoppedState,
+		resources: make(map[string]map[string]*k8sResource),
+	}
+
+	for _, k := range k8sKinds {
+		probe.resources[k.kind] = make(map[string]*k8sResource)
+	}
+
+	return probe
+}
+
+func NewK8sProbeFromConfig(g *graph.Graph) (*K8sProbe, error) {
+	url := config.GetConfig().GetString("k8s.url")
+
+	var token string
+	if tokenFile := config.GetConfig().GetString("k8s.token_file"); tokenFile != "" {
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read Kubernetes token: %s", err.Error())
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	tlsConfig := &tls.Config{}
+	if caFile := config.GetConfig().GetString("k8s.ca_file"); caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read Kubernetes CA: %s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificate found in %s", caFile)
+		}
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	return NewK8sProbe(g, url, token, client), nil
+}
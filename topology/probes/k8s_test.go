@@ -0,0 +1,285 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+type fakeK8sAPIServer struct {
+	sync.Mutex
+	*httptest.Server
+	lists      map[string][]interface{}
+	events     map[string]chan interface{}
+	watches    map[string]int
+	closeWatch bool
+}
+
+func (f *fakeK8sAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	items, ok := f.lists[r.URL.Path]
+	events := f.events[r.URL.Path]
+	closeWatch := f.closeWatch
+	if r.URL.Query().Get("watch") != "" {
+		f.watches[r.URL.Path]++
+	}
+	f.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("watch") == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]string{"resourceVersion": "1"},
+			"items":    items,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	if closeWatch {
+		return
+	}
+
+	closed := w.(http.CloseNotifier).CloseNotify()
+	for {
+		select {
+		case event := <-events:
+			json.NewEncoder(w).Encode(event)
+			w.(http.Flusher).Flush()
+		case <-closed:
+			return
+		}
+	}
+}
+
+func (f *fakeK8sAPIServer) send(path string, kind string, object interface{}) {
+	f.events[path] <- map[string]interface{}{"type": kind, "object": object}
+}
+
+func newFakeK8sAPIServer() *fakeK8sAPIServer {
+	f := &fakeK8sAPIServer{
+		lists:   make(map[string][]interface{}),
+		events:  make(map[string]chan interface{}),
+		watches: make(map[string]int),
+	}
+	for _, k := range k8sKinds {
+		f.lists[k.path] = []interface{}{}
+		f.events[k.path] = make(chan interface{}, 10)
+	}
+	f.Server = httptest.NewServer(f)
+	return f
+}
+
+func k8sTestObject(namespace, name, uid, labels, fields string) interface{} {
+	var object map[string]interface{}
+	js := fmt.Sprintf(`{"metadata": {"name": "%s", "namespace": "%s", "uid": "%s", "resourceVersion": "2", "labels": {%s}}%s}`, name, namespace, uid, labels, fields)
+	if err := json.Unmarshal([]byte(js), &object); err != nil {
+		panic(err)
+	}
+	return object
+}
+
+func k8sTestPod(labels string) interface{} {
+	return k8sTestObject("default", "web-1", "pod-1", labels, `, "spec": {"nodeName": "node1"}, "status": {"podIP": "10.0.0.2"}`)
+}
+
+func waitK8sGraph(t *testing.T, g *graph.Graph, msg string, check func() bool) {
+	for i := 0; i < 50; i++ {
+		g.RLock()
+		ok := check()
+		g.RUnlock()
+		if ok {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Timeout waiting for %s", msg)
+}
+
+func TestK8sProbe(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("analyzer", b)
+
+	g.Lock()
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "node1"})
+	netns := g.NewNode(graph.GenID(), graph.Metadata{"Type": "netns", "Name": "k8s_POD_web-1", "Path": "/proc/42/ns/net", "Manager": "docker"})
+	g.Link(host, netns, ownershipMetadata)
+	veth := g.NewNode(graph.GenID(), graph.Metadata{"Type": "veth", "Name": "eth0"})
+	g.Link(netns, veth, ownershipMetadata)
+	g.Unlock()
+
+	f := newFakeK8sAPIServer()
+	defer f.Close()
+
+	f.lists["/api/v1/namespaces"] = []interface{}{k8sTestObject("", "default", "ns-1", "", `, "status": {"phase": "Active"}`)}
+	f.lists["/api/v1/nodes"] = []interface{}{k8sTestObject("", "node1", "node-1", "", `, "status": {"addresses": [{"type": "InternalIP", "address": "192.168.0.1"}]}`)}
+	f.lists["/api/v1/pods"] = []interface{}{k8sTestPod(`"app": "web"`)}
+	f.lists["/api/v1/services"] = []interface{}{k8sTestObject("default", "web", "svc-1", "", `, "spec": {"clusterIP": "10.96.0.10", "selector": {"app": "web"}, "ports": [{"port": 80, "protocol": "TCP"}]}`)}
+	f.lists["/api/v1/endpoints"] = []interface{}{k8sTestObject("default", "web", "ep-1", "", `, "subsets": [{"addresses": [{"ip": "10.0.0.2", "targetRef": {"kind": "Pod", "uid": "pod-1"}}], "ports": [{"port": 80, "protocol": "TCP"}]}]`)}
+	f.lists["/apis/networking.k8s.io/v1/networkpolicies"] = []interface{}{k8sTestObject("default", "deny-web", "np-1", "", `, "spec": {"podSelector": {"matchLabels": {"app": "web"}}, "policyTypes": ["Ingress"]}`)}
+
+	probe := NewK8sProbe(g, f.URL, "", http.DefaultClient)
+	probe.Start()
+	defer probe.Stop()
+
+	var pod *graph.Node
+	waitK8sGraph(t, g, "pod", func() bool {
+		pod = g.LookupFirstNode(graph.Metadata{"Type": "pod", "K8s/Labels/app": "web"})
+		return pod != nil && g.LookupFirstNode(graph.Metadata{"Type": "networkpolicy"}) != nil
+	})
+
+	g.RLock()
+	if ip, _ := pod.GetFieldString("K8s/IP"); ip != "10.0.0.2" {
+		t.Errorf("Wrong pod IP: %s", ip)
+	}
+	g.RUnlock()
+
+	linked := func(parent graph.Metadata, child *graph.Node, m graph.Metadata) func() bool {
+		return func() bool {
+			node := g.LookupFirstNode(parent)
+			return node != nil && g.AreLinked(node, child, m)
+		}
+	}
+
+	waitK8sGraph(t, g, "namespace link", linked(graph.Metadata{"Type": "namespace"}, pod, k8sOwnershipMetadata))
+	waitK8sGraph(t, g, "node link", linked(graph.Metadata{"Type": "node"}, pod, k8sSchedulingMetadata))
+	waitK8sGraph(t, g, "host link", linked(graph.Metadata{"Type": "node"}, host, k8sHostMetadata))
+	waitK8sGraph(t, g, "endpoints link", linked(graph.Metadata{"Type": "endpoints"}, pod, k8sEndpointMetadata))
+	waitK8sGraph(t, g, "policy link", linked(graph.Metadata{"Type": "networkpolicy"}, pod, k8sPolicyMetadata))
+
+	g.RLock()
+	service := g.LookupFirstNode(graph.Metadata{"Type": "service"})
+	endpoints := g.LookupFirstNode(graph.Metadata{"Type": "endpoints"})
+	if service == nil || endpoints == nil || !g.AreLinked(service, endpoints, k8sOwnershipMetadata) {
+		t.Error("Service should be linked to its endpoints")
+	}
+	if ports, _ := service.GetFieldString("K8s/Ports"); ports != "80/TCP" {
+		t.Errorf("Wrong service ports: %s", ports)
+	}
+	g.RUnlock()
+
+	// container started by the kubelet after the pod was created
+	g.Lock()
+	container := g.NewNode(graph.GenID(), graph.Metadata{"Type": "container", "Name": "k8s_POD_web-1", k8sPodUIDLabel: "pod-1"})
+	g.Link(netns, container, ownershipMetadata)
+	g.Unlock()
+
+	g.RLock()
+	if !g.AreLinked(pod, container, k8sContainerMetadata) || !g.AreLinked(pod, netns, k8sNetworkMetadata) {
+		t.Error("Pod should be linked to its container and namespace")
+	}
+	g.RUnlock()
+
+	// interface added to the pod namespace later on
+	g.Lock()
+	eth1 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "veth", "Name": "eth1"})
+	g.Link(netns, eth1, ownershipMetadata)
+	g.Unlock()
+
+	g.RLock()
+	if !g.AreLinked(pod, veth, k8sNetworkMetadata) || !g.AreLinked(pod, eth1, k8sNetworkMetadata) {
+		t.Error("Pod should be linked to the interfaces of its namespace")
+	}
+	g.RUnlock()
+
+	f.send("/api/v1/pods", "MODIFIED", k8sTestPod(`"app": "db"`))
+	waitK8sGraph(t, g, "label update", func() bool {
+		app, _ := pod.GetFieldString("K8s/Labels/app")
+		return app == "db"
+	})
+	waitK8sGraph(t, g, "policy unlink", func() bool {
+		return !linked(graph.Metadata{"Type": "networkpolicy"}, pod, k8sPolicyMetadata)()
+	})
+
+	f.send("/api/v1/pods", "DELETED", k8sTestPod(""))
+	waitK8sGraph(t, g, "pod deletion", func() bool {
+		return g.LookupFirstNode(graph.Metadata{"Type": "pod"}) == nil
+	})
+}
+
+func TestK8sProbeRelist(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("analyzer", b)
+
+	f := newFakeK8sAPIServer()
+	defer f.Close()
+
+	f.lists["/api/v1/namespaces"] = []interface{}{k8sTestObject("", "default", "ns-1", "", "")}
+
+	probe := NewK8sProbe(g, f.URL, "", http.DefaultClient)
+	probe.Start()
+	defer probe.Stop()
+
+	waitK8sGraph(t, g, "namespace", func() bool {
+		return g.LookupFirstNode(graph.Metadata{"Type": "namespace", "Name": "default"}) != nil
+	})
+
+	// the namespace is removed while the watch is expired
+	f.Lock()
+	f.lists["/api/v1/namespaces"] = []interface{}{k8sTestObject("", "kube-system", "ns-2", "", "")}
+	f.Unlock()
+	f.send("/api/v1/namespaces", "ERROR", map[string]interface{}{"kind": "Status", "code": 410, "message": "too old resource version"})
+
+	waitK8sGraph(t, g, "relist", func() bool {
+		return g.LookupFirstNode(graph.Metadata{"Type": "namespace", "Name": "default"}) == nil &&
+			g.LookupFirstNode(graph.Metadata{"Type": "namespace", "Name": "kube-system"}) != nil
+	})
+}
+
+func TestK8sProbeWatchBackoff(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("analyzer", b)
+
+	f := newFakeK8sAPIServer()
+	defer f.Close()
+
+	// the server closes the watch streams right away
+	f.closeWatch = true
+
+	probe := NewK8sProbe(g, f.URL, "", http.DefaultClient)
+	probe.Start()
+	time.Sleep(1500 * time.Millisecond)
+	probe.Stop()
+
+	f.Lock()
+	watches := f.watches["/api/v1/namespaces"]
+	f.Unlock()
+
+	if watches == 0 || watches > 2 {
+		t.Errorf("Expected at most 2 watches with the backoff, got %d", watches)
+	}
+}
@@ -0,0 +1,448 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// lldpFilter accepts LLDP frames and frames sent to the CDP multicast
+// address 01:00:0c:cc:cc:cc
+var lldpFilter = []bpf.Instruction{
+	bpf.LoadAbsolute{Off: 12, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(layers.EthernetTypeLinkLayerDiscovery), SkipTrue: 4},
+	bpf.LoadAbsolute{Off: 0, Size: 4},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x01000ccc, SkipFalse: 3},
+	bpf.LoadAbsolute{Off: 4, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0xcccc, SkipFalse: 1},
+	bpf.RetConstant{Val: 0x40000},
+	bpf.RetConstant{Val: 0},
+}
+
+// the discovery frames being small and rare, the capture ring is kept to a
+// few frames instead of the default one of several megabytes
+const (
+	lldpFrameSize = 2048
+	lldpNumBlocks = 4
+)
+
+// lldpMulticastAddrs are the destinations of the LLDP and CDP frames, that
+// the interfaces have to accept even when not in promiscuous mode
+var lldpMulticastAddrs = []net.HardwareAddr{
+	{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e},
+	{0x01, 0x00, 0x0c, 0xcc, 0xcc, 0xcc},
+}
+
+// packetMreq is the packet_mreq structure of the PACKET_ADD_MEMBERSHIP
+// socket option
+type packetMreq struct {
+	ifindex int32
+	mrType  uint16
+	alen    uint16
+	address [8]byte
+}
+
+var lldpChassisIDTypes = map[layers.LLDPChassisIDSubType]string{
+	layers.LLDPChassisIDSubTypeChassisComp: "ChassisComponent",
+	layers.LLDPChassisIDSubtypeIfaceAlias:  "InterfaceAlias",
+	layers.LLDPChassisIDSubTypePortComp:    "PortComponent",
+	layers.LLDPChassisIDSubTypeMACAddr:     "MAC",
+	layers.LLDPChassisIDSubTypeNetworkAddr: "NetworkAddress",
+	layers.LLDPChassisIDSubtypeIfaceName:   "InterfaceName",
+	layers.LLDPChassisIDSubTypeLocal:       "Local",
+}
+
+var lldpPortIDTypes = map[layers.LLDPPortIDSubType]string{
+	layers.LLDPPortIDSubtypeIfaceAlias:     "InterfaceAlias",
+	layers.LLDPPortIDSubtypePortComp:       "PortComponent",
+	layers.LLDPPortIDSubtypeMACAddr:        "MAC",
+	layers.LLDPPortIDSubtypeNetworkAddr:    "NetworkAddress",
+	layers.LLDPPortIDSubtypeIfaceName:      "InterfaceName",
+	layers.LLDPPortIDSubtypeAgentCircuitID: "AgentCircuitID",
+	layers.LLDPPortIDSubtypeLocal:          "Local",
+}
+
+// LLDPProbe listens for the LLDP and CDP frames received by the physical
+// interfaces of the host and reports the remote switch and port in the
+// LLDP/ and CDP/ metadata of the interfaces
+type LLDPProbe struct {
+	sync.RWMutex
+	graph.DefaultGraphListener
+	Graph      *graph.Graph
+	Root       *graph.Node
+	interfaces map[string]bool
+	captures   map[graph.Identifier]*lldpCapture
+	state      int64
+	wg         sync.WaitGroup
+}
+
+type lldpCapture struct {
+	ifName  string
+	state   int64
+	expires map[string]time.Time
+}
+
+func ianaAddress(family layers.IANAAddressFamily, addr []byte) string {
+	if (family == layers.IANAAddressFamilyIPV4 && len(addr) == net.IPv4len) ||
+		(family == layers.IANAAddressFamilyIPV6 && len(addr) == net.IPv6len) {
+		return net.IP(addr).String()
+	}
+	return ""
+}
+
+// discoveryID returns the printable form of a LLDP chassis or port ID
+func discoveryID(id []byte, subtype string) string {
+	switch subtype {
+	case "MAC":
+		if len(id) == 6 {
+			return net.HardwareAddr(id).String()
+		}
+	case "NetworkAddress":
+		if len(id) > 1 {
+			return ianaAddress(layers.IANAAddressFamily(id[0]), id[1:])
+		}
+	}
+	return string(id)
+}
+
+func addDiscoveryField(m graph.Metadata, k string, v string) {
+	if v = strings.TrimSpace(v); v != "" {
+		m[k] = v
+	}
+}
+
+// discoveryMetadata decodes a LLDP or CDP frame. It returns the metadata
+// namespace, the metadata of the remote switch and port and how long they
+// are valid.
+func discoveryMetadata(packet gopacket.Packet) (string, graph.Metadata, time.Duration) {
+	if layer := packet.Layer(layers.LayerTypeLinkLayerDiscovery); layer != nil {
+		lldp := layer.(*layers.LinkLayerDiscovery)
+
+		chassisType := lldpChassisIDTypes[lldp.ChassisID.Subtype]
+		portType := lldpPortIDTypes[lldp.PortID.Subtype]
+
+		m := graph.Metadata{"LLDP/TTL": int64(lldp.TTL)}
+		addDiscoveryField(m, "LLDP/ChassisID", discoveryID(lldp.ChassisID.ID, chassisType))
+		addDiscoveryField(m, "LLDP/ChassisIDType", chassisType)
+		addDiscoveryField(m, "LLDP/PortID", discoveryID(lldp.PortID.ID, portType))
+		addDiscoveryField(m, "LLDP/PortIDType", portType)
+
+		if layer := packet.Layer(layers.LayerTypeLinkLayerDiscoveryInfo); layer != nil {
+			info := layer.(*layers.LinkLayerDiscoveryInfo)
+			addDiscoveryField(m, "LLDP/SysName", info.SysName)
+			addDiscoveryField(m, "LLDP/SysDescription", info.SysDescription)
+			addDiscoveryField(m, "LLDP/PortDescription", info.PortDescription)
+			addDiscoveryField(m, "LLDP/MgmtAddress", ianaAddress(info.MgmtAddress.Subtype, info.MgmtAddress.Address))
+		}
+
+		return "LLDP", m, time.Duration(lldp.TTL) * time.Second
+	}
+
+	if layer := packet.Layer(layers.LayerTypeCiscoDiscovery); layer != nil {
+		cdp := layer.(*layers.CiscoDiscovery)
+
+		m := graph.Metadata{"CDP/TTL": int64(cdp.TTL)}
+		if layer := packet.Layer(layers.LayerTypeCiscoDiscoveryInfo); layer != nil {
+			info := layer.(*layers.CiscoDiscoveryInfo)
+			addDiscoveryField(m, "CDP/DeviceID", info.DeviceID)
+			addDiscoveryField(m, "CDP/PortID", info.PortID)
+			addDiscoveryField(m, "CDP/Platform", info.Platform)
+			addDiscoveryField(m, "CDP/Version", info.Version)
+
+			if len(info.MgmtAddresses) > 0 {
+				m["CDP/MgmtAddress"] = info.MgmtAddresses[0].String()
+			} else if len(info.Addresses) > 0 {
+				m["CDP/MgmtAddress"] = info.Addresses[0].String()
+			}
+			if info.NativeVLAN != 0 {
+				m["CDP/NativeVLAN"] = int64(info.NativeVLAN)
+			}
+		}
+
+		return "CDP", m, time.Duration(cdp.TTL) * time.Second
+	}
+
+	return "", nil, 0
+}
+
+// setDiscoveryMetadata replaces the metadata of the given namespace, nil
+// metadata removing them. Graph lock has to be held.
+func setDiscoveryMetadata(g *graph.Graph, n *graph.Node, namespace string, m graph.Metadata) {
+	metadata := n.Metadata()
+	for k := range metadata {
+		if strings.HasPrefix(k, namespace+"/") {
+			delete(metadata, k)
+		}
+	}
+	for k, v := range m {
+		metadata[k] = v
+	}
+	g.SetMetadata(n, metadata)
+}
+
+func (c *lldpCapture) update(g *graph.Graph, id graph.Identifier, namespace string, m graph.Metadata, ttl time.Duration) {
+	g.Lock()
+	defer g.Unlock()
+
+	n := g.GetNode(id)
+	if n == nil {
+		return
+	}
+
+	// a null TTL means that the remote port is shutting down
+	if ttl == 0 {
+		delete(c.expires, namespace)
+		m = nil
+	} else {
+		c.expires[namespace] = time.Now().Add(ttl)
+	}
+
+	setDiscoveryMetadata(g, n, namespace, m)
+}
+
+func (c *lldpCapture) expire(g *graph.Graph, id graph.Identifier) {
+	now := time.Now()
+	for namespace, expire := range c.expires {
+		if now.After(expire) {
+			logging.GetLogger().Debugf("%s information of %s expired", namespace, c.ifName)
+			c.update(g, id, namespace, nil, 0)
+		}
+	}
+}
+
+// joinDiscoveryGroups adds the LLDP and CDP multicast addresses to the
+// interface. The memberships last as long as the returned socket is open,
+// the socket not receiving any frame by itself.
+func joinDiscoveryGroups(ifName string) (int, error) {
+	intf, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return -1, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	for _, addr := range lldpMulticastAddrs {
+		mreq := packetMreq{
+			ifindex: int32(intf.Index),
+			mrType:  syscall.PACKET_MR_MULTICAST,
+			alen:    uint16(len(addr)),
+		}
+		copy(mreq.address[:], addr)
+
+		_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP,
+			uintptr(unsafe.Pointer(&mreq)), unsafe.Sizeof(mreq), 0)
+		if errno != 0 {
+			syscall.Close(fd)
+			return -1, errno
+		}
+	}
+
+	return fd, nil
+}
+
+func (c *lldpCapture) run(g *graph.Graph, id graph.Identifier, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	filter, err := bpf.Assemble(lldpFilter)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to assemble LLDP filter: %s", err.Error())
+		return
+	}
+
+	tpacket, err := afpacket.NewTPacket(
+		afpacket.OptInterface(c.ifName),
+		afpacket.OptFrameSize(lldpFrameSize),
+		afpacket.OptBlockSize(os.Getpagesize()),
+		afpacket.OptNumBlocks(lldpNumBlocks),
+		afpacket.OptPollTimeout(1*time.Second),
+	)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to listen for LLDP frames on %s: %s", c.ifName, err.Error())
+		return
+	}
+	defer tpacket.Close()
+
+	if err = tpacket.SetBPF(filter); err != nil {
+		logging.GetLogger().Errorf("Unable to set LLDP filter on %s: %s", c.ifName, err.Error())
+		return
+	}
+
+	fd, err := joinDiscoveryGroups(c.ifName)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to join the LLDP and CDP multicast groups on %s: %s", c.ifName, err.Error())
+		return
+	}
+	defer syscall.Close(fd)
+
+	logging.GetLogger().Infof("Listening for LLDP and CDP frames on %s", c.ifName)
+
+	for atomic.LoadInt64(&c.state) == common.RunningState {
+		data, _, err := tpacket.ReadPacketData()
+		switch err {
+		case nil:
+			packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+			if namespace, m, ttl := discoveryMetadata(packet); namespace != "" {
+				c.update(g, id, namespace, m, ttl)
+			}
+		case afpacket.ErrTimeout:
+		default:
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		c.expire(g, id)
+	}
+}
+
+// isPhysical returns whether LLDP frames have to be listened on the given
+// interface, either the configured ones or the physical ethernet interfaces
+func (probe *LLDPProbe) isPhysical(n *graph.Node) bool {
+	name, _ := n.GetFieldString("Name")
+	if len(probe.interfaces) > 0 {
+		return probe.interfaces[name]
+	}
+
+	tp, _ := n.GetFieldString("Type")
+	encapType, _ := n.GetFieldString("EncapType")
+	return name != "" && tp == "device" && encapType == "ether"
+}
+
+func (probe *LLDPProbe) startCapture(n *graph.Node) {
+	if !probe.isPhysical(n) {
+		return
+	}
+
+	probe.Lock()
+	defer probe.Unlock()
+
+	if _, ok := probe.captures[n.ID]; ok || atomic.LoadInt64(&probe.state) != common.RunningState {
+		return
+	}
+
+	name, _ := n.GetFieldString("Name")
+	c := &lldpCapture{
+		ifName:  name,
+		state:   common.RunningState,
+		expires: make(map[string]time.Time),
+	}
+	probe.captures[n.ID] = c
+
+	probe.wg.Add(1)
+	go c.run(probe.Graph, n.ID, &probe.wg)
+}
+
+func (probe *LLDPProbe) OnEdgeAdded(e *graph.Edge) {
+	if e.GetParent() != probe.Root.ID {
+		return
+	}
+
+	if relation, _ := e.GetFieldString("RelationType"); relation == "ownership" {
+		if n := probe.Graph.GetNode(e.GetChild()); n != nil {
+			probe.startCapture(n)
+		}
+	}
+}
+
+func (probe *LLDPProbe) OnNodeDeleted(n *graph.Node) {
+	probe.Lock()
+	defer probe.Unlock()
+
+	if c, ok := probe.captures[n.ID]; ok {
+		atomic.StoreInt64(&c.state, common.StoppingState)
+		delete(probe.captures, n.ID)
+	}
+}
+
+func (probe *LLDPProbe) Start() {
+	if !atomic.CompareAndSwapInt64(&probe.state, common.StoppedState, common.RunningState) {
+		return
+	}
+
+	probe.Graph.AddEventListener(probe)
+
+	probe.Graph.RLock()
+	for _, n := range probe.Graph.LookupChildren(probe.Root, graph.Metadata{}, ownershipMetadata) {
+		probe.startCapture(n)
+	}
+	probe.Graph.RUnlock()
+}
+
+func (probe *LLDPProbe) Stop() {
+	if !atomic.CompareAndSwapInt64(&probe.state, common.RunningState, common.StoppingState) {
+		return
+	}
+
+	probe.Graph.RemoveEventListener(probe)
+
+	probe.Lock()
+	for id, c := range probe.captures {
+		atomic.StoreInt64(&c.state, common.StoppingState)
+		delete(probe.captures, id)
+	}
+	probe.Unlock()
+
+	probe.wg.Wait()
+
+	atomic.StoreInt64(&probe.state, common.StoppedState)
+}
+
+func NewLLDPProbe(g *graph.Graph, n *graph.Node, interfaces []string) *LLDPProbe {
+	probe := &LLDPProbe{
+		Graph:      g,
+		Root:       n,
+		interfaces: make(map[string]bool),
+		captures:   make(map[graph.Identifier]*lldpCapture),
+		state:      common.StoppedState,
+	}
+
+	for _, name := range interfaces {
+		probe.interfaces[name] = true
+	}
+
+	return probe
+}
+
+func NewLLDPProbeFromConfig(g *graph.Graph, n *graph.Node) *LLDPProbe {
+	interfaces := config.GetConfig().GetStringSlice("agent.topology.lldp.interfaces")
+	return NewLLDPProbe(g, n, interfaces)
+}
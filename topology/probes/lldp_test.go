@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const (
+	lldpFrame = "0180c200000e00112233440188cc020704001122334400040d0545746865726e6574312f3132" +
+		"06020078080c746f20636f6d707574652d310a04746f72310c0e5465737420537769746368204f53" +
+		"100c05010a0000010200000000000000"
+	cdpFrame = "01000ccccccc001122334402005faaaa0300000c200002b4000000010008746f7232000200110000" +
+		"00010101cc00040a000002000300164769676162697445746865726e6574302f310005000c494f53" +
+		"2031352e3200060012636973636f2057532d4332393630000a0006000a"
+)
+
+func decodeFrame(t *testing.T, frame string) gopacket.Packet {
+	data, err := hex.DecodeString(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func TestDiscoveryMetadata(t *testing.T) {
+	namespace, m, ttl := discoveryMetadata(decodeFrame(t, lldpFrame))
+	if namespace != "LLDP" || ttl != 120*time.Second {
+		t.Fatalf("Wrong LLDP frame decoding: %s %s", namespace, ttl)
+	}
+
+	expected := graph.Metadata{
+		"LLDP/ChassisID":       "00:11:22:33:44:00",
+		"LLDP/ChassisIDType":   "MAC",
+		"LLDP/PortID":          "Ethernet1/12",
+		"LLDP/PortIDType":      "InterfaceName",
+		"LLDP/TTL":             int64(120),
+		"LLDP/SysName":         "tor1",
+		"LLDP/SysDescription":  "Test Switch OS",
+		"LLDP/PortDescription": "to compute-1",
+		"LLDP/MgmtAddress":     "10.0.0.1",
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m[k])
+		}
+	}
+
+	namespace, m, ttl = discoveryMetadata(decodeFrame(t, cdpFrame))
+	if namespace != "CDP" || ttl != 180*time.Second {
+		t.Fatalf("Wrong CDP frame decoding: %s %s", namespace, ttl)
+	}
+
+	expected = graph.Metadata{
+		"CDP/DeviceID":    "tor2",
+		"CDP/PortID":      "GigabitEthernet0/1",
+		"CDP/Platform":    "cisco WS-C2960",
+		"CDP/Version":     "IOS 15.2",
+		"CDP/MgmtAddress": "10.0.0.2",
+		"CDP/NativeVLAN":  int64(10),
+		"CDP/TTL":         int64(180),
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m[k])
+		}
+	}
+}
+
+func TestLLDPCaptureExpire(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("host1", b)
+
+	g.Lock()
+	n := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "LLDP/PortID": "old", "LLDP/SysName": "old"})
+	g.Unlock()
+
+	c := &lldpCapture{ifName: "eth0", expires: make(map[string]time.Time)}
+
+	_, m, _ := discoveryMetadata(decodeFrame(t, lldpFrame))
+	c.update(g, n.ID, "LLDP", m, time.Second)
+
+	if name, _ := n.GetFieldString("LLDP/SysName"); name != "tor1" {
+		t.Errorf("LLDP metadata not updated: %v", n.Metadata())
+	}
+	if _, err := n.GetFieldString("LLDP/PortDescription"); err != nil {
+		t.Errorf("LLDP metadata not updated: %v", n.Metadata())
+	}
+
+	c.expires["LLDP"] = time.Now().Add(-time.Second)
+	c.expire(g, n.ID)
+
+	for k := range n.Metadata() {
+		if k != "Name" {
+			t.Errorf("Expired LLDP metadata not removed: %s", k)
+		}
+	}
+	if len(c.expires) != 0 {
+		t.Errorf("Expired LLDP information should be forgotten: %v", c.expires)
+	}
+}
@@ -0,0 +1,310 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// NeighborProbe links the interfaces to the interfaces owning the MAC
+// addresses of their ARP/NDP and forwarding database entries, including
+// the ones of other hosts.
+type NeighborProbe struct {
+	graph.DefaultGraphListener
+	graph   *graph.Graph
+	learnt  map[string]map[graph.Identifier]bool
+	sources map[graph.Identifier]map[string]learntMAC
+	owners  map[string]map[graph.Identifier]bool
+	owned   map[graph.Identifier]ownedMAC
+}
+
+type learntMAC struct {
+	mac      string
+	ip       string
+	metadata graph.Metadata
+}
+
+// ownedMAC is the MAC address of an interface with the attributes used to
+// choose between interfaces sharing the same address
+type ownedMAC struct {
+	mac  string
+	ipv4 string
+	ipv6 string
+}
+
+// learntMACs returns the remote MAC addresses known by an interface indexed
+// by the prefix of their entry
+func learntMACs(n *graph.Node) map[string]learntMAC {
+	macs := make(map[string]learntMAC)
+	for k, v := range n.Metadata() {
+		mac, ok := v.(string)
+		if !ok || !strings.HasSuffix(k, "/MAC") || !isNeighborMetadata(k) {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(k, "MAC")
+		state, _ := n.GetFieldString(prefix + "State")
+
+		if strings.HasPrefix(k, "FDB/") {
+			m := graph.Metadata{"RelationType": "fdb", "State": state}
+			if vlan, err := n.GetFieldInt64(prefix + "Vlan"); err == nil {
+				m["Vlan"] = vlan
+			}
+			macs[prefix] = learntMAC{mac: mac, metadata: m}
+		} else {
+			ip := strings.TrimSuffix(strings.TrimPrefix(prefix, "Neighbors/"), "/")
+			m := graph.Metadata{"RelationType": "neighbor", "IP": ip, "State": state}
+			macs[prefix] = learntMAC{mac: mac, ip: ip, metadata: m}
+		}
+	}
+
+	return macs
+}
+
+func nodeOwnedMAC(n *graph.Node) ownedMAC {
+	var o ownedMAC
+	o.mac, _ = n.GetFieldString("MAC")
+	o.ipv4, _ = n.GetFieldString("IPV4")
+	o.ipv6, _ = n.GetFieldString("IPV6")
+	return o
+}
+
+func hasIP(n *graph.Node, ip string) bool {
+	for _, key := range []string{"IPV4", "IPV6"} {
+		if ips, _ := n.GetFieldString(key); ips != "" {
+			for _, cidr := range strings.Split(ips, ",") {
+				if strings.SplitN(cidr, "/", 2)[0] == ip {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// lookupLearntMAC returns the interface owning a learnt MAC address, using
+// the IP address and the host of the source interface to choose between
+// interfaces sharing the same MAC address
+func (p *NeighborProbe) lookupLearntMAC(source *graph.Node, l learntMAC) *graph.Node {
+	var candidates []*graph.Node
+	for id := range p.owners[l.mac] {
+		if id != source.ID {
+			if n := p.graph.GetNode(id); n != nil {
+				candidates = append(candidates, n)
+			}
+		}
+	}
+
+	for _, filter := range []func(n *graph.Node) bool{
+		func(n *graph.Node) bool { return l.ip != "" && hasIP(n, l.ip) },
+		func(n *graph.Node) bool { return n.Host() == source.Host() },
+	} {
+		if len(candidates) <= 1 {
+			break
+		}
+
+		var filtered []*graph.Node
+		for _, n := range candidates {
+			if filter(n) {
+				filtered = append(filtered, n)
+			}
+		}
+		if len(filtered) > 0 {
+			candidates = filtered
+		}
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	return nil
+}
+
+func (p *NeighborProbe) learntEdges(n *graph.Node) (edges []*graph.Edge) {
+	for _, relation := range []string{"neighbor", "fdb"} {
+		for _, e := range p.graph.GetNodeEdges(n, graph.Metadata{"RelationType": relation}) {
+			if e.GetParent() == n.ID {
+				edges = append(edges, e)
+			}
+		}
+	}
+	return edges
+}
+
+func (p *NeighborProbe) unregisterSource(id graph.Identifier) {
+	for _, l := range p.sources[id] {
+		delete(p.learnt[l.mac], id)
+		if len(p.learnt[l.mac]) == 0 {
+			delete(p.learnt, l.mac)
+		}
+	}
+	delete(p.sources, id)
+}
+
+// updateLearntLinks synchronizes the links of an interface with its
+// neighbor and forwarding database entries
+func (p *NeighborProbe) updateLearntLinks(n *graph.Node, macs map[string]learntMAC) {
+	p.unregisterSource(n.ID)
+	if len(macs) > 0 {
+		p.sources[n.ID] = macs
+	}
+	for _, l := range macs {
+		if _, ok := p.learnt[l.mac]; !ok {
+			p.learnt[l.mac] = make(map[graph.Identifier]bool)
+		}
+		p.learnt[l.mac][n.ID] = true
+	}
+
+	existing := make(map[string]*graph.Edge)
+	for _, e := range p.learntEdges(n) {
+		relation, _ := e.GetFieldString("RelationType")
+		existing[relation+"/"+string(e.GetChild())] = e
+	}
+
+	for _, l := range macs {
+		target := p.lookupLearntMAC(n, l)
+		if target == nil {
+			continue
+		}
+
+		key := l.metadata["RelationType"].(string) + "/" + string(target.ID)
+		if e, ok := existing[key]; ok {
+			if e != nil {
+				p.graph.SetMetadata(e, l.metadata)
+				existing[key] = nil
+			}
+			continue
+		}
+		p.graph.Link(n, target, l.metadata)
+		existing[key] = nil
+	}
+
+	for _, e := range existing {
+		if e != nil {
+			p.graph.DelEdge(e)
+		}
+	}
+}
+
+// relinkLearners updates the links of the interfaces having learnt a MAC
+// address whose owners changed
+func (p *NeighborProbe) relinkLearners(mac string) {
+	var ids []graph.Identifier
+	for id := range p.learnt[mac] {
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		if source := p.graph.GetNode(id); source != nil {
+			p.updateLearntLinks(source, p.sources[id])
+		}
+	}
+}
+
+func (p *NeighborProbe) unregisterOwner(id graph.Identifier) string {
+	o, ok := p.owned[id]
+	if !ok {
+		return ""
+	}
+
+	delete(p.owners[o.mac], id)
+	if len(p.owners[o.mac]) == 0 {
+		delete(p.owners, o.mac)
+	}
+	delete(p.owned, id)
+
+	return o.mac
+}
+
+func (p *NeighborProbe) onNodeEvent(n *graph.Node) {
+	// the entries learnt by the interface changed
+	macs := learntMACs(n)
+	if !reflect.DeepEqual(macs, p.sources[n.ID]) && (len(macs) > 0 || len(p.sources[n.ID]) > 0) {
+		p.updateLearntLinks(n, macs)
+	}
+
+	// the interface may own a MAC address learnt by other interfaces
+	owned := nodeOwnedMAC(n)
+	if previous, ok := p.owned[n.ID]; ok && previous == owned {
+		return
+	}
+
+	previous := p.unregisterOwner(n.ID)
+	if owned.mac != "" {
+		if _, ok := p.owners[owned.mac]; !ok {
+			p.owners[owned.mac] = make(map[graph.Identifier]bool)
+		}
+		p.owners[owned.mac][n.ID] = true
+		p.owned[n.ID] = owned
+	}
+
+	if previous != "" && previous != owned.mac {
+		p.relinkLearners(previous)
+	}
+	if owned.mac != "" {
+		p.relinkLearners(owned.mac)
+	}
+}
+
+func (p *NeighborProbe) OnNodeUpdated(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+func (p *NeighborProbe) OnNodeAdded(n *graph.Node) {
+	p.onNodeEvent(n)
+}
+
+func (p *NeighborProbe) OnNodeDeleted(n *graph.Node) {
+	p.unregisterSource(n.ID)
+	if mac := p.unregisterOwner(n.ID); mac != "" {
+		p.relinkLearners(mac)
+	}
+}
+
+func (p *NeighborProbe) Start() {
+	p.graph.AddEventListener(p)
+
+	p.graph.Lock()
+	defer p.graph.Unlock()
+
+	for _, n := range p.graph.GetNodes(graph.Metadata{}) {
+		p.onNodeEvent(n)
+	}
+}
+
+func (p *NeighborProbe) Stop() {
+	p.graph.RemoveEventListener(p)
+}
+
+func NewNeighborProbe(g *graph.Graph) *NeighborProbe {
+	return &NeighborProbe{
+		graph:   g,
+		learnt:  make(map[string]map[graph.Identifier]bool),
+		sources: make(map[graph.Identifier]map[string]learntMAC),
+		owners:  make(map[string]map[graph.Identifier]bool),
+		owned:   make(map[graph.Identifier]ownedMAC),
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestNeighborProbe(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("analyzer", b)
+
+	probe := NewNeighborProbe(g)
+	probe.Start()
+	defer probe.Stop()
+
+	g.Lock()
+	defer g.Unlock()
+
+	// containers of two hosts sharing the same MAC address
+	remote1 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "MAC": "02:42:ac:11:00:02", "IPV4": "172.17.0.2/16"}, "host1")
+	remote2 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "MAC": "02:42:ac:11:00:02", "IPV4": "10.0.0.2/24"}, "host2")
+
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{
+		"Name":                     "eth0",
+		"MAC":                      "00:11:22:33:44:55",
+		"Neighbors/10.0.0.2/MAC":   "02:42:ac:11:00:02",
+		"Neighbors/10.0.0.2/State": "STALE",
+		"Neighbors/10.0.0.3/MAC":   "02:42:ac:11:00:03",
+		"Neighbors/10.0.0.3/State": "REACHABLE",
+		"Neighbors/10.0.0.4/State": "INCOMPLETE",
+		"Neighbors/Stale":          int64(1),
+		"Neighbors/Incomplete":     int64(1),
+		"Neighbors/Failed":         int64(0),
+	}, "host3")
+
+	if !g.AreLinked(eth0, remote2, graph.Metadata{"RelationType": "neighbor", "IP": "10.0.0.2", "State": "STALE"}) {
+		t.Error("Interface should be linked to the neighbor having the IP address")
+	}
+	if g.AreLinked(eth0, remote1, graph.Metadata{"RelationType": "neighbor"}) {
+		t.Error("Interface shouldn't be linked to the neighbor with another IP address")
+	}
+
+	// the MAC address of a neighbor appears after the entry was learnt
+	remote3 := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth1", "MAC": "02:42:ac:11:00:03"}, "host4")
+	if !g.AreLinked(eth0, remote3, graph.Metadata{"RelationType": "neighbor", "IP": "10.0.0.3"}) {
+		t.Error("Interface should be linked to a neighbor added later")
+	}
+
+	// forwarding database entries prefer the interfaces of the same host
+	port := g.NewNode(graph.GenID(), graph.Metadata{
+		"Name":                           "veth0",
+		"FDB/02:42:ac:11:00:02@10/MAC":   "02:42:ac:11:00:02",
+		"FDB/02:42:ac:11:00:02@10/State": "REACHABLE",
+		"FDB/02:42:ac:11:00:02@10/Vlan":  int64(10),
+	}, "host1")
+	if !g.AreLinked(port, remote1, graph.Metadata{"RelationType": "fdb", "Vlan": int64(10)}) {
+		t.Error("Bridge port should be linked to the local interface owning the MAC address")
+	}
+
+	// updates of other metadata keep the links
+	g.AddMetadata(eth0, "MTU", int64(1500))
+	if !g.AreLinked(eth0, remote2, graph.Metadata{"RelationType": "neighbor", "IP": "10.0.0.2"}) {
+		t.Error("Link should be kept on unrelated updates")
+	}
+
+	// the interface sharing the MAC address is linked once the other one is gone
+	g.DelNode(remote2)
+	if !g.AreLinked(eth0, remote1, graph.Metadata{"RelationType": "neighbor", "IP": "10.0.0.2"}) {
+		t.Error("Interface should be linked to the remaining owner of the MAC address")
+	}
+
+	g.DelMetadata(eth0, "Neighbors/10.0.0.2/MAC")
+	if g.AreLinked(eth0, remote1, graph.Metadata{"RelationType": "neighbor"}) {
+		t.Error("Link should be removed with the neighbor entry")
+	}
+}
@@ -0,0 +1,652 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+var netfilterMetadata = graph.Metadata{"RelationType": "netfilter"}
+
+// metadata keys owned by the netfilter probe, the other keys like the TID
+// are kept when a rule is updated
+var netfilterRuleKeys = map[string]bool{
+	"Type": true, "Name": true, "Family": true, "Backend": true, "Table": true,
+	"Chain": true, "Position": true, "Policy": true, "Handle": true, "Rule": true,
+	"Target": true, "Protocol": true, "Source": true, "Destination": true,
+	"SourcePort": true, "DestinationPort": true, "InputInterface": true,
+	"OutputInterface": true, "Packets": true, "Bytes": true,
+}
+
+// NetfilterProbe reports the iptables, ip6tables and nftables rules of the
+// host and of its network namespaces along with their counters. Rules are
+// owned by their namespace node and linked to the interfaces they match.
+type NetfilterProbe struct {
+	Graph  *graph.Graph
+	Root   *graph.Node
+	poller *nodePoller
+}
+
+type netfilterRule struct {
+	backend         string
+	family          string
+	table           string
+	chain           string
+	position        int64
+	policy          bool
+	handle          int64
+	spec            string
+	target          string
+	protocol        string
+	source          string
+	destination     string
+	sourcePort      string
+	destinationPort string
+	inputInterface  string
+	outputInterface string
+	counters        bool
+	packets         int64
+	bytes           int64
+}
+
+func (r *netfilterRule) name() string {
+	if r.policy {
+		return fmt.Sprintf("%s %s %s policy", r.family, r.table, r.chain)
+	}
+	return fmt.Sprintf("%s %s %s %d", r.family, r.table, r.chain, r.position)
+}
+
+func (r *netfilterRule) metadata() graph.Metadata {
+	m := graph.Metadata{
+		"Type":    "netfilterrule",
+		"Name":    r.name(),
+		"Backend": r.backend,
+		"Table":   r.table,
+		"Chain":   r.chain,
+	}
+
+	// inet tables apply to both families
+	switch r.family {
+	case "ip":
+		m["Family"] = "IPV4"
+	case "ip6":
+		m["Family"] = "IPV6"
+	}
+
+	if r.policy {
+		m["Policy"] = true
+	} else {
+		m["Position"] = r.position
+	}
+
+	if r.handle != 0 {
+		m["Handle"] = r.handle
+	}
+
+	for k, v := range map[string]string{
+		"Rule":            r.spec,
+		"Target":          r.target,
+		"Protocol":        r.protocol,
+		"Source":          r.source,
+		"Destination":     r.destination,
+		"SourcePort":      r.sourcePort,
+		"DestinationPort": r.destinationPort,
+		"InputInterface":  r.inputInterface,
+		"OutputInterface": r.outputInterface,
+	} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+
+	if r.counters {
+		m["Packets"] = r.packets
+		m["Bytes"] = r.bytes
+	}
+
+	return m
+}
+
+// splitRuleArgs splits an iptables-save rule into its arguments, taking
+// care of the quoted ones like comments
+func splitRuleArgs(s string) (args []string) {
+	var arg bytes.Buffer
+	var quoted, escaped, inArg bool
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args
+}
+
+// parseCounters parses the [packets:bytes] counters of iptables-save
+func parseCounters(s string) (packets int64, bytes int64, ok bool) {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return 0, 0, false
+	}
+
+	fields := strings.Split(s[1:len(s)-1], ":")
+	if len(fields) != 2 {
+		return 0, 0, false
+	}
+
+	var err error
+	if packets, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	if bytes, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return 0, 0, false
+	}
+
+	return packets, bytes, true
+}
+
+func (r *netfilterRule) parseIptablesArgs(args []string) {
+	negate := false
+	for i := 0; i < len(args); i++ {
+		var field *string
+		switch args[i] {
+		case "!":
+			negate = true
+			continue
+		case "-p", "--protocol":
+			field = &r.protocol
+		case "-s", "--source":
+			field = &r.source
+		case "-d", "--destination":
+			field = &r.destination
+		case "-i", "--in-interface":
+			field = &r.inputInterface
+		case "-o", "--out-interface":
+			field = &r.outputInterface
+		case "--sport", "--source-port", "--sports", "--source-ports":
+			field = &r.sourcePort
+		case "--dport", "--destination-port", "--dports", "--destination-ports":
+			field = &r.destinationPort
+		case "-j", "--jump", "-g", "--goto":
+			field = &r.target
+		}
+
+		if field != nil && i+1 < len(args) {
+			i++
+			*field = args[i]
+			if negate {
+				*field = "!" + *field
+			}
+		}
+		negate = false
+	}
+}
+
+// parseIptablesSave parses the output of iptables-save -c and returns the
+// rules along with the names of the tables found
+func parseIptablesSave(family string, data []byte) (rules []*netfilterRule, tables []string) {
+	var table string
+	positions := make(map[string]int64)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || line == "COMMIT":
+		case strings.HasPrefix(line, "*"):
+			table = line[1:]
+			tables = append(tables, table)
+			positions = make(map[string]int64)
+		case strings.HasPrefix(line, ":"):
+			// chain definition with its policy, user defined chains have none
+			fields := strings.Fields(line[1:])
+			if len(fields) < 2 || fields[1] == "-" {
+				continue
+			}
+
+			rule := &netfilterRule{backend: "iptables", family: family, table: table, chain: fields[0], policy: true, target: fields[1]}
+			if len(fields) > 2 {
+				rule.packets, rule.bytes, rule.counters = parseCounters(fields[2])
+			}
+			rules = append(rules, rule)
+		default:
+			rule := &netfilterRule{backend: "iptables", family: family, table: table}
+			if strings.HasPrefix(line, "[") {
+				if i := strings.Index(line, "]"); i != -1 {
+					rule.packets, rule.bytes, rule.counters = parseCounters(line[:i+1])
+					line = strings.TrimSpace(line[i+1:])
+				}
+			}
+
+			args := splitRuleArgs(line)
+			if len(args) < 2 || args[0] != "-A" {
+				continue
+			}
+
+			rule.chain = args[1]
+			positions[rule.chain]++
+			rule.position = positions[rule.chain]
+			rule.spec = line
+			rule.parseIptablesArgs(args[2:])
+
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, tables
+}
+
+type nftRuleset struct {
+	Nftables []map[string]json.RawMessage `json:"nftables"`
+}
+
+type nftChain struct {
+	Family string `json:"family"`
+	Table  string `json:"table"`
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+}
+
+type nftRule struct {
+	Family string                       `json:"family"`
+	Table  string                       `json:"table"`
+	Chain  string                       `json:"chain"`
+	Handle int64                        `json:"handle"`
+	Expr   []map[string]json.RawMessage `json:"expr"`
+}
+
+type nftMatch struct {
+	Op   string `json:"op"`
+	Left struct {
+		Payload *struct {
+			Protocol string `json:"protocol"`
+			Field    string `json:"field"`
+		} `json:"payload"`
+		Meta *struct {
+			Key string `json:"key"`
+		} `json:"meta"`
+	} `json:"left"`
+	Right interface{} `json:"right"`
+}
+
+type nftCounter struct {
+	Packets int64 `json:"packets"`
+	Bytes   int64 `json:"bytes"`
+}
+
+type nftJump struct {
+	Target string `json:"target"`
+}
+
+// nftValue returns the iptables like representation of the right operand
+// of a nftables match
+func nftValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, value := range v {
+			values[i] = nftValue(value)
+		}
+		return strings.Join(values, ",")
+	case map[string]interface{}:
+		if prefix, ok := v["prefix"].(map[string]interface{}); ok {
+			return fmt.Sprintf("%s/%s", nftValue(prefix["addr"]), nftValue(prefix["len"]))
+		}
+		if r, ok := v["range"].([]interface{}); ok && len(r) == 2 {
+			return nftValue(r[0]) + ":" + nftValue(r[1])
+		}
+		if set, ok := v["set"]; ok {
+			return nftValue(set)
+		}
+	}
+	return ""
+}
+
+func (r *netfilterRule) parseNftMatch(match *nftMatch) {
+	var field *string
+
+	if payload := match.Left.Payload; payload != nil {
+		switch payload.Field {
+		case "saddr":
+			field = &r.source
+		case "daddr":
+			field = &r.destination
+		case "protocol", "nexthdr":
+			field = &r.protocol
+		case "sport":
+			field = &r.sourcePort
+		case "dport":
+			field = &r.destinationPort
+		}
+
+		// a port match implies the transport protocol
+		if (payload.Field == "sport" || payload.Field == "dport") && r.protocol == "" {
+			r.protocol = payload.Protocol
+		}
+	} else if meta := match.Left.Meta; meta != nil {
+		switch meta.Key {
+		case "l4proto":
+			field = &r.protocol
+		case "iifname":
+			field = &r.inputInterface
+		case "oifname":
+			field = &r.outputInterface
+		}
+	}
+
+	if field != nil {
+		*field = nftValue(match.Right)
+		if match.Op == "!=" {
+			*field = "!" + *field
+		}
+	}
+}
+
+func (r *netfilterRule) parseNftExpr(key string, value json.RawMessage) {
+	switch key {
+	case "match":
+		var match nftMatch
+		if err := json.Unmarshal(value, &match); err == nil {
+			r.parseNftMatch(&match)
+		}
+	case "counter":
+		var counter nftCounter
+		if err := json.Unmarshal(value, &counter); err == nil {
+			r.packets, r.bytes, r.counters = counter.Packets, counter.Bytes, true
+		}
+	case "jump", "goto":
+		var jump nftJump
+		if err := json.Unmarshal(value, &jump); err == nil {
+			r.target = jump.Target
+		}
+	case "accept", "drop", "reject", "return", "queue", "masquerade", "snat", "dnat", "redirect":
+		r.target = strings.ToUpper(key)
+	}
+}
+
+// parseNftRuleset parses the output of nft -j list ruleset. The tables
+// already reported by iptables-save, for instance by iptables-nft, are
+// skipped.
+func parseNftRuleset(data []byte, skip map[string]bool) ([]*netfilterRule, error) {
+	var ruleset nftRuleset
+	if err := json.Unmarshal(data, &ruleset); err != nil {
+		return nil, err
+	}
+
+	supported := func(family, table string) bool {
+		switch family {
+		case "ip", "ip6", "inet":
+			return !skip[family+" "+table]
+		}
+		return false
+	}
+
+	var rules []*netfilterRule
+	positions := make(map[string]int64)
+
+	for _, object := range ruleset.Nftables {
+		if data, ok := object["chain"]; ok {
+			var chain nftChain
+			if err := json.Unmarshal(data, &chain); err != nil {
+				return nil, err
+			}
+
+			if chain.Policy != "" && supported(chain.Family, chain.Table) {
+				rules = append(rules, &netfilterRule{
+					backend: "nftables",
+					family:  chain.Family,
+					table:   chain.Table,
+					chain:   chain.Name,
+					policy:  true,
+					target:  strings.ToUpper(chain.Policy),
+				})
+			}
+		}
+
+		if data, ok := object["rule"]; ok {
+			var nr nftRule
+			if err := json.Unmarshal(data, &nr); err != nil {
+				return nil, err
+			}
+
+			if !supported(nr.Family, nr.Table) {
+				continue
+			}
+
+			key := nr.Family + " " + nr.Table + " " + nr.Chain
+			positions[key]++
+
+			rule := &netfilterRule{
+				backend:  "nftables",
+				family:   nr.Family,
+				table:    nr.Table,
+				chain:    nr.Chain,
+				position: positions[key],
+				handle:   nr.Handle,
+			}
+
+			for _, expr := range nr.Expr {
+				for k, v := range expr {
+					rule.parseNftExpr(k, v)
+				}
+			}
+
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, nil
+}
+
+// runNetfilterCommand returns the output of a command, or nothing if the
+// command is not installed
+func runNetfilterCommand(name string, args ...string) ([]byte, error) {
+	output, err := exec.Command(name, args...).Output()
+	if err != nil {
+		if e, ok := err.(*exec.Error); ok && e.Err == exec.ErrNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s failed: %s", name, err.Error())
+	}
+	return output, nil
+}
+
+// netfilterRules returns the rules of the network namespace at the given
+// path, the current one if empty
+func netfilterRules(path string) (rules []*netfilterRule, err error) {
+	if path != "" {
+		context, err := common.NewNetNsContext(path)
+		defer context.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tables := make(map[string]bool)
+	for _, family := range []string{"ip", "ip6"} {
+		command := "iptables-save"
+		if family == "ip6" {
+			command = "ip6tables-save"
+		}
+
+		output, err := runNetfilterCommand(command, "-c")
+		if err != nil {
+			return nil, err
+		}
+
+		r, t := parseIptablesSave(family, output)
+		for _, table := range t {
+			tables[family+" "+table] = true
+		}
+		rules = append(rules, r...)
+	}
+
+	output, err := runNetfilterCommand("nft", "-j", "list", "ruleset")
+	if err != nil || len(output) == 0 {
+		return rules, err
+	}
+
+	r, err := parseNftRuleset(output, tables)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse nftables ruleset: %s", err.Error())
+	}
+
+	return append(rules, r...), nil
+}
+
+// linkInterfaces links the rule to the interfaces it matches by name
+func (probe *NetfilterProbe) linkInterfaces(ns *graph.Node, node *graph.Node, rule *netfilterRule) {
+	linked := make(map[graph.Identifier]bool)
+	for _, name := range []string{rule.inputInterface, rule.outputInterface} {
+		if name == "" || strings.HasPrefix(name, "!") || strings.HasSuffix(name, "+") {
+			continue
+		}
+
+		for _, intf := range probe.Graph.LookupChildren(ns, graph.Metadata{"Name": name}, ownershipMetadata) {
+			linked[intf.ID] = true
+		}
+	}
+
+	for _, e := range probe.Graph.GetNodeEdges(node, netfilterMetadata) {
+		if linked[e.GetParent()] {
+			delete(linked, e.GetParent())
+		} else {
+			probe.Graph.DelEdge(e)
+		}
+	}
+
+	for id := range linked {
+		if intf := probe.Graph.GetNode(id); intf != nil {
+			probe.Graph.Link(intf, node, netfilterMetadata)
+		}
+	}
+}
+
+// syncRules updates the rule nodes of a namespace, graph lock has to be held
+func (probe *NetfilterProbe) syncRules(ns *graph.Node, rules []*netfilterRule) {
+	nodes := make(map[string]*graph.Node)
+	for _, node := range probe.Graph.LookupChildren(ns, graph.Metadata{"Type": "netfilterrule"}, ownershipMetadata) {
+		name, _ := node.GetFieldString("Name")
+		nodes[name] = node
+	}
+
+	for _, rule := range rules {
+		m := rule.metadata()
+		name := rule.name()
+
+		node, ok := nodes[name]
+		if ok {
+			delete(nodes, name)
+
+			for k, v := range node.Metadata() {
+				if !netfilterRuleKeys[k] {
+					m[k] = v
+				}
+			}
+			probe.Graph.SetMetadata(node, m)
+		} else {
+			node = probe.Graph.NewNode(graph.GenID(), m)
+			probe.Graph.Link(ns, node, ownershipMetadata)
+		}
+
+		probe.linkInterfaces(ns, node, rule)
+	}
+
+	for _, node := range nodes {
+		probe.Graph.DelNode(node)
+	}
+}
+
+// poll updates the rules of a namespace, the root namespace having no path
+func (probe *NetfilterProbe) poll(id graph.Identifier, path string, last, now time.Time) {
+	rules, err := netfilterRules(path)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to retrieve netfilter rules of %s: %s", path, err.Error())
+		return
+	}
+
+	probe.Graph.Lock()
+	if ns := probe.Graph.GetNode(id); ns != nil {
+		probe.syncRules(ns, rules)
+	}
+	probe.Graph.Unlock()
+}
+
+func (probe *NetfilterProbe) Start() {
+	probe.poller.Start()
+}
+
+func (probe *NetfilterProbe) Stop() {
+	probe.poller.Stop()
+}
+
+func NewNetfilterProbe(g *graph.Graph, n *graph.Node, interval time.Duration) *NetfilterProbe {
+	probe := &NetfilterProbe{
+		Graph: g,
+		Root:  n,
+	}
+	probe.poller = newNodePoller(g, n, "netns", "Path", interval, probe.poll)
+	return probe
+}
+
+func NewNetfilterProbeFromConfig(g *graph.Graph, n *graph.Node) *NetfilterProbe {
+	interval := config.GetConfig().GetInt("agent.topology.netfilter.update")
+	return NewNetfilterProbe(g, n, time.Duration(interval)*time.Second)
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const testIptablesSave = `# Generated by iptables-save v1.6.0 on Mon Jun 12 10:00:00 2017
+*nat
+:PREROUTING ACCEPT [12:720]
+:POSTROUTING ACCEPT [3:180]
+:DOCKER - [0:0]
+[5:300] -A POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE
+COMMIT
+*filter
+:INPUT ACCEPT [1000:64000]
+:FORWARD DROP [42:2520]
+:OUTPUT ACCEPT [900:58000]
+[10:600] -A INPUT -i lo -j ACCEPT
+[3:180] -A INPUT -s 10.0.0.0/8 -p tcp -m tcp --dport 22 -m comment --comment "deny ssh -j ACCEPT" -j DROP
+[0:0] -A INPUT -p udp -m multiport --dports 53,5353 -j ACCEPT
+[7:420] -A FORWARD -o docker0 -j DOCKER
+COMMIT
+`
+
+const testNftRuleset = `{"nftables": [
+  {"metainfo": {"version": "0.9.0", "json_schema_version": 1}},
+  {"table": {"family": "ip", "name": "filter", "handle": 1}},
+  {"chain": {"family": "ip", "table": "filter", "name": "INPUT", "handle": 1, "type": "filter", "hook": "input", "prio": 0, "policy": "accept"}},
+  {"rule": {"family": "ip", "table": "filter", "chain": "INPUT", "handle": 4, "expr": [{"counter": {"packets": 1, "bytes": 2}}, {"accept": null}]}},
+  {"table": {"family": "inet", "name": "firewall", "handle": 2}},
+  {"chain": {"family": "inet", "table": "firewall", "name": "input", "handle": 1, "type": "filter", "hook": "input", "prio": 0, "policy": "drop"}},
+  {"rule": {"family": "inet", "table": "firewall", "chain": "input", "handle": 3, "expr": [
+    {"match": {"op": "==", "left": {"meta": {"key": "iifname"}}, "right": "eth0"}},
+    {"match": {"op": "!=", "left": {"payload": {"protocol": "ip", "field": "saddr"}}, "right": {"prefix": {"addr": "192.168.0.0", "len": 16}}}},
+    {"match": {"op": "==", "left": {"payload": {"protocol": "tcp", "field": "dport"}}, "right": {"set": [80, {"range": [8000, 8080]}]}}},
+    {"counter": {"packets": 12, "bytes": 720}},
+    {"reject": null}
+  ]}},
+  {"rule": {"family": "inet", "table": "firewall", "chain": "input", "handle": 5, "expr": [
+    {"match": {"op": "==", "left": {"meta": {"key": "l4proto"}}, "right": "udp"}},
+    {"jump": {"target": "udp_chain"}}
+  ]}},
+  {"rule": {"family": "bridge", "table": "filter", "chain": "forward", "handle": 2, "expr": [{"drop": null}]}}
+]}`
+
+func TestParseIptablesSave(t *testing.T) {
+	rules, tables := parseIptablesSave("ip", []byte(testIptablesSave))
+
+	if len(tables) != 2 || tables[0] != "nat" || tables[1] != "filter" {
+		t.Errorf("Wrong tables: %v", tables)
+	}
+
+	rulesByName := make(map[string]graph.Metadata)
+	for _, rule := range rules {
+		rulesByName[rule.name()] = rule.metadata()
+	}
+
+	if len(rulesByName) != 10 {
+		t.Fatalf("Expected 10 rules, got %d: %v", len(rulesByName), rulesByName)
+	}
+
+	expected := map[string]graph.Metadata{
+		"ip nat POSTROUTING 1": {
+			"Type": "netfilterrule", "Name": "ip nat POSTROUTING 1", "Backend": "iptables", "Family": "IPV4",
+			"Table": "nat", "Chain": "POSTROUTING", "Position": int64(1), "Target": "MASQUERADE",
+			"Rule": "-A POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE", "Source": "172.17.0.0/16",
+			"OutputInterface": "!docker0", "Packets": int64(5), "Bytes": int64(300),
+		},
+		"ip filter FORWARD policy": {
+			"Type": "netfilterrule", "Name": "ip filter FORWARD policy", "Backend": "iptables", "Family": "IPV4",
+			"Table": "filter", "Chain": "FORWARD", "Policy": true, "Target": "DROP", "Packets": int64(42), "Bytes": int64(2520),
+		},
+		"ip filter INPUT 2": {
+			"Type": "netfilterrule", "Name": "ip filter INPUT 2", "Backend": "iptables", "Family": "IPV4",
+			"Table": "filter", "Chain": "INPUT", "Position": int64(2), "Target": "DROP", "Protocol": "tcp",
+			"Rule":   `-A INPUT -s 10.0.0.0/8 -p tcp -m tcp --dport 22 -m comment --comment "deny ssh -j ACCEPT" -j DROP`,
+			"Source": "10.0.0.0/8", "DestinationPort": "22", "Packets": int64(3), "Bytes": int64(180),
+		},
+		"ip filter INPUT 3": {
+			"Type": "netfilterrule", "Name": "ip filter INPUT 3", "Backend": "iptables", "Family": "IPV4",
+			"Table": "filter", "Chain": "INPUT", "Position": int64(3), "Target": "ACCEPT", "Protocol": "udp",
+			"Rule": "-A INPUT -p udp -m multiport --dports 53,5353 -j ACCEPT", "DestinationPort": "53,5353",
+			"Packets": int64(0), "Bytes": int64(0),
+		},
+	}
+
+	for name, m := range expected {
+		if len(rulesByName[name]) != len(m) {
+			t.Errorf("Wrong metadata for %s: %v", name, rulesByName[name])
+			continue
+		}
+		for k, v := range m {
+			if rulesByName[name][k] != v {
+				t.Errorf("Wrong %s for %s, expected %v, got %v", k, name, v, rulesByName[name][k])
+			}
+		}
+	}
+
+	if _, ok := rulesByName["ip nat DOCKER policy"]; ok {
+		t.Error("User defined chains shouldn't have a policy")
+	}
+}
+
+func TestParseNftRuleset(t *testing.T) {
+	rules, err := parseNftRuleset([]byte(testNftRuleset), map[string]bool{"ip filter": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %d", len(rules))
+	}
+
+	expected := []graph.Metadata{
+		{
+			"Name": "inet firewall input policy", "Backend": "nftables", "Table": "firewall", "Chain": "input",
+			"Policy": true, "Target": "DROP",
+		},
+		{
+			"Name": "inet firewall input 1", "Position": int64(1), "Handle": int64(3), "Target": "REJECT",
+			"InputInterface": "eth0", "Source": "!192.168.0.0/16", "Protocol": "tcp", "DestinationPort": "80,8000:8080",
+			"Packets": int64(12), "Bytes": int64(720),
+		},
+		{
+			"Name": "inet firewall input 2", "Position": int64(2), "Handle": int64(5), "Target": "udp_chain", "Protocol": "udp",
+		},
+	}
+
+	for i, m := range expected {
+		metadata := rules[i].metadata()
+		if _, ok := metadata["Family"]; ok {
+			t.Errorf("inet rules shouldn't have a family: %v", metadata)
+		}
+		for k, v := range m {
+			if metadata[k] != v {
+				t.Errorf("Wrong %s for rule %d, expected %v, got %v", k, i, v, metadata[k])
+			}
+		}
+	}
+}
+
+func TestNetfilterSyncRules(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	defer g.Unlock()
+
+	root := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth0"})
+	g.Link(root, eth0, ownershipMetadata)
+
+	probe := NewNetfilterProbe(g, root, 0)
+
+	rules, _ := parseNftRuleset([]byte(testNftRuleset), nil)
+	probe.syncRules(root, rules)
+
+	nodes := g.LookupChildren(root, graph.Metadata{"Type": "netfilterrule"}, ownershipMetadata)
+	if len(nodes) != 5 {
+		t.Fatalf("Expected 5 rule nodes, got %d", len(nodes))
+	}
+
+	rule := g.LookupFirstChild(root, graph.Metadata{"Name": "inet firewall input 1"})
+	if rule == nil {
+		t.Fatal("Rule node not found")
+	}
+	if !g.AreLinked(eth0, rule, netfilterMetadata) {
+		t.Error("Rule should be linked to its input interface")
+	}
+
+	g.AddMetadata(rule, "TID", "123")
+
+	// counters update and rule removal
+	rules = rules[2:]
+	rules[1].packets = 20
+	probe.syncRules(root, rules)
+
+	nodes = g.LookupChildren(root, graph.Metadata{"Type": "netfilterrule"}, ownershipMetadata)
+	if len(nodes) != 3 {
+		t.Fatalf("Expected 3 rule nodes, got %d", len(nodes))
+	}
+	if packets, _ := rule.GetFieldInt64("Packets"); packets != 20 {
+		t.Errorf("Counters should be updated, got %d packets", packets)
+	}
+	if tid, _ := rule.GetFieldString("TID"); tid != "123" {
+		t.Error("TID should be kept on update")
+	}
+}
@@ -54,9 +54,16 @@ type NetLinkProbe struct {
 	Root                 *graph.Node
 	state                int64
 	ethtool              *ethtool.Ethtool
+	ethtoolHandle        *ethtoolHandle
 	netlink              *netlink.Handle
 	indexToChildrenQueue map[int64][]graph.Identifier
 	links                map[string]*graph.Node
+	routes               map[string]*graph.Node
+	tableRoutes          map[string]*route
+	routingTables        map[int]bool
+	ruleTables           map[int]int
+	rules                map[string]*graph.Node
+	neighbors            map[int]map[string]*neighbor
 	wg                   sync.WaitGroup
 }
 
@@ -317,12 +324,19 @@ func (u *NetLinkProbe) addLinkToTopology(link netlink.Link) {
 		u.updateMetadataStatistics(statistics, metadata, "Statistics")
 	}
 
-	if link.Type() == "veth" {
+	if hasEthtoolInfo(link) {
+		for k, v := range u.ethtoolHandle.metadata(link.Attrs().Name) {
+			metadata[k] = v
+		}
+
 		stats, err := u.ethtool.Stats(link.Attrs().Name)
-		if err != nil && err != syscall.ENODEV {
+		if err != nil && err != syscall.ENODEV && err != syscall.EOPNOTSUPP {
 			logging.GetLogger().Errorf("Unable get stats from ethtool (%s): %s", link.Attrs().Name, err.Error())
-		} else if index, ok := stats["peer_ifindex"]; ok {
-			metadata["PeerIfIndex"] = int64(index)
+		} else if err == nil {
+			if index, ok := stats["peer_ifindex"]; ok {
+				metadata["PeerIfIndex"] = int64(index)
+			}
+			updateEthtoolStatistics(stats, metadata, "Ethtool/Statistics")
 		}
 	}
 
@@ -419,6 +433,7 @@ func (u *NetLinkProbe) onLinkDeleted(link netlink.Link) {
 		} else {
 			u.Graph.DelNode(intf)
 		}
+		delete(u.neighbors, index)
 	}
 
 	u.Lock()
@@ -571,7 +586,9 @@ func (u *NetLinkProbe) start(nsPath string) {
 	}
 	defer h.Delete()
 
-	s, err := nl.Subscribe(syscall.NETLINK_ROUTE, syscall.RTNLGRP_LINK, syscall.RTNLGRP_IPV4_IFADDR, syscall.RTNLGRP_IPV6_IFADDR)
+	s, err := nl.Subscribe(syscall.NETLINK_ROUTE, syscall.RTNLGRP_LINK, syscall.RTNLGRP_IPV4_IFADDR, syscall.RTNLGRP_IPV6_IFADDR,
+		syscall.RTNLGRP_IPV4_ROUTE, syscall.RTNLGRP_IPV6_ROUTE, syscall.RTNLGRP_IPV4_RULE, syscall.RTNLGRP_IPV6_RULE,
+		syscall.RTNLGRP_NEIGH)
 	if err != nil {
 		logging.GetLogger().Errorf("Failed to subscribe to netlink messages: %s", err.Error())
 		context.Close()
@@ -579,6 +596,18 @@ func (u *NetLinkProbe) start(nsPath string) {
 	}
 	defer s.Close()
 
+	// routes are added once the interfaces are known to link them to their
+	// egress interfaces
+	routing, err := dumpRoutingTables()
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to dump routing tables: %s", err.Error())
+	}
+
+	neighbors, err := dumpNeighbors()
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to dump neighbor tables: %s", err.Error())
+	}
+
 	u.ethtool, err = ethtool.NewEthtool()
 	if err != nil {
 		logging.GetLogger().Errorf("Failed to create ethtool object: %s", err.Error())
@@ -587,6 +616,14 @@ func (u *NetLinkProbe) start(nsPath string) {
 	}
 	defer u.ethtool.Close()
 
+	u.ethtoolHandle, err = newEthtoolHandle()
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to create ethtool socket: %s", err.Error())
+		context.Close()
+		return
+	}
+	defer u.ethtoolHandle.Close()
+
 	epfd, e := syscall.EpollCreate1(0)
 	if e != nil {
 		logging.GetLogger().Errorf("Failed to create epoll: %s", err.Error())
@@ -606,6 +643,12 @@ func (u *NetLinkProbe) start(nsPath string) {
 	u.netlink = h
 	u.initialize()
 
+	for _, msg := range routing {
+		u.handleRoutingMessage(msg.msgType, msg.data)
+	}
+
+	u.loadNeighbors(neighbors)
+
 	fd := s.GetFd()
 	err = syscall.SetNonblock(fd, true)
 	if err != nil {
@@ -676,6 +719,18 @@ func (u *NetLinkProbe) start(nsPath string) {
 							}
 							u.updateMetadataStatistics(stats, m, "Statistics")
 							u.updateMetadataStatistics(&metric, m, "LastMetric")
+
+							// offloads and rings can be changed without notification
+							if hasEthtoolInfo(link) {
+								for k, v := range u.ethtoolHandle.metadata(name) {
+									m[k] = v
+								}
+								if stats, err := u.ethtool.Stats(name); err == nil {
+									updateEthtoolStatistics(ethtoolMetric(stats, m), m, "Ethtool/LastMetric")
+									updateEthtoolStatistics(stats, m, "Ethtool/Statistics")
+								}
+							}
+
 							m["LastMetric/Start"] = common.UnixMillis(last)
 							m["LastMetric/Last"] = common.UnixMillis(now)
 							tr.Commit()
@@ -744,6 +799,10 @@ func (u *NetLinkProbe) start(nsPath string) {
 					continue
 				}
 				u.onAddressDeleted(addr, family, ifindex)
+			case syscall.RTM_NEWNEIGH, syscall.RTM_DELNEIGH:
+				u.handleNeighborMessage(msg.Header.Type, msg.Data)
+			default:
+				u.handleRoutingMessage(msg.Header.Type, msg.Data)
 			}
 		}
 	}
@@ -769,7 +828,25 @@ func NewNetLinkProbe(g *graph.Graph, n *graph.Node) *NetLinkProbe {
 		Root:                 n,
 		indexToChildrenQueue: make(map[int64][]graph.Identifier),
 		links:                make(map[string]*graph.Node),
+		routes:               make(map[string]*graph.Node),
+		tableRoutes:          make(map[string]*route),
+		ruleTables:           make(map[int]int),
+		rules:                make(map[string]*graph.Node),
+		neighbors:            make(map[int]map[string]*neighbor),
 		state:                common.StoppedState,
 	}
+
+	if tables := config.GetConfig().GetStringSlice("agent.topology.netlink.routing_tables"); len(tables) > 0 {
+		np.routingTables = make(map[int]bool)
+		for _, name := range tables {
+			table, err := parseTable(name)
+			if err != nil {
+				logging.GetLogger().Errorf("Ignoring routing table: %s", err.Error())
+				continue
+			}
+			np.routingTables[table] = true
+		}
+	}
+
 	return np
 }
@@ -0,0 +1,299 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Ethtool commands and string sets from linux/ethtool.h and linux/sockios.h
+const (
+	siocEthtool = 0x8946
+
+	ethtoolGset       = 0x01
+	ethtoolGdrvinfo   = 0x03
+	ethtoolGringparam = 0x10
+	ethtoolGstrings   = 0x1b
+	ethtoolGssetInfo  = 0x37
+	ethtoolGfeatures  = 0x3a
+
+	ethSsFeatures = 4
+	ethGstringLen = 32
+
+	sizeofEthtoolDrvinfo   = 196
+	sizeofEthtoolCmd       = 44
+	sizeofEthtoolRingparam = 36
+
+	ethtoolDuplexHalf    = 0x00
+	ethtoolDuplexFull    = 0x01
+	ethtoolAutonegEnable = 0x01
+)
+
+// ethtoolOffloads groups the kernel features the way ethtool -k reports
+// them, an offload being enabled if one of its features is active
+var ethtoolOffloads = map[string][]string{
+	"RxChecksum":    {"rx-checksum"},
+	"TxChecksum":    {"tx-checksum-ipv4", "tx-checksum-ip-generic", "tx-checksum-ipv6", "tx-checksum-fcoe-crc", "tx-checksum-sctp"},
+	"ScatterGather": {"tx-scatter-gather", "tx-scatter-gather-fraglist"},
+	"TSO":           {"tx-tcp-segmentation", "tx-tcp-ecn-segmentation", "tx-tcp-mangleid-segmentation", "tx-tcp6-segmentation"},
+	"GSO":           {"tx-generic-segmentation"},
+	"GRO":           {"rx-gro"},
+	"LRO":           {"rx-lro"},
+	"RxVlan":        {"rx-vlan-hw-parse"},
+	"TxVlan":        {"tx-vlan-hw-insert"},
+	"UDPTunnel":     {"tx-udp_tnl-segmentation", "tx-udp_tnl-csum-segmentation"},
+}
+
+// ifreqData is the ifreq structure used by the ethtool ioctl
+type ifreqData struct {
+	name [syscall.IFNAMSIZ]byte
+	data uintptr
+	pad  [16]byte
+}
+
+// ethtoolHandle queries the interfaces of the network namespace it was
+// created in
+type ethtoolHandle struct {
+	fd int
+}
+
+func (h *ethtoolHandle) ioctl(name string, buf []byte) error {
+	var ifr ifreqData
+	copy(ifr.name[:syscall.IFNAMSIZ-1], name)
+	ifr.data = uintptr(unsafe.Pointer(&buf[0]))
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(h.fd), siocEthtool, uintptr(unsafe.Pointer(&ifr)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (h *ethtoolHandle) command(name string, cmd uint32, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	nl.NativeEndian().PutUint32(buf[0:4], cmd)
+	if err := h.ioctl(name, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func ethtoolString(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// driverInfo returns the driver version, the firmware version and the bus
+// of an interface
+func (h *ethtoolHandle) driverInfo(name string) (version, firmware, bus string, err error) {
+	buf, err := h.command(name, ethtoolGdrvinfo, sizeofEthtoolDrvinfo)
+	if err != nil {
+		return "", "", "", err
+	}
+	return ethtoolString(buf[36:68]), ethtoolString(buf[68:100]), ethtoolString(buf[100:132]), nil
+}
+
+// linkSettings returns the duplex and the autonegotiation state of an
+// interface
+func (h *ethtoolHandle) linkSettings(name string) (duplex string, autoneg bool, err error) {
+	buf, err := h.command(name, ethtoolGset, sizeofEthtoolCmd)
+	if err != nil {
+		return "", false, err
+	}
+
+	switch buf[14] {
+	case ethtoolDuplexHalf:
+		duplex = "half"
+	case ethtoolDuplexFull:
+		duplex = "full"
+	}
+
+	return duplex, buf[18] == ethtoolAutonegEnable, nil
+}
+
+// rings returns the current and maximum sizes of the rx and tx rings
+func (h *ethtoolHandle) rings(name string) (map[string]int64, error) {
+	buf, err := h.command(name, ethtoolGringparam, sizeofEthtoolRingparam)
+	if err != nil {
+		return nil, err
+	}
+
+	native := nl.NativeEndian()
+	return map[string]int64{
+		"RxMax":     int64(native.Uint32(buf[4:8])),
+		"TxMax":     int64(native.Uint32(buf[16:20])),
+		"RxPending": int64(native.Uint32(buf[20:24])),
+		"TxPending": int64(native.Uint32(buf[32:36])),
+	}, nil
+}
+
+// features returns the state of the features of an interface indexed by
+// their kernel names
+func (h *ethtoolHandle) features(name string) (map[string]bool, error) {
+	native := nl.NativeEndian()
+
+	// number of features, the sset_mask being followed by their count
+	info := make([]byte, 20)
+	native.PutUint32(info[0:4], ethtoolGssetInfo)
+	native.PutUint64(info[8:16], 1<<ethSsFeatures)
+	if err := h.ioctl(name, info); err != nil {
+		return nil, err
+	}
+
+	count := int(native.Uint32(info[16:20]))
+	if native.Uint64(info[8:16]) == 0 || count == 0 {
+		return nil, nil
+	}
+
+	strs := make([]byte, 12+count*ethGstringLen)
+	native.PutUint32(strs[0:4], ethtoolGstrings)
+	native.PutUint32(strs[4:8], ethSsFeatures)
+	native.PutUint32(strs[8:12], uint32(count))
+	if err := h.ioctl(name, strs); err != nil {
+		return nil, err
+	}
+
+	// blocks of available, requested, active and never changed bitmaps
+	blocks := (count + 31) / 32
+	feats := make([]byte, 8+blocks*16)
+	native.PutUint32(feats[0:4], ethtoolGfeatures)
+	native.PutUint32(feats[4:8], uint32(blocks))
+	if err := h.ioctl(name, feats); err != nil {
+		return nil, err
+	}
+
+	features := make(map[string]bool)
+	for i := 0; i < count; i++ {
+		feature := ethtoolString(strs[12+i*ethGstringLen : 12+(i+1)*ethGstringLen])
+		if feature == "" {
+			continue
+		}
+
+		active := native.Uint32(feats[8+(i/32)*16+8:])
+		features[feature] = active&(1<<uint(i%32)) != 0
+	}
+
+	return features, nil
+}
+
+// offloads returns the state of the offloads from the one of the features
+func offloads(features map[string]bool) map[string]bool {
+	result := make(map[string]bool)
+	for offload, names := range ethtoolOffloads {
+		for _, name := range names {
+			active, ok := features[name]
+			if !ok {
+				continue
+			}
+			result[offload] = result[offload] || active
+		}
+	}
+	return result
+}
+
+// metadata returns the driver, duplex, autonegotiation, offloads and rings of an
+// interface, the informations not reported by its driver being omitted
+func (h *ethtoolHandle) metadata(name string) graph.Metadata {
+	m := graph.Metadata{}
+
+	if version, firmware, bus, err := h.driverInfo(name); err == nil {
+		for k, v := range map[string]string{"DriverVersion": version, "Firmware": firmware, "BusInfo": bus} {
+			if v != "" && v != "N/A" {
+				m["Ethtool/"+k] = v
+			}
+		}
+	}
+
+	if duplex, autoneg, err := h.linkSettings(name); err == nil {
+		if duplex != "" {
+			m["Duplex"] = duplex
+		}
+		m["Autoneg"] = autoneg
+	}
+
+	if features, err := h.features(name); err == nil {
+		for offload, active := range offloads(features) {
+			m["Ethtool/Offloads/"+offload] = active
+		}
+	}
+
+	if rings, err := h.rings(name); err == nil {
+		for k, v := range rings {
+			m["Ethtool/Rings/"+k] = v
+		}
+	}
+
+	return m
+}
+
+func (h *ethtoolHandle) Close() {
+	syscall.Close(h.fd)
+}
+
+func newEthtoolHandle() (*ethtoolHandle, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &ethtoolHandle{fd: fd}, nil
+}
+
+// updateEthtoolStatistics sets the ethtool -S counters of an interface,
+// per queue ones included, under the given prefix
+func updateEthtoolStatistics(stats map[string]uint64, m graph.Metadata, prefix string) {
+	for name, value := range stats {
+		// veth reports its peer along with its counters
+		if name == "peer_ifindex" {
+			continue
+		}
+		m[prefix+"/"+name] = int64(value)
+	}
+}
+
+// ethtoolMetric returns the increase of the ethtool -S counters since the
+// ones stored in the metadata, the counters seen for the first time or
+// reset being skipped
+func ethtoolMetric(stats map[string]uint64, m graph.Metadata) map[string]uint64 {
+	metric := make(map[string]uint64)
+	for name, value := range stats {
+		if previous, ok := m["Ethtool/Statistics/"+name].(int64); ok && int64(value) >= previous {
+			metric[name] = uint64(int64(value) - previous)
+		}
+	}
+	return metric
+}
+
+// hasEthtoolInfo returns whether the ethtool informations of an interface
+// are reported, only physical and veth ones being queried
+func hasEthtoolInfo(link netlink.Link) bool {
+	return link.Type() == "device" || link.Type() == "veth"
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestEthtoolOffloads(t *testing.T) {
+	features := map[string]bool{
+		"rx-checksum":            true,
+		"tx-checksum-ipv4":       false,
+		"tx-checksum-ip-generic": true,
+		"tx-tcp-segmentation":    false,
+		"tx-tcp6-segmentation":   false,
+		"rx-gro":                 true,
+	}
+
+	expected := map[string]bool{
+		"RxChecksum": true,
+		"TxChecksum": true,
+		"TSO":        false,
+		"GRO":        true,
+	}
+
+	result := offloads(features)
+	if len(result) != len(expected) {
+		t.Errorf("Expected offloads %v, got %v", expected, result)
+	}
+	for k, v := range expected {
+		if active, ok := result[k]; !ok || active != v {
+			t.Errorf("Expected offload %s to be %v, got %v", k, v, result)
+		}
+	}
+}
+
+func TestEthtoolStatistics(t *testing.T) {
+	m := graph.Metadata{}
+	stats := map[string]uint64{"peer_ifindex": 5, "rx_queue_0_packets": 10}
+	updateEthtoolStatistics(ethtoolMetric(stats, m), m, "Ethtool/LastMetric")
+	updateEthtoolStatistics(stats, m, "Ethtool/Statistics")
+
+	if _, ok := m["Ethtool/Statistics/peer_ifindex"]; ok {
+		t.Error("Peer index shouldn't be reported as a counter")
+	}
+
+	if _, ok := m["Ethtool/LastMetric/rx_queue_0_packets"]; ok {
+		t.Error("No delta expected for the first update")
+	}
+
+	stats = map[string]uint64{"rx_queue_0_packets": 25}
+	updateEthtoolStatistics(ethtoolMetric(stats, m), m, "Ethtool/LastMetric")
+	updateEthtoolStatistics(stats, m, "Ethtool/Statistics")
+	if m["Ethtool/Statistics/rx_queue_0_packets"] != int64(25) || m["Ethtool/LastMetric/rx_queue_0_packets"] != int64(15) {
+		t.Errorf("Wrong queue statistics: %v", m)
+	}
+}
+
+func TestEthtoolLoopbackFeatures(t *testing.T) {
+	h, err := newEthtoolHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	features, err := h.features("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if active, ok := features["tx-generic-segmentation"]; !ok || !active {
+		t.Errorf("Generic segmentation offload should be enabled on the loopback: %v", features)
+	}
+}
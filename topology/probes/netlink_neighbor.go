@@ -0,0 +1,421 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Neighbor attributes, states and flags from linux/neighbour.h
+const (
+	ndaDst    = 1
+	ndaLLAddr = 2
+	ndaVlan   = 5
+
+	nudIncomplete = 0x01
+	nudReachable  = 0x02
+	nudStale      = 0x04
+	nudDelay      = 0x08
+	nudProbe      = 0x10
+	nudFailed     = 0x20
+	nudNoArp      = 0x40
+	nudPermanent  = 0x80
+
+	ntfSelf      = 0x02
+	ntfMaster    = 0x04
+	ntfExtLearnt = 0x10
+
+	sizeofNdMsg = 12
+)
+
+var neighborStates = []struct {
+	state int
+	name  string
+}{
+	{nudIncomplete, "INCOMPLETE"},
+	{nudReachable, "REACHABLE"},
+	{nudStale, "STALE"},
+	{nudDelay, "DELAY"},
+	{nudProbe, "PROBE"},
+	{nudFailed, "FAILED"},
+	{nudNoArp, "NOARP"},
+	{nudPermanent, "PERMANENT"},
+}
+
+// ndMsg is the neighbor message header used to dump the neighbor tables
+type ndMsg struct {
+	family uint8
+}
+
+func (m *ndMsg) Len() int {
+	return sizeofNdMsg
+}
+
+func (m *ndMsg) Serialize() []byte {
+	b := make([]byte, sizeofNdMsg)
+	b[0] = m.family
+	return b
+}
+
+// neighbor is either an ARP/NDP entry or a bridge forwarding database entry
+type neighbor struct {
+	family  int
+	ifIndex int
+	state   int
+	flags   int
+	ip      net.IP
+	mac     net.HardwareAddr
+	vlan    int
+}
+
+func neighborStateName(state int) string {
+	var names []string
+	for _, s := range neighborStates {
+		if state&s.state != 0 {
+			names = append(names, s.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, ",")
+}
+
+func parseNeighbor(m []byte) (*neighbor, error) {
+	if len(m) < sizeofNdMsg {
+		return nil, errors.New("neighbor message too short")
+	}
+
+	native := nl.NativeEndian()
+	n := &neighbor{
+		family:  int(m[0]),
+		ifIndex: int(int32(native.Uint32(m[4:8]))),
+		state:   int(native.Uint16(m[8:10])),
+		flags:   int(m[10]),
+	}
+
+	attrs, err := nl.ParseRouteAttr(m[sizeofNdMsg:])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case ndaDst:
+			n.ip = net.IP(attr.Value)
+		case ndaLLAddr:
+			n.mac = net.HardwareAddr(attr.Value)
+		case ndaVlan:
+			n.vlan = int(native.Uint16(attr.Value))
+		}
+	}
+
+	return n, nil
+}
+
+func (n *neighbor) isFDB() bool {
+	return n.family == syscall.AF_BRIDGE
+}
+
+// key identifies an entry of an interface, ARP/NDP entries by their address
+// and forwarding database entries by their MAC and VLAN
+func (n *neighbor) key() string {
+	if n.isFDB() {
+		if n.vlan != 0 {
+			return fmt.Sprintf("FDB/%s@%d", n.mac.String(), n.vlan)
+		}
+		return "FDB/" + n.mac.String()
+	}
+	return "Neighbors/" + n.ip.String()
+}
+
+func (n *neighbor) flagsString() string {
+	var flags []string
+	if n.flags&ntfSelf != 0 {
+		flags = append(flags, "self")
+	}
+	if n.flags&ntfMaster != 0 {
+		flags = append(flags, "master")
+	}
+	if n.flags&ntfExtLearnt != 0 {
+		flags = append(flags, "extern_learn")
+	}
+	return strings.Join(flags, ",")
+}
+
+func (n *neighbor) metadata(m graph.Metadata) {
+	prefix := n.key() + "/"
+
+	if n.mac != nil {
+		m[prefix+"MAC"] = n.mac.String()
+	}
+	m[prefix+"State"] = neighborStateName(n.state)
+
+	if n.isFDB() {
+		if n.vlan != 0 {
+			m[prefix+"Vlan"] = int64(n.vlan)
+		}
+		if flags := n.flagsString(); flags != "" {
+			m[prefix+"Flags"] = flags
+		}
+	}
+}
+
+func isNeighborMetadata(k string) bool {
+	return strings.HasPrefix(k, "Neighbors/") || strings.HasPrefix(k, "FDB/")
+}
+
+var neighborCounterKeys = []string{"Neighbors/Stale", "Neighbors/Incomplete", "Neighbors/Failed"}
+
+// neighborCounters sets the counters of the ARP/NDP entries of an interface,
+// it returns false if the interface has no such entry
+func neighborCounters(entries map[string]*neighbor, m graph.Metadata) bool {
+	var neighbors, stale, incomplete, failed int64
+	for _, n := range entries {
+		if n.isFDB() {
+			continue
+		}
+
+		neighbors++
+		switch {
+		case n.state&nudStale != 0:
+			stale++
+		case n.state&nudIncomplete != 0:
+			incomplete++
+		case n.state&nudFailed != 0:
+			failed++
+		}
+	}
+
+	if neighbors == 0 {
+		return false
+	}
+
+	m["Neighbors/Stale"] = stale
+	m["Neighbors/Incomplete"] = incomplete
+	m["Neighbors/Failed"] = failed
+	return true
+}
+
+// updateNeighborMetadata replaces the neighbor metadata of an interface by
+// the entries currently known for it
+func (u *NetLinkProbe) updateNeighborMetadata(index int) {
+	intf := u.Graph.LookupFirstChild(u.Root, graph.Metadata{"IfIndex": int64(index)})
+	if intf == nil {
+		return
+	}
+
+	m := graph.Metadata{}
+	for k, v := range intf.Metadata() {
+		if !isNeighborMetadata(k) {
+			m[k] = v
+		}
+	}
+
+	for _, n := range u.neighbors[index] {
+		n.metadata(m)
+	}
+	neighborCounters(u.neighbors[index], m)
+
+	u.Graph.SetMetadata(intf, m)
+}
+
+// updateNeighborEntry applies the change of one entry of an interface to its
+// metadata, old being the previous version of the entry and n the new one,
+// nil if the entry was deleted
+func (u *NetLinkProbe) updateNeighborEntry(index int, old *neighbor, n *neighbor) {
+	intf := u.Graph.LookupFirstChild(u.Root, graph.Metadata{"IfIndex": int64(index)})
+	if intf == nil {
+		return
+	}
+
+	updated := graph.Metadata{}
+	if n != nil {
+		n.metadata(updated)
+	}
+
+	var removed []string
+	if old != nil {
+		previous := graph.Metadata{}
+		old.metadata(previous)
+		for k := range previous {
+			if _, ok := updated[k]; !ok {
+				removed = append(removed, k)
+			}
+		}
+	}
+
+	if !neighborCounters(u.neighbors[index], updated) {
+		for _, k := range neighborCounterKeys {
+			if _, err := intf.GetFieldInt64(k); err == nil {
+				removed = append(removed, k)
+			}
+		}
+	}
+
+	if len(removed) == 0 {
+		t := u.Graph.StartMetadataTransaction(intf)
+		for k, v := range updated {
+			t.AddMetadata(k, v)
+		}
+		t.Commit()
+		return
+	}
+
+	m := intf.Metadata()
+	for _, k := range removed {
+		delete(m, k)
+	}
+	for k, v := range updated {
+		m[k] = v
+	}
+	u.Graph.SetMetadata(intf, m)
+}
+
+// ignored returns whether an entry is not reported, multicast and broadcast
+// FDB entries being added to every bridge port
+func (n *neighbor) ignored() bool {
+	if n.isFDB() {
+		return len(n.mac) == 0 || n.mac[0]&0x01 == 0x01
+	}
+	return n.ip == nil
+}
+
+// addNeighbor stores an entry and returns the previous version of it
+func (u *NetLinkProbe) addNeighbor(n *neighbor) *neighbor {
+	entries, ok := u.neighbors[n.ifIndex]
+	if !ok {
+		entries = make(map[string]*neighbor)
+		u.neighbors[n.ifIndex] = entries
+	}
+
+	old := entries[n.key()]
+	entries[n.key()] = n
+	return old
+}
+
+func (u *NetLinkProbe) onNeighborAdded(n *neighbor) {
+	if n.ignored() {
+		return
+	}
+
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	old := u.addNeighbor(n)
+	u.updateNeighborEntry(n.ifIndex, old, n)
+}
+
+func (u *NetLinkProbe) onNeighborDeleted(n *neighbor) {
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	entries, ok := u.neighbors[n.ifIndex]
+	if !ok {
+		return
+	}
+
+	if old, ok := entries[n.key()]; ok {
+		delete(entries, n.key())
+		if len(entries) == 0 {
+			delete(u.neighbors, n.ifIndex)
+		}
+		u.updateNeighborEntry(n.ifIndex, old, nil)
+	}
+}
+
+func parseNeighborMessage(data []byte) *neighbor {
+	n, err := parseNeighbor(data)
+	if err != nil {
+		logging.GetLogger().Warningf("Failed to parse neighbor message: %s", err.Error())
+		return nil
+	}
+
+	switch n.family {
+	case syscall.AF_INET, syscall.AF_INET6, syscall.AF_BRIDGE:
+		return n
+	}
+	return nil
+}
+
+// loadNeighbors stores the entries of a neighbor tables dump and sets the
+// metadata of each interface once
+func (u *NetLinkProbe) loadNeighbors(msgs []routingMessage) {
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	indexes := make(map[int]bool)
+	for _, msg := range msgs {
+		if n := parseNeighborMessage(msg.data); n != nil && !n.ignored() {
+			u.addNeighbor(n)
+			indexes[n.ifIndex] = true
+		}
+	}
+
+	for index := range indexes {
+		u.updateNeighborMetadata(index)
+	}
+}
+
+// handleNeighborMessage updates the neighbor entries of the namespace from
+// a neighbor netlink message
+func (u *NetLinkProbe) handleNeighborMessage(msgType uint16, data []byte) {
+	n := parseNeighborMessage(data)
+	if n == nil {
+		return
+	}
+
+	if msgType == syscall.RTM_NEWNEIGH {
+		u.onNeighborAdded(n)
+	} else {
+		u.onNeighborDeleted(n)
+	}
+}
+
+// dumpNeighbors returns the ARP/NDP and forwarding database entries of the
+// current namespace, it has to be called within the namespace
+func dumpNeighbors() (msgs []routingMessage, err error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETNEIGH, syscall.NLM_F_DUMP)
+	req.AddData(&ndMsg{family: syscall.AF_UNSPEC})
+
+	res, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWNEIGH)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, data := range res {
+		msgs = append(msgs, routingMessage{msgType: syscall.RTM_NEWNEIGH, data: data})
+	}
+
+	return msgs, nil
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func testNdMsg(family byte, ifIndex uint32, state uint16, flags byte, attrs ...[]byte) []byte {
+	b := make([]byte, sizeofNdMsg)
+	b[0] = family
+	nl.NativeEndian().PutUint32(b[4:8], ifIndex)
+	nl.NativeEndian().PutUint16(b[8:10], state)
+	b[10] = flags
+	for _, attr := range attrs {
+		b = append(b, attr...)
+	}
+	return b
+}
+
+func testUint16(v uint16) []byte {
+	b := make([]byte, 2)
+	nl.NativeEndian().PutUint16(b, v)
+	return b
+}
+
+func testMAC(s string) []byte {
+	mac, _ := net.ParseMAC(s)
+	return mac
+}
+
+func TestNeighborTables(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	root := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth0", "IfIndex": int64(2)})
+	port := g.NewNode(graph.GenID(), graph.Metadata{"Type": "veth", "Name": "veth0", "IfIndex": int64(3)})
+	g.Link(root, eth0, ownershipMetadata)
+	g.Link(root, port, ownershipMetadata)
+	g.Unlock()
+
+	u := NewNetLinkProbe(g, root)
+
+	msgs := [][]byte{
+		testNdMsg(syscall.AF_INET, 2, nudReachable, 0,
+			testRtAttr(ndaDst, net.ParseIP("192.168.0.1").To4()),
+			testRtAttr(ndaLLAddr, testMAC("00:11:22:33:44:55"))),
+		testNdMsg(syscall.AF_INET, 2, nudStale, 0,
+			testRtAttr(ndaDst, net.ParseIP("192.168.0.2").To4()),
+			testRtAttr(ndaLLAddr, testMAC("00:11:22:33:44:66"))),
+		testNdMsg(syscall.AF_INET, 2, nudIncomplete, 0,
+			testRtAttr(ndaDst, net.ParseIP("192.168.0.3").To4())),
+		testNdMsg(syscall.AF_BRIDGE, 3, nudReachable, ntfMaster,
+			testRtAttr(ndaLLAddr, testMAC("02:42:ac:11:00:02")),
+			testRtAttr(ndaVlan, testUint16(10))),
+		// multicast entries are ignored
+		testNdMsg(syscall.AF_BRIDGE, 3, nudPermanent, ntfSelf,
+			testRtAttr(ndaLLAddr, testMAC("33:33:00:00:00:01"))),
+	}
+	for _, msg := range msgs {
+		u.handleNeighborMessage(syscall.RTM_NEWNEIGH, msg)
+	}
+
+	expected := graph.Metadata{
+		"Neighbors/192.168.0.1/MAC":   "00:11:22:33:44:55",
+		"Neighbors/192.168.0.1/State": "REACHABLE",
+		"Neighbors/192.168.0.2/State": "STALE",
+		"Neighbors/192.168.0.3/State": "INCOMPLETE",
+		"Neighbors/Stale":             int64(1),
+		"Neighbors/Incomplete":        int64(1),
+		"Neighbors/Failed":            int64(0),
+	}
+	for k, v := range expected {
+		if m := eth0.Metadata()[k]; m != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m)
+		}
+	}
+
+	fdb := graph.Metadata{
+		"FDB/02:42:ac:11:00:02@10/MAC":   "02:42:ac:11:00:02",
+		"FDB/02:42:ac:11:00:02@10/State": "REACHABLE",
+		"FDB/02:42:ac:11:00:02@10/Vlan":  int64(10),
+		"FDB/02:42:ac:11:00:02@10/Flags": "master",
+	}
+	for k, v := range fdb {
+		if m := port.Metadata()[k]; m != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m)
+		}
+	}
+
+	if len(port.Metadata()) != 3+len(fdb) {
+		t.Errorf("Multicast FDB entry should be ignored: %v", port.Metadata())
+	}
+
+	u.handleNeighborMessage(syscall.RTM_DELNEIGH, msgs[1])
+	if _, ok := eth0.Metadata()["Neighbors/192.168.0.2/State"]; ok {
+		t.Error("Deleted neighbor entry should be removed")
+	}
+	if stale := eth0.Metadata()["Neighbors/Stale"]; stale != int64(0) {
+		t.Errorf("Stale counter not updated: %v", stale)
+	}
+
+	// only the changed entry and the counters are updated
+	u.handleNeighborMessage(syscall.RTM_NEWNEIGH, testNdMsg(syscall.AF_INET, 2, nudStale, 0,
+		testRtAttr(ndaDst, net.ParseIP("192.168.0.1").To4()),
+		testRtAttr(ndaLLAddr, testMAC("00:11:22:33:44:55"))))
+	if state := eth0.Metadata()["Neighbors/192.168.0.1/State"]; state != "STALE" {
+		t.Errorf("Neighbor state not updated: %v", state)
+	}
+	if stale := eth0.Metadata()["Neighbors/Stale"]; stale != int64(1) {
+		t.Errorf("Stale counter not updated: %v", stale)
+	}
+	if state := eth0.Metadata()["Neighbors/192.168.0.3/State"]; state != "INCOMPLETE" {
+		t.Errorf("Other entries should be kept: %v", state)
+	}
+
+	// the dump sets the metadata of each interface at once
+	var dump []routingMessage
+	for _, msg := range msgs {
+		dump = append(dump, routingMessage{msgType: syscall.RTM_NEWNEIGH, data: msg})
+	}
+
+	g.Lock()
+	eth1 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth1", "IfIndex": int64(2)})
+	g.Link(root, eth1, ownershipMetadata)
+	g.DelNode(eth0)
+	g.Unlock()
+
+	u = NewNetLinkProbe(g, root)
+	u.loadNeighbors(dump)
+
+	for k, v := range expected {
+		if m := eth1.Metadata()[k]; m != v {
+			t.Errorf("Expected %s to be %v after the dump, got %v", k, v, m)
+		}
+	}
+}
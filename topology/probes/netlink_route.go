@@ -0,0 +1,706 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Policy routing rule attributes and actions from linux/fib_rules.h
+const (
+	fraDst      = 1
+	fraSrc      = 2
+	fraIifName  = 3
+	fraGoto     = 4
+	fraPriority = 6
+	fraFwMark   = 10
+	fraTable    = 15
+	fraFwMask   = 16
+	fraOifName  = 17
+
+	frActToTbl       = 1
+	frActGoto        = 2
+	frActNop         = 3
+	frActBlackhole   = 6
+	frActUnreachable = 7
+	frActProhibit    = 8
+
+	fibRuleInvert = 0x2
+)
+
+var egressMetadata = graph.Metadata{"RelationType": "egress"}
+
+var errUnsupportedFamily = errors.New("unsupported address family")
+
+type nextHop struct {
+	gateway net.IP
+	ifIndex int
+	weight  int
+}
+
+type route struct {
+	family   int
+	table    int
+	tos      int
+	dst      *net.IPNet
+	src      net.IP
+	priority int
+	protocol int
+	scope    int
+	kind     int
+	cloned   bool
+	nextHops []nextHop
+}
+
+type rule struct {
+	family    int
+	table     int
+	priority  int
+	action    int
+	invert    bool
+	src       *net.IPNet
+	dst       *net.IPNet
+	iifName   string
+	oifName   string
+	mark      int
+	mask      int
+	gotoTable int
+}
+
+// parseTable returns the identifier of a routing table from its name or its
+// number
+func parseTable(name string) (int, error) {
+	switch name {
+	case "default":
+		return syscall.RT_TABLE_DEFAULT, nil
+	case "main":
+		return syscall.RT_TABLE_MAIN, nil
+	case "local":
+		return syscall.RT_TABLE_LOCAL, nil
+	}
+
+	table, err := strconv.Atoi(name)
+	if err != nil || table <= 0 {
+		return 0, fmt.Errorf("Invalid routing table: %s", name)
+	}
+	return table, nil
+}
+
+func tableName(table int) string {
+	switch table {
+	case syscall.RT_TABLE_DEFAULT:
+		return "default"
+	case syscall.RT_TABLE_MAIN:
+		return "main"
+	case syscall.RT_TABLE_LOCAL:
+		return "local"
+	}
+	return strconv.Itoa(table)
+}
+
+func routeProtocolName(protocol int) string {
+	switch protocol {
+	case syscall.RTPROT_REDIRECT:
+		return "redirect"
+	case syscall.RTPROT_KERNEL:
+		return "kernel"
+	case syscall.RTPROT_BOOT:
+		return "boot"
+	case syscall.RTPROT_STATIC:
+		return "static"
+	case syscall.RTPROT_RA:
+		return "ra"
+	case syscall.RTPROT_DHCP:
+		return "dhcp"
+	}
+	return strconv.Itoa(protocol)
+}
+
+func routeScopeName(scope int) string {
+	switch scope {
+	case syscall.RT_SCOPE_UNIVERSE:
+		return "universe"
+	case syscall.RT_SCOPE_SITE:
+		return "site"
+	case syscall.RT_SCOPE_LINK:
+		return "link"
+	case syscall.RT_SCOPE_HOST:
+		return "host"
+	case syscall.RT_SCOPE_NOWHERE:
+		return "nowhere"
+	}
+	return strconv.Itoa(scope)
+}
+
+func routeTypeName(kind int) string {
+	switch kind {
+	case syscall.RTN_UNICAST:
+		return "unicast"
+	case syscall.RTN_LOCAL:
+		return "local"
+	case syscall.RTN_BROADCAST:
+		return "broadcast"
+	case syscall.RTN_ANYCAST:
+		return "anycast"
+	case syscall.RTN_MULTICAST:
+		return "multicast"
+	case syscall.RTN_BLACKHOLE:
+		return "blackhole"
+	case syscall.RTN_UNREACHABLE:
+		return "unreachable"
+	case syscall.RTN_PROHIBIT:
+		return "prohibit"
+	case syscall.RTN_THROW:
+		return "throw"
+	}
+	return strconv.Itoa(kind)
+}
+
+func ruleActionName(action int) string {
+	switch action {
+	case frActToTbl:
+		return "lookup"
+	case frActGoto:
+		return "goto"
+	case frActNop:
+		return "nop"
+	case frActBlackhole:
+		return "blackhole"
+	case frActUnreachable:
+		return "unreachable"
+	case frActProhibit:
+		return "prohibit"
+	}
+	return strconv.Itoa(action)
+}
+
+func familyPrefix(family int, value []byte, length int) (*net.IPNet, error) {
+	bits := 8 * net.IPv4len
+	if family == netlink.FAMILY_V6 {
+		bits = 8 * net.IPv6len
+	} else if family != netlink.FAMILY_V4 {
+		return nil, errUnsupportedFamily
+	}
+
+	ip := make(net.IP, bits/8)
+	copy(ip, value)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(length, bits)}, nil
+}
+
+func parseRoute(m []byte) (*route, error) {
+	msg := nl.DeserializeRtMsg(m)
+
+	attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+	if err != nil {
+		return nil, err
+	}
+
+	r := &route{
+		family:   int(msg.Family),
+		table:    int(msg.Table),
+		tos:      int(msg.Tos),
+		protocol: int(msg.Protocol),
+		scope:    int(msg.Scope),
+		kind:     int(msg.Type),
+		cloned:   msg.Flags&syscall.RTM_F_CLONED != 0,
+	}
+
+	if r.dst, err = familyPrefix(r.family, nil, int(msg.Dst_len)); err != nil {
+		return nil, err
+	}
+
+	var nh nextHop
+	native := nl.NativeEndian()
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.RTA_DST:
+			if r.dst, err = familyPrefix(r.family, attr.Value, int(msg.Dst_len)); err != nil {
+				return nil, err
+			}
+		case syscall.RTA_PREFSRC:
+			r.src = net.IP(attr.Value)
+		case syscall.RTA_GATEWAY:
+			nh.gateway = net.IP(attr.Value)
+		case syscall.RTA_OIF:
+			nh.ifIndex = int(native.Uint32(attr.Value))
+		case syscall.RTA_PRIORITY:
+			r.priority = int(native.Uint32(attr.Value))
+		case syscall.RTA_TABLE:
+			r.table = int(native.Uint32(attr.Value))
+		case syscall.RTA_MULTIPATH:
+			if r.nextHops, err = parseMultipath(attr.Value, native); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(r.nextHops) == 0 && (nh.gateway != nil || nh.ifIndex != 0) {
+		nh.weight = 1
+		r.nextHops = []nextHop{nh}
+	}
+
+	return r, nil
+}
+
+// parseMultipath decodes the rtnexthop structures of an ECMP route
+func parseMultipath(b []byte, native binary.ByteOrder) (nextHops []nextHop, err error) {
+	for len(b) >= syscall.SizeofRtNexthop {
+		length := int(native.Uint16(b[0:2]))
+		if length < syscall.SizeofRtNexthop || length > len(b) {
+			return nil, fmt.Errorf("invalid next hop length %d", length)
+		}
+
+		nh := nextHop{
+			weight:  int(b[3]) + 1,
+			ifIndex: int(int32(native.Uint32(b[4:8]))),
+		}
+
+		attrs, err := nl.ParseRouteAttr(b[syscall.SizeofRtNexthop:length])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.RTA_GATEWAY {
+				nh.gateway = net.IP(attr.Value)
+			}
+		}
+		nextHops = append(nextHops, nh)
+
+		length = (length + syscall.NLMSG_ALIGNTO - 1) & ^(syscall.NLMSG_ALIGNTO - 1)
+		if length > len(b) {
+			break
+		}
+		b = b[length:]
+	}
+
+	return nextHops, nil
+}
+
+// parseRule decodes a policy routing rule, the fib_rule_hdr having the same
+// layout as the route header
+func parseRule(m []byte) (*rule, error) {
+	msg := nl.DeserializeRtMsg(m)
+
+	attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rule{
+		family: int(msg.Family),
+		table:  int(msg.Table),
+		action: int(msg.Type),
+		invert: msg.Flags&fibRuleInvert != 0,
+	}
+
+	if r.family != netlink.FAMILY_V4 && r.family != netlink.FAMILY_V6 {
+		return nil, errUnsupportedFamily
+	}
+
+	native := nl.NativeEndian()
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case fraDst:
+			r.dst, _ = familyPrefix(r.family, attr.Value, int(msg.Dst_len))
+		case fraSrc:
+			r.src, _ = familyPrefix(r.family, attr.Value, int(msg.Src_len))
+		case fraIifName:
+			r.iifName = strings.TrimRight(string(attr.Value), "\x00")
+		case fraOifName:
+			r.oifName = strings.TrimRight(string(attr.Value), "\x00")
+		case fraGoto:
+			r.gotoTable = int(native.Uint32(attr.Value))
+		case fraPriority:
+			r.priority = int(native.Uint32(attr.Value))
+		case fraFwMark:
+			r.mark = int(native.Uint32(attr.Value))
+		case fraFwMask:
+			r.mask = int(native.Uint32(attr.Value))
+		case fraTable:
+			r.table = int(native.Uint32(attr.Value))
+		}
+	}
+
+	return r, nil
+}
+
+func (r *route) destination() string {
+	if ones, _ := r.dst.Mask.Size(); ones == 0 {
+		return "default"
+	}
+	return r.dst.String()
+}
+
+// key identifies a route within a namespace like the kernel does
+func (r *route) key() string {
+	return fmt.Sprintf("%d/%s/%d/%d", r.table, r.dst.String(), r.tos, r.priority)
+}
+
+func (r *route) name() string {
+	name := r.destination()
+	if r.table != syscall.RT_TABLE_MAIN {
+		name += " table " + tableName(r.table)
+	}
+	if r.priority != 0 {
+		name += fmt.Sprintf(" metric %d", r.priority)
+	}
+	return name
+}
+
+func (r *route) metadata() graph.Metadata {
+	m := graph.Metadata{
+		"Type":        "route",
+		"Name":        r.name(),
+		"Family":      getFamilyKey(r.family),
+		"Table":       int64(r.table),
+		"TableName":   tableName(r.table),
+		"Destination": r.dst.String(),
+		"Priority":    int64(r.priority),
+		"Protocol":    routeProtocolName(r.protocol),
+		"Scope":       routeScopeName(r.scope),
+		"RouteType":   routeTypeName(r.kind),
+	}
+
+	if r.tos != 0 {
+		m["Tos"] = int64(r.tos)
+	}
+
+	if r.src != nil {
+		m["Source"] = r.src.String()
+	}
+
+	for i, nh := range r.nextHops {
+		prefix := fmt.Sprintf("NextHops/%d/", i)
+		if nh.gateway != nil {
+			m[prefix+"Gateway"] = nh.gateway.String()
+		}
+		m[prefix+"IfIndex"] = int64(nh.ifIndex)
+		m[prefix+"Weight"] = int64(nh.weight)
+	}
+
+	return m
+}
+
+func (r *rule) name() string {
+	name := fmt.Sprintf("%d: ", r.priority)
+	if r.invert {
+		name += "not "
+	}
+
+	if r.src != nil {
+		name += "from " + r.src.String()
+	} else {
+		name += "from all"
+	}
+
+	if r.dst != nil {
+		name += " to " + r.dst.String()
+	}
+	if r.mark != 0 {
+		name += fmt.Sprintf(" fwmark %#x", r.mark)
+	}
+	if r.iifName != "" {
+		name += " iif " + r.iifName
+	}
+	if r.oifName != "" {
+		name += " oif " + r.oifName
+	}
+
+	switch r.action {
+	case frActToTbl:
+		name += " lookup " + tableName(r.table)
+	case frActGoto:
+		name += fmt.Sprintf(" goto %d", r.gotoTable)
+	default:
+		name += " " + ruleActionName(r.action)
+	}
+
+	return name
+}
+
+func (r *rule) key() string {
+	return getFamilyKey(r.family) + "/" + r.name()
+}
+
+func (r *rule) metadata() graph.Metadata {
+	m := graph.Metadata{
+		"Type":      "routingrule",
+		"Name":      r.name(),
+		"Family":    getFamilyKey(r.family),
+		"Priority":  int64(r.priority),
+		"Action":    ruleActionName(r.action),
+		"Table":     int64(r.table),
+		"TableName": tableName(r.table),
+	}
+
+	if r.invert {
+		m["Invert"] = true
+	}
+	if r.src != nil {
+		m["Source"] = r.src.String()
+	}
+	if r.dst != nil {
+		m["Destination"] = r.dst.String()
+	}
+	if r.iifName != "" {
+		m["InputInterface"] = r.iifName
+	}
+	if r.oifName != "" {
+		m["OutputInterface"] = r.oifName
+	}
+	if r.mark != 0 {
+		m["Mark"] = int64(r.mark)
+		m["Mask"] = int64(r.mask)
+	}
+	if r.action == frActGoto {
+		m["Goto"] = int64(r.gotoTable)
+	}
+
+	return m
+}
+
+type routingMessage struct {
+	msgType uint16
+	data    []byte
+}
+
+// dumpRoutingTables returns the rules and routes messages of the current
+// namespace, it has to be called within the namespace
+func dumpRoutingTables() (msgs []routingMessage, err error) {
+	for _, t := range []struct{ req, res int }{
+		{syscall.RTM_GETRULE, syscall.RTM_NEWRULE},
+		{syscall.RTM_GETROUTE, syscall.RTM_NEWROUTE},
+	} {
+		req := nl.NewNetlinkRequest(t.req, syscall.NLM_F_DUMP)
+		req.AddData(nl.NewRtMsg())
+
+		res, err := req.Execute(syscall.NETLINK_ROUTE, uint16(t.res))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, data := range res {
+			msgs = append(msgs, routingMessage{msgType: uint16(t.res), data: data})
+		}
+	}
+
+	return msgs, nil
+}
+
+func (u *NetLinkProbe) linkRouteToInterfaces(node *graph.Node, r *route) {
+	var intfs []*graph.Node
+	for _, nh := range r.nextHops {
+		if nh.ifIndex == 0 {
+			continue
+		}
+		if intf := u.Graph.LookupFirstChild(u.Root, graph.Metadata{"IfIndex": int64(nh.ifIndex)}); intf != nil {
+			intfs = append(intfs, intf)
+		}
+	}
+
+	for _, child := range u.Graph.LookupChildren(node, graph.Metadata{}, egressMetadata) {
+		found := false
+		for _, intf := range intfs {
+			found = found || intf.ID == child.ID
+		}
+		if !found {
+			u.Graph.Unlink(node, child)
+		}
+	}
+
+	for _, intf := range intfs {
+		if !u.Graph.AreLinked(node, intf, egressMetadata) {
+			u.Graph.Link(node, intf, egressMetadata)
+		}
+	}
+}
+
+// isTableReported returns whether the routes of a table are reported, the
+// configured tables or by default the main table and the tables looked up
+// by a rule
+func (u *NetLinkProbe) isTableReported(table int) bool {
+	if u.routingTables != nil {
+		return u.routingTables[table]
+	}
+	return table == syscall.RT_TABLE_MAIN || u.ruleTables[table] > 0
+}
+
+func (u *NetLinkProbe) addRouteNode(key string, r *route) {
+	node, ok := u.routes[key]
+	if ok {
+		u.Graph.SetMetadata(node, r.metadata())
+	} else {
+		node = u.Graph.NewNode(graph.GenID(), r.metadata())
+		u.Graph.Link(u.Root, node, ownershipMetadata)
+		u.routes[key] = node
+	}
+
+	u.linkRouteToInterfaces(node, r)
+}
+
+// onTableReported adds or removes the nodes of the routes of a table once a
+// first rule looks it up or the last one is deleted
+func (u *NetLinkProbe) onTableReported(table int, reported bool) {
+	for key, r := range u.tableRoutes {
+		if r.table != table {
+			continue
+		}
+
+		if reported {
+			u.addRouteNode(key, r)
+		} else if node, ok := u.routes[key]; ok {
+			u.Graph.DelNode(node)
+			delete(u.routes, key)
+		}
+	}
+}
+
+func (u *NetLinkProbe) onRouteAdded(r *route) {
+	if r.family != netlink.FAMILY_V4 && r.family != netlink.FAMILY_V6 {
+		return
+	}
+
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	// the routes of the tables not reported are kept in case a rule looks
+	// them up later
+	key := r.key()
+	u.tableRoutes[key] = r
+
+	if u.isTableReported(r.table) {
+		u.addRouteNode(key, r)
+	}
+}
+
+func (u *NetLinkProbe) onRouteDeleted(r *route) {
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	key := r.key()
+	delete(u.tableRoutes, key)
+	if node, ok := u.routes[key]; ok {
+		u.Graph.DelNode(node)
+		delete(u.routes, key)
+	}
+}
+
+func (u *NetLinkProbe) onRuleAdded(r *rule) {
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	key := r.key()
+	if _, ok := u.rules[key]; ok {
+		return
+	}
+
+	node := u.Graph.NewNode(graph.GenID(), r.metadata())
+	u.Graph.Link(u.Root, node, ownershipMetadata)
+	u.rules[key] = node
+
+	if r.action == frActToTbl {
+		reported := u.isTableReported(r.table)
+		u.ruleTables[r.table]++
+		if !reported && u.isTableReported(r.table) {
+			u.onTableReported(r.table, true)
+		}
+	}
+}
+
+func (u *NetLinkProbe) onRuleDeleted(r *rule) {
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	key := r.key()
+	node, ok := u.rules[key]
+	if !ok {
+		return
+	}
+
+	u.Graph.DelNode(node)
+	delete(u.rules, key)
+
+	if r.action == frActToTbl {
+		reported := u.isTableReported(r.table)
+		if u.ruleTables[r.table]--; u.ruleTables[r.table] <= 0 {
+			delete(u.ruleTables, r.table)
+		}
+		if reported && !u.isTableReported(r.table) {
+			u.onTableReported(r.table, false)
+		}
+	}
+}
+
+// handleRoutingMessage updates the routing tables of the namespace from a
+// route or rule netlink message
+func (u *NetLinkProbe) handleRoutingMessage(msgType uint16, data []byte) {
+	switch msgType {
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		r, err := parseRoute(data)
+		if err != nil {
+			if err != errUnsupportedFamily {
+				logging.GetLogger().Warningf("Failed to parse route message: %s", err.Error())
+			}
+			return
+		}
+
+		// routing cache entries are not part of the routing tables, the
+		// local and broadcast routes are the addresses of the interfaces
+		if r.cloned || r.kind == syscall.RTN_LOCAL || r.kind == syscall.RTN_BROADCAST {
+			return
+		}
+
+		if msgType == syscall.RTM_NEWROUTE {
+			u.onRouteAdded(r)
+		} else {
+			u.onRouteDeleted(r)
+		}
+	case syscall.RTM_NEWRULE, syscall.RTM_DELRULE:
+		r, err := parseRule(data)
+		if err != nil {
+			if err != errUnsupportedFamily {
+				logging.GetLogger().Warningf("Failed to parse rule message: %s", err.Error())
+			}
+			return
+		}
+
+		if msgType == syscall.RTM_NEWRULE {
+			u.onRuleAdded(r)
+		} else {
+			u.onRuleDeleted(r)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func testRtMsg(family, dstLen, srcLen, table, protocol, scope, kind byte, flags uint32, attrs ...[]byte) []byte {
+	b := []byte{family, dstLen, srcLen, 0, table, protocol, scope, kind, 0, 0, 0, 0}
+	nl.NativeEndian().PutUint32(b[8:], flags)
+	for _, attr := range attrs {
+		b = append(b, attr...)
+	}
+	return b
+}
+
+func testRtAttr(kind int, value []byte) []byte {
+	length := syscall.SizeofRtAttr + len(value)
+	b := make([]byte, (length+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	nl.NativeEndian().PutUint16(b[0:2], uint16(length))
+	nl.NativeEndian().PutUint16(b[2:4], uint16(kind))
+	copy(b[syscall.SizeofRtAttr:], value)
+	return b
+}
+
+func testUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	nl.NativeEndian().PutUint32(b, v)
+	return b
+}
+
+func testRtNexthop(hops byte, ifIndex uint32, gateway net.IP) []byte {
+	attr := testRtAttr(syscall.RTA_GATEWAY, gateway.To4())
+	b := make([]byte, syscall.SizeofRtNexthop)
+	nl.NativeEndian().PutUint16(b[0:2], uint16(len(b)+len(attr)))
+	b[3] = hops
+	nl.NativeEndian().PutUint32(b[4:8], ifIndex)
+	return append(b, attr...)
+}
+
+func TestParseRoute(t *testing.T) {
+	msg := testRtMsg(syscall.AF_INET, 0, 0, syscall.RT_TABLE_MAIN, syscall.RTPROT_DHCP, syscall.RT_SCOPE_UNIVERSE, syscall.RTN_UNICAST, 0,
+		testRtAttr(syscall.RTA_TABLE, testUint32(syscall.RT_TABLE_MAIN)),
+		testRtAttr(syscall.RTA_PRIORITY, testUint32(100)),
+		testRtAttr(syscall.RTA_GATEWAY, net.ParseIP("192.168.0.1").To4()),
+		testRtAttr(syscall.RTA_OIF, testUint32(2)),
+	)
+
+	r, err := parseRoute(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := r.metadata()
+	expected := map[string]interface{}{
+		"Name":               "default metric 100",
+		"Destination":        "0.0.0.0/0",
+		"Table":              int64(254),
+		"Protocol":           "dhcp",
+		"RouteType":          "unicast",
+		"NextHops/0/Gateway": "192.168.0.1",
+		"NextHops/0/IfIndex": int64(2),
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m[k])
+		}
+	}
+}
+
+func TestParseMultipathRoute(t *testing.T) {
+	var multipath []byte
+	multipath = append(multipath, testRtNexthop(0, 2, net.ParseIP("10.1.0.1"))...)
+	multipath = append(multipath, testRtNexthop(1, 3, net.ParseIP("10.2.0.1"))...)
+
+	msg := testRtMsg(syscall.AF_INET, 8, 0, 100, syscall.RTPROT_STATIC, syscall.RT_SCOPE_UNIVERSE, syscall.RTN_UNICAST, 0,
+		testRtAttr(syscall.RTA_DST, net.ParseIP("10.0.0.0").To4()),
+		testRtAttr(syscall.RTA_MULTIPATH, multipath),
+	)
+
+	r, err := parseRoute(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.name() != "10.0.0.0/8 table 100" {
+		t.Errorf("Wrong route name: %s", r.name())
+	}
+
+	if len(r.nextHops) != 2 {
+		t.Fatalf("Expected 2 next hops, got %+v", r.nextHops)
+	}
+
+	if !r.nextHops[1].gateway.Equal(net.ParseIP("10.2.0.1")) || r.nextHops[1].ifIndex != 3 || r.nextHops[1].weight != 2 {
+		t.Errorf("Wrong second next hop: %+v", r.nextHops[1])
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	msg := testRtMsg(syscall.AF_INET, 0, 24, 100, 0, 0, frActToTbl, 0,
+		testRtAttr(fraSrc, net.ParseIP("192.168.1.0").To4()),
+		testRtAttr(fraPriority, testUint32(1000)),
+		testRtAttr(fraIifName, []byte("eth0\x00")),
+	)
+
+	r, err := parseRule(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := r.name(); name != "1000: from 192.168.1.0/24 iif eth0 lookup 100" {
+		t.Errorf("Wrong rule name: %s", name)
+	}
+
+	m := r.metadata()
+	if m["InputInterface"] != "eth0" || m["Source"] != "192.168.1.0/24" || m["Action"] != "lookup" {
+		t.Errorf("Wrong rule metadata: %v", m)
+	}
+}
+
+func TestRoutingTables(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	root := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host"})
+	g.Unlock()
+
+	u := NewNetLinkProbe(g, root)
+
+	newRoute := func(table int, dst string) *route {
+		_, ipnet, _ := net.ParseCIDR(dst)
+		return &route{family: netlink.FAMILY_V4, table: table, dst: ipnet, kind: syscall.RTN_UNICAST}
+	}
+
+	routeNodes := func() int {
+		g.RLock()
+		defer g.RUnlock()
+		return len(g.GetNodes(graph.Metadata{"Type": "route"}))
+	}
+
+	u.onRouteAdded(newRoute(syscall.RT_TABLE_MAIN, "0.0.0.0/0"))
+	u.onRouteAdded(newRoute(100, "10.0.0.0/8"))
+	if n := routeNodes(); n != 1 {
+		t.Fatalf("Only the routes of the main table should be reported, got %d", n)
+	}
+
+	// the table 100 is reported as long as a rule looks it up
+	rule := &rule{family: netlink.FAMILY_V4, table: 100, priority: 1000, action: frActToTbl}
+	u.onRuleAdded(rule)
+	if n := routeNodes(); n != 2 {
+		t.Errorf("The routes of a table looked up by a rule should be reported, got %d", n)
+	}
+
+	u.onRuleDeleted(rule)
+	if n := routeNodes(); n != 1 {
+		t.Errorf("The routes of a table no more looked up should be removed, got %d", n)
+	}
+
+	// the local and broadcast routes are skipped
+	local := testRtMsg(syscall.AF_INET, 32, 0, syscall.RT_TABLE_MAIN, syscall.RTPROT_KERNEL, syscall.RT_SCOPE_HOST, syscall.RTN_LOCAL, 0,
+		testRtAttr(syscall.RTA_DST, net.ParseIP("192.168.0.1").To4()),
+	)
+	u.handleRoutingMessage(syscall.RTM_NEWROUTE, local)
+	if n := routeNodes(); n != 1 {
+		t.Errorf("Local routes should not be reported, got %d", n)
+	}
+}
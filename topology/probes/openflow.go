@@ -0,0 +1,345 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+var openFlowMetadata = graph.Metadata{"RelationType": "openflow"}
+
+// metadata keys owned by the OpenFlow probe, the other keys like the TID
+// are kept when a rule is updated
+var openFlowRuleKeys = map[string]bool{
+	"Type": true, "Name": true, "Table": true, "Priority": true, "Cookie": true,
+	"Match": true, "Actions": true, "IdleTimeout": true, "HardTimeout": true,
+	"Packets": true, "Bytes": true,
+}
+
+// ovs-ofctl fields reporting the state of a flow rather than its match
+var openFlowStatFields = map[string]bool{
+	"cookie": true, "duration": true, "table": true, "n_packets": true,
+	"n_bytes": true, "idle_timeout": true, "hard_timeout": true,
+	"idle_age": true, "hard_age": true, "importance": true,
+	"send_flow_rem": true, "reset_counts": true, "no_packet_counts": true,
+	"no_byte_counts": true, "check_overlap": true,
+}
+
+// default priority not reported by ovs-ofctl
+const openFlowDefaultPriority = 32768
+
+// versions negotiated with the bridges not listing their OpenFlow versions
+const openFlowDefaultProtocols = "OpenFlow10,OpenFlow13"
+
+// OpenFlowProbe periodically dumps the OpenFlow rules of the OVS bridges
+// along with their counters. Rules are owned by their bridge node and linked
+// to the interface matched by their input port.
+type OpenFlowProbe struct {
+	Graph  *graph.Graph
+	Root   *graph.Node
+	poller *nodePoller
+}
+
+type openFlowRule struct {
+	table       int64
+	priority    int64
+	cookie      string
+	match       []string
+	actions     string
+	idleTimeout int64
+	hardTimeout int64
+	packets     int64
+	bytes       int64
+}
+
+func (r *openFlowRule) name() string {
+	name := fmt.Sprintf("table=%d priority=%d", r.table, r.priority)
+	if len(r.match) > 0 {
+		name += " " + strings.Join(r.match, ",")
+	}
+	return name
+}
+
+func (r *openFlowRule) metadata() graph.Metadata {
+	m := graph.Metadata{
+		"Type":     "ofrule",
+		"Name":     r.name(),
+		"Table":    r.table,
+		"Priority": r.priority,
+		"Cookie":   r.cookie,
+		"Actions":  r.actions,
+		"Packets":  r.packets,
+		"Bytes":    r.bytes,
+	}
+
+	if len(r.match) > 0 {
+		m["Match"] = strings.Join(r.match, ",")
+	}
+	if r.idleTimeout != 0 {
+		m["IdleTimeout"] = r.idleTimeout
+	}
+	if r.hardTimeout != 0 {
+		m["HardTimeout"] = r.hardTimeout
+	}
+
+	return m
+}
+
+// inPort returns the input port number matched by the rule, 0 if none
+func (r *openFlowRule) inPort() int64 {
+	for _, field := range r.match {
+		if strings.HasPrefix(field, "in_port=") {
+			port, _ := strconv.ParseInt(field[len("in_port="):], 10, 64)
+			return port
+		}
+	}
+	return 0
+}
+
+// parseOpenFlowRule parses a flow as reported by ovs-ofctl dump-flows
+func parseOpenFlowRule(line string) (*openFlowRule, error) {
+	index := strings.Index(line, " actions=")
+	if index == -1 {
+		return nil, fmt.Errorf("no actions found in flow: %s", line)
+	}
+
+	rule := &openFlowRule{
+		cookie:   "0x0",
+		priority: openFlowDefaultPriority,
+		actions:  strings.TrimSpace(line[index+len(" actions="):]),
+	}
+
+	for _, field := range strings.Split(line[:index], ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		kv := strings.SplitN(field, "=", 2)
+		if !openFlowStatFields[kv[0]] && kv[0] != "priority" {
+			rule.match = append(rule.match, field)
+			continue
+		}
+
+		if len(kv) != 2 {
+			continue
+		}
+
+		var value *int64
+		switch kv[0] {
+		case "cookie":
+			rule.cookie = kv[1]
+		case "table":
+			value = &rule.table
+		case "priority":
+			value = &rule.priority
+		case "n_packets":
+			value = &rule.packets
+		case "n_bytes":
+			value = &rule.bytes
+		case "idle_timeout":
+			value = &rule.idleTimeout
+		case "hard_timeout":
+			value = &rule.hardTimeout
+		}
+
+		if value != nil {
+			v, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in flow: %s", kv[0], line)
+			}
+			*value = v
+		}
+	}
+
+	return rule, nil
+}
+
+// parseOpenFlowRules parses the output of ovs-ofctl dump-flows, skipping the
+// reply headers
+func parseOpenFlowRules(data []byte) (rules []*openFlowRule) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "cookie=") {
+			continue
+		}
+
+		rule, err := parseOpenFlowRule(line)
+		if err != nil {
+			logging.GetLogger().Warningf("Failed to parse OpenFlow rule: %s", err.Error())
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// openFlowProtocol returns the highest of the OpenFlow versions enabled on
+// a bridge, or the versions to negotiate if the bridge uses the default ones
+func openFlowProtocol(protocols string) string {
+	highest := ""
+	for _, protocol := range strings.Split(protocols, ",") {
+		if strings.HasPrefix(protocol, "OpenFlow") && protocol > highest {
+			highest = protocol
+		}
+	}
+	if highest == "" {
+		return openFlowDefaultProtocols
+	}
+	return highest
+}
+
+// openFlowRules returns the rules of the bridge
+func openFlowRules(bridge string, protocol string) ([]*openFlowRule, error) {
+	output, err := exec.Command("ovs-ofctl", "-O", protocol, "dump-flows", bridge).Output()
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok && len(e.Stderr) > 0 {
+			return nil, fmt.Errorf("ovs-ofctl failed: %s", strings.TrimSpace(string(e.Stderr)))
+		}
+		return nil, fmt.Errorf("ovs-ofctl failed: %s", err.Error())
+	}
+	return parseOpenFlowRules(output), nil
+}
+
+// bridgeInterfaces returns the interfaces of a bridge indexed by their
+// OpenFlow port number
+func (probe *OpenFlowProbe) bridgeInterfaces(bridge *graph.Node) map[int64]*graph.Node {
+	intfs := make(map[int64]*graph.Node)
+	for _, port := range probe.Graph.LookupChildren(bridge, graph.Metadata{"Type": "ovsport"}, ownershipMetadata) {
+		for _, intf := range probe.Graph.LookupChildren(port, graph.Metadata{}, layer2Metadata) {
+			if ofport, err := intf.GetFieldInt64("OfPort"); err == nil {
+				intfs[ofport] = intf
+			}
+		}
+	}
+	return intfs
+}
+
+// linkInterface links the rule to the interface matched by its input port
+func (probe *OpenFlowProbe) linkInterface(node *graph.Node, intf *graph.Node) {
+	linked := false
+	for _, e := range probe.Graph.GetNodeEdges(node, openFlowMetadata) {
+		if intf != nil && e.GetParent() == intf.ID {
+			linked = true
+		} else {
+			probe.Graph.DelEdge(e)
+		}
+	}
+
+	if intf != nil && !linked {
+		probe.Graph.Link(intf, node, openFlowMetadata)
+	}
+}
+
+// syncRules updates the rule nodes of a bridge, graph lock has to be held
+func (probe *OpenFlowProbe) syncRules(bridge *graph.Node, rules []*openFlowRule) {
+	nodes := make(map[string]*graph.Node)
+	for _, node := range probe.Graph.LookupChildren(bridge, graph.Metadata{"Type": "ofrule"}, ownershipMetadata) {
+		name, _ := node.GetFieldString("Name")
+		nodes[name] = node
+	}
+
+	intfs := probe.bridgeInterfaces(bridge)
+
+	for _, rule := range rules {
+		m := rule.metadata()
+		name := rule.name()
+
+		node, ok := nodes[name]
+		if ok {
+			delete(nodes, name)
+
+			for k, v := range node.Metadata() {
+				if !openFlowRuleKeys[k] {
+					m[k] = v
+				}
+			}
+			probe.Graph.SetMetadata(node, m)
+		} else {
+			node = probe.Graph.NewNode(graph.GenID(), m)
+			probe.Graph.Link(bridge, node, ownershipMetadata)
+		}
+
+		probe.linkInterface(node, intfs[rule.inPort()])
+	}
+
+	for _, node := range nodes {
+		probe.Graph.DelNode(node)
+	}
+}
+
+// poll updates the rules of a bridge
+func (probe *OpenFlowProbe) poll(id graph.Identifier, name string, last, now time.Time) {
+	var protocols string
+	probe.Graph.RLock()
+	if bridge := probe.Graph.GetNode(id); bridge != nil {
+		protocols, _ = bridge.GetFieldString("Protocols")
+	}
+	probe.Graph.RUnlock()
+
+	rules, err := openFlowRules(name, openFlowProtocol(protocols))
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to retrieve OpenFlow rules of %s: %s", name, err.Error())
+		return
+	}
+
+	probe.Graph.Lock()
+	if bridge := probe.Graph.GetNode(id); bridge != nil {
+		probe.syncRules(bridge, rules)
+	}
+	probe.Graph.Unlock()
+}
+
+func (probe *OpenFlowProbe) Start() {
+	probe.poller.Start()
+}
+
+func (probe *OpenFlowProbe) Stop() {
+	probe.poller.Stop()
+}
+
+func NewOpenFlowProbe(g *graph.Graph, n *graph.Node, interval time.Duration) *OpenFlowProbe {
+	probe := &OpenFlowProbe{
+		Graph: g,
+		Root:  n,
+	}
+	probe.poller = newNodePoller(g, nil, "ovsbridge", "Name", interval, probe.poll)
+	return probe
+}
+
+func NewOpenFlowProbeFromConfig(g *graph.Graph, n *graph.Node) *OpenFlowProbe {
+	interval := config.GetConfig().GetInt("agent.topology.openflow.update")
+	return NewOpenFlowProbe(g, n, time.Duration(interval)*time.Second)
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+const testOpenFlowDump = `NXST_FLOW reply (xid=0x4):
+ cookie=0x9a4ec9c1e9aa8a7b, duration=120.208s, table=0, n_packets=10, n_bytes=980, idle_age=5, priority=10,icmp6,in_port=5,icmp_type=136 actions=resubmit(,24)
+ cookie=0x9a4ec9c1e9aa8a7b, duration=120.211s, table=0, n_packets=3, n_bytes=126, idle_age=20, priority=10,arp,in_port=5 actions=resubmit(,24)
+ cookie=0x0, duration=3.123s, table=0, n_packets=0, n_bytes=0, idle_timeout=60, idle_age=3, in_port=6 actions=NORMAL
+ cookie=0x9a4ec9c1e9aa8a7b, duration=120.214s, table=24, n_packets=0, n_bytes=0, idle_age=120, priority=0 actions=drop
+`
+
+func TestParseOpenFlowRules(t *testing.T) {
+	rules := parseOpenFlowRules([]byte(testOpenFlowDump))
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %d", len(rules))
+	}
+
+	m := rules[0].metadata()
+	expected := graph.Metadata{
+		"Type":     "ofrule",
+		"Name":     "table=0 priority=10 icmp6,in_port=5,icmp_type=136",
+		"Table":    int64(0),
+		"Priority": int64(10),
+		"Cookie":   "0x9a4ec9c1e9aa8a7b",
+		"Match":    "icmp6,in_port=5,icmp_type=136",
+		"Actions":  "resubmit(,24)",
+		"Packets":  int64(10),
+		"Bytes":    int64(980),
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m[k])
+		}
+	}
+
+	if rules[0].inPort() != 5 {
+		t.Errorf("Expected input port 5, got %d", rules[0].inPort())
+	}
+
+	// default priority isn't reported by ovs-ofctl
+	if m = rules[2].metadata(); m["Priority"] != int64(openFlowDefaultPriority) || m["IdleTimeout"] != int64(60) {
+		t.Errorf("Wrong priority or timeout: %v", m)
+	}
+
+	if m = rules[3].metadata(); m["Name"] != "table=24 priority=0" || m["Match"] != nil || m["Actions"] != "drop" {
+		t.Errorf("Wrong table miss rule: %v", m)
+	}
+}
+
+func TestOpenFlowProtocol(t *testing.T) {
+	for protocols, expected := range map[string]string{
+		"":                                 openFlowDefaultProtocols,
+		"OpenFlow13":                       "OpenFlow13",
+		"OpenFlow10,OpenFlow14,OpenFlow13": "OpenFlow14",
+	} {
+		if protocol := openFlowProtocol(protocols); protocol != expected {
+			t.Errorf("Expected protocol %s for %s, got %s", expected, protocols, protocol)
+		}
+	}
+}
+
+func TestOpenFlowSyncRules(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	defer g.Unlock()
+
+	root := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	bridge := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsbridge", "Name": "br-int"})
+	port := g.NewNode(graph.GenID(), graph.Metadata{"Type": "ovsport", "Name": "tap1"})
+	tap := g.NewNode(graph.GenID(), graph.Metadata{"Type": "tap", "Name": "tap1", "OfPort": int64(5)})
+	g.Link(root, bridge, ownershipMetadata)
+	g.Link(bridge, port, ownershipMetadata)
+	g.Link(port, tap, layer2Metadata)
+
+	probe := NewOpenFlowProbe(g, root, 0)
+
+	rules := parseOpenFlowRules([]byte(testOpenFlowDump))
+	probe.syncRules(bridge, rules)
+
+	if nodes := g.LookupChildren(bridge, graph.Metadata{"Type": "ofrule"}, ownershipMetadata); len(nodes) != 4 {
+		t.Fatalf("Expected 4 rule nodes, got %d", len(nodes))
+	}
+
+	if nodes := g.LookupChildren(tap, graph.Metadata{"Type": "ofrule"}, openFlowMetadata); len(nodes) != 2 {
+		t.Errorf("Expected 2 rules linked to the interface, got %d", len(nodes))
+	}
+
+	arp := g.LookupFirstChild(bridge, graph.Metadata{"Name": "table=0 priority=10 arp,in_port=5"})
+	if arp == nil {
+		t.Fatal("Rule node not found")
+	}
+	g.AddMetadata(arp, "TID", "123")
+
+	rules[1].packets = 4
+	probe.syncRules(bridge, rules[1:])
+
+	if packets, _ := arp.GetFieldInt64("Packets"); packets != 4 {
+		t.Errorf("Expected counters to be updated, got %d packets", packets)
+	}
+	if tid, _ := arp.GetFieldString("TID"); tid != "123" {
+		t.Error("TID should have been kept")
+	}
+	if nodes := g.LookupChildren(bridge, graph.Metadata{"Type": "ofrule"}, ownershipMetadata); len(nodes) != 3 {
+		t.Errorf("Expected 3 rule nodes, got %d", len(nodes))
+	}
+}
@@ -0,0 +1,655 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/socketplane/libovsdb"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/ovs"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+var (
+	ovnOwnershipMetadata = graph.Metadata{"RelationType": "ovn", "Type": "ownership"}
+	ovnPeerMetadata      = graph.Metadata{"RelationType": "ovn", "Type": "peer"}
+	ovnBindingMetadata   = graph.Metadata{"RelationType": "ovn", "Type": "binding"}
+	ovnInterfaceMetadata = graph.Metadata{"RelationType": "ovn", "Type": "interface"}
+	ovnHostMetadata      = graph.Metadata{"RelationType": "ovn", "Type": "host"}
+)
+
+// monitored tables of the northbound and southbound databases
+var (
+	ovnNorthboundTables = []string{"Logical_Switch", "Logical_Switch_Port", "Logical_Router", "Logical_Router_Port"}
+	ovnSouthboundTables = []string{"Chassis", "Encap", "Port_Binding"}
+)
+
+// node types of the tables, the rows of the other tables being only used
+// to link or to complete the nodes
+var ovnNodeTypes = map[string]string{
+	"Logical_Switch":      "logical_switch",
+	"Logical_Switch_Port": "logical_port",
+	"Logical_Router":      "logical_router",
+	"Logical_Router_Port": "logical_router_port",
+	"Chassis":             "chassis",
+}
+
+// column naming the rows of the tables, the rows being looked up by name
+var ovnNameColumns = map[string]string{
+	"Port_Binding": "logical_port",
+}
+
+// ovnRow binds an OVN database row to its graph node if any
+type ovnRow struct {
+	uuid   string
+	fields map[string]interface{}
+	node   *graph.Node
+}
+
+// OvnMapper maps the logical switches, routers and ports of the OVN
+// northbound database and the chassis and port bindings of the southbound
+// database to graph nodes. Logical switch ports are linked to the OVS
+// interfaces having their name as external_ids:iface-id and chassis to the
+// host of their agent. The rows and their indexes are protected by the
+// graph lock.
+type OvnMapper struct {
+	graph.DefaultGraphListener
+	graph     *graph.Graph
+	nbMonitor *ovsdb.OvsMonitor
+	sbMonitor *ovsdb.OvsMonitor
+	state     int64
+	rows      map[string]map[string]*ovnRow
+	names     map[string]map[string]*ovnRow
+	bindings  map[string]map[string]*ovnRow
+	ifaces    map[string]map[graph.Identifier]bool
+	ifaceIDs  map[graph.Identifier]string
+}
+
+// ovnRowName returns the name of a row of the given table
+func ovnRowName(table string, fields map[string]interface{}) string {
+	if column, ok := ovnNameColumns[table]; ok {
+		return ovnString(fields[column])
+	}
+	return ovnString(fields["name"])
+}
+
+func ovnString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return ""
+}
+
+// ovnStrings returns the strings of a set column, sets of one element being
+// reported as a single atom by OVSDB
+func ovnStrings(v interface{}) (values []string) {
+	switch v := v.(type) {
+	case string:
+		values = append(values, v)
+	case libovsdb.OvsSet:
+		for _, e := range v.GoSet {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func ovnUUIDs(v interface{}) (uuids []string) {
+	switch v := v.(type) {
+	case libovsdb.UUID:
+		uuids = append(uuids, v.GoUUID)
+	case libovsdb.OvsSet:
+		for _, e := range v.GoSet {
+			if u, ok := e.(libovsdb.UUID); ok {
+				uuids = append(uuids, u.GoUUID)
+			}
+		}
+	}
+	return uuids
+}
+
+func ovnMap(v interface{}) map[string]string {
+	m := make(map[string]string)
+	if v, ok := v.(libovsdb.OvsMap); ok {
+		for key, value := range v.GoMap {
+			if k, ok := key.(string); ok {
+				m[k] = fmt.Sprintf("%v", value)
+			}
+		}
+	}
+	return m
+}
+
+// ovnBool returns the value of an optional boolean column
+func ovnBool(v interface{}) (bool, bool) {
+	switch v := v.(type) {
+	case bool:
+		return v, true
+	case libovsdb.OvsSet:
+		if len(v.GoSet) == 1 {
+			b, ok := v.GoSet[0].(bool)
+			return b, ok
+		}
+	}
+	return false, false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// metadata returns the metadata of the node of a row
+func (mapper *OvnMapper) metadata(table string, row *ovnRow) graph.Metadata {
+	m := graph.Metadata{
+		"Type": ovnNodeTypes[table],
+		"Name": ovnString(row.fields["name"]),
+		"UUID": row.uuid,
+	}
+
+	for k, v := range ovnMap(row.fields["external_ids"]) {
+		m["ExtID/"+k] = v
+	}
+
+	if enabled, ok := ovnBool(row.fields["enabled"]); ok {
+		m["Enabled"] = enabled
+	}
+
+	switch table {
+	case "Logical_Switch_Port":
+		if tp := ovnString(row.fields["type"]); tp != "" {
+			m["PortType"] = tp
+		}
+		if addresses := ovnStrings(row.fields["addresses"]); len(addresses) > 0 {
+			m["Addresses"] = strings.Join(addresses, ",")
+		}
+		if up, ok := ovnBool(row.fields["up"]); ok {
+			m["Up"] = up
+		}
+		for k, v := range ovnMap(row.fields["options"]) {
+			m["Options/"+k] = v
+		}
+	case "Logical_Router_Port":
+		m["MAC"] = ovnString(row.fields["mac"])
+		if networks := ovnStrings(row.fields["networks"]); len(networks) > 0 {
+			m["Networks"] = strings.Join(networks, ",")
+		}
+	case "Chassis":
+		if hostname := ovnString(row.fields["hostname"]); hostname != "" {
+			m["Hostname"] = hostname
+		}
+
+		var types, ips []string
+		for _, uuid := range ovnUUIDs(row.fields["encaps"]) {
+			if encap, ok := mapper.rows["Encap"][uuid]; ok {
+				types = append(types, ovnString(encap.fields["type"]))
+				ips = append(ips, ovnString(encap.fields["ip"]))
+			}
+		}
+		if len(types) > 0 {
+			sort.Strings(types)
+			m["EncapType"] = strings.Join(types, ",")
+			m["EncapIP"] = ips[0]
+		}
+	}
+
+	return m
+}
+
+// lookup returns the row of a table having the given name
+func (mapper *OvnMapper) lookup(table, name string) *ovnRow {
+	if name == "" {
+		return nil
+	}
+	return mapper.names[table][name]
+}
+
+// indexRow indexes a row by name and the port bindings by chassis
+func (mapper *OvnMapper) indexRow(table string, row *ovnRow, fields map[string]interface{}) {
+	if name := ovnRowName(table, fields); name != "" {
+		mapper.names[table][name] = row
+	}
+
+	if table == "Port_Binding" {
+		for _, uuid := range ovnUUIDs(fields["chassis"]) {
+			bindings, ok := mapper.bindings[uuid]
+			if !ok {
+				bindings = make(map[string]*ovnRow)
+				mapper.bindings[uuid] = bindings
+			}
+			bindings[row.uuid] = row
+		}
+	}
+}
+
+// unindexRow removes a row indexed with the given fields
+func (mapper *OvnMapper) unindexRow(table string, row *ovnRow, fields map[string]interface{}) {
+	if name := ovnRowName(table, fields); mapper.names[table][name] == row {
+		delete(mapper.names[table], name)
+	}
+
+	if table == "Port_Binding" {
+		for _, uuid := range ovnUUIDs(fields["chassis"]) {
+			if bindings, ok := mapper.bindings[uuid]; ok {
+				delete(bindings, row.uuid)
+				if len(bindings) == 0 {
+					delete(mapper.bindings, uuid)
+				}
+			}
+		}
+	}
+}
+
+// link makes sure the node is linked to the given children with the relation
+// and removes the edges of this relation to other nodes
+func (mapper *OvnMapper) link(parent *graph.Node, children []*graph.Node, m graph.Metadata) {
+	existing := make(map[graph.Identifier]*graph.Edge)
+	for _, e := range mapper.graph.GetNodeEdges(parent, m) {
+		if e.GetParent() == parent.ID {
+			existing[e.GetChild()] = e
+		}
+	}
+
+	for _, child := range children {
+		if _, ok := existing[child.ID]; ok {
+			delete(existing, child.ID)
+			continue
+		}
+		mapper.graph.Link(parent, child, m)
+		existing[child.ID] = nil
+	}
+
+	for _, e := range existing {
+		if e != nil {
+			mapper.graph.DelEdge(e)
+		}
+	}
+}
+
+// linkPorts links a logical switch or router to its ports
+func (mapper *OvnMapper) linkPorts(row *ovnRow, portTable string) {
+	var ports []*graph.Node
+	for _, uuid := range ovnUUIDs(row.fields["ports"]) {
+		if port, ok := mapper.rows[portTable][uuid]; ok {
+			ports = append(ports, port.node)
+		}
+	}
+	mapper.link(row.node, ports, ovnOwnershipMetadata)
+}
+
+// linkSwitchPort links a logical switch port to the router port it is
+// attached to and to the OVS interfaces bound to it
+func (mapper *OvnMapper) linkSwitchPort(row *ovnRow) {
+	var peers []*graph.Node
+	if ovnString(row.fields["type"]) == "router" {
+		if lrp := mapper.lookup("Logical_Router_Port", ovnMap(row.fields["options"])["router-port"]); lrp != nil {
+			peers = append(peers, lrp.node)
+		}
+	}
+	mapper.link(row.node, peers, ovnPeerMetadata)
+
+	var intfs []*graph.Node
+	if name := ovnString(row.fields["name"]); name != "" {
+		for id := range mapper.ifaces[name] {
+			if intf := mapper.graph.GetNode(id); intf != nil {
+				intfs = append(intfs, intf)
+			}
+		}
+	}
+	mapper.link(row.node, intfs, ovnInterfaceMetadata)
+}
+
+// linkRouterPort links a logical router port to the one of the router it
+// is connected to, the link being owned by the port with the lowest name
+func (mapper *OvnMapper) linkRouterPort(row *ovnRow) {
+	var peers []*graph.Node
+	name := ovnString(row.fields["name"])
+	if peerName := ovnString(row.fields["peer"]); peerName != "" && name < peerName {
+		if peer := mapper.lookup("Logical_Router_Port", peerName); peer != nil {
+			peers = append(peers, peer.node)
+		}
+	}
+	mapper.link(row.node, peers, ovnPeerMetadata)
+}
+
+// bindingPort returns the logical port of a port binding, the gateway ports
+// of distributed routers being bound through chassis redirect ports
+func (mapper *OvnMapper) bindingPort(binding *ovnRow) *ovnRow {
+	name := ovnString(binding.fields["logical_port"])
+	if port := mapper.lookup("Logical_Switch_Port", name); port != nil {
+		return port
+	}
+	return mapper.lookup("Logical_Router_Port", strings.TrimPrefix(name, "cr-"))
+}
+
+// linkChassis links a chassis to the host of its agent and to the logical
+// ports bound to it
+func (mapper *OvnMapper) linkChassis(row *ovnRow) {
+	var hosts []*graph.Node
+	if hostname := ovnString(row.fields["hostname"]); hostname != "" {
+		hosts = mapper.graph.GetNodes(graph.Metadata{"Type": "host", "Name": hostname})
+	}
+	mapper.link(row.node, hosts, ovnHostMetadata)
+
+	var ports []*graph.Node
+	for _, binding := range mapper.bindings[row.uuid] {
+		if port := mapper.bindingPort(binding); port != nil {
+			ports = append(ports, port.node)
+		}
+	}
+	mapper.link(row.node, ports, ovnBindingMetadata)
+}
+
+// linkBindings updates the links of the chassis the logical port is bound to
+func (mapper *OvnMapper) linkBindings(names ...string) {
+	for _, name := range names {
+		binding := mapper.lookup("Port_Binding", name)
+		if binding == nil {
+			continue
+		}
+		for _, uuid := range ovnUUIDs(binding.fields["chassis"]) {
+			if chassis, ok := mapper.rows["Chassis"][uuid]; ok {
+				mapper.linkChassis(chassis)
+			}
+		}
+	}
+}
+
+// linkRow updates the links of a row and of the rows referring to it
+func (mapper *OvnMapper) linkRow(table string, row *ovnRow, fields map[string]interface{}) {
+	name := ovnString(fields["name"])
+
+	switch table {
+	case "Logical_Switch":
+		mapper.linkPorts(row, "Logical_Switch_Port")
+	case "Logical_Router":
+		mapper.linkPorts(row, "Logical_Router_Port")
+	case "Logical_Switch_Port":
+		for _, ls := range mapper.rows["Logical_Switch"] {
+			if containsString(ovnUUIDs(ls.fields["ports"]), row.uuid) {
+				mapper.linkPorts(ls, "Logical_Switch_Port")
+			}
+		}
+		if row.node != nil {
+			mapper.linkSwitchPort(row)
+		}
+		mapper.linkBindings(name)
+	case "Logical_Router_Port":
+		for _, lr := range mapper.rows["Logical_Router"] {
+			if containsString(ovnUUIDs(lr.fields["ports"]), row.uuid) {
+				mapper.linkPorts(lr, "Logical_Router_Port")
+			}
+		}
+		for _, lsp := range mapper.rows["Logical_Switch_Port"] {
+			if ovnMap(lsp.fields["options"])["router-port"] == name {
+				mapper.linkSwitchPort(lsp)
+			}
+		}
+		for _, lrp := range mapper.rows["Logical_Router_Port"] {
+			if lrp != row && ovnString(lrp.fields["peer"]) == name {
+				mapper.linkRouterPort(lrp)
+			}
+		}
+		if row.node != nil {
+			mapper.linkRouterPort(row)
+		}
+		mapper.linkBindings(name, "cr-"+name)
+	case "Chassis":
+		if row.node != nil {
+			mapper.linkChassis(row)
+		}
+	case "Encap":
+		for _, chassis := range mapper.rows["Chassis"] {
+			if containsString(ovnUUIDs(chassis.fields["encaps"]), row.uuid) {
+				mapper.graph.SetMetadata(chassis.node, mapper.metadata("Chassis", chassis))
+			}
+		}
+	case "Port_Binding":
+		for _, uuid := range ovnUUIDs(fields["chassis"]) {
+			if chassis, ok := mapper.rows["Chassis"][uuid]; ok {
+				mapper.linkChassis(chassis)
+			}
+		}
+	}
+}
+
+func (mapper *OvnMapper) OnOvsRowAdd(monitor *ovsdb.OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	mapper.graph.Lock()
+	defer mapper.graph.Unlock()
+
+	rows, ok := mapper.rows[table]
+	if !ok {
+		return
+	}
+
+	r, ok := rows[uuid]
+	if !ok {
+		r = &ovnRow{uuid: uuid}
+		rows[uuid] = r
+	}
+
+	if r.fields != nil {
+		mapper.unindexRow(table, r, r.fields)
+
+		// the chassis of a binding may have changed
+		if table == "Port_Binding" {
+			defer mapper.linkRow(table, r, r.fields)
+		}
+	}
+	r.fields = row.New.Fields
+	mapper.indexRow(table, r, r.fields)
+
+	if _, ok := ovnNodeTypes[table]; ok {
+		metadata := mapper.metadata(table, r)
+		if r.node == nil {
+			logging.GetLogger().Debugf("Adding OVN %s %s", metadata["Type"], metadata["Name"])
+			r.node = mapper.graph.NewNode(graph.GenID(), metadata)
+		} else {
+			mapper.graph.SetMetadata(r.node, metadata)
+		}
+	}
+
+	mapper.linkRow(table, r, r.fields)
+}
+
+func (mapper *OvnMapper) OnOvsRowUpdate(monitor *ovsdb.OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	mapper.OnOvsRowAdd(monitor, table, uuid, row)
+}
+
+func (mapper *OvnMapper) OnOvsRowDel(monitor *ovsdb.OvsMonitor, table string, uuid string, row *libovsdb.RowUpdate) {
+	mapper.graph.Lock()
+	defer mapper.graph.Unlock()
+
+	r, ok := mapper.rows[table][uuid]
+	if !ok {
+		return
+	}
+	delete(mapper.rows[table], uuid)
+	mapper.unindexRow(table, r, r.fields)
+
+	if r.node != nil {
+		logging.GetLogger().Debugf("Removing OVN %s %s", ovnNodeTypes[table], ovnString(r.fields["name"]))
+		mapper.graph.DelNode(r.node)
+		r.node = nil
+	}
+
+	mapper.linkRow(table, r, r.fields)
+}
+
+// OnNodeAdded links the hosts of the agents to their chassis and the OVS
+// interfaces to their logical port
+func (mapper *OvnMapper) OnNodeAdded(n *graph.Node) {
+	if tp, _ := n.GetFieldString("Type"); tp == "host" {
+		name, _ := n.GetFieldString("Name")
+		for _, chassis := range mapper.rows["Chassis"] {
+			if ovnString(chassis.fields["hostname"]) == name {
+				mapper.linkChassis(chassis)
+			}
+		}
+	}
+
+	mapper.updateIfaceID(n)
+}
+
+// OnNodeUpdated handles the external ids set once the OVS interfaces have
+// been added
+func (mapper *OvnMapper) OnNodeUpdated(n *graph.Node) {
+	mapper.updateIfaceID(n)
+}
+
+func (mapper *OvnMapper) OnNodeDeleted(n *graph.Node) {
+	if ifaceID, ok := mapper.ifaceIDs[n.ID]; ok {
+		mapper.unindexIfaceID(n.ID, ifaceID)
+	}
+}
+
+func (mapper *OvnMapper) unindexIfaceID(id graph.Identifier, ifaceID string) {
+	delete(mapper.ifaceIDs, id)
+	delete(mapper.ifaces[ifaceID], id)
+	if len(mapper.ifaces[ifaceID]) == 0 {
+		delete(mapper.ifaces, ifaceID)
+	}
+}
+
+// updateIfaceID indexes the OVS interfaces by their external_ids:iface-id
+// and relinks the logical ports only when it changes
+func (mapper *OvnMapper) updateIfaceID(n *graph.Node) {
+	ifaceID, _ := n.GetFieldString("ExtID/iface-id")
+	if ifaceID == mapper.ifaceIDs[n.ID] {
+		return
+	}
+
+	if old, ok := mapper.ifaceIDs[n.ID]; ok {
+		mapper.unindexIfaceID(n.ID, old)
+		if lsp := mapper.lookup("Logical_Switch_Port", old); lsp != nil {
+			mapper.linkSwitchPort(lsp)
+		}
+	}
+
+	if ifaceID != "" {
+		mapper.ifaceIDs[n.ID] = ifaceID
+		if _, ok := mapper.ifaces[ifaceID]; !ok {
+			mapper.ifaces[ifaceID] = make(map[graph.Identifier]bool)
+		}
+		mapper.ifaces[ifaceID][n.ID] = true
+
+		if lsp := mapper.lookup("Logical_Switch_Port", ifaceID); lsp != nil {
+			mapper.linkSwitchPort(lsp)
+		}
+	}
+}
+
+func (mapper *OvnMapper) Start() {
+	if !atomic.CompareAndSwapInt64(&mapper.state, common.StoppedState, common.RunningState) {
+		return
+	}
+
+	mapper.graph.AddEventListener(mapper)
+
+	// index the interfaces added before the mapper was started
+	mapper.graph.Lock()
+	mapper.ifaces = make(map[string]map[graph.Identifier]bool)
+	mapper.ifaceIDs = make(map[graph.Identifier]string)
+	for _, n := range mapper.graph.GetNodes(graph.Metadata{}) {
+		mapper.updateIfaceID(n)
+	}
+	mapper.graph.Unlock()
+
+	mapper.nbMonitor.StartMonitoring()
+	mapper.sbMonitor.StartMonitoring()
+}
+
+func (mapper *OvnMapper) Stop() {
+	if !atomic.CompareAndSwapInt64(&mapper.state, common.RunningState, common.StoppingState) {
+		return
+	}
+
+	mapper.nbMonitor.StopMonitoring()
+	mapper.sbMonitor.StopMonitoring()
+	mapper.graph.RemoveEventListener(mapper)
+
+	atomic.StoreInt64(&mapper.state, common.StoppedState)
+}
+
+// ovnAddress returns the protocol and the target of an OVSDB address, either
+// unix://<path> or tcp://<addr>:<port>
+func ovnAddress(address string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://"), nil
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	}
+	return "", "", fmt.Errorf("Invalid OVSDB address %s, should be unix://<path> or tcp://<addr>:<port>", address)
+}
+
+func NewOvnMapper(g *graph.Graph, nbProtocol, nbTarget, sbProtocol, sbTarget string) *OvnMapper {
+	mapper := &OvnMapper{
+		graph:     g,
+		nbMonitor: ovsdb.NewOvsDatabaseMonitor(nbProtocol, nbTarget, "OVN_Northbound", ovnNorthboundTables),
+		sbMonitor: ovsdb.NewOvsDatabaseMonitor(sbProtocol, sbTarget, "OVN_Southbound", ovnSouthboundTables),
+		state:     common.StoppedState,
+		rows:      make(map[string]map[string]*ovnRow),
+		names:     make(map[string]map[string]*ovnRow),
+		bindings:  make(map[string]map[string]*ovnRow),
+		ifaces:    make(map[string]map[graph.Identifier]bool),
+		ifaceIDs:  make(map[graph.Identifier]string),
+	}
+
+	for _, table := range append(ovnNorthboundTables, ovnSouthboundTables...) {
+		mapper.rows[table] = make(map[string]*ovnRow)
+		mapper.names[table] = make(map[string]*ovnRow)
+	}
+
+	mapper.nbMonitor.AddTableMonitorHandler(mapper)
+	mapper.sbMonitor.AddTableMonitorHandler(mapper)
+
+	return mapper
+}
+
+func NewOvnMapperFromConfig(g *graph.Graph) (*OvnMapper, error) {
+	nbProtocol, nbTarget, err := ovnAddress(config.GetConfig().GetString("ovn.northbound"))
+	if err != nil {
+		return nil, err
+	}
+
+	sbProtocol, sbTarget, err := ovnAddress(config.GetConfig().GetString("ovn.southbound"))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOvnMapper(g, nbProtocol, nbTarget, sbProtocol, sbTarget), nil
+}
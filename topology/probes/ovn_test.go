@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"testing"
+
+	"github.com/socketplane/libovsdb"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func ovnTestRow(fields map[string]interface{}) *libovsdb.RowUpdate {
+	return &libovsdb.RowUpdate{New: libovsdb.Row{Fields: fields}}
+}
+
+func ovnTestSet(uuids ...string) libovsdb.OvsSet {
+	set := libovsdb.OvsSet{}
+	for _, uuid := range uuids {
+		set.GoSet = append(set.GoSet, libovsdb.UUID{GoUUID: uuid})
+	}
+	return set
+}
+
+func ovnTestMap(kv ...string) libovsdb.OvsMap {
+	m := libovsdb.OvsMap{GoMap: make(map[interface{}]interface{})}
+	for i := 0; i+1 < len(kv); i += 2 {
+		m.GoMap[kv[i]] = kv[i+1]
+	}
+	return m
+}
+
+func TestOvnMapper(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("analyzer", b)
+
+	mapper := NewOvnMapper(g, "tcp", "127.0.0.1:6641", "tcp", "127.0.0.1:6642")
+	g.AddEventListener(mapper)
+
+	g.Lock()
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "compute-1"})
+	g.Unlock()
+
+	// rows are notified in any order
+	mapper.OnOvsRowAdd(nil, "Logical_Switch_Port", "lsp1", ovnTestRow(map[string]interface{}{
+		"name":         "vm1-port",
+		"addresses":    "fa:16:3e:00:00:01 10.0.0.5",
+		"up":           true,
+		"options":      ovnTestMap(),
+		"external_ids": ovnTestMap("neutron:device_owner", "compute:nova"),
+	}))
+	mapper.OnOvsRowAdd(nil, "Logical_Switch_Port", "lsp2", ovnTestRow(map[string]interface{}{
+		"name":    "router-port",
+		"type":    "router",
+		"options": ovnTestMap("router-port", "lrp-net1"),
+	}))
+	mapper.OnOvsRowAdd(nil, "Logical_Switch", "ls1", ovnTestRow(map[string]interface{}{
+		"name":         "neutron-net1",
+		"ports":        ovnTestSet("lsp1", "lsp2"),
+		"external_ids": ovnTestMap("neutron:network_name", "net1"),
+	}))
+	mapper.OnOvsRowAdd(nil, "Logical_Router", "lr1", ovnTestRow(map[string]interface{}{
+		"name":  "router1",
+		"ports": libovsdb.UUID{GoUUID: "lrp1"},
+	}))
+	mapper.OnOvsRowAdd(nil, "Logical_Router_Port", "lrp1", ovnTestRow(map[string]interface{}{
+		"name":     "lrp-net1",
+		"mac":      "fa:16:3e:00:00:ff",
+		"networks": "10.0.0.1/24",
+	}))
+	mapper.OnOvsRowAdd(nil, "Port_Binding", "pb1", ovnTestRow(map[string]interface{}{
+		"logical_port": "vm1-port",
+		"chassis":      libovsdb.UUID{GoUUID: "ch1"},
+	}))
+	mapper.OnOvsRowAdd(nil, "Chassis", "ch1", ovnTestRow(map[string]interface{}{
+		"name":     "c0ffee",
+		"hostname": "compute-1",
+		"encaps":   libovsdb.UUID{GoUUID: "encap1"},
+	}))
+	mapper.OnOvsRowAdd(nil, "Encap", "encap1", ovnTestRow(map[string]interface{}{
+		"type": "geneve",
+		"ip":   "192.168.0.10",
+	}))
+
+	// interface reported by the ovsdb probe of an agent
+	g.Lock()
+	tap := g.NewNode(graph.GenID(), graph.Metadata{"Type": "tap", "Name": "tap1"})
+	g.AddMetadata(tap, "ExtID/iface-id", "vm1-port")
+	g.Unlock()
+
+	g.RLock()
+
+	ls := g.LookupFirstNode(graph.Metadata{"Type": "logical_switch", "Name": "neutron-net1"})
+	if ls == nil {
+		t.Fatal("Logical switch not found")
+	}
+	if name, _ := ls.GetFieldString("ExtID/neutron:network_name"); name != "net1" {
+		t.Errorf("Wrong logical switch external ids: %v", ls.Metadata())
+	}
+
+	lsp := g.LookupFirstChild(ls, graph.Metadata{"Type": "logical_port", "Name": "vm1-port"})
+	if lsp == nil {
+		t.Fatal("Logical port should be owned by its switch")
+	}
+	if addresses, _ := lsp.GetFieldString("Addresses"); addresses != "fa:16:3e:00:00:01 10.0.0.5" {
+		t.Errorf("Wrong logical port addresses: %s", addresses)
+	}
+
+	if !g.AreLinked(lsp, tap, ovnInterfaceMetadata) {
+		t.Error("Logical port should be linked to its OVS interface")
+	}
+
+	lr := g.LookupFirstNode(graph.Metadata{"Type": "logical_router", "Name": "router1"})
+	if lr == nil {
+		t.Fatal("Logical router not found")
+	}
+	lrp := g.LookupFirstChild(lr, graph.Metadata{"Type": "logical_router_port", "Name": "lrp-net1"})
+	if lrp == nil {
+		t.Fatal("Logical router port should be owned by its router")
+	}
+
+	routerPort := g.LookupFirstChild(ls, graph.Metadata{"Name": "router-port"})
+	if routerPort == nil || !g.AreLinked(routerPort, lrp, ovnPeerMetadata) {
+		t.Error("Router type logical port should be linked to its router port")
+	}
+
+	chassis := g.LookupFirstNode(graph.Metadata{"Type": "chassis", "Name": "c0ffee"})
+	if chassis == nil {
+		t.Fatal("Chassis not found")
+	}
+	if encap, _ := chassis.GetFieldString("EncapType"); encap != "geneve" {
+		t.Errorf("Wrong chassis encapsulation: %v", chassis.Metadata())
+	}
+	if !g.AreLinked(chassis, host, ovnHostMetadata) {
+		t.Error("Chassis should be linked to the host of its agent")
+	}
+	if !g.AreLinked(chassis, lsp, ovnBindingMetadata) {
+		t.Error("Chassis should be linked to the logical port bound to it")
+	}
+
+	g.RUnlock()
+
+	// the interface is unlinked once bound to another logical port
+	g.Lock()
+	g.AddMetadata(tap, "ExtID/iface-id", "other-port")
+	if g.AreLinked(lsp, tap, ovnInterfaceMetadata) {
+		t.Error("Logical port shouldn't be linked to an interface bound to another port")
+	}
+	g.AddMetadata(tap, "ExtID/iface-id", "vm1-port")
+	if !g.AreLinked(lsp, tap, ovnInterfaceMetadata) {
+		t.Error("Logical port should be linked again to its OVS interface")
+	}
+	g.Unlock()
+
+	// the port is unbound when migrated
+	mapper.OnOvsRowUpdate(nil, "Port_Binding", "pb1", ovnTestRow(map[string]interface{}{
+		"logical_port": "vm1-port",
+		"chassis":      libovsdb.OvsSet{},
+	}))
+
+	g.RLock()
+	if g.AreLinked(chassis, lsp, ovnBindingMetadata) {
+		t.Error("Logical port shouldn't be bound to the chassis anymore")
+	}
+	g.RUnlock()
+
+	mapper.OnOvsRowDel(nil, "Logical_Switch_Port", "lsp1", &libovsdb.RowUpdate{})
+
+	g.RLock()
+	if g.LookupFirstNode(graph.Metadata{"Type": "logical_port", "Name": "vm1-port"}) != nil {
+		t.Error("Logical port should have been removed")
+	}
+	g.RUnlock()
+}
@@ -71,6 +71,24 @@ func (o *OvsdbProbe) OnOvsBridgeAdd(monitor *ovsdb.OvsMonitor, uuid string, row
 		o.Graph.Link(o.Root, bridge, graph.Metadata{"RelationType": "ownership"})
 	}
 
+	// OpenFlow versions enabled on the bridge, the default ones if empty
+	var protocols []string
+	switch row.New.Fields["protocols"].(type) {
+	case libovsdb.OvsSet:
+		for _, p := range row.New.Fields["protocols"].(libovsdb.OvsSet).GoSet {
+			if protocol, ok := p.(string); ok {
+				protocols = append(protocols, protocol)
+			}
+		}
+	case string:
+		protocols = append(protocols, row.New.Fields["protocols"].(string))
+	}
+	if len(protocols) > 0 {
+		o.Graph.AddMetadata(bridge, "Protocols", strings.Join(protocols, ","))
+	} else if _, ok := bridge.Metadata()["Protocols"]; ok {
+		o.Graph.DelMetadata(bridge, "Protocols")
+	}
+
 	switch row.New.Fields["ports"].(type) {
 	case libovsdb.OvsSet:
 		set := row.New.Fields["ports"].(libovsdb.OvsSet)
@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package probes
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// nodePollHandler reads the state of a polled node, key being the field
+// identifying the node, empty for the root node. last is the time of the
+// previous poll of the node, zero for the first one. It is called without
+// the graph lock.
+type nodePollHandler func(id graph.Identifier, key string, last, now time.Time)
+
+// nodePoller periodically polls the nodes of a type reported by the other
+// probes, for instance the namespaces or the bridges, and the root node if
+// set.
+type nodePoller struct {
+	sync.RWMutex
+	graph.DefaultGraphListener
+	graph    *graph.Graph
+	root     *graph.Node
+	nodeType string
+	field    string
+	interval time.Duration
+	handler  nodePollHandler
+	nodes    map[graph.Identifier]string
+	last     map[graph.Identifier]time.Time
+	state    int64
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func (p *nodePoller) update() {
+	p.RLock()
+	nodes := make(map[graph.Identifier]string)
+	if p.root != nil {
+		nodes[p.root.ID] = ""
+	}
+	for id, key := range p.nodes {
+		nodes[id] = key
+	}
+	p.RUnlock()
+
+	for id, key := range nodes {
+		now := time.Now().UTC()
+		p.handler(id, key, p.last[id], now)
+		p.last[id] = now
+	}
+
+	for id := range p.last {
+		if _, ok := nodes[id]; !ok {
+			delete(p.last, id)
+		}
+	}
+}
+
+func (p *nodePoller) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.update()
+
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *nodePoller) OnNodeAdded(n *graph.Node) {
+	if tp, _ := n.GetFieldString("Type"); tp == p.nodeType {
+		if key, _ := n.GetFieldString(p.field); key != "" {
+			p.Lock()
+			p.nodes[n.ID] = key
+			p.Unlock()
+		}
+	}
+}
+
+func (p *nodePoller) OnNodeDeleted(n *graph.Node) {
+	p.Lock()
+	delete(p.nodes, n.ID)
+	p.Unlock()
+}
+
+func (p *nodePoller) Start() {
+	if !atomic.CompareAndSwapInt64(&p.state, common.StoppedState, common.RunningState) {
+		return
+	}
+
+	p.Lock()
+	p.nodes = make(map[graph.Identifier]string)
+	p.Unlock()
+
+	p.graph.AddEventListener(p)
+
+	p.graph.RLock()
+	for _, n := range p.graph.GetNodes(graph.Metadata{"Type": p.nodeType}) {
+		p.OnNodeAdded(n)
+	}
+	p.graph.RUnlock()
+
+	p.quit = make(chan struct{})
+	p.wg.Add(1)
+	go p.run()
+}
+
+func (p *nodePoller) Stop() {
+	if !atomic.CompareAndSwapInt64(&p.state, common.RunningState, common.StoppingState) {
+		return
+	}
+
+	close(p.quit)
+	p.wg.Wait()
+	p.graph.RemoveEventListener(p)
+
+	atomic.StoreInt64(&p.state, common.StoppedState)
+}
+
+// newNodePoller returns a poller of the nodes of the given type identified by
+// field, root being polled too if not nil
+func newNodePoller(g *graph.Graph, root *graph.Node, nodeType, field string, interval time.Duration, handler nodePollHandler) *nodePoller {
+	return &nodePoller{
+		graph:    g,
+		root:     root,
+		nodeType: nodeType,
+		field:    field,
+		interval: interval,
+		handler:  handler,
+		nodes:    make(map[graph.Identifier]string),
+		last:     make(map[graph.Identifier]time.Time),
+		state:    common.StoppedState,
+	}
+}
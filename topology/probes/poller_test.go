@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package probes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func TestNodePoller(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	root := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	ns1 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "netns", "Name": "ns1", "Path": "/var/run/netns/ns1"})
+	g.Unlock()
+
+	polled := make(map[string][]time.Time)
+	p := newNodePoller(g, root, "netns", "Path", time.Hour, func(id graph.Identifier, key string, last, now time.Time) {
+		polled[key] = append(polled[key], last)
+	})
+
+	p.Start()
+	p.Stop()
+
+	if len(polled[""]) != 1 || len(polled["/var/run/netns/ns1"]) != 1 {
+		t.Fatalf("Root and namespace should be polled once at start: %v", polled)
+	}
+	if !polled[""][0].IsZero() {
+		t.Error("No previous poll expected for the first one")
+	}
+
+	// the namespaces changed while stopped are known on the next start
+	g.Lock()
+	ns2 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "netns", "Name": "ns2", "Path": "/var/run/netns/ns2"})
+	g.DelNode(ns1)
+	g.Unlock()
+
+	p.Start()
+	p.Stop()
+
+	if len(polled["/var/run/netns/ns2"]) != 1 {
+		t.Errorf("Added namespace should be polled: %v", polled)
+	}
+	if len(polled["/var/run/netns/ns1"]) != 1 {
+		t.Errorf("Deleted namespace shouldn't be polled anymore: %v", polled)
+	}
+	if len(polled[""]) != 2 || polled[""][1].IsZero() {
+		t.Errorf("Time of the previous poll expected: %v", polled[""])
+	}
+	if _, ok := p.last[ns2.ID]; !ok {
+		t.Error("Time of the poll of the namespace should be kept")
+	}
+}
@@ -0,0 +1,795 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Traffic control attributes and handles from linux/rtnetlink.h,
+// linux/gen_stats.h and linux/pkt_sched.h
+const (
+	tcaKind    = 1
+	tcaOptions = 2
+	tcaStats2  = 7
+
+	tcaStatsBasic = 1
+	tcaStatsQueue = 3
+
+	tcaHtbParms  = 1
+	tcaHtbInit   = 2
+	tcaHtbRate64 = 6
+	tcaHtbCeil64 = 7
+
+	tcaFqCodelTarget   = 1
+	tcaFqCodelLimit    = 2
+	tcaFqCodelInterval = 3
+	tcaFqCodelECN      = 4
+	tcaFqCodelFlows    = 5
+
+	tcaNetemLatency64 = 10
+	tcaNetemJitter64  = 11
+
+	tcaBpfClassid = 3
+	tcaBpfName    = 7
+	tcaBpfFlags   = 8
+	tcaBpfTag     = 10
+	tcaBpfID      = 11
+
+	tcaBpfFlagActDirect = 1
+
+	tcHRoot       = 0xffffffff
+	tcHIngress    = 0xfffffff1
+	tcHMinIngress = 0xfff2
+	tcHMinEgress  = 0xfff3
+
+	sizeofTcMsg = 20
+
+	// psched ticks are 64ns long since linux 2.6.31
+	pschedTicksPerUsec = 1000.0 / 64
+)
+
+// qdiscs reporting their flows as classes
+var flowQdiscs = map[string]bool{
+	"fq_codel": true,
+	"sfq":      true,
+	"fq":       true,
+	"cake":     true,
+}
+
+// tcMsg is the traffic control message header used to dump the qdiscs,
+// classes and filters
+type tcMsg struct {
+	ifIndex int32
+	handle  uint32
+	parent  uint32
+}
+
+func (m *tcMsg) Len() int {
+	return sizeofTcMsg
+}
+
+func (m *tcMsg) Serialize() []byte {
+	native := nl.NativeEndian()
+
+	b := make([]byte, sizeofTcMsg)
+	b[0] = syscall.AF_UNSPEC
+	native.PutUint32(b[4:8], uint32(m.ifIndex))
+	native.PutUint32(b[8:12], m.handle)
+	native.PutUint32(b[12:16], m.parent)
+	return b
+}
+
+// TrafficControlProbe reports the qdiscs, classes and filters of the
+// interfaces with their statistics
+type TrafficControlProbe struct {
+	Graph  *graph.Graph
+	Root   *graph.Node
+	poller *nodePoller
+}
+
+type tcStatistics struct {
+	bytes      int64
+	packets    int64
+	drops      int64
+	overlimits int64
+	requeues   int64
+	backlog    int64
+	qlen       int64
+}
+
+// tcObject is either a qdisc, a class or a filter
+type tcObject struct {
+	objType  string
+	ifIndex  int64
+	handle   uint32
+	parent   uint32
+	info     uint32
+	kind     string
+	options  graph.Metadata
+	stats    *tcStatistics
+	children []*tcObject
+}
+
+var trafficControlKeys = map[string]bool{
+	"Type":      true,
+	"Name":      true,
+	"Kind":      true,
+	"Handle":    true,
+	"Parent":    true,
+	"Direction": true,
+	"Priority":  true,
+	"Protocol":  true,
+}
+
+var tcProtocols = map[uint16]string{
+	0x0003: "all",
+	0x0800: "ip",
+	0x0806: "arp",
+	0x86dd: "ipv6",
+	0x8100: "802.1Q",
+	0x88a8: "802.1ad",
+	0x8847: "mpls_uc",
+}
+
+func isTrafficControlKey(k string) bool {
+	return trafficControlKeys[k] ||
+		strings.HasPrefix(k, "Options/") ||
+		strings.HasPrefix(k, "Statistics/") ||
+		strings.HasPrefix(k, "LastMetric/")
+}
+
+// tcHandle returns a handle formatted the way tc does
+func tcHandle(h uint32) string {
+	major, minor := h>>16, h&0xffff
+	switch {
+	case h == tcHRoot:
+		return "root"
+	case h == 0:
+		return "none"
+	case major == 0:
+		return fmt.Sprintf(":%x", minor)
+	case minor == 0:
+		return fmt.Sprintf("%x:", major)
+	}
+	return fmt.Sprintf("%x:%x", major, minor)
+}
+
+// direction returns the hook of the filters attached to the ingress and
+// clsact qdiscs
+func (o *tcObject) direction() string {
+	if o.objType != "tcfilter" {
+		return ""
+	}
+
+	switch {
+	case o.parent&0xffff == tcHMinIngress, o.parent == tcHIngress&0xffff0000:
+		return "ingress"
+	case o.parent&0xffff == tcHMinEgress:
+		return "egress"
+	}
+	return ""
+}
+
+func (o *tcObject) priority() int64 {
+	return int64(o.info >> 16)
+}
+
+func (o *tcObject) protocol() string {
+	// the protocol is stored in network byte order
+	p := uint16(o.info&0xff)<<8 | uint16(o.info&0xff00)>>8
+	if name, ok := tcProtocols[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", p)
+}
+
+// name identifies the object among the ones of an interface
+func (o *tcObject) name() string {
+	switch o.objType {
+	case "qdisc":
+		return fmt.Sprintf("%s %x:", o.kind, o.handle>>16)
+	case "tcclass":
+		return o.kind + " " + tcHandle(o.handle)
+	}
+
+	name := o.kind
+	if direction := o.direction(); direction != "" {
+		name += " " + direction
+	}
+	return fmt.Sprintf("%s pref %d %s handle 0x%x", name, o.priority(), o.protocol(), o.handle)
+}
+
+func (o *tcObject) metadata() graph.Metadata {
+	m := graph.Metadata{
+		"Type":   o.objType,
+		"Name":   o.name(),
+		"Kind":   o.kind,
+		"Handle": tcHandle(o.handle),
+		"Parent": tcHandle(o.parent),
+	}
+
+	switch o.objType {
+	case "qdisc":
+		m["Handle"] = fmt.Sprintf("%x:", o.handle>>16)
+	case "tcfilter":
+		m["Handle"] = fmt.Sprintf("0x%x", o.handle)
+		m["Priority"] = o.priority()
+		m["Protocol"] = o.protocol()
+	}
+
+	if direction := o.direction(); direction != "" {
+		m["Direction"] = direction
+	}
+
+	for k, v := range o.options {
+		m["Options/"+k] = v
+	}
+
+	if s := o.stats; s != nil {
+		m["Statistics/Bytes"] = s.bytes
+		m["Statistics/Packets"] = s.packets
+		m["Statistics/Drops"] = s.drops
+		m["Statistics/Overlimits"] = s.overlimits
+		m["Statistics/Requeues"] = s.requeues
+		m["Statistics/Backlog"] = s.backlog
+		m["Statistics/Qlen"] = s.qlen
+	}
+
+	return m
+}
+
+func parseTcStatistics(b []byte) (*tcStatistics, error) {
+	attrs, err := parseNestedAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+
+	native := nl.NativeEndian()
+	stats := &tcStatistics{}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case tcaStatsBasic:
+			if len(attr.Value) >= 12 {
+				stats.bytes = int64(native.Uint64(attr.Value[0:8]))
+				stats.packets = int64(native.Uint32(attr.Value[8:12]))
+			}
+		case tcaStatsQueue:
+			if len(attr.Value) >= 20 {
+				stats.qlen = int64(native.Uint32(attr.Value[0:4]))
+				stats.backlog = int64(native.Uint32(attr.Value[4:8]))
+				stats.drops = int64(native.Uint32(attr.Value[8:12]))
+				stats.requeues = int64(native.Uint32(attr.Value[12:16]))
+				stats.overlimits = int64(native.Uint32(attr.Value[16:20]))
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+func attrUint32(attr syscall.NetlinkRouteAttr) (int64, bool) {
+	if len(attr.Value) < 4 {
+		return 0, false
+	}
+	return int64(nl.NativeEndian().Uint32(attr.Value[0:4])), true
+}
+
+func attrUint64(attr syscall.NetlinkRouteAttr) (int64, bool) {
+	if len(attr.Value) < 8 {
+		return 0, false
+	}
+	return int64(nl.NativeEndian().Uint64(attr.Value[0:8])), true
+}
+
+func (o *tcObject) parseHtbOptions(attrs []syscall.NetlinkRouteAttr) {
+	native := nl.NativeEndian()
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case tcaHtbInit:
+			if len(attr.Value) >= 12 {
+				o.options["DefaultClass"] = tcHandle(o.handle&0xffff0000 | native.Uint32(attr.Value[8:12]))
+			}
+		case tcaHtbParms:
+			// rate and ceil tc_ratespec followed by buffer, cbuffer,
+			// quantum, level and prio
+			if len(attr.Value) >= 44 {
+				if _, ok := o.options["Rate"]; !ok {
+					o.options["Rate"] = int64(native.Uint32(attr.Value[8:12]))
+				}
+				if _, ok := o.options["Ceil"]; !ok {
+					o.options["Ceil"] = int64(native.Uint32(attr.Value[20:24]))
+				}
+				o.options["Quantum"] = int64(native.Uint32(attr.Value[32:36]))
+				o.options["Level"] = int64(native.Uint32(attr.Value[36:40]))
+				o.options["Prio"] = int64(native.Uint32(attr.Value[40:44]))
+			}
+		case tcaHtbRate64:
+			if v, ok := attrUint64(attr); ok {
+				o.options["Rate"] = v
+			}
+		case tcaHtbCeil64:
+			if v, ok := attrUint64(attr); ok {
+				o.options["Ceil"] = v
+			}
+		}
+	}
+}
+
+func (o *tcObject) parseFqCodelOptions(attrs []syscall.NetlinkRouteAttr) {
+	names := map[uint16]string{
+		tcaFqCodelTarget:   "Target",
+		tcaFqCodelLimit:    "Limit",
+		tcaFqCodelInterval: "Interval",
+		tcaFqCodelECN:      "ECN",
+		tcaFqCodelFlows:    "Flows",
+	}
+
+	for _, attr := range attrs {
+		if name, ok := names[attr.Attr.Type]; ok {
+			if v, ok := attrUint32(attr); ok {
+				o.options[name] = v
+			}
+		}
+	}
+}
+
+// parseNetemOptions parses the tc_netem_qopt structure, followed by the
+// netem attributes, delay and jitter being reported in microseconds
+func (o *tcObject) parseNetemOptions(b []byte) error {
+	if len(b) < 24 {
+		return errors.New("netem options too short")
+	}
+
+	native := nl.NativeEndian()
+	o.options["Delay"] = int64(float64(native.Uint32(b[0:4])) / pschedTicksPerUsec)
+	o.options["Limit"] = int64(native.Uint32(b[4:8]))
+	o.options["Loss"] = float64(native.Uint32(b[8:12])) * 100 / 0xffffffff
+	o.options["Duplicate"] = float64(native.Uint32(b[16:20])) * 100 / 0xffffffff
+	o.options["Jitter"] = int64(float64(native.Uint32(b[20:24])) / pschedTicksPerUsec)
+
+	attrs, err := parseNestedAttrs(b[24:])
+	if err != nil {
+		return err
+	}
+
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case tcaNetemLatency64:
+			if v, ok := attrUint64(attr); ok {
+				o.options["Delay"] = v / 1000
+			}
+		case tcaNetemJitter64:
+			if v, ok := attrUint64(attr); ok {
+				o.options["Jitter"] = v / 1000
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o *tcObject) parseBpfOptions(attrs []syscall.NetlinkRouteAttr) {
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case tcaBpfName:
+			o.options["BPFName"] = strings.TrimRight(string(attr.Value), "\x00")
+		case tcaBpfTag:
+			o.options["BPFTag"] = fmt.Sprintf("%x", attr.Value)
+		case tcaBpfID:
+			if v, ok := attrUint32(attr); ok {
+				o.options["BPFID"] = v
+			}
+		case tcaBpfClassid:
+			if v, ok := attrUint32(attr); ok {
+				o.options["ClassID"] = tcHandle(uint32(v))
+			}
+		case tcaBpfFlags:
+			if v, ok := attrUint32(attr); ok {
+				o.options["DirectAction"] = v&tcaBpfFlagActDirect != 0
+			}
+		}
+	}
+}
+
+// parseOptions parses the options of the kinds reported, the format of the
+// options being specific to each kind
+func (o *tcObject) parseOptions(b []byte) error {
+	var parse func(attrs []syscall.NetlinkRouteAttr)
+	switch o.kind {
+	case "netem":
+		return o.parseNetemOptions(b)
+	case "htb":
+		parse = o.parseHtbOptions
+	case "fq_codel":
+		parse = o.parseFqCodelOptions
+	case "bpf":
+		parse = o.parseBpfOptions
+	default:
+		return nil
+	}
+
+	attrs, err := parseNestedAttrs(b)
+	if err != nil {
+		return err
+	}
+	parse(attrs)
+
+	return nil
+}
+
+func parseTcMessage(msgType uint16, b []byte) (*tcObject, error) {
+	if len(b) < sizeofTcMsg {
+		return nil, errors.New("traffic control message too short")
+	}
+
+	native := nl.NativeEndian()
+	o := &tcObject{
+		ifIndex: int64(int32(native.Uint32(b[4:8]))),
+		handle:  native.Uint32(b[8:12]),
+		parent:  native.Uint32(b[12:16]),
+		info:    native.Uint32(b[16:20]),
+		options: graph.Metadata{},
+	}
+
+	switch msgType {
+	case syscall.RTM_NEWQDISC:
+		o.objType = "qdisc"
+	case syscall.RTM_NEWTCLASS:
+		o.objType = "tcclass"
+	case syscall.RTM_NEWTFILTER:
+		o.objType = "tcfilter"
+	default:
+		return nil, fmt.Errorf("unexpected traffic control message %d", msgType)
+	}
+
+	attrs, err := nl.ParseRouteAttr(b[sizeofTcMsg:])
+	if err != nil {
+		return nil, err
+	}
+
+	var options []byte
+	for _, attr := range attrs {
+		switch attr.Attr.Type & nlaTypeMask {
+		case tcaKind:
+			o.kind = strings.TrimRight(string(attr.Value), "\x00")
+		case tcaOptions:
+			options = attr.Value
+		case tcaStats2:
+			if o.stats, err = parseTcStatistics(attr.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if options != nil {
+		if err := o.parseOptions(options); err != nil {
+			return nil, fmt.Errorf("unable to parse %s options: %s", o.kind, err.Error())
+		}
+	}
+
+	return o, nil
+}
+
+func dumpTc(req, res int, msg *tcMsg) (objects []*tcObject, err error) {
+	r := nl.NewNetlinkRequest(req, syscall.NLM_F_DUMP)
+	r.AddData(msg)
+
+	msgs, err := r.Execute(syscall.NETLINK_ROUTE, uint16(res))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range msgs {
+		o, err := parseTcMessage(uint16(res), m)
+		if err != nil {
+			logging.GetLogger().Warningf("Failed to parse traffic control message: %s", err.Error())
+			continue
+		}
+		objects = append(objects, o)
+	}
+
+	return objects, nil
+}
+
+// filterParents returns the parents to dump the filters attached to a
+// qdisc, clsact exposing its ingress and egress hooks as pseudo classes
+func (o *tcObject) filterParents() []uint32 {
+	if o.kind == "clsact" {
+		major := o.handle & 0xffff0000
+		return []uint32{major | tcHMinIngress, major | tcHMinEgress}
+	}
+	return []uint32{o.handle}
+}
+
+// trafficControlObjects returns the qdiscs, classes and filters of the
+// network namespace at the given path, the current one if empty
+func trafficControlObjects(path string) ([]*tcObject, error) {
+	if path != "" {
+		context, err := common.NewNetNsContext(path)
+		defer context.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	qdiscs, err := dumpTc(syscall.RTM_GETQDISC, syscall.RTM_NEWQDISC, &tcMsg{})
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []*tcObject
+	classKinds := make(map[int64]map[uint32]string)
+	for _, qdisc := range qdiscs {
+		// default qdisc of the virtual interfaces
+		if qdisc.kind == "noqueue" {
+			continue
+		}
+		objects = append(objects, qdisc)
+
+		if _, ok := classKinds[qdisc.ifIndex]; !ok {
+			classKinds[qdisc.ifIndex] = make(map[uint32]string)
+		}
+		classKinds[qdisc.ifIndex][qdisc.handle] = qdisc.kind
+
+		// filters can't be attached to the qdiscs without handle
+		if qdisc.handle == 0 {
+			continue
+		}
+
+		for _, parent := range qdisc.filterParents() {
+			filters, err := dumpTc(syscall.RTM_GETTFILTER, syscall.RTM_NEWTFILTER, &tcMsg{ifIndex: int32(qdisc.ifIndex), parent: parent})
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, filters...)
+		}
+	}
+
+	for ifIndex, kinds := range classKinds {
+		classes, err := dumpTc(syscall.RTM_GETTCLASS, syscall.RTM_NEWTCLASS, &tcMsg{ifIndex: int32(ifIndex)})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, class := range classes {
+			if flowQdiscs[kinds[class.handle&0xffff0000]] {
+				continue
+			}
+			objects = append(objects, class)
+
+			filters, err := dumpTc(syscall.RTM_GETTFILTER, syscall.RTM_NEWTFILTER, &tcMsg{ifIndex: int32(ifIndex), parent: class.handle})
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, filters...)
+		}
+	}
+
+	// the chain heads of the filters have a null handle
+	var result []*tcObject
+	for _, o := range objects {
+		if o.objType != "tcfilter" || o.handle != 0 {
+			result = append(result, o)
+		}
+	}
+
+	return result, nil
+}
+
+// buildTcTree returns the objects directly attached to an interface, the
+// others being set as children of their qdisc or class
+func buildTcTree(objects []*tcObject) []*tcObject {
+	qdiscs := make(map[uint32]*tcObject)
+	classes := make(map[uint32]*tcObject)
+	for _, o := range objects {
+		switch o.objType {
+		case "qdisc":
+			qdiscs[o.handle] = o
+		case "tcclass":
+			classes[o.handle] = o
+		}
+	}
+
+	var roots []*tcObject
+	for _, o := range objects {
+		if o.objType == "qdisc" && (o.parent == tcHRoot || o.parent == tcHIngress) {
+			roots = append(roots, o)
+			continue
+		}
+
+		parent, ok := classes[o.parent]
+		if !ok {
+			parent, ok = qdiscs[o.parent&0xffff0000]
+		}
+		if !ok {
+			roots = append(roots, o)
+			continue
+		}
+		parent.children = append(parent.children, o)
+	}
+
+	return roots
+}
+
+// key identifies the object among the ones of an interface, several
+// qdiscs without handle being attached to multiqueue interfaces
+func (o *tcObject) key() string {
+	return o.objType + "/" + tcHandle(o.parent) + "/" + o.name()
+}
+
+// tcNodes returns the traffic control nodes below the given node
+func (probe *TrafficControlProbe) tcNodes(parent *graph.Node, nodes map[string]*graph.Node) {
+	for _, tp := range []string{"qdisc", "tcclass", "tcfilter"} {
+		for _, n := range probe.Graph.LookupChildren(parent, graph.Metadata{"Type": tp}, ownershipMetadata) {
+			parentHandle, _ := n.GetFieldString("Parent")
+			name, _ := n.GetFieldString("Name")
+
+			if key := tp + "/" + parentHandle + "/" + name; nodes[key] == nil {
+				nodes[key] = n
+				probe.tcNodes(n, nodes)
+			}
+		}
+	}
+}
+
+func updateTcMetric(m graph.Metadata, old graph.Metadata, start, now time.Time) {
+	for _, field := range []string{"Bytes", "Packets", "Drops", "Overlimits", "Requeues"} {
+		value, ok := m["Statistics/"+field].(int64)
+		if !ok {
+			return
+		}
+
+		// counters are reset when a qdisc is replaced
+		if previous, ok := old["Statistics/"+field].(int64); ok && previous <= value {
+			value -= previous
+		}
+		m["LastMetric/"+field] = value
+	}
+
+	m["LastMetric/Start"] = common.UnixMillis(start)
+	m["LastMetric/Last"] = common.UnixMillis(now)
+}
+
+// syncObjects updates the traffic control nodes attached to a node, graph
+// lock has to be held
+func (probe *TrafficControlProbe) syncObjects(parent *graph.Node, objects []*tcObject, nodes map[string]*graph.Node, last, now time.Time) {
+	for _, o := range objects {
+		m := o.metadata()
+		key := o.key()
+
+		node, ok := nodes[key]
+		if ok {
+			delete(nodes, key)
+
+			old := node.Metadata()
+			if !last.IsZero() {
+				updateTcMetric(m, old, last, now)
+			}
+			for k, v := range old {
+				if !isTrafficControlKey(k) {
+					m[k] = v
+				}
+			}
+			probe.Graph.SetMetadata(node, m)
+
+			// the parent of a qdisc may have changed
+			linked := false
+			for _, e := range probe.Graph.GetNodeEdges(node, ownershipMetadata) {
+				if e.GetChild() != node.ID {
+					continue
+				}
+
+				if e.GetParent() == parent.ID {
+					linked = true
+				} else {
+					probe.Graph.DelEdge(e)
+				}
+			}
+
+			if !linked {
+				probe.Graph.Link(parent, node, ownershipMetadata)
+			}
+		} else {
+			node = probe.Graph.NewNode(graph.GenID(), m)
+			probe.Graph.Link(parent, node, ownershipMetadata)
+		}
+
+		probe.syncObjects(node, o.children, nodes, last, now)
+	}
+}
+
+// syncNamespace updates the traffic control nodes of the interfaces of a
+// namespace, graph lock has to be held
+func (probe *TrafficControlProbe) syncNamespace(ns *graph.Node, objects []*tcObject, last, now time.Time) {
+	byIndex := make(map[int64][]*tcObject)
+	for _, o := range objects {
+		byIndex[o.ifIndex] = append(byIndex[o.ifIndex], o)
+	}
+
+	for _, intf := range probe.Graph.LookupChildren(ns, graph.Metadata{}, ownershipMetadata) {
+		ifIndex, err := intf.GetFieldInt64("IfIndex")
+		if err != nil {
+			continue
+		}
+
+		nodes := make(map[string]*graph.Node)
+		probe.tcNodes(intf, nodes)
+
+		probe.syncObjects(intf, buildTcTree(byIndex[ifIndex]), nodes, last, now)
+
+		for _, node := range nodes {
+			probe.Graph.DelNode(node)
+		}
+	}
+}
+
+// poll updates the traffic control nodes of a namespace, the root namespace
+// having no path
+func (probe *TrafficControlProbe) poll(id graph.Identifier, path string, last, now time.Time) {
+	objects, err := trafficControlObjects(path)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to retrieve traffic control of %s: %s", path, err.Error())
+		return
+	}
+
+	probe.Graph.Lock()
+	if ns := probe.Graph.GetNode(id); ns != nil {
+		probe.syncNamespace(ns, objects, last, now)
+	}
+	probe.Graph.Unlock()
+}
+
+func (probe *TrafficControlProbe) Start() {
+	probe.poller.Start()
+}
+
+func (probe *TrafficControlProbe) Stop() {
+	probe.poller.Stop()
+}
+
+func NewTrafficControlProbe(g *graph.Graph, n *graph.Node, interval time.Duration) *TrafficControlProbe {
+	probe := &TrafficControlProbe{
+		Graph: g,
+		Root:  n,
+	}
+	probe.poller = newNodePoller(g, n, "netns", "Path", interval, probe.poll)
+	return probe
+}
+
+func NewTrafficControlProbeFromConfig(g *graph.Graph, n *graph.Node) *TrafficControlProbe {
+	interval := config.GetConfig().GetInt("agent.topology.tc.update")
+	return NewTrafficControlProbe(g, n, time.Duration(interval)*time.Second)
+}
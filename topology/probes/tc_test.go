@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+func testNative32(v uint32) []byte {
+	b := make([]byte, 4)
+	nl.NativeEndian().PutUint32(b, v)
+	return b
+}
+
+func testNative64(v uint64) []byte {
+	b := make([]byte, 8)
+	nl.NativeEndian().PutUint64(b, v)
+	return b
+}
+
+func testTcMsg(ifIndex, handle, parent, info uint32, attrs ...[]byte) []byte {
+	b := make([]byte, sizeofTcMsg)
+	native := nl.NativeEndian()
+	native.PutUint32(b[4:8], ifIndex)
+	native.PutUint32(b[8:12], handle)
+	native.PutUint32(b[12:16], parent)
+	native.PutUint32(b[16:20], info)
+	for _, attr := range attrs {
+		b = append(b, attr...)
+	}
+	return b
+}
+
+func testTcStats(bytes uint64, packets, drops, overlimits uint32) []byte {
+	basic := append(testNative64(bytes), testNative32(packets)...)
+	// qlen, backlog, drops, requeues, overlimits
+	var queue []byte
+	for _, v := range []uint32{1, 1500, drops, 0, overlimits} {
+		queue = append(queue, testNative32(v)...)
+	}
+	return testNested(tcaStats2, testRtAttr(tcaStatsBasic, basic), testRtAttr(tcaStatsQueue, queue))
+}
+
+func testNetemQdisc(handle, parent uint32, drops uint32) []byte {
+	// latency, limit, loss, gap, duplicate and jitter
+	var qopt []byte
+	for _, v := range []uint32{0, 1000, 0xffffffff / 10, 0, 0, 0} {
+		qopt = append(qopt, testNative32(v)...)
+	}
+	qopt = append(qopt, testRtAttr(tcaNetemLatency64, testNative64(100000000))...)
+
+	return testTcMsg(2, handle, parent, 0,
+		testRtAttr(tcaKind, []byte("netem\x00")),
+		testRtAttr(tcaOptions, qopt),
+		testTcStats(3000, 2, drops, 0))
+}
+
+func TestParseTcMessage(t *testing.T) {
+	o, err := parseTcMessage(syscall.RTM_NEWQDISC, testNetemQdisc(0x100000, tcHRoot, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := o.metadata()
+	expected := graph.Metadata{
+		"Type":                  "qdisc",
+		"Name":                  "netem 10:",
+		"Handle":                "10:",
+		"Parent":                "root",
+		"Options/Delay":         int64(100000),
+		"Options/Limit":         int64(1000),
+		"Statistics/Bytes":      int64(3000),
+		"Statistics/Packets":    int64(2),
+		"Statistics/Drops":      int64(5),
+		"Statistics/Backlog":    int64(1500),
+		"Statistics/Overlimits": int64(0),
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m[k])
+		}
+	}
+
+	if loss, ok := m["Options/Loss"].(float64); !ok || loss < 9.9 || loss > 10.1 {
+		t.Errorf("Expected a 10%% loss, got %v", m["Options/Loss"])
+	}
+
+	// direct action bpf filter on the egress hook of a clsact qdisc,
+	// protocol all being stored in network byte order
+	filter := testTcMsg(2, 1, 0xfffffff3, 1<<16|0x0300,
+		testRtAttr(tcaKind, []byte("bpf\x00")),
+		testNested(tcaOptions,
+			testRtAttr(tcaBpfName, []byte("prog.o:[classifier]\x00")),
+			testRtAttr(tcaBpfFlags, testNative32(tcaBpfFlagActDirect)),
+			testRtAttr(tcaBpfID, testNative32(42)),
+			testRtAttr(tcaBpfTag, []byte{0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 1})))
+
+	if o, err = parseTcMessage(syscall.RTM_NEWTFILTER, filter); err != nil {
+		t.Fatal(err)
+	}
+
+	m = o.metadata()
+	expected = graph.Metadata{
+		"Type":                 "tcfilter",
+		"Name":                 "bpf egress pref 1 all handle 0x1",
+		"Direction":            "egress",
+		"Priority":             int64(1),
+		"Protocol":             "all",
+		"Options/BPFName":      "prog.o:[classifier]",
+		"Options/BPFID":        int64(42),
+		"Options/BPFTag":       "deadbeef00000001",
+		"Options/DirectAction": true,
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, m[k])
+		}
+	}
+}
+
+func TestTrafficControlSync(t *testing.T) {
+	b, _ := graph.NewMemoryBackend()
+	g := graph.NewGraph("host", b)
+
+	g.Lock()
+	defer g.Unlock()
+
+	root := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "host"})
+	intf := g.NewNode(graph.GenID(), graph.Metadata{"Type": "veth", "Name": "eth0", "IfIndex": int64(2)})
+	g.Link(root, intf, ownershipMetadata)
+
+	probe := NewTrafficControlProbe(g, root, time.Second)
+
+	objects := func(drops uint32, netem bool) []*tcObject {
+		msgs := []struct {
+			msgType uint16
+			b       []byte
+		}{
+			{syscall.RTM_NEWQDISC, testTcMsg(2, 0x10000, tcHRoot, 0,
+				testRtAttr(tcaKind, []byte("htb\x00")),
+				testTcStats(0, 0, 0, 0))},
+			{syscall.RTM_NEWTCLASS, testTcMsg(2, 0x10010, 0x10000, 0,
+				testRtAttr(tcaKind, []byte("htb\x00")),
+				testTcStats(0, 0, 0, 0))},
+		}
+		if netem {
+			msgs = append(msgs, struct {
+				msgType uint16
+				b       []byte
+			}{syscall.RTM_NEWQDISC, testNetemQdisc(0x100000, 0x10010, drops)})
+		}
+
+		var objects []*tcObject
+		for _, msg := range msgs {
+			o, err := parseTcMessage(msg.msgType, msg.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			objects = append(objects, o)
+		}
+		return objects
+	}
+
+	start := time.Now()
+	probe.syncNamespace(root, objects(5, true), time.Time{}, start)
+
+	qdisc := g.LookupFirstChild(intf, graph.Metadata{"Type": "qdisc", "Name": "htb 1:"})
+	if qdisc == nil {
+		t.Fatal("htb qdisc should be attached to the interface")
+	}
+
+	class := g.LookupFirstChild(qdisc, graph.Metadata{"Type": "tcclass", "Name": "htb 1:10"})
+	if class == nil {
+		t.Fatal("htb class should be attached to its qdisc")
+	}
+
+	netem := g.LookupFirstChild(class, graph.Metadata{"Type": "qdisc", "Name": "netem 10:"})
+	if netem == nil {
+		t.Fatal("netem qdisc should be attached to its class")
+	}
+
+	probe.syncNamespace(root, objects(12, true), start, start.Add(time.Second))
+
+	if drops, _ := netem.GetFieldInt64("LastMetric/Drops"); drops != 7 {
+		t.Errorf("Expected 7 drops since last update, got %d", drops)
+	}
+
+	probe.syncNamespace(root, objects(0, false), start, start.Add(2*time.Second))
+
+	if g.GetNode(netem.ID) != nil {
+		t.Error("netem qdisc should have been removed")
+	}
+
+	if g.GetNode(class.ID) == nil {
+		t.Error("htb class should have been kept")
+	}
+}
@@ -0,0 +1,311 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package topology
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+var ownershipMetadata = graph.Metadata{"RelationType": "ownership"}
+
+// rules used by the kernel when no policy routing rule is reported
+var defaultRoutingRules = []routingRule{
+	{priority: 0, action: "lookup", table: 255},
+	{priority: 32766, action: "lookup", table: 254},
+	{priority: 32767, action: "lookup", table: 253},
+}
+
+// NextHop describes a gateway and egress interface of a route
+type NextHop struct {
+	Gateway   string      `json:",omitempty"`
+	Interface *graph.Node `json:",omitempty"`
+	Weight    int64
+}
+
+// Route is the result of a route lookup within a namespace
+type Route struct {
+	Namespace *graph.Node
+	Rule      *graph.Node `json:",omitempty"`
+	Route     *graph.Node `json:",omitempty"`
+	NextHops  []NextHop   `json:",omitempty"`
+}
+
+type routingRule struct {
+	node     *graph.Node
+	priority int64
+	action   string
+	table    int64
+	goTo     int64
+}
+
+type routingRules []routingRule
+
+func (r routingRules) Len() int           { return len(r) }
+func (r routingRules) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r routingRules) Less(i, j int) bool { return r[i].priority < r[j].priority }
+
+type RouteToGremlinTraversalStep struct {
+	context traversal.GremlinTraversalContext
+	dst     net.IP
+	src     net.IP
+}
+
+type RouteToTraversalStep struct {
+	routes []*Route
+}
+
+func (r *RouteToTraversalStep) Values() []interface{} {
+	s := make([]interface{}, len(r.routes))
+	for i, route := range r.routes {
+		s[i] = route
+	}
+	return s
+}
+
+func (r *RouteToTraversalStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Values())
+}
+
+func (r *RouteToTraversalStep) Error() error {
+	return nil
+}
+
+func prefixContains(prefix string, ip net.IP) bool {
+	_, ipnet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return false
+	}
+	return ipnet.Contains(ip) && (ipnet.IP.To4() != nil) == (ip.To4() != nil)
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IPV4"
+	}
+	return "IPV6"
+}
+
+// routingNamespace returns the host or netns node holding the routing tables
+// used by the given node
+func routingNamespace(g *graph.Graph, n *graph.Node) *graph.Node {
+	for {
+		if tp, _ := n.GetFieldString("Type"); tp == "host" || tp == "netns" {
+			return n
+		}
+
+		parents := g.LookupParents(n, graph.Metadata{}, ownershipMetadata)
+		if len(parents) == 0 {
+			return nil
+		}
+		n = parents[0]
+	}
+}
+
+func lookupRoutingRules(g *graph.Graph, ns *graph.Node, family string) (rules routingRules) {
+	for _, node := range g.LookupChildren(ns, graph.Metadata{"Type": "routingrule", "Family": family}, ownershipMetadata) {
+		priority, _ := node.GetFieldInt64("Priority")
+		action, _ := node.GetFieldString("Action")
+		table, _ := node.GetFieldInt64("Table")
+		goTo, _ := node.GetFieldInt64("Goto")
+		rules = append(rules, routingRule{node: node, priority: priority, action: action, table: table, goTo: goTo})
+	}
+
+	if len(rules) == 0 {
+		rules = append(rules, defaultRoutingRules...)
+	}
+	sort.Sort(rules)
+
+	return rules
+}
+
+// matchRoutingRule returns whether the rule selects traffic to dst from src,
+// rules using packet marks or interfaces can't be evaluated and never match
+func matchRoutingRule(r routingRule, dst, src net.IP) bool {
+	if r.node == nil {
+		return true
+	}
+
+	match := true
+	if prefix, _ := r.node.GetFieldString("Source"); prefix != "" {
+		match = src != nil && prefixContains(prefix, src)
+	}
+	if prefix, _ := r.node.GetFieldString("Destination"); prefix != "" {
+		match = match && prefixContains(prefix, dst)
+	}
+	if invert, ok := r.node.Metadata()["Invert"].(bool); ok && invert {
+		match = !match
+	}
+
+	for _, field := range []string{"InputInterface", "OutputInterface"} {
+		if v, _ := r.node.GetFieldString(field); v != "" {
+			return false
+		}
+	}
+	if mark, _ := r.node.GetFieldInt64("Mark"); mark != 0 {
+		return false
+	}
+
+	return match
+}
+
+// lookupTable returns the longest prefix match of a table, the lowest
+// metric winning between routes of the same prefix
+func lookupTable(g *graph.Graph, ns *graph.Node, table int64, dst net.IP) (best *graph.Node) {
+	bestLen, bestPriority := -1, int64(0)
+
+	for _, node := range g.LookupChildren(ns, graph.Metadata{"Type": "route", "Family": ipFamily(dst)}, ownershipMetadata) {
+		if t, _ := node.GetFieldInt64("Table"); t != table {
+			continue
+		}
+
+		prefix, _ := node.GetFieldString("Destination")
+		_, ipnet, err := net.ParseCIDR(prefix)
+		if err != nil || !ipnet.Contains(dst) {
+			continue
+		}
+
+		ones, _ := ipnet.Mask.Size()
+		priority, _ := node.GetFieldInt64("Priority")
+		if ones > bestLen || (ones == bestLen && priority < bestPriority) {
+			best, bestLen, bestPriority = node, ones, priority
+		}
+	}
+
+	return best
+}
+
+func routeNextHops(g *graph.Graph, ns *graph.Node, route *graph.Node) (nextHops []NextHop) {
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("NextHops/%d/", i)
+
+		index, err := route.GetFieldInt64(prefix + "IfIndex")
+		if err != nil {
+			return
+		}
+
+		nh := NextHop{}
+		nh.Gateway, _ = route.GetFieldString(prefix + "Gateway")
+		nh.Weight, _ = route.GetFieldInt64(prefix + "Weight")
+		if index != 0 {
+			nh.Interface = g.LookupFirstChild(ns, graph.Metadata{"IfIndex": index})
+		}
+		nextHops = append(nextHops, nh)
+	}
+}
+
+// LookupRoute resolves the route used by the namespace of a node to reach
+// dst, evaluating the policy routing rules as the kernel does
+func LookupRoute(g *graph.Graph, n *graph.Node, dst, src net.IP) *Route {
+	ns := routingNamespace(g, n)
+	if ns == nil {
+		return nil
+	}
+
+	var minPriority int64
+	for _, rule := range lookupRoutingRules(g, ns, ipFamily(dst)) {
+		if rule.priority < minPriority || !matchRoutingRule(rule, dst, src) {
+			continue
+		}
+
+		switch rule.action {
+		case "lookup":
+			route := lookupTable(g, ns, rule.table, dst)
+			if route == nil {
+				continue
+			}
+
+			if tp, _ := route.GetFieldString("RouteType"); tp == "throw" {
+				continue
+			}
+
+			return &Route{Namespace: ns, Rule: rule.node, Route: route, NextHops: routeNextHops(g, ns, route)}
+		case "goto":
+			minPriority = rule.goTo
+		case "nop":
+		default:
+			// blackhole, unreachable or prohibit rules
+			return &Route{Namespace: ns, Rule: rule.node}
+		}
+	}
+
+	return nil
+}
+
+func (s *RouteToGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch last.(type) {
+	case *traversal.GraphTraversalV:
+		tv := last.(*traversal.GraphTraversalV)
+
+		tv.GraphTraversal.RLock()
+		defer tv.GraphTraversal.RUnlock()
+
+		routes := []*Route{}
+		for _, i := range tv.Values() {
+			if route := LookupRoute(tv.GraphTraversal.Graph, i.(*graph.Node), s.dst, s.src); route != nil {
+				routes = append(routes, route)
+			}
+		}
+
+		return &RouteToTraversalStep{routes: routes}, nil
+	}
+
+	return nil, traversal.ExecutionError
+}
+
+func (s *RouteToGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) traversal.GremlinTraversalStep {
+	return next
+}
+
+func (s *RouteToGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.context
+}
+
+func newRouteToGremlinTraversalStep(p traversal.GremlinTraversalContext) (traversal.GremlinTraversalStep, error) {
+	if len(p.Params) != 1 && len(p.Params) != 2 {
+		return nil, errors.New("RouteTo requires a destination and an optional source address")
+	}
+
+	step := &RouteToGremlinTraversalStep{context: p}
+	for i, param := range p.Params {
+		s, ok := param.(string)
+		ip := net.ParseIP(s)
+		if !ok || ip == nil {
+			return nil, fmt.Errorf("RouteTo parameter %d has to be an IP address", i+1)
+		}
+
+		if i == 0 {
+			step.dst = ip
+		} else {
+			step.src = ip
+		}
+	}
+
+	return step, nil
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package topology
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+func newRoutingGraph(t *testing.T) *graph.Graph {
+	g := newGraph(t)
+
+	host := g.NewNode(graph.GenID(), graph.Metadata{"Type": "host", "Name": "localhost"})
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth0", "IfIndex": int64(2)})
+	eth1 := g.NewNode(graph.GenID(), graph.Metadata{"Type": "device", "Name": "eth1", "IfIndex": int64(3)})
+	g.Link(host, eth0, ownershipMetadata)
+	g.Link(host, eth1, ownershipMetadata)
+
+	routes := []graph.Metadata{
+		{"Name": "default", "Table": int64(254), "Destination": "0.0.0.0/0", "Priority": int64(100),
+			"NextHops/0/Gateway": "192.168.0.1", "NextHops/0/IfIndex": int64(2), "NextHops/0/Weight": int64(1)},
+		{"Name": "default metric 200", "Table": int64(254), "Destination": "0.0.0.0/0", "Priority": int64(200),
+			"NextHops/0/Gateway": "192.168.0.254", "NextHops/0/IfIndex": int64(2), "NextHops/0/Weight": int64(1)},
+		{"Name": "10.0.0.0/8", "Table": int64(254), "Destination": "10.0.0.0/8",
+			"NextHops/0/Gateway": "10.1.0.1", "NextHops/0/IfIndex": int64(2), "NextHops/0/Weight": int64(1),
+			"NextHops/1/Gateway": "10.2.0.1", "NextHops/1/IfIndex": int64(3), "NextHops/1/Weight": int64(2)},
+		{"Name": "192.168.0.0/24", "Table": int64(254), "Destination": "192.168.0.0/24",
+			"NextHops/0/IfIndex": int64(2), "NextHops/0/Weight": int64(1)},
+		{"Name": "default table 100", "Table": int64(100), "Destination": "0.0.0.0/0",
+			"NextHops/0/Gateway": "172.16.0.1", "NextHops/0/IfIndex": int64(3), "NextHops/0/Weight": int64(1)},
+	}
+	for _, m := range routes {
+		m["Type"] = "route"
+		m["Family"] = "IPV4"
+		m["RouteType"] = "unicast"
+		g.Link(host, g.NewNode(graph.GenID(), m), ownershipMetadata)
+	}
+
+	rules := []graph.Metadata{
+		{"Name": "0: from all lookup local", "Priority": int64(0), "Action": "lookup", "Table": int64(255)},
+		{"Name": "1000: from 192.168.1.0/24 lookup 100", "Priority": int64(1000), "Action": "lookup", "Table": int64(100), "Source": "192.168.1.0/24"},
+		{"Name": "2000: from all to 10.10.0.0/16 blackhole", "Priority": int64(2000), "Action": "blackhole", "Destination": "10.10.0.0/16"},
+		{"Name": "32766: from all lookup main", "Priority": int64(32766), "Action": "lookup", "Table": int64(254)},
+		{"Name": "32767: from all lookup default", "Priority": int64(32767), "Action": "lookup", "Table": int64(253)},
+	}
+	for _, m := range rules {
+		m["Type"] = "routingrule"
+		m["Family"] = "IPV4"
+		g.Link(host, g.NewNode(graph.GenID(), m), ownershipMetadata)
+	}
+
+	return g
+}
+
+func execRouteTo(t *testing.T, g *graph.Graph, query string) []*Route {
+	tp := traversal.NewGremlinTraversalParser(g)
+	tp.AddTraversalExtension(NewTopologyTraversalExtension())
+
+	ts, err := tp.Parse(strings.NewReader(query), false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := ts.Exec()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var routes []*Route
+	for _, v := range res.Values() {
+		routes = append(routes, v.(*Route))
+	}
+	return routes
+}
+
+func checkRoute(t *testing.T, route *Route, name string, nextHops ...string) {
+	if route.Route == nil {
+		t.Fatalf("No route found, expected %s", name)
+	}
+
+	if n, _ := route.Route.GetFieldString("Name"); n != name {
+		t.Errorf("Expected route %s, got %s", name, n)
+	}
+
+	if len(route.NextHops) != len(nextHops) {
+		t.Fatalf("Expected %d next hops, got %+v", len(nextHops), route.NextHops)
+	}
+
+	for i, nh := range route.NextHops {
+		intf, _ := nh.Interface.GetFieldString("Name")
+		if s := nh.Gateway + "@" + intf; s != nextHops[i] {
+			t.Errorf("Expected next hop %s, got %s", nextHops[i], s)
+		}
+	}
+}
+
+func TestRouteTo(t *testing.T) {
+	g := newRoutingGraph(t)
+
+	routes := execRouteTo(t, g, `G.V().Has("Type", "host").RouteTo("8.8.8.8")`)
+	if len(routes) != 1 {
+		t.Fatalf("Should return 1 route, returned: %v", routes)
+	}
+	checkRoute(t, routes[0], "default", "192.168.0.1@eth0")
+
+	routes = execRouteTo(t, g, `G.V().Has("Type", "host").RouteTo("10.0.0.5")`)
+	checkRoute(t, routes[0], "10.0.0.0/8", "10.1.0.1@eth0", "10.2.0.1@eth1")
+
+	routes = execRouteTo(t, g, `G.V().Has("Name", "eth1").RouteTo("192.168.0.12")`)
+	checkRoute(t, routes[0], "192.168.0.0/24", "@eth0")
+
+	// policy routing based on the source address
+	routes = execRouteTo(t, g, `G.V().Has("Type", "host").RouteTo("8.8.8.8", "192.168.1.10")`)
+	checkRoute(t, routes[0], "default table 100", "172.16.0.1@eth1")
+
+	routes = execRouteTo(t, g, `G.V().Has("Type", "host").RouteTo("10.10.1.1")`)
+	if len(routes) != 1 || routes[0].Route != nil || routes[0].Rule == nil {
+		t.Fatalf("Traffic should be dropped by the blackhole rule: %v", routes)
+	}
+}
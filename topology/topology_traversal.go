@@ -32,6 +32,7 @@ import (
 
 type TopologyTraversalExtension struct {
 	graphPathToken traversal.Token
+	routeToToken   traversal.Token
 }
 
 type GraphPathGremlinTraversalStep struct {
@@ -60,6 +61,7 @@ func (p *GraphPathTraversalStep) Error() error {
 func NewTopologyTraversalExtension() *TopologyTraversalExtension {
 	return &TopologyTraversalExtension{
 		graphPathToken: traversal.Token(1000),
+		routeToToken:   traversal.Token(1100),
 	}
 }
 
@@ -67,6 +69,8 @@ func (e *TopologyTraversalExtension) ScanIdent(s string) (traversal.Token, bool)
 	switch s {
 	case "GRAPHPATH":
 		return e.graphPathToken, true
+	case "ROUTETO":
+		return e.routeToToken, true
 	}
 	return traversal.IDENT, false
 }
@@ -75,6 +79,8 @@ func (e *TopologyTraversalExtension) ParseStep(t traversal.Token, p traversal.Gr
 	switch t {
 	case e.graphPathToken:
 		return &GraphPathGremlinTraversalStep{}, nil
+	case e.routeToToken:
+		return newRouteToGremlinTraversalStep(p)
 	}
 
 	return nil, nil